@@ -0,0 +1,66 @@
+// Command import loads Nmap XML (-oX) or masscan JSON (-oJ) output into the
+// scan history, the same way POST /api/import does.
+//
+//	go run ./cmd/import -format nmap-xml -file scan.xml
+//	masscan ... -oJ - | go run ./cmd/import -format masscan-json
+package main
+
+import (
+	"flag"
+	"io"
+	"log"
+	"os"
+
+	"backend/internal/application/services"
+	database "backend/internal/infrastructure/database"
+	"shared/tlsconfig"
+)
+
+func getEnv(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+func main() {
+	format := flag.String("format", services.ImportFormatNmapXML, "input format: nmap-xml or masscan-json")
+	file := flag.String("file", "-", "input file, - for stdin")
+	flag.Parse()
+
+	var in io.Reader = os.Stdin
+	if *file != "-" {
+		f, err := os.Open(*file)
+		if err != nil {
+			log.Fatalf("[Import] Cannot open %s: %v", *file, err)
+		}
+		defer f.Close()
+		in = f
+	}
+
+	mongoTLS, err := tlsconfig.Options{
+		CAFile:     getEnv("MONGODB_TLS_CA", ""),
+		CertFile:   getEnv("MONGODB_TLS_CERT", ""),
+		KeyFile:    getEnv("MONGODB_TLS_KEY", ""),
+		ServerName: getEnv("MONGODB_TLS_SERVER_NAME", ""),
+	}.Client()
+	if err != nil {
+		log.Fatalf("[Import] MongoDB TLS: %v", err)
+	}
+	db, err := database.NewDatabase(
+		getEnv("MONGODB_URI", "mongodb://localhost:27017"),
+		getEnv("MONGODB_DATABASE", "network_scanner"),
+		mongoTLS,
+	)
+	if err != nil {
+		log.Fatalf("[Import] Failed to connect to MongoDB: %v", err)
+	}
+	defer db.Close()
+
+	result, err := services.NewImportService(database.NewRepository(db)).Import(*format, in)
+	if err != nil {
+		log.Fatalf("[Import] %v", err)
+	}
+	log.Printf("[Import] Done: %d hosts, %d port records, %d OS records, %d skipped",
+		result.Hosts, result.TcpUdpRecords, result.OsDetectionCount, result.Skipped)
+}
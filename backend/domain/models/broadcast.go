@@ -0,0 +1,34 @@
+package models
+
+import "time"
+
+// Broadcast event types.
+const (
+	BroadcastChange    = "change_event"
+	BroadcastTask      = "task_status"
+	BroadcastResult    = "response"
+	BroadcastChunk     = "result_chunk"
+	BroadcastInventory = "inventory"
+)
+
+// BroadcastEvent is fanned out to every backend instance, each of which
+// passes it on to those of its WebSocket clients subscribed to the event's
+// topic: change events, task updates and inventory updates, or results and
+// their chunks for the task.
+type BroadcastEvent struct {
+	Type      string           `json:"type"`
+	Change    *ChangeEvent     `json:"change,omitempty"`
+	Task      *TaskUpdate      `json:"task,omitempty"`
+	Response  *Response        `json:"response,omitempty"`
+	Chunk     *ResultChunk     `json:"chunk,omitempty"`
+	Inventory *InventoryUpdate `json:"inventory,omitempty"`
+}
+
+// InventoryUpdate tells that a stored result may have changed the device
+// inventory of a layer (l2, l3 or l4): the hosts the result reported on.
+type InventoryUpdate struct {
+	TaskID string    `json:"task_id"`
+	Layer  string    `json:"layer"`
+	Hosts  []string  `json:"hosts"`
+	At     time.Time `json:"at"`
+}
@@ -0,0 +1,18 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// DeadLetter is a scan request parked on the dead-letter queue: either a
+// message no scanner could process or a scan whose retries ran out.
+type DeadLetter struct {
+	ID             string          `json:"id"`
+	TaskID         string          `json:"task_id,omitempty"`
+	Queue          string          `json:"queue"`
+	Reason         string          `json:"reason"`
+	Attempts       int             `json:"attempts,omitempty"`
+	DeadLetteredAt time.Time       `json:"dead_lettered_at"`
+	Body           json.RawMessage `json:"body"`
+}
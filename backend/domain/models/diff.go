@@ -0,0 +1,117 @@
+package models
+
+import "time"
+
+// ScanDiff is the result of comparing two history records of the same type.
+// A is the baseline and B the scan compared against it; only the section
+// matching ScanType is set.
+type ScanDiff struct {
+	ScanType   string      `json:"scan_type"`
+	A          ScanRef     `json:"a"`
+	B          ScanRef     `json:"b"`
+	SameTarget bool        `json:"same_target"`
+	Changed    bool        `json:"changed"`
+	ARP        *ARPDiff    `json:"arp,omitempty"`
+	ICMP       *ICMPDiff   `json:"icmp,omitempty"`
+	Ports      *PortDiff   `json:"ports,omitempty"`
+	OS         *FieldDiff  `json:"os,omitempty"`
+	Discovery  *FieldDiff  `json:"host_discovery,omitempty"`
+	TCP        *BannerDiff `json:"tcp,omitempty"`
+}
+
+// ScanRef identifies one side of a diff.
+type ScanRef struct {
+	ID        string    `json:"id"`
+	TaskID    string    `json:"task_id"`
+	Target    string    `json:"target"`
+	Status    string    `json:"status"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ── ARP ──────────────────────────────────────────────────────────────────────
+
+type ARPDiff struct {
+	Added      []ARPDevice    `json:"added"`
+	Removed    []ARPDevice    `json:"removed"`
+	MACChanged []ARPMACChange `json:"mac_changed"`
+	Unchanged  int            `json:"unchanged"`
+}
+
+type ARPMACChange struct {
+	IP        string `json:"ip"`
+	OldMAC    string `json:"old_mac"`
+	NewMAC    string `json:"new_mac"`
+	OldVendor string `json:"old_vendor,omitempty"`
+	NewVendor string `json:"new_vendor,omitempty"`
+}
+
+// ── ICMP ─────────────────────────────────────────────────────────────────────
+
+type ICMPDiff struct {
+	Added     []ICMPResult `json:"added"`
+	Removed   []ICMPResult `json:"removed"`
+	Changed   []ICMPChange `json:"changed"`
+	Unchanged int          `json:"unchanged"`
+}
+
+// ICMPChange reports a target whose reachability or packet loss changed.
+type ICMPChange struct {
+	Target        string  `json:"target"`
+	OldReachable  bool    `json:"old_reachable"`
+	NewReachable  bool    `json:"new_reachable"`
+	OldPacketLoss float64 `json:"old_packet_loss_percent"`
+	NewPacketLoss float64 `json:"new_packet_loss_percent"`
+}
+
+// ── Nmap ports ───────────────────────────────────────────────────────────────
+
+// PortDiff compares the open ports of two nmap_tcp_udp scans.
+type PortDiff struct {
+	Opened         []PortEntry     `json:"opened"`
+	Closed         []PortEntry     `json:"closed"`
+	ServiceChanged []ServiceChange `json:"service_changed"`
+	Unchanged      int             `json:"unchanged"`
+}
+
+type PortEntry struct {
+	Port     uint16 `json:"port"`
+	Protocol string `json:"protocol"`
+	Service  string `json:"service"`
+}
+
+type ServiceChange struct {
+	Port       uint16 `json:"port"`
+	Protocol   string `json:"protocol"`
+	OldService string `json:"old_service"`
+	NewService string `json:"new_service"`
+}
+
+// ── OS detection / host discovery ────────────────────────────────────────────
+
+// FieldDiff lists the scalar fields that differ between two records.
+type FieldDiff struct {
+	Changes []FieldChange `json:"changes"`
+}
+
+type FieldChange struct {
+	Field string      `json:"field"`
+	Old   interface{} `json:"old"`
+	New   interface{} `json:"new"`
+}
+
+// ── TCP banners ──────────────────────────────────────────────────────────────
+
+// BannerDiff is a line diff of the decoded TCP banners.
+type BannerDiff struct {
+	Identical bool       `json:"identical"`
+	Added     int        `json:"added"`
+	Removed   int        `json:"removed"`
+	Lines     []DiffLine `json:"lines"`
+	Truncated bool       `json:"truncated,omitempty"`
+}
+
+// DiffLine is one line of a banner diff. Op is "+", "-" or " ".
+type DiffLine struct {
+	Op   string `json:"op"`
+	Text string `json:"text"`
+}
@@ -0,0 +1,7 @@
+package models
+
+import "errors"
+
+// ErrNotFound is returned by repositories when no record has the requested
+// id, including when the id is not a valid record id at all.
+var ErrNotFound = errors.New("record not found")
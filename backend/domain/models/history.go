@@ -6,19 +6,27 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// ARPHistoryRecord is one stored ARP scan. Scans whose devices were
+// streamed in chunks list only their online devices; the offline ones are
+// still counted in OfflineCount. Devices, every device in one list, is
+// only set on records stored before the split lists were the only ones.
 type ARPHistoryRecord struct {
 	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id"`
 	TaskID         string      `bson:"task_id" json:"task_id"`
 	InterfaceName  string      `bson:"interface_name" json:"interface_name"`
 	IPRange        string      `bson:"ip_range" json:"ip_range"`
+	IPStart        int64       `bson:"ip_start,omitempty" json:"-"`
+	IPEnd          int64       `bson:"ip_end,omitempty" json:"-"`
 	Status         string      `bson:"status" json:"status"`
-	Devices        []ARPDevice `bson:"devices" json:"devices"`
+	Devices        []ARPDevice `bson:"devices,omitempty" json:"devices,omitempty"`
 	OnlineDevices  []ARPDevice `bson:"online_devices" json:"online_devices"`
 	OfflineDevices []ARPDevice `bson:"offline_devices" json:"offline_devices"`
 	TotalCount     int         `bson:"total_count" json:"total_count"`
 	OnlineCount    int         `bson:"online_count" json:"online_count"`
 	OfflineCount   int         `bson:"offline_count" json:"offline_count"`
 	Error          string      `bson:"error,omitempty" json:"error,omitempty"`
+	Agent          *ScanAgent  `bson:"agent,omitempty" json:"agent,omitempty"`
+	Attempts       []ScanAttempt `bson:"attempts,omitempty" json:"attempts,omitempty"`
 	CreatedAt      time.Time   `bson:"created_at" json:"created_at"`
 }
 
@@ -27,10 +35,14 @@ type ICMPHistoryRecord struct {
 	ScanType  string       `bson:"scan_type"  json:"-"`
 	TaskID    string       `bson:"task_id" json:"task_id"`
 	Targets   []string     `bson:"targets" json:"targets"`
+	IPStart   int64        `bson:"ip_start,omitempty" json:"-"`
+	IPEnd     int64        `bson:"ip_end,omitempty" json:"-"`
 	PingCount int          `bson:"ping_count" json:"ping_count"`
 	Status    string       `bson:"status" json:"status"`
 	Results   []ICMPResult `bson:"results" json:"results"`
 	Error     string       `bson:"error,omitempty" json:"error,omitempty"`
+	Agent     *ScanAgent   `bson:"agent,omitempty" json:"agent,omitempty"`
+	Attempts  []ScanAttempt `bson:"attempts,omitempty" json:"attempts,omitempty"`
 	CreatedAt time.Time    `bson:"created_at" json:"created_at"`
 }
 
@@ -42,9 +54,17 @@ type NmapTcpUdpHistoryRecord struct {
 	ScannerType string               `bson:"scanner_type" json:"scanner_type"`
 	Ports       string               `bson:"ports" json:"ports"`
 	Host        string               `bson:"host" json:"host"`
+	IPStart     int64                `bson:"ip_start,omitempty" json:"-"`
+	IPEnd       int64                `bson:"ip_end,omitempty" json:"-"`
+	IPNum       int64                `bson:"ip_num,omitempty" json:"-"`
 	PortInfo    []NmapPortTcpUdpInfo `bson:"port_info" json:"port_info"`
+	MAC         string               `bson:"mac,omitempty" json:"mac,omitempty"`
+	MACVendor   string               `bson:"mac_vendor,omitempty" json:"mac_vendor,omitempty"`
 	Status      string               `bson:"status" json:"status"`
 	Error       string               `bson:"error,omitempty" json:"error,omitempty"`
+	Source      string               `bson:"source,omitempty" json:"source,omitempty"`
+	Agent       *ScanAgent           `bson:"agent,omitempty" json:"agent,omitempty"`
+	Attempts    []ScanAttempt        `bson:"attempts,omitempty" json:"attempts,omitempty"`
 	CreatedAt   time.Time            `bson:"created_at" json:"created_at"`
 }
 
@@ -54,6 +74,9 @@ type NmapOsDetectionHistoryRecord struct {
 	TaskID    string    `bson:"task_id" json:"task_id"`
 	IP        string    `bson:"ip" json:"ip"`
 	Host      string    `bson:"host" json:"host"`
+	IPStart   int64     `bson:"ip_start,omitempty" json:"-"`
+	IPEnd     int64     `bson:"ip_end,omitempty" json:"-"`
+	IPNum     int64     `bson:"ip_num,omitempty" json:"-"`
 	Name      string    `bson:"name" json:"name"`
 	Accuracy  int       `bson:"accuracy" json:"accuracy"`
 	Vendor    string    `bson:"vendor" json:"vendor"`
@@ -61,6 +84,9 @@ type NmapOsDetectionHistoryRecord struct {
 	Type      string    `bson:"type" json:"type"`
 	Status    string    `bson:"status" json:"status"`
 	Error     string    `bson:"error,omitempty" json:"error,omitempty"`
+	Source    string    `bson:"source,omitempty" json:"source,omitempty"`
+	Agent     *ScanAgent `bson:"agent,omitempty" json:"agent,omitempty"`
+	Attempts  []ScanAttempt `bson:"attempts,omitempty" json:"attempts,omitempty"`
 	CreatedAt time.Time `bson:"created_at" json:"created_at"`
 }
 
@@ -70,12 +96,17 @@ type NmapHostDiscoveryHistoryRecord struct {
 	TaskID    string    `bson:"task_id" json:"task_id"`
 	IP        string    `bson:"ip" json:"ip"`
 	Host      string    `bson:"host" json:"host"`
+	IPStart   int64     `bson:"ip_start,omitempty" json:"-"`
+	IPEnd     int64     `bson:"ip_end,omitempty" json:"-"`
+	IPNum     int64     `bson:"ip_num,omitempty" json:"-"`
 	HostUP    int       `bson:"host_up" json:"host_up"`
 	HostTotal int       `bson:"host_total" json:"host_total"`
 	Status    string    `bson:"status" json:"status"`
 	DNS       string    `bson:"dns" json:"dns"`
 	Reason    string    `bson:"reason" json:"reason"`
 	Error     string    `bson:"error,omitempty" json:"error,omitempty"`
+	Agent     *ScanAgent `bson:"agent,omitempty" json:"agent,omitempty"`
+	Attempts  []ScanAttempt `bson:"attempts,omitempty" json:"attempts,omitempty"`
 	CreatedAt time.Time `bson:"created_at" json:"created_at"`
 }
 
@@ -84,11 +115,14 @@ type TCPHistoryRecord struct {
 	ScanType     string    `bson:"scan_type"  json:"-"`
 	TaskID       string    `bson:"task_id" json:"task_id"`
 	Host         string    `bson:"host" json:"host"`
+	IPNum        int64     `bson:"ip_num,omitempty" json:"-"`
 	Port         string    `bson:"port" json:"port"`
 	HexObjectKey string    `bson:"hex_object_key" json:"hex_object_key"`
 	DecodedText  string    `bson:"decoded_text" json:"decoded_text"`
 	Status       string    `bson:"status" json:"status"`
 	Error        string    `bson:"error,omitempty" json:"error,omitempty"`
+	Agent        *ScanAgent `bson:"agent,omitempty" json:"agent,omitempty"`
+	Attempts     []ScanAttempt `bson:"attempts,omitempty" json:"attempts,omitempty"`
 	CreatedAt    time.Time `bson:"created_at" json:"created_at"`
 }
 
@@ -98,3 +132,73 @@ type HistoryResponse struct {
 	Error   string      `json:"error,omitempty"`
 	Count   int         `json:"count,omitempty"`
 }
+
+// HistoryDeleteFilter selects history records for selective deletion.
+// Zero-valued fields are ignored; at least one of them must be set.
+//
+// Target is matched against the field that identifies what was scanned:
+// ip_range for ARP, targets for ICMP, ip for Nmap and host for TCP.
+type HistoryDeleteFilter struct {
+	ID        string
+	Target    string
+	OlderThan time.Time
+}
+
+// HistoryDailySummary is what remains of raw per-scan documents once they
+// fall outside the retention window: one document per scan type, target
+// and UTC day.
+type HistoryDailySummary struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	ScanType  string    `bson:"scan_type" json:"scan_type"`
+	Target    string    `bson:"target" json:"target"`
+	Day       time.Time `bson:"day" json:"day"`
+	ScanCount int       `bson:"scan_count" json:"scan_count"`
+	Completed int       `bson:"completed" json:"completed"`
+	Failed    int       `bson:"failed" json:"failed"`
+	FirstScan time.Time `bson:"first_scan" json:"first_scan"`
+	LastScan  time.Time `bson:"last_scan" json:"last_scan"`
+}
+
+// HistoryScanTypes lists the scan-type names accepted by the selective
+// deletion, retention and summary APIs.
+var HistoryScanTypes = []string{
+	"arp",
+	"icmp",
+	"nmap_tcp_udp",
+	"nmap_os_detection",
+	"nmap_host_discovery",
+	"tcp",
+}
+
+// HistoryQuery narrows history listings and exports.
+// Zero-valued fields are ignored.
+type HistoryQuery struct {
+	Target string
+	Since  time.Time
+	Until  time.Time
+	Limit  int
+}
+
+// ARPSearchQuery selects ARP history records; zero-valued fields are
+// ignored. IPRange is an address, CIDR or range and matches scans whose
+// range overlaps it. Interface is matched exactly. MACPrefix and Vendor
+// must match the same device.
+type ARPSearchQuery struct {
+	IPRange   string
+	Interface string
+	MACPrefix string // any notation: 00:1b:54, 00-1B-54, 001b54
+	Vendor    string // case-insensitive substring
+}
+
+// HistorySourceImport marks records created from imported Nmap / masscan
+// output rather than from a scan run by this system.
+const HistorySourceImport = "import"
+
+// ImportResult summarises one import run.
+type ImportResult struct {
+	Format           string `json:"format"`
+	Hosts            int    `json:"hosts"`
+	TcpUdpRecords    int    `json:"tcp_udp_records"`
+	OsDetectionCount int    `json:"os_detection_records"`
+	Skipped          int    `json:"skipped"`
+}
@@ -0,0 +1,198 @@
+package models
+
+import (
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// IPv4 addresses are stored next to their string form as integers
+// (ip_num, ip_start/ip_end), so history can be searched by range with
+// ordinary indexed comparisons. IPv6 and host names are not normalized.
+
+// IPv4ToNum converts a dotted-quad IPv4 address to its integer value.
+func IPv4ToNum(s string) (int64, bool) {
+	ip := net.ParseIP(strings.TrimSpace(s)).To4()
+	if ip == nil {
+		return 0, false
+	}
+	return int64(ip[0])<<24 | int64(ip[1])<<16 | int64(ip[2])<<8 | int64(ip[3]), true
+}
+
+// ParseIPv4Range returns the inclusive numeric bounds of an IPv4 target in
+// any of the forms scans are started with:
+//
+//	10.0.5.7                 single address
+//	10.0.5.0/24              CIDR
+//	10.0.5.1-10.0.5.254      address range
+//	10.0.5.1-254, 10.0.*.1   nmap octet ranges and wildcards
+//	10.0.5.1,10.0.7.0/24     comma/space separated lists (bounding range)
+//
+// Octet ranges and lists yield the smallest range covering the target; it
+// is what records store as ip_start/ip_end. Searches use ParseIPv4Intervals,
+// which does not match the gaps in between.
+func ParseIPv4Range(s string) (lo, hi int64, ok bool) {
+	parts := splitIPv4Target(s)
+	if len(parts) == 0 {
+		return 0, 0, false
+	}
+	for i, part := range parts {
+		a, b, valid := parseIPv4RangePart(part)
+		if !valid {
+			return 0, 0, false
+		}
+		if i == 0 || a < lo {
+			lo = a
+		}
+		if i == 0 || b > hi {
+			hi = b
+		}
+	}
+	return lo, hi, true
+}
+
+// IPv4Interval is an inclusive range of numeric IPv4 addresses.
+type IPv4Interval struct {
+	Lo, Hi int64
+}
+
+// MaxIPv4Intervals bounds the intervals a target may expand to, e.g.
+// 10.0.*.1 expands to 256 single addresses.
+const MaxIPv4Intervals = 1024
+
+// ParseIPv4Intervals returns the exact addresses of a target in the forms
+// accepted by ParseIPv4Range, as sorted, non-overlapping intervals: each
+// list entry is kept apart and octet ranges are expanded, so
+// 10.0.0.1,10.255.0.1 is two addresses rather than everything in between.
+// Targets expanding to more than MaxIPv4Intervals are rejected.
+func ParseIPv4Intervals(s string) ([]IPv4Interval, bool) {
+	parts := splitIPv4Target(s)
+	if len(parts) == 0 {
+		return nil, false
+	}
+	var intervals []IPv4Interval
+	for _, part := range parts {
+		if strings.Contains(part, "/") || strings.Count(part, ".") > 3 {
+			lo, hi, ok := parseIPv4RangePart(part)
+			if !ok {
+				return nil, false
+			}
+			intervals = append(intervals, IPv4Interval{lo, hi})
+		} else {
+			octets, ok := parseIPv4Octets(part)
+			if !ok {
+				return nil, false
+			}
+			intervals = expandIPv4Octets(intervals, octets)
+		}
+		if len(intervals) > MaxIPv4Intervals {
+			return nil, false
+		}
+	}
+
+	sort.Slice(intervals, func(i, j int) bool { return intervals[i].Lo < intervals[j].Lo })
+	merged := intervals[:1]
+	for _, iv := range intervals[1:] {
+		last := &merged[len(merged)-1]
+		if iv.Lo <= last.Hi+1 {
+			if iv.Hi > last.Hi {
+				last.Hi = iv.Hi
+			}
+			continue
+		}
+		merged = append(merged, iv)
+	}
+	return merged, true
+}
+
+func splitIPv4Target(s string) []string {
+	return strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == ' ' || r == ';' })
+}
+
+// expandIPv4Octets appends the intervals of an nmap-style target: the
+// octets after the last partial one are contiguous, so there is one
+// interval per combination of the octets before it.
+func expandIPv4Octets(intervals []IPv4Interval, octets [4][2]int64) []IPv4Interval {
+	last := 3
+	for last > 0 && octets[last][0] == 0 && octets[last][1] == 255 {
+		last--
+	}
+	var walk func(i int, prefix int64)
+	walk = func(i int, prefix int64) {
+		if len(intervals) > MaxIPv4Intervals {
+			return
+		}
+		if i == last {
+			lo, hi := prefix<<8|octets[i][0], prefix<<8|octets[i][1]
+			for j := i + 1; j < 4; j++ {
+				lo, hi = lo<<8, hi<<8|255
+			}
+			intervals = append(intervals, IPv4Interval{lo, hi})
+			return
+		}
+		for v := octets[i][0]; v <= octets[i][1]; v++ {
+			walk(i+1, prefix<<8|v)
+		}
+	}
+	walk(0, 0)
+	return intervals
+}
+
+func parseIPv4RangePart(s string) (int64, int64, bool) {
+	if strings.Contains(s, "/") {
+		_, ipNet, err := net.ParseCIDR(s)
+		if err != nil || ipNet.IP.To4() == nil {
+			return 0, 0, false
+		}
+		lo, _ := IPv4ToNum(ipNet.IP.String())
+		ones, _ := ipNet.Mask.Size()
+		return lo, lo | (1<<(32-ones) - 1), true
+	}
+
+	if from, to, found := strings.Cut(s, "-"); found {
+		if a, okA := IPv4ToNum(from); okA {
+			if b, okB := IPv4ToNum(to); okB && a <= b {
+				return a, b, true
+			}
+		}
+	}
+
+	octets, ok := parseIPv4Octets(s)
+	if !ok {
+		return 0, 0, false
+	}
+	var lo, hi int64
+	for _, octet := range octets {
+		lo = lo<<8 | octet[0]
+		hi = hi<<8 | octet[1]
+	}
+	return lo, hi, true
+}
+
+// parseIPv4Octets parses an nmap-style target, where every octet is n, a-b
+// or *, into the bounds of each octet.
+func parseIPv4Octets(s string) ([4][2]int64, bool) {
+	var out [4][2]int64
+	octets := strings.Split(s, ".")
+	if len(octets) != 4 {
+		return out, false
+	}
+	for i, octet := range octets {
+		a, b := 0, 255
+		if octet != "*" {
+			from, to, isRange := strings.Cut(octet, "-")
+			var errA, errB error
+			a, errA = strconv.Atoi(from)
+			b = a
+			if isRange {
+				b, errB = strconv.Atoi(to)
+			}
+			if errA != nil || errB != nil || a < 0 || b > 255 || a > b {
+				return out, false
+			}
+		}
+		out[i] = [2]int64{int64(a), int64(b)}
+	}
+	return out, true
+}
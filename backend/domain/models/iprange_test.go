@@ -0,0 +1,103 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+)
+
+func ip(t *testing.T, s string) int64 {
+	t.Helper()
+	n, ok := IPv4ToNum(s)
+	if !ok {
+		t.Fatalf("bad address %q", s)
+	}
+	return n
+}
+
+func TestParseIPv4Range(t *testing.T) {
+	tests := []struct {
+		target string
+		lo, hi string
+	}{
+		{"10.0.5.7", "10.0.5.7", "10.0.5.7"},
+		{"10.0.5.0/24", "10.0.5.0", "10.0.5.255"},
+		{"10.0.5.1-10.0.5.254", "10.0.5.1", "10.0.5.254"},
+		{"10.0.5.1-254", "10.0.5.1", "10.0.5.254"},
+		{"10.0.*.1", "10.0.0.1", "10.0.255.1"},
+		{"10.0.5.1,10.0.7.0/24", "10.0.5.1", "10.0.7.255"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			lo, hi, ok := ParseIPv4Range(tt.target)
+			if !ok {
+				t.Fatal("not parsed")
+			}
+			if lo != ip(t, tt.lo) || hi != ip(t, tt.hi) {
+				t.Errorf("got %d-%d, want %s-%s", lo, hi, tt.lo, tt.hi)
+			}
+		})
+	}
+}
+
+func TestParseIPv4Intervals(t *testing.T) {
+	tests := []struct {
+		target string
+		want   [][2]string
+	}{
+		{"10.0.5.7", [][2]string{{"10.0.5.7", "10.0.5.7"}}},
+		{"10.0.5.0/24", [][2]string{{"10.0.5.0", "10.0.5.255"}}},
+		{"10.0.5.1-10.0.5.254", [][2]string{{"10.0.5.1", "10.0.5.254"}}},
+		{"10.0.5.1-254", [][2]string{{"10.0.5.1", "10.0.5.254"}}},
+		{"10.0.*.*", [][2]string{{"10.0.0.0", "10.0.255.255"}}},
+		{"10.0.1-2.*", [][2]string{{"10.0.1.0", "10.0.2.255"}}},
+		{"10.0.0.1,10.255.0.1", [][2]string{{"10.0.0.1", "10.0.0.1"}, {"10.255.0.1", "10.255.0.1"}}},
+		{"10.0.7.0/24 10.0.5.1", [][2]string{{"10.0.5.1", "10.0.5.1"}, {"10.0.7.0", "10.0.7.255"}}},
+		{"10.0.0.0/25,10.0.0.128/25", [][2]string{{"10.0.0.0", "10.0.0.255"}}},
+		{"10.0-1.5.1", [][2]string{{"10.0.5.1", "10.0.5.1"}, {"10.1.5.1", "10.1.5.1"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			got, ok := ParseIPv4Intervals(tt.target)
+			if !ok {
+				t.Fatal("not parsed")
+			}
+			var want []IPv4Interval
+			for _, iv := range tt.want {
+				want = append(want, IPv4Interval{ip(t, iv[0]), ip(t, iv[1])})
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestParseIPv4IntervalsOctetWildcard(t *testing.T) {
+	got, ok := ParseIPv4Intervals("10.0.*.1")
+	if !ok {
+		t.Fatal("not parsed")
+	}
+	if len(got) != 256 {
+		t.Fatalf("got %d intervals, want 256 single addresses", len(got))
+	}
+	for i, iv := range got {
+		if iv.Lo != iv.Hi || iv.Lo != ip(t, "10.0.0.1")+int64(i)<<8 {
+			t.Fatalf("interval %d = %v", i, iv)
+		}
+	}
+}
+
+func TestParseIPv4IntervalsInvalid(t *testing.T) {
+	for _, target := range []string{
+		"",
+		"host.example",
+		"10.0.5.0/33",
+		"10.0.5.300",
+		"10.0.5.9-10.0.5.1",
+		"10.*.*.1", // 65536 intervals
+	} {
+		if got, ok := ParseIPv4Intervals(target); ok {
+			t.Errorf("%q: got %v, want rejected", target, got)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package models
+
+import "fmt"
+
+// Query is a parsed search query such as
+//
+//	port:22 state:open vendor:"Cisco" os.family:Linux net:10.1.0.0/16 seen:<7d
+//
+// Terms are AND-ed; the comma-separated values of one term are OR-ed.
+type Query struct {
+	Raw   string      `json:"raw"`
+	Terms []QueryTerm `json:"terms"`
+	Text  []string    `json:"text,omitempty"` // bare words, matched against each scope's text fields
+}
+
+// QueryTerm is one field:value clause. Op is ":" for a plain match or one
+// of "<", "<=", ">", ">=" for number and time fields.
+type QueryTerm struct {
+	Field  string   `json:"field"`
+	Op     string   `json:"op"`
+	Values []string `json:"values"`
+	Negate bool     `json:"negate,omitempty"`
+}
+
+// QueryError is returned for queries that cannot be parsed or compiled.
+type QueryError struct {
+	Field string
+	Msg   string
+}
+
+func (e *QueryError) Error() string {
+	if e.Field == "" {
+		return e.Msg
+	}
+	return fmt.Sprintf("%s: %s", e.Field, e.Msg)
+}
+
+// QueryScopeResult holds the matches of a query in one scope. For a query
+// joined across scopes, Terms are the terms run in this scope and the
+// records are limited to the hosts matching every part.
+type QueryScopeResult struct {
+	Scope   string      `json:"scope"`
+	Terms   []QueryTerm `json:"terms,omitempty"`
+	Count   int         `json:"count"`
+	Records interface{} `json:"records"`
+}
+
+// Query scopes: the collections a query can run against.
+const (
+	QueryScopeNmap      = "nmap"      // nmap_tcp_udp history
+	QueryScopeOS        = "os"        // nmap_os_detection history
+	QueryScopeARP       = "arp"       // ARP history
+	QueryScopeTCP       = "tcp"       // TCP banner history
+	QueryScopeInventory = "inventory" // L3 device inventory
+)
+
+var QueryScopes = []string{QueryScopeNmap, QueryScopeOS, QueryScopeARP, QueryScopeTCP, QueryScopeInventory}
+
+// Query field types; they decide which operators and value syntax apply.
+const (
+	QueryTypeString = "string" // case-insensitive substring, * wildcard
+	QueryTypeEnum   = "enum"   // case-insensitive exact match
+	QueryTypeNumber = "number" // exact, a-b range or comparison
+	QueryTypeIP     = "ip"     // exact address, * wildcard
+	QueryTypeCIDR   = "cidr"   // IPv4 network
+	QueryTypeMAC    = "mac"    // exact address, * wildcard
+	QueryTypeTime   = "time"   // age (7d, 12h) or date, with comparison
+)
+
+// QueryField describes one query field for validation and autocomplete.
+type QueryField struct {
+	Name        string   `json:"name"`
+	Aliases     []string `json:"aliases,omitempty"`
+	Type        string   `json:"type"`
+	Description string   `json:"description"`
+	Scopes      []string `json:"scopes"`
+	Operators   []string `json:"operators"`
+	Values      []string `json:"values,omitempty"` // known values for enum fields
+	Example     string   `json:"example"`
+}
+
+var (
+	queryOpsMatch   = []string{":"}
+	queryOpsCompare = []string{":", "<", "<=", ">", ">="}
+)
+
+// QueryFields is the catalogue of fields understood by the query language.
+var QueryFields = []QueryField{
+	{Name: "ip", Aliases: []string{"host"}, Type: QueryTypeIP, Scopes: QueryScopes, Operators: queryOpsMatch,
+		Description: "Host IP address; * matches any suffix", Example: "ip:10.1.2.*"},
+	{Name: "net", Type: QueryTypeCIDR, Scopes: QueryScopes, Operators: queryOpsMatch,
+		Description: "Host IP inside an IPv4 network or range", Example: "net:10.1.0.0/16"},
+	{Name: "port", Type: QueryTypeNumber, Scopes: []string{QueryScopeNmap, QueryScopeTCP}, Operators: queryOpsCompare,
+		Description: "Port number, list or range", Example: "port:22,80,8000-8100"},
+	{Name: "state", Type: QueryTypeEnum, Scopes: []string{QueryScopeNmap}, Operators: queryOpsMatch,
+		Values:      []string{"open", "closed", "filtered", "unfiltered", "open|filtered", "closed|filtered"},
+		Description: "Nmap port state; applies to the same port as port/service/proto", Example: "state:open"},
+	{Name: "service", Type: QueryTypeString, Scopes: []string{QueryScopeNmap}, Operators: queryOpsMatch,
+		Description: "Nmap service name", Example: "service:ssh"},
+	{Name: "proto", Aliases: []string{"protocol"}, Type: QueryTypeEnum, Scopes: []string{QueryScopeNmap}, Operators: queryOpsMatch,
+		Values: []string{"tcp", "udp", "sctp"}, Description: "Port protocol", Example: "proto:udp"},
+	{Name: "mac", Type: QueryTypeMAC, Scopes: []string{QueryScopeNmap, QueryScopeARP, QueryScopeInventory}, Operators: queryOpsMatch,
+		Description: "MAC address; * matches any suffix", Example: "mac:00:1b:54:*"},
+	{Name: "vendor", Type: QueryTypeString, Scopes: []string{QueryScopeNmap, QueryScopeARP, QueryScopeInventory}, Operators: queryOpsMatch,
+		Description: "Hardware vendor derived from the MAC address", Example: `vendor:"Cisco"`},
+	{Name: "os.name", Aliases: []string{"os"}, Type: QueryTypeString, Scopes: []string{QueryScopeOS}, Operators: queryOpsMatch,
+		Description: "Best OS match name", Example: `os:"Windows Server"`},
+	{Name: "os.family", Type: QueryTypeString, Scopes: []string{QueryScopeOS}, Operators: queryOpsMatch,
+		Description: "OS family", Example: "os.family:Linux"},
+	{Name: "os.vendor", Type: QueryTypeString, Scopes: []string{QueryScopeOS}, Operators: queryOpsMatch,
+		Description: "OS vendor", Example: "os.vendor:Microsoft"},
+	{Name: "os.type", Type: QueryTypeString, Scopes: []string{QueryScopeOS}, Operators: queryOpsMatch,
+		Description: "Device type reported with the OS match", Example: `os.type:"general purpose"`},
+	{Name: "os.accuracy", Type: QueryTypeNumber, Scopes: []string{QueryScopeOS}, Operators: queryOpsCompare,
+		Description: "OS match accuracy in percent", Example: "os.accuracy:>=90"},
+	{Name: "banner", Type: QueryTypeString, Scopes: []string{QueryScopeTCP}, Operators: queryOpsMatch,
+		Description: "Decoded TCP banner text", Example: `banner:"OpenSSH_7"`},
+	{Name: "iface", Aliases: []string{"interface"}, Type: QueryTypeString, Scopes: []string{QueryScopeARP}, Operators: queryOpsMatch,
+		Description: "Interface the ARP scan ran on", Example: "iface:eth0"},
+	{Name: "status", Type: QueryTypeEnum, Scopes: []string{QueryScopeNmap, QueryScopeOS, QueryScopeARP, QueryScopeTCP}, Operators: queryOpsMatch,
+		Values: []string{"completed", "failed", "up", "down"}, Description: "Scan status", Example: "status:completed"},
+	{Name: "source", Type: QueryTypeEnum, Scopes: []string{QueryScopeNmap, QueryScopeOS}, Operators: queryOpsMatch,
+		Values: []string{HistorySourceImport}, Description: "Record origin; import for imported Nmap/masscan output", Example: "source:import"},
+	{Name: "task", Aliases: []string{"task_id"}, Type: QueryTypeEnum, Scopes: []string{QueryScopeNmap, QueryScopeOS, QueryScopeARP, QueryScopeTCP}, Operators: queryOpsMatch,
+		Description: "Scan task ID", Example: "task:import-8c2190a75687-10.0.0.1"},
+	{Name: "seen", Type: QueryTypeTime, Scopes: QueryScopes, Operators: queryOpsCompare,
+		Description: "Scan time (last_seen for inventory): <7d is newer than 7 days, >2024-01-01 is after that date", Example: "seen:<7d"},
+}
+
+// LookupQueryField resolves a field name or alias.
+func LookupQueryField(name string) (QueryField, bool) {
+	for _, f := range QueryFields {
+		if f.Name == name {
+			return f, true
+		}
+		for _, alias := range f.Aliases {
+			if alias == name {
+				return f, true
+			}
+		}
+	}
+	return QueryField{}, false
+}
+
+// Supports reports whether the field is available in scope.
+func (f QueryField) Supports(scope string) bool {
+	for _, s := range f.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
@@ -1,13 +1,59 @@
 package models
 
+import "time"
+
+// Request is a scan request from a client. Agent optionally names the site
+// to scan from; otherwise the agent whose subnets contain the target is
+// picked. Priority is the lane the scan runs in, interactive by default.
 type Request struct {
 	ScannerService string `json:"scanner_service"`
+	Agent          string `json:"agent,omitempty"`
+	Priority       string `json:"priority,omitempty"`
 	Options        any    `json:"options"`
 }
 
+// Priority lanes. Interactive scans are started before scheduled ones and
+// scheduled before bulk, both by the backend's scan limiter and, through
+// message priorities, on the scanner queues.
+const (
+	PriorityInteractive = "interactive"
+	PriorityScheduled   = "scheduled"
+	PriorityBulk        = "bulk"
+)
+
+// Priorities lists the lanes from most to least urgent.
+var Priorities = []string{PriorityInteractive, PriorityScheduled, PriorityBulk}
+
+// PriorityRank orders lanes: 0 is the most urgent. ok is false for
+// unknown lanes.
+func PriorityRank(priority string) (rank int, ok bool) {
+	for i, p := range Priorities {
+		if p == priority {
+			return i, true
+		}
+	}
+	return len(Priorities), false
+}
+
+// Response is the outcome of a scan. Attempts lists the earlier attempts
+// that failed with a retryable error before Result was produced.
 type Response struct {
-	TaskID string `json:"task_id"`
-	Result any    `json:"result"`
+	TaskID   string        `json:"task_id"`
+	Agent    *ScanAgent    `json:"agent,omitempty"`
+	Attempts []ScanAttempt `json:"attempts,omitempty"`
+	Result   any           `json:"result"`
+}
+
+// ScanAttempt is a failed attempt of a scan that was retried: the error,
+// its retry class, the instance that reported it and the backoff before
+// the next attempt.
+type ScanAttempt struct {
+	Attempt    int        `bson:"attempt" json:"attempt"`
+	Error      string     `bson:"error" json:"error"`
+	Class      string     `bson:"class" json:"class"`
+	Agent      *ScanAgent `bson:"agent,omitempty" json:"agent,omitempty"`
+	FailedAt   time.Time  `bson:"failed_at" json:"failed_at"`
+	RetryAfter string     `bson:"retry_after" json:"retry_after"`
 }
 
 type ARPRequest struct {
@@ -26,6 +72,9 @@ type ARPResponse struct {
 	OnlineCount    int         `json:"online_count"`
 	OfflineCount   int         `json:"offline_count"`
 	Error          string      `json:"error,omitempty"`
+	// Chunks is the number of chunks the devices were streamed in. Streamed
+	// results only list the online devices; offline ones are counted.
+	Chunks int `json:"chunks,omitempty"`
 }
 
 type ARPDevice struct {
@@ -33,6 +82,7 @@ type ARPDevice struct {
 	MAC    string `json:"mac"`
 	Vendor string `json:"vendor,omitempty"`
 	Status string `json:"status"`
+	IPNum  int64  `bson:"ip_num,omitempty" json:"-"`
 }
 
 type ICMPRequest struct {
@@ -46,6 +96,34 @@ type ICMPResponse struct {
 	Status  string       `json:"status"`
 	Results []ICMPResult `json:"results"`
 	Error   string       `json:"error,omitempty"`
+	Chunks  int          `json:"chunks,omitempty"`
+}
+
+// ResultChunk is part of a result streamed by a scanner while it runs:
+// the devices or results found since the previous chunk, and how many of
+// the targets are done.
+type ResultChunk struct {
+	TaskID  string       `json:"task_id"`
+	Seq     int          `json:"seq"`
+	Done    int          `json:"done"`
+	Total   int          `json:"total"`
+	Devices []ARPDevice  `json:"devices,omitempty"`
+	Results []ICMPResult `json:"results,omitempty"`
+}
+
+// ScanProgress is a progress event of a running scan: targets done out of
+// total (0 when the scanner only knows a percentage, as nmap does), the
+// target last finished, and the time taken so far and estimated to go.
+type ScanProgress struct {
+	TaskID    string    `json:"task_id"`
+	Service   string    `json:"service"`
+	Done      int       `json:"done"`
+	Total     int       `json:"total"`
+	Percent   float64   `json:"percent"`
+	Current   string    `json:"current,omitempty"`
+	ElapsedMs int64     `json:"elapsed_ms"`
+	EtaMs     int64     `json:"eta_ms,omitempty"`
+	At        time.Time `json:"at"`
 }
 
 type ICMPResult struct {
@@ -55,6 +133,7 @@ type ICMPResult struct {
 	PacketsReceived   int     `json:"packets_received"`
 	PacketLossPercent float64 `json:"packet_loss_percent"`
 	Error             string  `json:"error,omitempty"`
+	IPNum             int64   `bson:"ip_num,omitempty" json:"-"`
 }
 
 type NmapRequest struct {
@@ -135,3 +214,9 @@ type TCPResponse struct {
 	Status       string `json:"status"`
 	Error        string `json:"error,omitempty"`
 }
+
+// TCPPurgeRequest asks the TCP scanner to remove raw banner objects from
+// MinIO after the history records referencing them were deleted.
+type TCPPurgeRequest struct {
+	ObjectKeys []string `json:"object_keys"`
+}
@@ -0,0 +1,122 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ScannerHeartbeat is published periodically by every scanner instance on
+// the scanner_heartbeats exchange. Service is the RPC queue the instance
+// consumes, e.g. "nmap_service".
+type ScannerHeartbeat struct {
+	InstanceID      string             `json:"instance_id"`
+	Service         string             `json:"service"`
+	Version         string             `json:"version"`
+	Hostname        string             `json:"hostname"`
+	Site            string             `json:"site,omitempty"`
+	Subnets         []string           `json:"subnets"`
+	Interfaces      []ScannerInterface `json:"interfaces"`
+	ScanMethods     []string           `json:"scan_methods"`
+	OptionsSchema   json.RawMessage    `json:"options_schema,omitempty"`
+	ResultSchema    json.RawMessage    `json:"result_schema,omitempty"`
+	Collection      string             `json:"collection,omitempty"`
+	IntervalSeconds float64            `json:"interval_seconds"`
+	StartedAt       time.Time          `json:"started_at"`
+	SentAt          time.Time          `json:"sent_at"`
+}
+
+// ScanAgent identifies the scanner instance that produced a result. Site
+// is empty for instances consuming the shared scanner queue.
+type ScanAgent struct {
+	Site       string `bson:"site,omitempty" json:"site,omitempty"`
+	InstanceID string `bson:"instance_id" json:"instance_id"`
+	Hostname   string `bson:"hostname" json:"hostname"`
+}
+
+type ScannerInterface struct {
+	Name  string   `json:"name"`
+	MAC   string   `json:"mac,omitempty"`
+	Addrs []string `json:"addrs,omitempty"`
+}
+
+// ScannerInstance is the last heartbeat of an instance and when the
+// backend received it.
+type ScannerInstance struct {
+	ScannerHeartbeat
+	LastSeen time.Time `json:"last_seen"`
+	Live     bool      `json:"live"`
+}
+
+// ScannerInfo groups the known instances of one scanner service. The scan
+// methods and options schema are those of the instance heard from last.
+type ScannerInfo struct {
+	Service       string            `json:"service"`
+	Live          bool              `json:"live"`
+	LiveInstances int               `json:"live_instances"`
+	Sites         []string          `json:"sites"`
+	ScanMethods   []string          `json:"scan_methods"`
+	OptionsSchema json.RawMessage   `json:"options_schema,omitempty"`
+	ResultSchema  json.RawMessage   `json:"result_schema,omitempty"`
+	Collection    string            `json:"collection,omitempty"`
+	Builtin       bool              `json:"builtin"`
+	Instances     []ScannerInstance `json:"instances"`
+}
+
+// SiteQueue is the queue, and routing key on the request exchange, of
+// service's instances tagged with site.
+func SiteQueue(service, site string) string {
+	return service + "." + site
+}
+
+// BuiltinScanners are the services with typed request, response and
+// history handling. Any other service advertising itself through
+// heartbeats is served as a plug-in from its metadata.
+var BuiltinScanners = map[string]bool{
+	"arp_service":  true,
+	"icmp_service": true,
+	"ping_service": true,
+	"nmap_service": true,
+	"tcp_service":  true,
+}
+
+// ScannerPlugin is the contract a plug-in scanner advertises: requests go
+// to Queue and must match OptionsSchema; replies should match
+// ResultSchema and are stored in Collection.
+type ScannerPlugin struct {
+	Service       string          `json:"service"`
+	Queue         string          `json:"queue"`
+	ScanMethods   []string        `json:"scan_methods"`
+	OptionsSchema json.RawMessage `json:"options_schema,omitempty"`
+	ResultSchema  json.RawMessage `json:"result_schema,omitempty"`
+	Collection    string          `json:"collection"`
+}
+
+// PluginResponse is the reply of a plug-in scanner. Result holds the
+// decoded reply body; Options the request it answers. Collection and
+// ResultSchema come from the plug-in and are not sent to clients.
+type PluginResponse struct {
+	TaskID       string                 `json:"task_id"`
+	Service      string                 `json:"service"`
+	Status       string                 `json:"status"`
+	Error        string                 `json:"error,omitempty"`
+	Result       map[string]interface{} `json:"result"`
+	Options      map[string]interface{} `json:"-"`
+	Collection   string                 `json:"-"`
+	ResultSchema json.RawMessage        `json:"-"`
+}
+
+// PluginHistoryRecord is one stored plug-in result.
+type PluginHistoryRecord struct {
+	ID        primitive.ObjectID     `bson:"_id,omitempty" json:"id"`
+	TaskID    string                 `bson:"task_id" json:"task_id"`
+	Service   string                 `bson:"service" json:"service"`
+	Status    string                 `bson:"status" json:"status"`
+	Error     string                 `bson:"error,omitempty" json:"error,omitempty"`
+	Agent     *ScanAgent             `bson:"agent,omitempty" json:"agent,omitempty"`
+	Attempts  []ScanAttempt          `bson:"attempts,omitempty" json:"attempts,omitempty"`
+	Request   map[string]interface{} `bson:"request" json:"request"`
+	Result    map[string]interface{} `bson:"result" json:"result"`
+	CreatedAt time.Time              `bson:"created_at" json:"created_at"`
+}
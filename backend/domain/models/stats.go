@@ -0,0 +1,98 @@
+package models
+
+import "time"
+
+// StatsFilter narrows the dashboard aggregations. Since/Until bound the
+// scan time, Subnet (an address, CIDR or range) restricts hosts to an IPv4
+// network. Bucket is the width of time-series points ("hour" or "day"),
+// Limit caps top-N lists.
+type StatsFilter struct {
+	Since  time.Time `json:"since"`
+	Until  time.Time `json:"until"`
+	Subnet string    `json:"subnet,omitempty"`
+	Bucket string    `json:"bucket,omitempty"`
+	Limit  int       `json:"limit,omitempty"`
+}
+
+const (
+	StatsBucketHour = "hour"
+	StatsBucketDay  = "day"
+)
+
+// PortStat is the number of distinct hosts seen with a port open.
+type PortStat struct {
+	Port     int    `bson:"port" json:"port"`
+	Protocol string `bson:"protocol" json:"protocol"`
+	Hosts    int    `bson:"hosts" json:"hosts"`
+}
+
+// ServiceStat is the number of distinct hosts and ports a service was
+// detected on.
+type ServiceStat struct {
+	Service string `bson:"service" json:"service"`
+	Hosts   int    `bson:"hosts" json:"hosts"`
+	Ports   int    `bson:"ports" json:"ports"`
+}
+
+// BreakdownStat is one slice of a breakdown (vendor, OS family, …).
+type BreakdownStat struct {
+	Name  string `bson:"name" json:"name"`
+	Count int    `bson:"count" json:"count"`
+}
+
+// HostsPoint counts the distinct hosts seen up and down in one time bucket.
+// A host seen both up and down within the bucket counts as up.
+type HostsPoint struct {
+	Time time.Time `bson:"time" json:"time"`
+	Up   int       `bson:"up" json:"up"`
+	Down int       `bson:"down" json:"down"`
+}
+
+// SeverityPoint counts the change events of one UTC day per severity.
+type SeverityPoint struct {
+	Day        time.Time      `bson:"day" json:"day"`
+	Severities map[string]int `bson:"severities" json:"severities"`
+	Total      int            `bson:"total" json:"total"`
+}
+
+// ScannerVolume is the scan activity of one scanner service: distinct
+// tasks, stored result records and failed records, in total and per time
+// bucket.
+type ScannerVolume struct {
+	Scanner string        `json:"scanner"`
+	Scans   int           `json:"scans"`
+	Records int           `json:"records"`
+	Failed  int           `json:"failed"`
+	Series  []VolumePoint `json:"series"`
+}
+
+type VolumePoint struct {
+	Time    time.Time `bson:"time" json:"time"`
+	Scans   int       `bson:"scans" json:"scans"`
+	Records int       `bson:"records" json:"records"`
+	Failed  int       `bson:"failed" json:"failed"`
+}
+
+// StatsScanners maps each scanner service to the history scan types it
+// produces.
+var StatsScanners = []struct {
+	Name      string
+	ScanTypes []string
+}{
+	{"arp", []string{"arp"}},
+	{"icmp", []string{"icmp"}},
+	{"nmap", []string{"nmap_tcp_udp", "nmap_os_detection", "nmap_host_discovery"}},
+	{"tcp", []string{"tcp"}},
+}
+
+// StatsOverview bundles every aggregation for the dashboard.
+type StatsOverview struct {
+	Filter   StatsFilter     `json:"filter"`
+	Ports    []PortStat      `json:"ports"`
+	Services []ServiceStat   `json:"services"`
+	Vendors  []BreakdownStat `json:"vendors"`
+	OS       []BreakdownStat `json:"os_families"`
+	Hosts    []HostsPoint    `json:"hosts"`
+	Events   []SeverityPoint `json:"events"`
+	Scanners []ScannerVolume `json:"scanners"`
+}
@@ -0,0 +1,55 @@
+package models
+
+import "time"
+
+// Health states of a component, a scanner and the system as a whole.
+const (
+	StatusOK       = "ok"
+	StatusDegraded = "degraded"
+	StatusDown     = "down"
+)
+
+// ComponentCheck is the result of probing one dependency.
+type ComponentCheck struct {
+	Name      string  `json:"name"`
+	Status    string  `json:"status"`
+	LatencyMs float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// QueueStatus is the state of a RabbitMQ queue as reported by a passive
+// declare.
+type QueueStatus struct {
+	Name      string `json:"name"`
+	Consumers int    `json:"consumers"`
+	Messages  int    `json:"messages"`
+}
+
+// ScannerStatus combines one RPC queue of a scanner service, the shared
+// queue or that of a site, with the time of its last successful result. A
+// scanner without consumers is down, one with a backlog is degraded.
+type ScannerStatus struct {
+	Name       string       `json:"name"`
+	Site       string       `json:"site,omitempty"`
+	Status     string       `json:"status"`
+	Queue      *QueueStatus `json:"queue,omitempty"`
+	LastResult *time.Time   `json:"last_result,omitempty"`
+	Error      string       `json:"error,omitempty"`
+}
+
+// SystemStatus is the response of /api/status.
+type SystemStatus struct {
+	Status     string           `json:"status"`
+	CheckedAt  time.Time        `json:"checked_at"`
+	Components []ComponentCheck `json:"components"`
+	Scanners   []ScannerStatus  `json:"scanners"`
+}
+
+// ScannerQueues maps each built-in scanner to the shared RPC queue it
+// consumes. Site queues and plug-in queues come from the scanner registry.
+var ScannerQueues = map[string]string{
+	"arp":  "arp_service",
+	"icmp": "icmp_service",
+	"nmap": "nmap_service",
+	"tcp":  "tcp_service",
+}
@@ -0,0 +1,78 @@
+package models
+
+import "time"
+
+// Task states. A task is pending from publish until its result is stored;
+// a pending task nobody answered is moved to timed_out.
+const (
+	TaskPending   = "pending"
+	TaskCompleted = "completed"
+	TaskFailed    = "failed"
+	TaskTimedOut  = "timed_out"
+)
+
+// TaskStatuses lists the task states.
+var TaskStatuses = []string{TaskPending, TaskCompleted, TaskFailed, TaskTimedOut}
+
+// ScanTask is a scan request as published to a scanner, kept in MongoDB so
+// its parameters survive until the result is stored. Params is the
+// request with its JSON field names. Agent is the site the client asked
+// for; ScanAgent the instance that answered. Plugin is the contract of the
+// plug-in the task went to, kept so its reply can be decoded even after a
+// restart. Backend is the reply queue of the backend instance that
+// published the task, the one its reply goes to.
+type ScanTask struct {
+	TaskID      string                 `bson:"task_id" json:"task_id"`
+	Service     string                 `bson:"service" json:"service"`
+	ScanMethod  string                 `bson:"scan_method,omitempty" json:"scan_method,omitempty"`
+	Priority    string                 `bson:"priority,omitempty" json:"priority,omitempty"`
+	Agent       string                 `bson:"agent,omitempty" json:"agent,omitempty"`
+	Backend     string                 `bson:"backend,omitempty" json:"backend,omitempty"`
+	Requester   *TaskRequester         `bson:"requester,omitempty" json:"requester,omitempty"`
+	Params      map[string]interface{} `bson:"params" json:"params"`
+	Plugin      *ScannerPlugin         `bson:"plugin,omitempty" json:"-"`
+	Status      string                 `bson:"status" json:"status"`
+	Error       string                 `bson:"error,omitempty" json:"error,omitempty"`
+	ScanAgent   *ScanAgent             `bson:"scan_agent,omitempty" json:"scan_agent,omitempty"`
+	Attempts    []ScanAttempt          `bson:"attempts,omitempty" json:"attempts,omitempty"`
+	CreatedAt   time.Time              `bson:"created_at" json:"created_at"`
+	UpdatedAt   time.Time              `bson:"updated_at" json:"updated_at"`
+	CompletedAt *time.Time             `bson:"completed_at,omitempty" json:"completed_at,omitempty"`
+}
+
+// TaskRequester is who asked for a scan: the channel the request came in
+// on and, for network clients, their address and user agent.
+type TaskRequester struct {
+	Source    string `bson:"source" json:"source"`
+	Address   string `bson:"address,omitempty" json:"address,omitempty"`
+	UserAgent string `bson:"user_agent,omitempty" json:"user_agent,omitempty"`
+}
+
+// TaskOutcome is what is recorded on a task when it leaves pending.
+type TaskOutcome struct {
+	Status    string
+	Error     string
+	ScanAgent *ScanAgent
+	Attempts  []ScanAttempt
+}
+
+// TaskQuery selects tasks; zero fields do not filter.
+type TaskQuery struct {
+	Status  string
+	Service string
+	Target  string // matched against the target parameters
+	Since   time.Time
+	Until   time.Time
+	Limit   int
+}
+
+// TaskUpdate announces a task entering a state, to every backend instance
+// and from there to the WebSocket clients.
+type TaskUpdate struct {
+	TaskID  string    `json:"task_id"`
+	Service string    `json:"service,omitempty"`
+	Status  string    `json:"status"`
+	Error   string    `json:"error,omitempty"`
+	Backend string    `json:"backend,omitempty"`
+	At      time.Time `json:"at"`
+}
@@ -4,6 +4,10 @@ import (
 	"backend/domain/models"
 	"backend/internal/application/services"
 	rabbitmq "backend/internal/infrastructure/messaging"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 )
 
 type App struct {
@@ -11,57 +15,55 @@ type App struct {
 	responseService  *services.ResponseService
 	publisherService *services.PublisherService
 	historyService   *services.HistoryService
+	taskService      *services.TaskService
+	registry         *services.ScannerRegistry
 }
 
-func NewApp(publisher *rabbitmq.RPCScannerPublisher, repo services.RepositoryInterface) *App {
-	historyService := services.NewHistoryService(repo)
+func NewApp(publisher *rabbitmq.RPCScannerPublisher, repo services.RepositoryInterface, registry *services.ScannerRegistry, tasks *services.TaskService) *App {
+	historyService := services.NewHistoryService(repo, tasks)
 	requestService := services.NewRequestService()
-	responseService := services.NewResponseService(historyService)
+	responseService := services.NewResponseService(historyService, tasks)
 	publisherService := services.NewPublisherService(publisher)
 
-	publisherService.SetResponseCallback(func(response *models.Response) {
-		responseService.ProcessResponse(response)
-	})
+	publisherService.SetResponseCallback(responseService.ProcessResponse)
+	publisherService.SetErrorCallback(tasks.Fail)
 
 	return &App{
 		requestService:   requestService,
 		responseService:  responseService,
 		publisherService: publisherService,
 		historyService:   historyService,
+		taskService:      tasks,
+		registry:         registry,
 	}
 }
 
-func (a *App) ProcessRequest(req *models.Request) *models.Response {
+// ProcessRequest validates req and publishes it to its scanner. ctx carries
+// the task id and trace context propagated to the scanner.
+func (a *App) ProcessRequest(ctx context.Context, req *models.Request) *models.Response {
 	response := a.requestService.ProcessRequest(req)
 
 	if response.TaskID != "error" && response.TaskID != "unknown" {
 		switch req.ScannerService {
 		case "nmap_service":
-			if nmapReq, ok := response.Result.(models.NmapTcpUdpRequest); ok {
-				a.historyService.CacheRequest(nmapReq.TaskID, nmapReq)
-			} else if nmapReq, ok := response.Result.(models.NmapOsDetectionRequest); ok {
-				a.historyService.CacheRequest(nmapReq.TaskID, nmapReq)
-			} else if nmapReq, ok := response.Result.(models.NmapHostDiscoveryRequest); ok {
-				a.historyService.CacheRequest(nmapReq.TaskID, nmapReq)
-			}
-			return a.publisherService.PublishNmapRequest(response.Result)
+			return a.PublishNmapRequest(ctx, response.Result)
 
 		case "arp_service":
 			if arpReq, ok := response.Result.(models.ARPRequest); ok {
-				a.historyService.CacheRequest(arpReq.TaskID, arpReq)
-				return a.publisherService.PublishARPRequest(arpReq)
+				a.taskService.Create(ctx, arpReq.TaskID, "arp_service", arpReq)
+				return a.publisherService.PublishARPRequest(ctx, arpReq)
 			}
 
 		case "icmp_service":
 			if icmpReq, ok := response.Result.(models.ICMPRequest); ok {
-				a.historyService.CacheRequest(icmpReq.TaskID, icmpReq)
-				return a.publisherService.PublishICMPRequest(icmpReq)
+				a.taskService.Create(ctx, icmpReq.TaskID, "icmp_service", icmpReq)
+				return a.publisherService.PublishICMPRequest(ctx, icmpReq)
 			}
 
 		case "tcp_service":
 			if tcpReq, ok := response.Result.(models.TCPRequest); ok {
-				a.historyService.CacheRequest(tcpReq.TaskID, tcpReq)
-				return a.publisherService.PublishTCPRequest(tcpReq)
+				a.taskService.Create(ctx, tcpReq.TaskID, "tcp_service", tcpReq)
+				return a.publisherService.PublishTCPRequest(ctx, tcpReq)
 			}
 		}
 	}
@@ -69,12 +71,73 @@ func (a *App) ProcessRequest(req *models.Request) *models.Response {
 	return response
 }
 
-func (a *App) ProcessResponse(response *models.Response) {
-	a.responseService.ProcessResponse(response)
+// ProcessPluginRequest validates the options of a request for a plug-in
+// scanner against the schema it advertises and publishes them, with
+// task_id added, to its queue. An error wrapping
+// services.ErrUnknownScanner means service is not a plug-in.
+func (a *App) ProcessPluginRequest(ctx context.Context, taskID string, req *models.Request) (*models.Response, error) {
+	plugin, err := a.registry.Plugin(req.ScannerService)
+	if errors.Is(err, services.ErrUnknownScanner) {
+		return nil, err
+	}
+	if err != nil {
+		return pluginError(taskID, err), nil
+	}
+
+	// Round-trip through JSON so options are checked exactly as the
+	// scanner will decode them.
+	raw, err := json.Marshal(req.Options)
+	if err != nil {
+		return pluginError(taskID, err), nil
+	}
+	options := map[string]interface{}{}
+	if string(raw) != "null" {
+		if err := json.Unmarshal(raw, &options); err != nil {
+			return pluginError(taskID, fmt.Errorf("options must be an object")), nil
+		}
+	}
+	if err := services.ValidateSchema(plugin.OptionsSchema, options); err != nil {
+		return pluginError(taskID, fmt.Errorf("invalid options: %w", err)), nil
+	}
+
+	options["task_id"] = taskID
+	a.taskService.CreatePlugin(ctx, taskID, plugin, options)
+	return a.publisherService.PublishPluginRequest(ctx, plugin, options), nil
+}
+
+// TaskPlugin returns the plug-in taskID was published to and its options,
+// for replies that arrive after the backend stopped waiting for them.
+func (a *App) TaskPlugin(taskID string) (*models.ScannerPlugin, map[string]interface{}, bool) {
+	task, err := a.taskService.Get(taskID)
+	if err != nil || task.Plugin == nil {
+		return nil, nil, false
+	}
+	return task.Plugin, task.Params, true
 }
 
-func (a *App) PublishNmapRequest(req interface{}) *models.Response {
-	return a.publisherService.PublishNmapRequest(req)
+func pluginError(taskID string, err error) *models.Response {
+	return &models.Response{
+		TaskID: taskID,
+		Result: map[string]string{"error": err.Error()},
+	}
+}
+
+func (a *App) ProcessResponse(response *models.Response) error {
+	return a.responseService.ProcessResponse(response)
+}
+
+// PublishNmapRequest records req as a task and publishes it to the nmap
+// scanner.
+func (a *App) PublishNmapRequest(ctx context.Context, req interface{}) *models.Response {
+	switch r := req.(type) {
+	case models.NmapTcpUdpRequest:
+		a.taskService.Create(ctx, r.TaskID, "nmap_service", r)
+	case models.NmapOsDetectionRequest:
+		a.taskService.Create(ctx, r.TaskID, "nmap_service", r)
+	case models.NmapHostDiscoveryRequest:
+		a.taskService.Create(ctx, r.TaskID, "nmap_service", r)
+	}
+	return a.publisherService.PublishNmapRequest(ctx, req)
 }
 
 func (a *App) GetARPHistory(limit int) ([]models.ARPHistoryRecord, error) {
@@ -0,0 +1,431 @@
+package services
+
+import (
+	"backend/domain/models"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+var (
+	ErrDiffNotFound     = errors.New("history record not found")
+	ErrDiffTypeMismatch = errors.New("records belong to different scan types")
+)
+
+// maxBannerDiffCells bounds the LCS table used for TCP banner diffs.
+const maxBannerDiffCells = 4_000_000
+
+type DiffRepository interface {
+	GetHistoryScanType(id string) (string, error)
+	GetARPHistoryByID(id string) (*models.ARPHistoryRecord, error)
+	GetICMPHistoryByID(id string) (*models.ICMPHistoryRecord, error)
+	GetNmapTcpUdpHistoryByID(id string) (*models.NmapTcpUdpHistoryRecord, error)
+	GetNmapOsDetectionHistoryByID(id string) (*models.NmapOsDetectionHistoryRecord, error)
+	GetNmapHostDiscoveryHistoryByID(id string) (*models.NmapHostDiscoveryHistoryRecord, error)
+	GetTCPHistoryByID(id string) (*models.TCPHistoryRecord, error)
+}
+
+// DiffService compares two arbitrary history records of the same type.
+// Unlike the change detector, which only looks at the two latest scans of a
+// target, any pair can be compared, e.g. last month's scan against today's.
+type DiffService struct {
+	repo DiffRepository
+}
+
+func NewDiffService(repo DiffRepository) *DiffService {
+	return &DiffService{repo: repo}
+}
+
+// Diff compares record a (baseline) with record b.
+func (ds *DiffService) Diff(a, b string) (*models.ScanDiff, error) {
+	typeA, err := ds.scanType(a)
+	if err != nil {
+		return nil, err
+	}
+	typeB, err := ds.scanType(b)
+	if err != nil {
+		return nil, err
+	}
+	if typeA != typeB {
+		return nil, fmt.Errorf("%w: %s vs %s", ErrDiffTypeMismatch, typeA, typeB)
+	}
+
+	switch typeA {
+	case "arp":
+		recA, recB, err := loadPair(a, b, ds.repo.GetARPHistoryByID)
+		if err != nil {
+			return nil, err
+		}
+		return diffARP(recA, recB), nil
+	case "icmp":
+		recA, recB, err := loadPair(a, b, ds.repo.GetICMPHistoryByID)
+		if err != nil {
+			return nil, err
+		}
+		return diffICMP(recA, recB), nil
+	case "nmap_tcp_udp":
+		recA, recB, err := loadPair(a, b, ds.repo.GetNmapTcpUdpHistoryByID)
+		if err != nil {
+			return nil, err
+		}
+		return diffNmapTcpUdp(recA, recB), nil
+	case "nmap_os_detection":
+		recA, recB, err := loadPair(a, b, ds.repo.GetNmapOsDetectionHistoryByID)
+		if err != nil {
+			return nil, err
+		}
+		return diffNmapOs(recA, recB), nil
+	case "nmap_host_discovery":
+		recA, recB, err := loadPair(a, b, ds.repo.GetNmapHostDiscoveryHistoryByID)
+		if err != nil {
+			return nil, err
+		}
+		return diffHostDiscovery(recA, recB), nil
+	case "tcp":
+		recA, recB, err := loadPair(a, b, ds.repo.GetTCPHistoryByID)
+		if err != nil {
+			return nil, err
+		}
+		return diffTCP(recA, recB), nil
+	}
+	return nil, fmt.Errorf("diff is not supported for scan type %q", typeA)
+}
+
+func (ds *DiffService) scanType(id string) (string, error) {
+	scanType, err := ds.repo.GetHistoryScanType(id)
+	if err != nil {
+		return "", lookupError(id, err)
+	}
+	if scanType == "" {
+		return "", fmt.Errorf("%w: %s", ErrDiffNotFound, id)
+	}
+	return scanType, nil
+}
+
+func loadPair[T any](a, b string, get func(id string) (*T, error)) (*T, *T, error) {
+	recA, err := get(a)
+	if err != nil {
+		return nil, nil, lookupError(a, err)
+	}
+	recB, err := get(b)
+	if err != nil {
+		return nil, nil, lookupError(b, err)
+	}
+	return recA, recB, nil
+}
+
+// lookupError maps a missing record or a malformed id to ErrDiffNotFound;
+// any other failure, e.g. MongoDB being unreachable, is passed on.
+func lookupError(id string, err error) error {
+	if errors.Is(err, models.ErrNotFound) {
+		return fmt.Errorf("%w: %s", ErrDiffNotFound, id)
+	}
+	return fmt.Errorf("loading record %s: %w", id, err)
+}
+
+func newScanDiff(scanType string, a, b models.ScanRef) *models.ScanDiff {
+	return &models.ScanDiff{
+		ScanType:   scanType,
+		A:          a,
+		B:          b,
+		SameTarget: a.Target == b.Target,
+	}
+}
+
+// ── ARP ──────────────────────────────────────────────────────────────────────
+
+func diffARP(a, b *models.ARPHistoryRecord) *models.ScanDiff {
+	d := newScanDiff("arp",
+		models.ScanRef{ID: a.ID.Hex(), TaskID: a.TaskID, Target: a.IPRange, Status: a.Status, CreatedAt: a.CreatedAt},
+		models.ScanRef{ID: b.ID.Hex(), TaskID: b.TaskID, Target: b.IPRange, Status: b.Status, CreatedAt: b.CreatedAt},
+	)
+	res := &models.ARPDiff{
+		Added:      []models.ARPDevice{},
+		Removed:    []models.ARPDevice{},
+		MACChanged: []models.ARPMACChange{},
+	}
+
+	// Same device set as the change detector: online devices keyed by IP.
+	oldDevices := make(map[string]models.ARPDevice, len(a.OnlineDevices))
+	for _, dev := range a.OnlineDevices {
+		oldDevices[dev.IP] = dev
+	}
+	newDevices := make(map[string]models.ARPDevice, len(b.OnlineDevices))
+	for _, dev := range b.OnlineDevices {
+		newDevices[dev.IP] = dev
+	}
+
+	for _, ip := range sortedKeys(newDevices) {
+		dev := newDevices[ip]
+		old, ok := oldDevices[ip]
+		switch {
+		case !ok:
+			res.Added = append(res.Added, dev)
+		case !strings.EqualFold(old.MAC, dev.MAC):
+			res.MACChanged = append(res.MACChanged, models.ARPMACChange{
+				IP: ip, OldMAC: old.MAC, NewMAC: dev.MAC, OldVendor: old.Vendor, NewVendor: dev.Vendor,
+			})
+		default:
+			res.Unchanged++
+		}
+	}
+	for _, ip := range sortedKeys(oldDevices) {
+		if _, ok := newDevices[ip]; !ok {
+			res.Removed = append(res.Removed, oldDevices[ip])
+		}
+	}
+
+	d.ARP = res
+	d.Changed = len(res.Added)+len(res.Removed)+len(res.MACChanged) > 0
+	return d
+}
+
+// ── ICMP ─────────────────────────────────────────────────────────────────────
+
+func diffICMP(a, b *models.ICMPHistoryRecord) *models.ScanDiff {
+	d := newScanDiff("icmp",
+		models.ScanRef{ID: a.ID.Hex(), TaskID: a.TaskID, Target: strings.Join(a.Targets, ","), Status: a.Status, CreatedAt: a.CreatedAt},
+		models.ScanRef{ID: b.ID.Hex(), TaskID: b.TaskID, Target: strings.Join(b.Targets, ","), Status: b.Status, CreatedAt: b.CreatedAt},
+	)
+	res := &models.ICMPDiff{
+		Added:   []models.ICMPResult{},
+		Removed: []models.ICMPResult{},
+		Changed: []models.ICMPChange{},
+	}
+
+	oldResults := make(map[string]models.ICMPResult, len(a.Results))
+	for _, r := range a.Results {
+		oldResults[r.Target] = r
+	}
+	newResults := make(map[string]models.ICMPResult, len(b.Results))
+	for _, r := range b.Results {
+		newResults[r.Target] = r
+	}
+
+	for _, target := range sortedKeys(newResults) {
+		r := newResults[target]
+		old, ok := oldResults[target]
+		if !ok {
+			res.Added = append(res.Added, r)
+			continue
+		}
+		if icmpReachable(old) != icmpReachable(r) || old.PacketLossPercent != r.PacketLossPercent {
+			res.Changed = append(res.Changed, models.ICMPChange{
+				Target:        target,
+				OldReachable:  icmpReachable(old),
+				NewReachable:  icmpReachable(r),
+				OldPacketLoss: old.PacketLossPercent,
+				NewPacketLoss: r.PacketLossPercent,
+			})
+			continue
+		}
+		res.Unchanged++
+	}
+	for _, target := range sortedKeys(oldResults) {
+		if _, ok := newResults[target]; !ok {
+			res.Removed = append(res.Removed, oldResults[target])
+		}
+	}
+
+	d.ICMP = res
+	d.Changed = len(res.Added)+len(res.Removed)+len(res.Changed) > 0
+	return d
+}
+
+func icmpReachable(r models.ICMPResult) bool {
+	return r.Error == "" && r.PacketsReceived > 0
+}
+
+// ── Nmap ports ───────────────────────────────────────────────────────────────
+
+func diffNmapTcpUdp(a, b *models.NmapTcpUdpHistoryRecord) *models.ScanDiff {
+	d := newScanDiff("nmap_tcp_udp",
+		models.ScanRef{ID: a.ID.Hex(), TaskID: a.TaskID, Target: a.IP, Status: a.Status, CreatedAt: a.CreatedAt},
+		models.ScanRef{ID: b.ID.Hex(), TaskID: b.TaskID, Target: b.IP, Status: b.Status, CreatedAt: b.CreatedAt},
+	)
+	res := &models.PortDiff{
+		Opened:         []models.PortEntry{},
+		Closed:         []models.PortEntry{},
+		ServiceChanged: []models.ServiceChange{},
+	}
+
+	oldPorts := openPorts(a.PortInfo)
+	newPorts := openPorts(b.PortInfo)
+
+	for _, key := range sortedKeys(newPorts) {
+		p := newPorts[key]
+		old, ok := oldPorts[key]
+		switch {
+		case !ok:
+			res.Opened = append(res.Opened, p)
+		case old.Service != p.Service:
+			res.ServiceChanged = append(res.ServiceChanged, models.ServiceChange{
+				Port: p.Port, Protocol: p.Protocol, OldService: old.Service, NewService: p.Service,
+			})
+		default:
+			res.Unchanged++
+		}
+	}
+	for _, key := range sortedKeys(oldPorts) {
+		if _, ok := newPorts[key]; !ok {
+			res.Closed = append(res.Closed, oldPorts[key])
+		}
+	}
+
+	d.Ports = res
+	d.Changed = len(res.Opened)+len(res.Closed)+len(res.ServiceChanged) > 0
+	return d
+}
+
+// openPorts flattens port_info into the open ports, keyed by "port/proto"
+// (zero-padded so the keys sort numerically).
+func openPorts(infos []models.NmapPortTcpUdpInfo) map[string]models.PortEntry {
+	ports := make(map[string]models.PortEntry)
+	for _, info := range infos {
+		for i, port := range info.AllPorts {
+			state := "open"
+			if i < len(info.State) {
+				state = info.State[i]
+			}
+			if !strings.EqualFold(state, "open") {
+				continue
+			}
+			p := models.PortEntry{Port: port, Protocol: "tcp", Service: "unknown"}
+			if i < len(info.Protocols) && info.Protocols[i] != "" {
+				p.Protocol = info.Protocols[i]
+			}
+			if i < len(info.ServiceName) && info.ServiceName[i] != "" {
+				p.Service = info.ServiceName[i]
+			}
+			ports[fmt.Sprintf("%05d/%s", port, p.Protocol)] = p
+		}
+	}
+	return ports
+}
+
+// ── OS detection / host discovery ────────────────────────────────────────────
+
+func diffNmapOs(a, b *models.NmapOsDetectionHistoryRecord) *models.ScanDiff {
+	d := newScanDiff("nmap_os_detection",
+		models.ScanRef{ID: a.ID.Hex(), TaskID: a.TaskID, Target: a.IP, Status: a.Status, CreatedAt: a.CreatedAt},
+		models.ScanRef{ID: b.ID.Hex(), TaskID: b.TaskID, Target: b.IP, Status: b.Status, CreatedAt: b.CreatedAt},
+	)
+	res := &models.FieldDiff{Changes: []models.FieldChange{}}
+	res.Changes = appendFieldChange(res.Changes, "name", a.Name, b.Name)
+	res.Changes = appendFieldChange(res.Changes, "vendor", a.Vendor, b.Vendor)
+	res.Changes = appendFieldChange(res.Changes, "family", a.Family, b.Family)
+	res.Changes = appendFieldChange(res.Changes, "type", a.Type, b.Type)
+	res.Changes = appendFieldChange(res.Changes, "accuracy", a.Accuracy, b.Accuracy)
+
+	d.OS = res
+	d.Changed = len(res.Changes) > 0
+	return d
+}
+
+func diffHostDiscovery(a, b *models.NmapHostDiscoveryHistoryRecord) *models.ScanDiff {
+	d := newScanDiff("nmap_host_discovery",
+		models.ScanRef{ID: a.ID.Hex(), TaskID: a.TaskID, Target: a.IP, Status: a.Status, CreatedAt: a.CreatedAt},
+		models.ScanRef{ID: b.ID.Hex(), TaskID: b.TaskID, Target: b.IP, Status: b.Status, CreatedAt: b.CreatedAt},
+	)
+	res := &models.FieldDiff{Changes: []models.FieldChange{}}
+	res.Changes = appendFieldChange(res.Changes, "status", a.Status, b.Status)
+	res.Changes = appendFieldChange(res.Changes, "host", a.Host, b.Host)
+	res.Changes = appendFieldChange(res.Changes, "host_up", a.HostUP, b.HostUP)
+	res.Changes = appendFieldChange(res.Changes, "host_total", a.HostTotal, b.HostTotal)
+	res.Changes = appendFieldChange(res.Changes, "dns", a.DNS, b.DNS)
+	res.Changes = appendFieldChange(res.Changes, "reason", a.Reason, b.Reason)
+
+	d.Discovery = res
+	d.Changed = len(res.Changes) > 0
+	return d
+}
+
+func appendFieldChange[T comparable](changes []models.FieldChange, field string, old, new T) []models.FieldChange {
+	if old == new {
+		return changes
+	}
+	return append(changes, models.FieldChange{Field: field, Old: old, New: new})
+}
+
+// ── TCP banners ──────────────────────────────────────────────────────────────
+
+func diffTCP(a, b *models.TCPHistoryRecord) *models.ScanDiff {
+	d := newScanDiff("tcp",
+		models.ScanRef{ID: a.ID.Hex(), TaskID: a.TaskID, Target: a.Host + ":" + a.Port, Status: a.Status, CreatedAt: a.CreatedAt},
+		models.ScanRef{ID: b.ID.Hex(), TaskID: b.TaskID, Target: b.Host + ":" + b.Port, Status: b.Status, CreatedAt: b.CreatedAt},
+	)
+	d.TCP = diffLines(a.DecodedText, b.DecodedText)
+	d.Changed = !d.TCP.Identical
+	return d
+}
+
+// diffLines produces a line diff of two banners from their longest common
+// subsequence. Banners too large for the LCS table are reported as changed
+// without line detail.
+func diffLines(a, b string) *models.BannerDiff {
+	res := &models.BannerDiff{Identical: a == b, Lines: []models.DiffLine{}}
+	if res.Identical {
+		return res
+	}
+
+	oldLines := splitLines(a)
+	newLines := splitLines(b)
+	n, m := len(oldLines), len(newLines)
+	if n*m > maxBannerDiffCells {
+		res.Removed, res.Added, res.Truncated = n, m, true
+		return res
+	}
+
+	// lcs[i][j] = LCS length of oldLines[i:] and newLines[j:]
+	lcs := make([][]int, n+1)
+	for i := range lcs {
+		lcs[i] = make([]int, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if oldLines[i] == newLines[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < n || j < m {
+		switch {
+		case i < n && j < m && oldLines[i] == newLines[j]:
+			res.Lines = append(res.Lines, models.DiffLine{Op: " ", Text: oldLines[i]})
+			i++
+			j++
+		case j < m && (i == n || lcs[i][j+1] > lcs[i+1][j]):
+			res.Lines = append(res.Lines, models.DiffLine{Op: "+", Text: newLines[j]})
+			res.Added++
+			j++
+		default:
+			res.Lines = append(res.Lines, models.DiffLine{Op: "-", Text: oldLines[i]})
+			res.Removed++
+			i++
+		}
+	}
+	return res
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.ReplaceAll(s, "\r\n", "\n"), "\n")
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
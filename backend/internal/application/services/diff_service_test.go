@@ -0,0 +1,31 @@
+package services
+
+import (
+	"backend/domain/models"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestLookupError(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		notFound bool
+	}{
+		{"missing record", models.ErrNotFound, true},
+		{"invalid id", fmt.Errorf("%w: invalid id %q", models.ErrNotFound, "x"), true},
+		{"database down", errors.New("server selection timeout"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := lookupError("id", tt.err)
+			if got := errors.Is(err, ErrDiffNotFound); got != tt.notFound {
+				t.Errorf("lookupError(%v) = %v, not found: %v", tt.err, err, got)
+			}
+			if !tt.notFound && !errors.Is(err, tt.err) {
+				t.Errorf("cause %v lost from %v", tt.err, err)
+			}
+		})
+	}
+}
@@ -3,7 +3,6 @@ package services
 import (
 	"backend/domain/models"
 	"log"
-	"sync"
 )
 
 type SearchRepository interface {
@@ -17,6 +16,7 @@ type SearchRepository interface {
 	GetNmapHostDiscoveryHistoryByIP(ip string, limit int) ([]models.NmapHostDiscoveryHistoryRecord, error)
 	GetNmapHostDiscoveryHistoryByID(id string) (*models.NmapHostDiscoveryHistoryRecord, error)
 	GetARPHistoryByIPRange(ipRange string, limit int) ([]models.ARPHistoryRecord, error)
+	SearchARPHistory(q models.ARPSearchQuery, limit int) ([]models.ARPHistoryRecord, error)
 	GetARPHistoryByID(id string) (*models.ARPHistoryRecord, error)
 	GetTCPHistoryByHostPort(host, port string, limit int) ([]models.TCPHistoryRecord, error)
 	GetTCPHistoryByID(id string) (*models.TCPHistoryRecord, error)
@@ -46,65 +46,61 @@ type RepositoryInterface interface {
 	SaveTCPHistory(record *models.TCPHistoryRecord) error
 	GetTCPHistory(limit int) ([]models.TCPHistoryRecord, error)
 	DeleteTCPHistory() error
+
+	SavePluginHistory(collection string, record *models.PluginHistoryRecord) error
+	GetPluginHistory(collection string, limit int) ([]models.PluginHistoryRecord, error)
+	DeletePluginHistory(collection string) error
 }
 
 // DeviceRepository — removed: L2/L3 inventory is now handled by the
 // l2_devices / l3_devices collection structure, not a separate interface.
 
 type HistoryService struct {
-	repo         RepositoryInterface
-	requestCache map[string]interface{}
-	mu           sync.RWMutex
+	repo  RepositoryInterface
+	tasks *TaskService
 }
 
-func NewHistoryService(repo RepositoryInterface) *HistoryService {
+// NewHistoryService stores scan results in repo, joined with the request
+// parameters recorded in tasks.
+func NewHistoryService(repo RepositoryInterface, tasks *TaskService) *HistoryService {
 	return &HistoryService{
-		repo:         repo,
-		requestCache: make(map[string]interface{}),
+		repo:  repo,
+		tasks: tasks,
 	}
 }
 
-func (hs *HistoryService) CacheRequest(taskID string, request interface{}) {
-	hs.mu.Lock()
-	defer hs.mu.Unlock()
-	hs.requestCache[taskID] = request
-}
-
-func (hs *HistoryService) GetCachedRequest(taskID string) interface{} {
-	hs.mu.RLock()
-	defer hs.mu.RUnlock()
-	return hs.requestCache[taskID]
-}
-
-func (hs *HistoryService) RemoveCachedRequest(taskID string) {
-	hs.mu.Lock()
-	defer hs.mu.Unlock()
-	delete(hs.requestCache, taskID)
+// request decodes the parameters the scan for taskID was requested with.
+func (hs *HistoryService) request(taskID string, request interface{}) bool {
+	return hs.tasks != nil && hs.tasks.Params(taskID, request)
 }
 
 func (hs *HistoryService) GetRepo() RepositoryInterface {
 	return hs.repo
 }
 
-func (hs *HistoryService) SaveARPResponse(result models.ARPResponse) {
+func (hs *HistoryService) SaveARPResponse(result models.ARPResponse, agent *models.ScanAgent, attempts []models.ScanAttempt) error {
 	if hs.repo == nil {
-		return
+		return nil
 	}
 
 	var interfaceName, ipRange string
-	if cachedReq := hs.GetCachedRequest(result.TaskID); cachedReq != nil {
-		if arpReq, ok := cachedReq.(models.ARPRequest); ok {
-			interfaceName = arpReq.InterfaceName
-			ipRange = arpReq.IPRange
-		}
+	var arpReq models.ARPRequest
+	if hs.request(result.TaskID, &arpReq) {
+		interfaceName = arpReq.InterfaceName
+		ipRange = arpReq.IPRange
 	}
 
-	var onlineDevices, offlineDevices []models.ARPDevice
-	for _, device := range result.Devices {
-		if device.Status == "online" {
-			onlineDevices = append(onlineDevices, device)
-		} else {
-			offlineDevices = append(offlineDevices, device)
+	// Every device is stored once, in the list for its status. The devices
+	// of streamed results are already stored, chunk by chunk, and the
+	// final reply lists none.
+	onlineDevices, offlineDevices := result.OnlineDevices, result.OfflineDevices
+	if len(onlineDevices) == 0 && len(offlineDevices) == 0 {
+		for _, device := range result.Devices {
+			if device.Status == "online" {
+				onlineDevices = append(onlineDevices, device)
+			} else {
+				offlineDevices = append(offlineDevices, device)
+			}
 		}
 	}
 
@@ -113,35 +109,35 @@ func (hs *HistoryService) SaveARPResponse(result models.ARPResponse) {
 		InterfaceName:  interfaceName,
 		IPRange:        ipRange,
 		Status:         result.Status,
-		Devices:        result.Devices,
 		OnlineDevices:  onlineDevices,
 		OfflineDevices: offlineDevices,
 		TotalCount:     result.TotalCount,
 		OnlineCount:    result.OnlineCount,
 		OfflineCount:   result.OfflineCount,
 		Error:          result.Error,
+		Agent:          agent,
+		Attempts:       attempts,
 	}
 
 	if err := hs.repo.SaveARPHistory(historyRecord); err != nil {
 		log.Printf("Failed to save ARP history: %v", err)
-	} else {
-		log.Printf("Successfully saved ARP history for task %s", result.TaskID)
-		hs.RemoveCachedRequest(result.TaskID)
+		return err
 	}
+	log.Printf("Successfully saved ARP history for task %s", result.TaskID)
+	return nil
 }
 
-func (hs *HistoryService) SaveICMPResponse(result models.ICMPResponse) {
+func (hs *HistoryService) SaveICMPResponse(result models.ICMPResponse, agent *models.ScanAgent, attempts []models.ScanAttempt) error {
 	if hs.repo == nil {
-		return
+		return nil
 	}
 
 	var targets []string
 	var pingCount int
-	if cachedReq := hs.GetCachedRequest(result.TaskID); cachedReq != nil {
-		if icmpReq, ok := cachedReq.(models.ICMPRequest); ok {
-			targets = icmpReq.Targets
-			pingCount = icmpReq.PingCount
-		}
+	var icmpReq models.ICMPRequest
+	if hs.request(result.TaskID, &icmpReq) {
+		targets = icmpReq.Targets
+		pingCount = icmpReq.PingCount
 	}
 
 	historyRecord := &models.ICMPHistoryRecord{
@@ -151,28 +147,29 @@ func (hs *HistoryService) SaveICMPResponse(result models.ICMPResponse) {
 		Status:    result.Status,
 		Results:   result.Results,
 		Error:     result.Error,
+		Agent:     agent,
+		Attempts:  attempts,
 	}
 
 	if err := hs.repo.SaveICMPHistory(historyRecord); err != nil {
 		log.Printf("Failed to save ICMP history: %v", err)
-	} else {
-		log.Printf("Successfully saved ICMP history for task %s", result.TaskID)
-		hs.RemoveCachedRequest(result.TaskID)
+		return err
 	}
+	log.Printf("Successfully saved ICMP history for task %s", result.TaskID)
+	return nil
 }
 
-func (hs *HistoryService) SaveNmapTcpUdpResponse(result models.NmapTcpUdpResponse) {
+func (hs *HistoryService) SaveNmapTcpUdpResponse(result models.NmapTcpUdpResponse, agent *models.ScanAgent, attempts []models.ScanAttempt) error {
 	if hs.repo == nil {
-		return
+		return nil
 	}
 
 	var ip, scannerType, ports string
-	if cachedReq := hs.GetCachedRequest(result.TaskID); cachedReq != nil {
-		if tcpUdpReq, ok := cachedReq.(models.NmapTcpUdpRequest); ok {
-			ip = tcpUdpReq.IP
-			scannerType = tcpUdpReq.ScannerType
-			ports = tcpUdpReq.Ports
-		}
+	var tcpUdpReq models.NmapTcpUdpRequest
+	if hs.request(result.TaskID, &tcpUdpReq) {
+		ip = tcpUdpReq.IP
+		scannerType = tcpUdpReq.ScannerType
+		ports = tcpUdpReq.Ports
 	}
 
 	if ip == "" && result.Host != "" {
@@ -188,26 +185,27 @@ func (hs *HistoryService) SaveNmapTcpUdpResponse(result models.NmapTcpUdpRespons
 		PortInfo:    result.PortInfo,
 		Status:      result.Status,
 		Error:       result.Error,
+		Agent:       agent,
+		Attempts:    attempts,
 	}
 
 	if err := hs.repo.SaveNmapTcpUdpHistory(historyRecord); err != nil {
 		log.Printf("Failed to save Nmap TCP/UDP history: %v", err)
-	} else {
-		log.Printf("Successfully saved Nmap TCP/UDP history for task %s", result.TaskID)
-		hs.RemoveCachedRequest(result.TaskID)
+		return err
 	}
+	log.Printf("Successfully saved Nmap TCP/UDP history for task %s", result.TaskID)
+	return nil
 }
 
-func (hs *HistoryService) SaveNmapOsDetectionResponse(result models.NmapOsDetectionResponse) {
+func (hs *HistoryService) SaveNmapOsDetectionResponse(result models.NmapOsDetectionResponse, agent *models.ScanAgent, attempts []models.ScanAttempt) error {
 	if hs.repo == nil {
-		return
+		return nil
 	}
 
 	var ip string
-	if cachedReq := hs.GetCachedRequest(result.TaskID); cachedReq != nil {
-		if osReq, ok := cachedReq.(models.NmapOsDetectionRequest); ok {
-			ip = osReq.IP
-		}
+	var osReq models.NmapOsDetectionRequest
+	if hs.request(result.TaskID, &osReq) {
+		ip = osReq.IP
 	}
 
 	historyRecord := &models.NmapOsDetectionHistoryRecord{
@@ -221,26 +219,27 @@ func (hs *HistoryService) SaveNmapOsDetectionResponse(result models.NmapOsDetect
 		Type:     result.Type,
 		Status:   result.Status,
 		Error:    result.Error,
+		Agent:    agent,
+		Attempts: attempts,
 	}
 
 	if err := hs.repo.SaveNmapOsDetectionHistory(historyRecord); err != nil {
 		log.Printf("Failed to save Nmap OS Detection history: %v", err)
-	} else {
-		log.Printf("Successfully saved Nmap OS Detection history for task %s", result.TaskID)
-		hs.RemoveCachedRequest(result.TaskID)
+		return err
 	}
+	log.Printf("Successfully saved Nmap OS Detection history for task %s", result.TaskID)
+	return nil
 }
 
-func (hs *HistoryService) SaveNmapHostDiscoveryResponse(result models.NmapHostDiscoveryResponse) {
+func (hs *HistoryService) SaveNmapHostDiscoveryResponse(result models.NmapHostDiscoveryResponse, agent *models.ScanAgent, attempts []models.ScanAttempt) error {
 	if hs.repo == nil {
-		return
+		return nil
 	}
 
 	var ip string
-	if cachedReq := hs.GetCachedRequest(result.TaskID); cachedReq != nil {
-		if hostReq, ok := cachedReq.(models.NmapHostDiscoveryRequest); ok {
-			ip = hostReq.IP
-		}
+	var hostReq models.NmapHostDiscoveryRequest
+	if hs.request(result.TaskID, &hostReq) {
+		ip = hostReq.IP
 	}
 
 	if ip == "" && result.Host != "" {
@@ -261,27 +260,28 @@ func (hs *HistoryService) SaveNmapHostDiscoveryResponse(result models.NmapHostDi
 		DNS:       result.DNS,
 		Reason:    result.Reason,
 		Error:     result.Error,
+		Agent:     agent,
+		Attempts:  attempts,
 	}
 
 	if err := hs.repo.SaveNmapHostDiscoveryHistory(historyRecord); err != nil {
 		log.Printf("Failed to save Nmap Host Discovery history: %v", err)
-	} else {
-		log.Printf("Successfully saved Nmap Host Discovery history for task %s", result.TaskID)
-		hs.RemoveCachedRequest(result.TaskID)
+		return err
 	}
+	log.Printf("Successfully saved Nmap Host Discovery history for task %s", result.TaskID)
+	return nil
 }
 
-func (hs *HistoryService) SaveTCPResponse(result models.TCPResponse) {
+func (hs *HistoryService) SaveTCPResponse(result models.TCPResponse, agent *models.ScanAgent, attempts []models.ScanAttempt) error {
 	if hs.repo == nil {
-		return
+		return nil
 	}
 
 	var host, port string
-	if cachedReq := hs.GetCachedRequest(result.TaskID); cachedReq != nil {
-		if tcpReq, ok := cachedReq.(models.TCPRequest); ok {
-			host = tcpReq.Host
-			port = tcpReq.Port
-		}
+	var tcpReq models.TCPRequest
+	if hs.request(result.TaskID, &tcpReq) {
+		host = tcpReq.Host
+		port = tcpReq.Port
 	}
 
 	historyRecord := &models.TCPHistoryRecord{
@@ -292,12 +292,47 @@ func (hs *HistoryService) SaveTCPResponse(result models.TCPResponse) {
 		DecodedText:  result.DecodedText,
 		Status:       result.Status,
 		Error:        result.Error,
+		Agent:        agent,
+		Attempts:     attempts,
 	}
 
 	if err := hs.repo.SaveTCPHistory(historyRecord); err != nil {
 		log.Printf("Failed to save TCP history: %v", err)
-	} else {
-		log.Printf("Successfully saved TCP history for task %s", result.TaskID)
-		hs.RemoveCachedRequest(result.TaskID)
+		return err
+	}
+	log.Printf("Successfully saved TCP history for task %s", result.TaskID)
+	return nil
+}
+
+// SavePluginResponse stores a plug-in reply with the options it answers in
+// the collection the plug-in advertises. Replies that do not match the
+// plug-in's result schema are stored with status "invalid".
+func (hs *HistoryService) SavePluginResponse(result models.PluginResponse, agent *models.ScanAgent, attempts []models.ScanAttempt) error {
+	if hs.repo == nil || result.Collection == "" {
+		return nil
+	}
+
+	if err := ValidateSchema(result.ResultSchema, result.Result); err != nil {
+		log.Printf("%s result for task %s: %v", result.Service, result.TaskID, err)
+		result.Status = "invalid"
+		result.Error = err.Error()
+	}
+
+	historyRecord := &models.PluginHistoryRecord{
+		TaskID:   result.TaskID,
+		Service:  result.Service,
+		Status:   result.Status,
+		Error:    result.Error,
+		Agent:    agent,
+		Attempts: attempts,
+		Request:  result.Options,
+		Result:   result.Result,
+	}
+
+	if err := hs.repo.SavePluginHistory(result.Collection, historyRecord); err != nil {
+		log.Printf("Failed to save %s history: %v", result.Service, err)
+		return err
 	}
+	log.Printf("Successfully saved %s history for task %s", result.Service, result.TaskID)
+	return nil
 }
@@ -0,0 +1,333 @@
+package services
+
+import (
+	"backend/domain/models"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Ullaakut/nmap/v3"
+)
+
+const (
+	ImportFormatNmapXML     = "nmap-xml"
+	ImportFormatMasscanJSON = "masscan-json"
+)
+
+type ImportRepository interface {
+	SaveNmapTcpUdpHistory(record *models.NmapTcpUdpHistoryRecord) error
+	SaveNmapOsDetectionHistory(record *models.NmapOsDetectionHistoryRecord) error
+}
+
+// ImportService turns externally produced Nmap XML and masscan JSON output
+// into regular nmap_tcp_udp / nmap_os_detection history records, so imported
+// scans show up in history, search, export and the device inventory.
+//
+// Task IDs are derived from the file content and the host address, which
+// makes importing the same file twice a no-op thanks to the task_id upsert.
+type ImportService struct {
+	repo ImportRepository
+}
+
+func NewImportService(repo ImportRepository) *ImportService {
+	return &ImportService{repo: repo}
+}
+
+// Import reads r in the given format and stores the contained hosts.
+func (is *ImportService) Import(format string, r io.Reader) (*models.ImportResult, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read import data: %w", err)
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil, fmt.Errorf("import data is empty")
+	}
+
+	switch format {
+	case ImportFormatNmapXML:
+		return is.ImportNmapXML(data)
+	case ImportFormatMasscanJSON:
+		return is.ImportMasscanJSON(data)
+	default:
+		return nil, fmt.Errorf("unsupported import format %q (expected %s or %s)",
+			format, ImportFormatNmapXML, ImportFormatMasscanJSON)
+	}
+}
+
+// ImportNmapXML stores one nmap_tcp_udp record per host with ports and one
+// nmap_os_detection record per host with OS matches.
+func (is *ImportService) ImportNmapXML(data []byte) (*models.ImportResult, error) {
+	var run nmap.Run
+	if err := nmap.Parse(data, &run); err != nil {
+		return nil, fmt.Errorf("failed to parse nmap XML: %w", err)
+	}
+
+	result := &models.ImportResult{Format: ImportFormatNmapXML}
+	prefix := importTaskPrefix(data)
+	runStart := time.Time(run.Start)
+
+	for _, host := range run.Hosts {
+		addr, mac, vendor := nmapHostAddresses(host)
+		if addr == "" {
+			result.Skipped++
+			continue
+		}
+		result.Hosts++
+
+		// Zero times are replaced with the import time by the repository.
+		scannedAt := time.Time(host.StartTime)
+		if scannedAt.Unix() <= 0 {
+			scannedAt = runStart
+		}
+		if scannedAt.Unix() <= 0 {
+			scannedAt = time.Time{}
+		}
+
+		if len(host.Ports) > 0 {
+			portInfo := models.NmapPortTcpUdpInfo{Status: host.Status.State}
+			ports := make([]string, 0, len(host.Ports))
+			for _, port := range host.Ports {
+				portInfo.AllPorts = append(portInfo.AllPorts, port.ID)
+				portInfo.Protocols = append(portInfo.Protocols, port.Protocol)
+				portInfo.State = append(portInfo.State, port.State.State)
+
+				serviceName := port.Service.Name
+				if serviceName == "" {
+					serviceName = "unknown"
+				}
+				portInfo.ServiceName = append(portInfo.ServiceName, serviceName)
+				ports = append(ports, strconv.Itoa(int(port.ID)))
+			}
+
+			record := &models.NmapTcpUdpHistoryRecord{
+				TaskID:      prefix + "-" + addr,
+				IP:          addr,
+				ScannerType: "import",
+				Ports:       strings.Join(ports, ","),
+				Host:        addr,
+				PortInfo:    []models.NmapPortTcpUdpInfo{portInfo},
+				MAC:         mac,
+				MACVendor:   vendor,
+				Status:      "completed",
+				Source:      models.HistorySourceImport,
+				CreatedAt:   scannedAt,
+			}
+			if err := is.repo.SaveNmapTcpUdpHistory(record); err != nil {
+				return result, fmt.Errorf("failed to save host %s: %w", addr, err)
+			}
+			result.TcpUdpRecords++
+		}
+
+		if len(host.OS.Matches) > 0 {
+			osMatch := host.OS.Matches[0]
+			record := &models.NmapOsDetectionHistoryRecord{
+				TaskID:    prefix + "-os-" + addr,
+				IP:        addr,
+				Host:      addr,
+				Name:      osMatch.Name,
+				Accuracy:  osMatch.Accuracy,
+				Vendor:    "unknown",
+				Family:    "unknown",
+				Type:      "unknown",
+				Status:    "completed",
+				Source:    models.HistorySourceImport,
+				CreatedAt: scannedAt,
+			}
+			if len(osMatch.Classes) > 0 {
+				osClass := osMatch.Classes[0]
+				record.Vendor = osClass.Vendor
+				record.Family = osClass.Family
+				record.Type = osClass.Type
+			}
+			if err := is.repo.SaveNmapOsDetectionHistory(record); err != nil {
+				return result, fmt.Errorf("failed to save OS match for %s: %w", addr, err)
+			}
+			result.OsDetectionCount++
+		}
+
+		if len(host.Ports) == 0 && len(host.OS.Matches) == 0 {
+			result.Skipped++
+		}
+	}
+
+	log.Printf("[Import] Nmap XML: %d hosts, %d port records, %d OS records, %d skipped",
+		result.Hosts, result.TcpUdpRecords, result.OsDetectionCount, result.Skipped)
+	return result, nil
+}
+
+// masscanRecord is one object of masscan's -oJ output.
+type masscanRecord struct {
+	IP        string `json:"ip"`
+	Timestamp string `json:"timestamp"`
+	Ports     []struct {
+		Port    uint16 `json:"port"`
+		Proto   string `json:"proto"`
+		Status  string `json:"status"`
+		Service struct {
+			Name string `json:"name"`
+		} `json:"service"`
+	} `json:"ports"`
+}
+
+// masscanHost accumulates all masscan records seen for one address; masscan
+// emits a separate object per open port (and per banner).
+type masscanHost struct {
+	portInfo  models.NmapPortTcpUdpInfo
+	seen      map[string]int
+	scannedAt time.Time
+}
+
+// ImportMasscanJSON stores one nmap_tcp_udp record per address found in
+// masscan -oJ output.
+func (is *ImportService) ImportMasscanJSON(data []byte) (*models.ImportResult, error) {
+	records, err := parseMasscanJSON(data)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &models.ImportResult{Format: ImportFormatMasscanJSON}
+	hosts := make(map[string]*masscanHost)
+	for _, rec := range records {
+		if rec.IP == "" {
+			// {"finished": 1} and similar trailers carry no address or ports.
+			if len(rec.Ports) > 0 {
+				result.Skipped++
+			}
+			continue
+		}
+		h, ok := hosts[rec.IP]
+		if !ok {
+			h = &masscanHost{
+				portInfo: models.NmapPortTcpUdpInfo{Status: "up"},
+				seen:     make(map[string]int),
+			}
+			hosts[rec.IP] = h
+		}
+		if sec, err := strconv.ParseInt(strings.TrimSpace(rec.Timestamp), 10, 64); err == nil && sec > 0 {
+			if ts := time.Unix(sec, 0); h.scannedAt.IsZero() || ts.Before(h.scannedAt) {
+				h.scannedAt = ts
+			}
+		}
+
+		for _, port := range rec.Ports {
+			key := fmt.Sprintf("%d/%s", port.Port, port.Proto)
+			idx, exists := h.seen[key]
+			if !exists {
+				state := port.Status
+				if state == "" {
+					state = "open"
+				}
+				idx = len(h.portInfo.AllPorts)
+				h.seen[key] = idx
+				h.portInfo.AllPorts = append(h.portInfo.AllPorts, port.Port)
+				h.portInfo.Protocols = append(h.portInfo.Protocols, port.Proto)
+				h.portInfo.State = append(h.portInfo.State, state)
+				h.portInfo.ServiceName = append(h.portInfo.ServiceName, "unknown")
+			}
+			// Banner records carry the service name for a port reported earlier.
+			if port.Service.Name != "" {
+				h.portInfo.ServiceName[idx] = port.Service.Name
+			}
+		}
+	}
+
+	addrs := make([]string, 0, len(hosts))
+	for addr := range hosts {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+
+	prefix := importTaskPrefix(data)
+	for _, addr := range addrs {
+		h := hosts[addr]
+		result.Hosts++
+		if len(h.portInfo.AllPorts) == 0 {
+			result.Skipped++
+			continue
+		}
+
+		ports := make([]string, 0, len(h.portInfo.AllPorts))
+		for _, p := range h.portInfo.AllPorts {
+			ports = append(ports, strconv.Itoa(int(p)))
+		}
+		record := &models.NmapTcpUdpHistoryRecord{
+			TaskID:      prefix + "-" + addr,
+			IP:          addr,
+			ScannerType: "masscan",
+			Ports:       strings.Join(ports, ","),
+			Host:        addr,
+			PortInfo:    []models.NmapPortTcpUdpInfo{h.portInfo},
+			Status:      "completed",
+			Source:      models.HistorySourceImport,
+			CreatedAt:   h.scannedAt,
+		}
+		if err := is.repo.SaveNmapTcpUdpHistory(record); err != nil {
+			return result, fmt.Errorf("failed to save host %s: %w", addr, err)
+		}
+		result.TcpUdpRecords++
+	}
+
+	log.Printf("[Import] masscan JSON: %d hosts, %d port records, %d skipped",
+		result.Hosts, result.TcpUdpRecords, result.Skipped)
+	return result, nil
+}
+
+// parseMasscanJSON accepts both a well-formed JSON array and masscan's
+// line-oriented -oJ output, which has leading/trailing commas, bare "[" /
+// "]" lines and a trailing {"finished": 1} object.
+func parseMasscanJSON(data []byte) ([]masscanRecord, error) {
+	var records []masscanRecord
+	if err := json.Unmarshal(data, &records); err == nil {
+		return records, nil
+	}
+
+	records = records[:0]
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		line = strings.TrimPrefix(line, "[")
+		line = strings.TrimSuffix(line, "]")
+		line = strings.Trim(strings.TrimSpace(line), ",")
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		var rec masscanRecord
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			return nil, fmt.Errorf("invalid masscan JSON on line %d: %w", i+1, err)
+		}
+		records = append(records, rec)
+	}
+	return records, nil
+}
+
+// nmapHostAddresses returns the host's IP address (IPv4 preferred) and, when
+// nmap reported it, its MAC address and vendor.
+func nmapHostAddresses(host nmap.Host) (addr, mac, vendor string) {
+	for _, a := range host.Addresses {
+		switch a.AddrType {
+		case "ipv4":
+			addr = a.Addr
+		case "ipv6":
+			if addr == "" {
+				addr = a.Addr
+			}
+		case "mac":
+			mac = strings.ToLower(a.Addr)
+			vendor = a.Vendor
+		}
+	}
+	return addr, mac, vendor
+}
+
+func importTaskPrefix(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "import-" + hex.EncodeToString(sum[:6])
+}
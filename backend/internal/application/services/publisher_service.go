@@ -3,11 +3,12 @@ package services
 import (
 	"backend/domain/models"
 	rabbitmq "backend/internal/infrastructure/messaging"
-	"log"
+	"context"
 )
 
 type PublisherService struct {
 	publisher *rabbitmq.RPCScannerPublisher
+	onError   func(taskID string, err error)
 }
 
 func NewPublisherService(publisher *rabbitmq.RPCScannerPublisher) *PublisherService {
@@ -16,13 +17,9 @@ func NewPublisherService(publisher *rabbitmq.RPCScannerPublisher) *PublisherServ
 	}
 }
 
-func (ps *PublisherService) PublishNmapRequest(req interface{}) *models.Response {
-	log.Printf("Publishing Nmap request: %+v", req)
-
-	resp, err := ps.publisher.PublishNmap(req)
+func (ps *PublisherService) PublishNmapRequest(ctx context.Context, req interface{}) *models.Response {
+	resp, err := ps.publisher.PublishNmap(ctx, req)
 	if err != nil {
-		log.Printf("Failed to publish Nmap task: %v", err)
-
 		var taskID string
 		switch r := req.(type) {
 		case models.NmapTcpUdpRequest:
@@ -35,56 +32,62 @@ func (ps *PublisherService) PublishNmapRequest(req interface{}) *models.Response
 			taskID = "unknown"
 		}
 
-		return &models.Response{
-			TaskID: taskID,
-			Result: map[string]string{"error": err.Error()},
-		}
+		return ps.fail(taskID, err)
 	}
 	return resp
 }
 
-func (ps *PublisherService) PublishARPRequest(req models.ARPRequest) *models.Response {
-	log.Printf("Publishing ARP request: %+v", req)
-
-	resp, err := ps.publisher.PublishArp(req)
+func (ps *PublisherService) PublishARPRequest(ctx context.Context, req models.ARPRequest) *models.Response {
+	resp, err := ps.publisher.PublishArp(ctx, req)
 	if err != nil {
-		log.Printf("Failed to publish ARP task: %v", err)
-		return &models.Response{
-			TaskID: req.TaskID,
-			Result: map[string]string{"error": err.Error()},
-		}
+		return ps.fail(req.TaskID, err)
 	}
 	return resp
 }
 
-func (ps *PublisherService) PublishICMPRequest(req models.ICMPRequest) *models.Response {
-	log.Printf("Publishing ICMP request: %+v", req)
-
-	resp, err := ps.publisher.PublishIcmp(req)
+func (ps *PublisherService) PublishICMPRequest(ctx context.Context, req models.ICMPRequest) *models.Response {
+	resp, err := ps.publisher.PublishIcmp(ctx, req)
 	if err != nil {
-		log.Printf("Failed to publish ICMP task: %v", err)
-		return &models.Response{
-			TaskID: req.TaskID,
-			Result: map[string]string{"error": err.Error()},
-		}
+		return ps.fail(req.TaskID, err)
 	}
 	return resp
 }
 
-func (ps *PublisherService) PublishTCPRequest(req models.TCPRequest) *models.Response {
-	log.Printf("Publishing TCP request: %+v", req)
+func (ps *PublisherService) PublishTCPRequest(ctx context.Context, req models.TCPRequest) *models.Response {
+	resp, err := ps.publisher.PublishTcp(ctx, req)
+	if err != nil {
+		return ps.fail(req.TaskID, err)
+	}
+	return resp
+}
 
-	resp, err := ps.publisher.PublishTcp(req)
+func (ps *PublisherService) PublishPluginRequest(ctx context.Context, plugin models.ScannerPlugin, req map[string]interface{}) *models.Response {
+	resp, err := ps.publisher.PublishPlugin(ctx, plugin, req)
 	if err != nil {
-		log.Printf("Failed to publish TCP task: %v", err)
-		return &models.Response{
-			TaskID: req.TaskID,
-			Result: map[string]string{"error": err.Error()},
-		}
+		taskID, _ := req["task_id"].(string)
+		return ps.fail(taskID, err)
 	}
 	return resp
 }
 
-func (ps *PublisherService) SetResponseCallback(callback func(*models.Response)) {
+// fail reports err to the error callback and returns it as the response
+// for taskID.
+func (ps *PublisherService) fail(taskID string, err error) *models.Response {
+	if ps.onError != nil {
+		ps.onError(taskID, err)
+	}
+	return &models.Response{
+		TaskID: taskID,
+		Result: map[string]string{"error": err.Error()},
+	}
+}
+
+// SetErrorCallback is called with the task id of every request that could
+// not be published or got no reply.
+func (ps *PublisherService) SetErrorCallback(callback func(taskID string, err error)) {
+	ps.onError = callback
+}
+
+func (ps *PublisherService) SetResponseCallback(callback func(*models.Response) error) {
 	ps.publisher.SetResponseCallback(callback)
 }
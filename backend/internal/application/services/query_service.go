@@ -0,0 +1,397 @@
+package services
+
+import (
+	"backend/domain/models"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+const (
+	defaultQueryLimit = 50
+	maxQueryLimit     = 1000
+	maxSuggestions    = 20
+	// maxJoinHosts bounds the hosts matched by each part of a query joined
+	// across scopes.
+	maxJoinHosts = 100000
+)
+
+// QueryRepository runs compiled queries. hosts, when not nil, limits the
+// matches to those host addresses (ip_num).
+type QueryRepository interface {
+	RunQuery(scope string, q *models.Query, hosts []int64, limit int) (interface{}, int, error)
+	// QueryHosts returns up to limit distinct addresses of the hosts
+	// matching q in scope.
+	QueryHosts(scope string, q *models.Query, hosts []int64, limit int) ([]int64, error)
+	SuggestQueryValues(scope string, field models.QueryField, prefix string, limit int) ([]string, error)
+}
+
+// QueryService runs search queries written in the query language, e.g.
+//
+//	port:22 state:open vendor:"Cisco" net:10.1.0.0/16 seen:<7d
+//
+// against the nmap, OS, ARP, TCP and inventory collections. A query using
+// fields of several collections is split by collection and joined per host.
+type QueryService struct {
+	repo QueryRepository
+}
+
+func NewQueryService(repo QueryRepository) *QueryService {
+	return &QueryService{repo: repo}
+}
+
+// Search parses raw and runs it in the requested scopes. With no scopes it
+// runs in every scope that supports all fields used by the query, or, when
+// there is none, joins the scopes the fields belong to (see join).
+func (qs *QueryService) Search(raw string, scopes []string, limit int) (*models.Query, []models.QueryScopeResult, error) {
+	q, err := ParseQuery(raw)
+	if err != nil {
+		return nil, nil, err
+	}
+	if limit <= 0 {
+		limit = defaultQueryLimit
+	}
+	if limit > maxQueryLimit {
+		limit = maxQueryLimit
+	}
+
+	if len(scopes) == 0 {
+		scopes = queryScopesFor(q)
+		if len(scopes) == 0 {
+			return qs.join(q, limit)
+		}
+	}
+	for _, scope := range scopes {
+		if err := checkQueryScope(q, scope); err != nil {
+			return q, nil, err
+		}
+	}
+
+	results := make([]models.QueryScopeResult, 0, len(scopes))
+	for _, scope := range scopes {
+		records, count, err := qs.repo.RunQuery(scope, q, nil, limit)
+		if err != nil {
+			return q, nil, fmt.Errorf("query in scope %s: %w", scope, err)
+		}
+		results = append(results, models.QueryScopeResult{Scope: scope, Count: count, Records: records})
+	}
+	return q, results, nil
+}
+
+// join runs the parts of q from splitQuery one after another, each limited
+// to the hosts matched by the parts before it, so the hosts left match
+// every part. The records of each part are returned for those hosts.
+func (qs *QueryService) join(q *models.Query, limit int) (*models.Query, []models.QueryScopeResult, error) {
+	parts := splitQuery(q)
+
+	var hosts []int64
+	for i, part := range parts {
+		matched, err := qs.repo.QueryHosts(part.scope, part.query, hosts, maxJoinHosts+1)
+		if err != nil {
+			return q, nil, fmt.Errorf("query in scope %s: %w", part.scope, err)
+		}
+		if len(matched) > maxJoinHosts {
+			return q, nil, &models.QueryError{Msg: fmt.Sprintf("more than %d hosts match in scope %s; narrow the query with ip: or net:", maxJoinHosts, part.scope)}
+		}
+		hosts = matched
+		if len(hosts) == 0 {
+			parts = parts[:i+1]
+			break
+		}
+	}
+
+	results := make([]models.QueryScopeResult, 0, len(parts))
+	for _, part := range parts {
+		res := models.QueryScopeResult{Scope: part.scope, Terms: part.query.Terms, Records: []interface{}{}}
+		if len(hosts) > 0 {
+			records, count, err := qs.repo.RunQuery(part.scope, part.query, hosts, limit)
+			if err != nil {
+				return q, nil, fmt.Errorf("query in scope %s: %w", part.scope, err)
+			}
+			res.Count, res.Records = count, records
+		}
+		results = append(results, res)
+	}
+	return q, results, nil
+}
+
+type queryPart struct {
+	scope string
+	query *models.Query
+}
+
+// splitQuery divides the terms of q among as few scopes as possible:
+// repeatedly, the scope supporting most of the unassigned terms takes all
+// of them. Terms available in every scope (ip, net, seen) go to every
+// part; bare words go to the first.
+func splitQuery(q *models.Query) []queryPart {
+	var shared, pending []models.QueryTerm
+	for _, t := range q.Terms {
+		field, _ := models.LookupQueryField(t.Field)
+		if len(field.Scopes) == len(models.QueryScopes) {
+			shared = append(shared, t)
+		} else {
+			pending = append(pending, t)
+		}
+	}
+
+	var parts []queryPart
+	for len(pending) > 0 {
+		best, bestCount := "", 0
+		for _, scope := range models.QueryScopes {
+			n := 0
+			for _, t := range pending {
+				if field, _ := models.LookupQueryField(t.Field); field.Supports(scope) {
+					n++
+				}
+			}
+			if n > bestCount {
+				best, bestCount = scope, n
+			}
+		}
+
+		part := queryPart{scope: best, query: &models.Query{Raw: q.Raw}}
+		var rest []models.QueryTerm
+		for _, t := range pending {
+			if field, _ := models.LookupQueryField(t.Field); field.Supports(best) {
+				part.query.Terms = append(part.query.Terms, t)
+			} else {
+				rest = append(rest, t)
+			}
+		}
+		part.query.Terms = append(part.query.Terms, shared...)
+		parts = append(parts, part)
+		pending = rest
+	}
+	if len(parts) > 0 {
+		parts[0].query.Text = q.Text
+	}
+	return parts
+}
+
+// Fields returns the field catalogue for autocomplete.
+func (qs *QueryService) Fields() []models.QueryField {
+	return models.QueryFields
+}
+
+// Suggest returns known values of field starting with prefix, for
+// autocomplete. Enum fields answer from the catalogue, other fields from
+// the data in the first scope that has them.
+func (qs *QueryService) Suggest(name, prefix, scope string) ([]string, error) {
+	field, ok := models.LookupQueryField(strings.ToLower(name))
+	if !ok {
+		return nil, unknownFieldError(name)
+	}
+
+	prefix = strings.ToLower(prefix)
+	if len(field.Values) > 0 {
+		values := []string{}
+		for _, v := range field.Values {
+			if strings.HasPrefix(v, prefix) {
+				values = append(values, v)
+			}
+		}
+		return values, nil
+	}
+	switch field.Type {
+	case models.QueryTypeCIDR, models.QueryTypeTime, models.QueryTypeNumber:
+		return []string{}, nil
+	}
+
+	if scope == "" {
+		scope = field.Scopes[0]
+	} else if !field.Supports(scope) {
+		return nil, &models.QueryError{Field: field.Name, Msg: "not available in scope " + scope}
+	}
+	return qs.repo.SuggestQueryValues(scope, field, prefix, maxSuggestions)
+}
+
+// queryScopesFor returns the scopes supporting every field used by q.
+func queryScopesFor(q *models.Query) []string {
+	var scopes []string
+	for _, scope := range models.QueryScopes {
+		if checkQueryScope(q, scope) == nil {
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes
+}
+
+func checkQueryScope(q *models.Query, scope string) error {
+	known := false
+	for _, s := range models.QueryScopes {
+		known = known || s == scope
+	}
+	if !known {
+		return &models.QueryError{Msg: fmt.Sprintf("unknown scope %q (expected one of %s)", scope, strings.Join(models.QueryScopes, ", "))}
+	}
+	for _, t := range q.Terms {
+		field, _ := models.LookupQueryField(t.Field)
+		if !field.Supports(scope) {
+			return &models.QueryError{Field: t.Field, Msg: "not available in scope " + scope}
+		}
+	}
+	return nil
+}
+
+// ── Parser ───────────────────────────────────────────────────────────────────
+
+// ParseQuery parses the query language:
+//
+//	query  = { clause }
+//	clause = [ "-" ] field ":" [ op ] values | word | quoted
+//	op     = "<" | "<=" | ">" | ">="
+//	values = value { "," value } | quoted
+//
+// Field names are resolved to their canonical names (aliases such as os
+// become os.name) and checked against the catalogue.
+func ParseQuery(raw string) (*models.Query, error) {
+	q := &models.Query{Raw: raw, Terms: []models.QueryTerm{}}
+	p := &queryParser{src: []rune(raw)}
+
+	for {
+		p.skipSpace()
+		if p.eof() {
+			break
+		}
+
+		if p.peek() == '"' {
+			text, err := p.quoted()
+			if err != nil {
+				return nil, err
+			}
+			if text != "" {
+				q.Text = append(q.Text, text)
+			}
+			continue
+		}
+
+		start := p.pos
+		negate := false
+		if p.peek() == '-' {
+			negate = true
+			p.pos++
+		}
+		name := p.until(func(r rune) bool { return r == ':' || unicode.IsSpace(r) })
+		if p.eof() || p.peek() != ':' || name == "" {
+			// A bare word: free-text search.
+			p.pos = start
+			q.Text = append(q.Text, p.until(unicode.IsSpace))
+			continue
+		}
+		p.pos++ // ':'
+
+		field, ok := models.LookupQueryField(strings.ToLower(name))
+		if !ok {
+			return nil, unknownFieldError(name)
+		}
+
+		term := models.QueryTerm{Field: field.Name, Op: ":", Negate: negate}
+		for _, op := range []string{"<=", ">=", "<", ">"} {
+			if strings.HasPrefix(string(p.src[p.pos:]), op) {
+				term.Op = op
+				p.pos += len(op)
+				break
+			}
+		}
+		if !contains(field.Operators, term.Op) {
+			return nil, &models.QueryError{Field: field.Name, Msg: fmt.Sprintf("operator %q is not supported (use %s)", term.Op, strings.Join(field.Operators, " "))}
+		}
+
+		if !p.eof() && p.peek() == '"' {
+			value, err := p.quoted()
+			if err != nil {
+				return nil, err
+			}
+			term.Values = []string{value}
+		} else {
+			for _, v := range strings.Split(p.until(unicode.IsSpace), ",") {
+				if v = strings.TrimSpace(v); v != "" {
+					term.Values = append(term.Values, v)
+				}
+			}
+		}
+		if len(term.Values) == 0 || (len(term.Values) == 1 && term.Values[0] == "") {
+			return nil, &models.QueryError{Field: field.Name, Msg: "value required"}
+		}
+		if term.Op != ":" && len(term.Values) > 1 {
+			return nil, &models.QueryError{Field: field.Name, Msg: "comparison takes a single value"}
+		}
+		q.Terms = append(q.Terms, term)
+	}
+
+	if len(q.Terms) == 0 && len(q.Text) == 0 {
+		return nil, &models.QueryError{Msg: "empty query"}
+	}
+	return q, nil
+}
+
+type queryParser struct {
+	src []rune
+	pos int
+}
+
+func (p *queryParser) eof() bool  { return p.pos >= len(p.src) }
+func (p *queryParser) peek() rune { return p.src[p.pos] }
+
+func (p *queryParser) skipSpace() {
+	for !p.eof() && unicode.IsSpace(p.peek()) {
+		p.pos++
+	}
+}
+
+// until consumes runes up to (not including) the first one matching stop.
+func (p *queryParser) until(stop func(rune) bool) string {
+	start := p.pos
+	for !p.eof() && !stop(p.peek()) {
+		p.pos++
+	}
+	return string(p.src[start:p.pos])
+}
+
+// quoted consumes a double-quoted string; \" and \\ are escapes.
+func (p *queryParser) quoted() (string, error) {
+	start := p.pos
+	p.pos++ // opening quote
+	var b strings.Builder
+	for !p.eof() {
+		r := p.peek()
+		p.pos++
+		switch {
+		case r == '\\' && !p.eof():
+			b.WriteRune(p.peek())
+			p.pos++
+		case r == '"':
+			return b.String(), nil
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return "", &models.QueryError{Msg: fmt.Sprintf("unterminated quote at position %d", start)}
+}
+
+func unknownFieldError(name string) error {
+	name = strings.ToLower(name)
+	var candidates []string
+	for _, f := range models.QueryFields {
+		if strings.HasPrefix(f.Name, name) || strings.HasPrefix(name, f.Name) {
+			candidates = append(candidates, f.Name)
+		}
+	}
+	msg := "unknown field"
+	if len(candidates) > 0 {
+		sort.Strings(candidates)
+		msg += ", did you mean " + strings.Join(candidates, " or ") + "?"
+	}
+	return &models.QueryError{Field: name, Msg: msg}
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
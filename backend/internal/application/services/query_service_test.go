@@ -0,0 +1,188 @@
+package services
+
+import (
+	"backend/domain/models"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+const exampleQuery = `port:22 state:open vendor:"Cisco" os.family:Linux net:10.1.0.0/16 banner:"OpenSSH_7" seen:<7d`
+
+func TestParseQuery(t *testing.T) {
+	tests := []struct {
+		raw   string
+		terms []models.QueryTerm
+		text  []string
+	}{
+		{
+			raw: exampleQuery,
+			terms: []models.QueryTerm{
+				{Field: "port", Op: ":", Values: []string{"22"}},
+				{Field: "state", Op: ":", Values: []string{"open"}},
+				{Field: "vendor", Op: ":", Values: []string{"Cisco"}},
+				{Field: "os.family", Op: ":", Values: []string{"Linux"}},
+				{Field: "net", Op: ":", Values: []string{"10.1.0.0/16"}},
+				{Field: "banner", Op: ":", Values: []string{"OpenSSH_7"}},
+				{Field: "seen", Op: "<", Values: []string{"7d"}},
+			},
+		},
+		{
+			raw:   "port:22,80,8000-8100",
+			terms: []models.QueryTerm{{Field: "port", Op: ":", Values: []string{"22", "80", "8000-8100"}}},
+		},
+		{
+			raw: `os:"Windows Server" -service:http host:10.1.2.*`,
+			terms: []models.QueryTerm{
+				{Field: "os.name", Op: ":", Values: []string{"Windows Server"}},
+				{Field: "service", Op: ":", Values: []string{"http"}, Negate: true},
+				{Field: "ip", Op: ":", Values: []string{"10.1.2.*"}},
+			},
+		},
+		{
+			raw:   `os.accuracy:>=90 nginx "a \"b\""`,
+			terms: []models.QueryTerm{{Field: "os.accuracy", Op: ">=", Values: []string{"90"}}},
+			text:  []string{"nginx", `a "b"`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			q, err := ParseQuery(tt.raw)
+			if err != nil {
+				t.Fatalf("ParseQuery: %v", err)
+			}
+			if !reflect.DeepEqual(q.Terms, tt.terms) {
+				t.Errorf("terms = %+v, want %+v", q.Terms, tt.terms)
+			}
+			if !reflect.DeepEqual(q.Text, tt.text) {
+				t.Errorf("text = %q, want %q", q.Text, tt.text)
+			}
+		})
+	}
+}
+
+func TestParseQueryErrors(t *testing.T) {
+	tests := []struct {
+		raw   string
+		field string
+	}{
+		{raw: ""},
+		{raw: "prot:22", field: "prot"},
+		{raw: "vendor:>Cisco", field: "vendor"},
+		{raw: "port:", field: "port"},
+		{raw: "port:<22,80", field: "port"},
+		{raw: `banner:"OpenSSH`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			_, err := ParseQuery(tt.raw)
+			var qerr *models.QueryError
+			if !errors.As(err, &qerr) {
+				t.Fatalf("err = %v, want a QueryError", err)
+			}
+			if qerr.Field != tt.field {
+				t.Errorf("field = %q, want %q", qerr.Field, tt.field)
+			}
+		})
+	}
+}
+
+func TestSplitQuery(t *testing.T) {
+	tests := []struct {
+		raw   string
+		parts map[string][]string // scope → fields
+	}{
+		{
+			raw: exampleQuery,
+			parts: map[string][]string{
+				models.QueryScopeNmap: {"port", "state", "vendor", "net", "seen"},
+				models.QueryScopeOS:   {"os.family", "net", "seen"},
+				models.QueryScopeTCP:  {"banner", "net", "seen"},
+			},
+		},
+		{
+			raw: "iface:eth0 os.family:Linux",
+			parts: map[string][]string{
+				models.QueryScopeOS:  {"os.family"},
+				models.QueryScopeARP: {"iface"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			q, err := ParseQuery(tt.raw)
+			if err != nil {
+				t.Fatalf("ParseQuery: %v", err)
+			}
+			got := map[string][]string{}
+			for _, part := range splitQuery(q) {
+				for _, term := range part.query.Terms {
+					got[part.scope] = append(got[part.scope], term.Field)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.parts) {
+				t.Errorf("parts = %v, want %v", got, tt.parts)
+			}
+		})
+	}
+}
+
+// joinRepo answers QueryHosts with fixed hosts per scope.
+type joinRepo struct {
+	hosts map[string][]int64
+}
+
+func (r *joinRepo) RunQuery(scope string, q *models.Query, hosts []int64, limit int) (interface{}, int, error) {
+	return hosts, len(hosts), nil
+}
+
+func (r *joinRepo) QueryHosts(scope string, q *models.Query, hosts []int64, limit int) ([]int64, error) {
+	var out []int64
+	for _, h := range r.hosts[scope] {
+		if hosts == nil || contains64(hosts, h) {
+			out = append(out, h)
+		}
+	}
+	return out, nil
+}
+
+func (r *joinRepo) SuggestQueryValues(scope string, field models.QueryField, prefix string, limit int) ([]string, error) {
+	return nil, nil
+}
+
+func contains64(list []int64, n int64) bool {
+	for _, v := range list {
+		if v == n {
+			return true
+		}
+	}
+	return false
+}
+
+func TestSearchJoinsScopes(t *testing.T) {
+	repo := &joinRepo{hosts: map[string][]int64{
+		models.QueryScopeNmap: {1, 2, 3},
+		models.QueryScopeOS:   {2, 3, 4},
+		models.QueryScopeTCP:  {2, 3, 5},
+	}}
+	_, results, err := NewQueryService(repo).Search(exampleQuery, nil, 0)
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if len(results) != 3 {
+		t.Fatalf("got %d results, want one per part", len(results))
+	}
+	for _, res := range results {
+		if hosts := res.Records.([]int64); !reflect.DeepEqual(hosts, []int64{2, 3}) {
+			t.Errorf("%s: hosts = %v, want [2 3]", res.Scope, hosts)
+		}
+	}
+}
+
+func TestSearchExplicitScopeRejectsForeignFields(t *testing.T) {
+	_, _, err := NewQueryService(&joinRepo{}).Search(exampleQuery, []string{models.QueryScopeNmap}, 0)
+	var qerr *models.QueryError
+	if !errors.As(err, &qerr) || qerr.Field != "os.family" {
+		t.Fatalf("err = %v, want os.family not available", err)
+	}
+}
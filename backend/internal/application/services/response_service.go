@@ -7,43 +7,69 @@ import (
 
 type ResponseService struct {
 	historyService *HistoryService
+	tasks          *TaskService
 }
 
-func NewResponseService(historyService *HistoryService) *ResponseService {
+func NewResponseService(historyService *HistoryService, tasks *TaskService) *ResponseService {
 	return &ResponseService{
 		historyService: historyService,
+		tasks:          tasks,
 	}
 }
 
-func (rs *ResponseService) ProcessResponse(response *models.Response) {
+// ProcessResponse stores response and finishes its task. An error means
+// the result was not stored and the reply should be delivered again.
+func (rs *ResponseService) ProcessResponse(response *models.Response) error {
 	if response == nil {
 		log.Printf("ProcessResponse: response is nil")
-		return
+		return nil
 	}
 
 	log.Printf("ProcessResponse: processing response for task %s", response.TaskID)
 	log.Printf("ProcessResponse: response result type: %T", response.Result)
 
+	var errMsg string
+	var err error
 	switch result := response.Result.(type) {
 	case models.ARPResponse:
 		log.Printf("Processing ARP response")
-		rs.historyService.SaveARPResponse(result)
+		err = rs.historyService.SaveARPResponse(result, response.Agent, response.Attempts)
+		errMsg = result.Error
 	case models.ICMPResponse:
 		log.Printf("Processing ICMP response")
-		rs.historyService.SaveICMPResponse(result)
+		err = rs.historyService.SaveICMPResponse(result, response.Agent, response.Attempts)
+		errMsg = result.Error
 	case models.NmapTcpUdpResponse:
 		log.Printf("Processing Nmap TCP/UDP response")
-		rs.historyService.SaveNmapTcpUdpResponse(result)
+		err = rs.historyService.SaveNmapTcpUdpResponse(result, response.Agent, response.Attempts)
+		errMsg = result.Error
 	case models.NmapOsDetectionResponse:
 		log.Printf("Processing Nmap OS Detection response")
-		rs.historyService.SaveNmapOsDetectionResponse(result)
+		err = rs.historyService.SaveNmapOsDetectionResponse(result, response.Agent, response.Attempts)
+		errMsg = result.Error
 	case models.NmapHostDiscoveryResponse:
 		log.Printf("Processing Nmap Host Discovery response")
-		rs.historyService.SaveNmapHostDiscoveryResponse(result)
+		err = rs.historyService.SaveNmapHostDiscoveryResponse(result, response.Agent, response.Attempts)
+		errMsg = result.Error
 	case models.TCPResponse:
 		log.Printf("Processing TCP response")
-		rs.historyService.SaveTCPResponse(result)
+		err = rs.historyService.SaveTCPResponse(result, response.Agent, response.Attempts)
+		errMsg = result.Error
+	case models.PluginResponse:
+		log.Printf("Processing %s response", result.Service)
+		err = rs.historyService.SavePluginResponse(result, response.Agent, response.Attempts)
+		errMsg = result.Error
 	default:
 		log.Printf("Unknown response type: %T", result)
+		return nil
 	}
+	if err != nil {
+		return err
+	}
+
+	// The task is finished once its result is stored with its parameters.
+	if rs.tasks != nil {
+		rs.tasks.Finish(response.TaskID, errMsg, response.Agent, response.Attempts)
+	}
+	return nil
 }
@@ -0,0 +1,152 @@
+package services
+
+import (
+	"backend/domain/models"
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// ObjectPurger removes raw scanner artefacts stored outside MongoDB —
+// currently the TCP scanner's MinIO objects referenced by HexObjectKey.
+type ObjectPurger interface {
+	PurgeTCPObjects(keys []string) error
+}
+
+type RetentionRepository interface {
+	DeleteHistoryWhere(scanType string, f models.HistoryDeleteFilter) (int64, error)
+	GetTCPObjectKeysWhere(f models.HistoryDeleteFilter) ([]string, error)
+	CompactHistoryBefore(scanType string, cutoff time.Time) (int64, error)
+	GetHistorySummaries(scanType, target string, limit int) ([]models.HistoryDailySummary, error)
+}
+
+// RetentionService handles selective history deletion and the retention
+// policy: raw per-scan documents are kept for RetentionDays, after which
+// only daily summaries remain.
+type RetentionService struct {
+	repo          RetentionRepository
+	retentionDays int
+	purger        ObjectPurger
+	mu            sync.RWMutex
+}
+
+func NewRetentionService(repo RetentionRepository, retentionDays int) *RetentionService {
+	return &RetentionService{
+		repo:          repo,
+		retentionDays: retentionDays,
+	}
+}
+
+// SetPurger wires the MinIO purger once RabbitMQ is available.
+func (rs *RetentionService) SetPurger(purger ObjectPurger) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.purger = purger
+}
+
+func (rs *RetentionService) RetentionDays() int {
+	return rs.retentionDays
+}
+
+// Delete removes the scanType records selected by filter. For TCP history
+// the referenced MinIO objects are purged as well.
+func (rs *RetentionService) Delete(scanType string, filter models.HistoryDeleteFilter) (int64, error) {
+	var keys []string
+	if scanType == "tcp" {
+		var err error
+		if keys, err = rs.repo.GetTCPObjectKeysWhere(filter); err != nil {
+			return 0, err
+		}
+	}
+
+	deleted, err := rs.repo.DeleteHistoryWhere(scanType, filter)
+	if err != nil {
+		return 0, err
+	}
+
+	rs.purgeTCPObjects(keys)
+	return deleted, nil
+}
+
+// ApplyPolicy compacts every scan type's history older than the retention
+// window into daily summaries. The cutoff is aligned to UTC midnight so a
+// day is always summarised as a whole.
+func (rs *RetentionService) ApplyPolicy() {
+	if rs.retentionDays <= 0 {
+		return
+	}
+
+	cutoff := time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, -rs.retentionDays)
+	log.Printf("[Retention] Compacting history older than %s", cutoff.Format(time.RFC3339))
+
+	for _, scanType := range models.HistoryScanTypes {
+		var keys []string
+		if scanType == "tcp" {
+			var err error
+			keys, err = rs.repo.GetTCPObjectKeysWhere(models.HistoryDeleteFilter{OlderThan: cutoff})
+			if err != nil {
+				log.Printf("[Retention] Failed to collect TCP object keys: %v", err)
+				continue
+			}
+		}
+
+		deleted, err := rs.repo.CompactHistoryBefore(scanType, cutoff)
+		if err != nil {
+			log.Printf("[Retention] Failed to compact %s history: %v", scanType, err)
+			continue
+		}
+		if deleted > 0 {
+			log.Printf("[Retention] Compacted %d %s records into daily summaries", deleted, scanType)
+		}
+
+		rs.purgeTCPObjects(keys)
+	}
+}
+
+// Run applies the retention policy immediately and then every interval
+// until ctx is cancelled. It is a no-op when retention is disabled.
+func (rs *RetentionService) Run(ctx context.Context, interval time.Duration) {
+	if rs.retentionDays <= 0 {
+		log.Printf("[Retention] Disabled (HISTORY_RETENTION_DAYS=0)")
+		return
+	}
+	log.Printf("[Retention] Keeping raw history for %d days, checking every %s", rs.retentionDays, interval)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	rs.ApplyPolicy()
+	for {
+		select {
+		case <-ticker.C:
+			rs.ApplyPolicy()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (rs *RetentionService) GetSummaries(scanType, target string, limit int) ([]models.HistoryDailySummary, error) {
+	return rs.repo.GetHistorySummaries(scanType, target, limit)
+}
+
+func (rs *RetentionService) purgeTCPObjects(keys []string) {
+	if len(keys) == 0 {
+		return
+	}
+
+	rs.mu.RLock()
+	purger := rs.purger
+	rs.mu.RUnlock()
+
+	if purger == nil {
+		log.Printf("[Retention] RabbitMQ not ready — %d TCP objects left in MinIO", len(keys))
+		return
+	}
+	if err := purger.PurgeTCPObjects(keys); err != nil {
+		log.Printf("[Retention] Failed to request purge of %d TCP objects: %v", len(keys), err)
+		return
+	}
+	log.Printf("[Retention] Requested purge of %d TCP objects", len(keys))
+}
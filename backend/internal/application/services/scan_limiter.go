@@ -0,0 +1,188 @@
+package services
+
+import (
+	"backend/domain/models"
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+)
+
+// ScanLimits configures the ScanLimiter. A limit of 0 means unlimited.
+type ScanLimits struct {
+	// PerScanner caps the scans in flight per scanner service; Default
+	// applies to services not listed.
+	PerScanner map[string]int
+	Default    int
+	// PerSubnet caps the scans in flight per target subnet, whatever the
+	// scanner. IPv4 targets are grouped by their SubnetPrefix network,
+	// IPv6 targets by their /64. Targets that are not addresses (host
+	// names) are not subnet-limited.
+	PerSubnet    int
+	SubnetPrefix int
+	// Reserve slots of every scanner and subnet limit are kept for
+	// interactive scans.
+	Reserve int
+}
+
+// ScanLimiter caps the scans the backend has in flight across all clients.
+// Waiting scans are admitted lane by lane (interactive, scheduled, bulk),
+// in arrival order within a lane, so a queue of bulk sweeps cannot starve
+// an operator's interactive check.
+type ScanLimiter struct {
+	mu      sync.Mutex
+	limits  ScanLimits
+	running map[string]int // by scanner service
+	subnets map[string]int // by subnet
+	waiting []*scanWaiter  // by rank, then arrival
+}
+
+type scanWaiter struct {
+	service string
+	subnet  string
+	rank    int
+	ready   chan struct{}
+}
+
+func NewScanLimiter(limits ScanLimits) *ScanLimiter {
+	return &ScanLimiter{
+		limits:  limits,
+		running: make(map[string]int),
+		subnets: make(map[string]int),
+	}
+}
+
+// Acquire waits until a scan of target by service may start in the given
+// priority lane and returns the function that frees its slot. Unknown
+// lanes are treated as bulk. The wait ends early with ctx's error.
+func (l *ScanLimiter) Acquire(ctx context.Context, service, target, priority string) (func(), error) {
+	rank, _ := models.PriorityRank(priority)
+	w := &scanWaiter{
+		service: service,
+		subnet:  subnetKey(target, l.limits.SubnetPrefix),
+		rank:    rank,
+		ready:   make(chan struct{}),
+	}
+
+	l.mu.Lock()
+	i := sort.Search(len(l.waiting), func(i int) bool { return l.waiting[i].rank > w.rank })
+	l.waiting = append(l.waiting, nil)
+	copy(l.waiting[i+1:], l.waiting[i:])
+	l.waiting[i] = w
+	l.dispatch()
+	l.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		return l.releaser(w), nil
+	case <-ctx.Done():
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		select {
+		case <-w.ready:
+			// Admitted while giving up: hand the slot on.
+			l.free(w)
+		default:
+			l.remove(w)
+		}
+		l.dispatch()
+		return nil, fmt.Errorf("waiting for a %s slot: %w", service, ctx.Err())
+	}
+}
+
+func (l *ScanLimiter) releaser(w *scanWaiter) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			l.free(w)
+			l.dispatch()
+		})
+	}
+}
+
+// dispatch admits every waiting scan, in order, whose limits allow it. A
+// scan held back by its own subnet does not hold up other subnets. Must be
+// called with l.mu held.
+func (l *ScanLimiter) dispatch() {
+	kept := l.waiting[:0]
+	for _, w := range l.waiting {
+		if !l.fits(w) {
+			kept = append(kept, w)
+			continue
+		}
+		l.running[w.service]++
+		if w.subnet != "" {
+			l.subnets[w.subnet]++
+		}
+		close(w.ready)
+	}
+	for i := len(kept); i < len(l.waiting); i++ {
+		l.waiting[i] = nil
+	}
+	l.waiting = kept
+}
+
+func (l *ScanLimiter) fits(w *scanWaiter) bool {
+	limit, ok := l.limits.PerScanner[w.service]
+	if !ok {
+		limit = l.limits.Default
+	}
+	if !underLimit(l.running[w.service], limit, w.rank, l.limits.Reserve) {
+		return false
+	}
+	return w.subnet == "" || underLimit(l.subnets[w.subnet], l.limits.PerSubnet, w.rank, l.limits.Reserve)
+}
+
+// underLimit reports whether one more scan fits under limit. Lanes below
+// interactive may not use the reserved slots, but always get at least one.
+func underLimit(inFlight, limit, rank, reserve int) bool {
+	if limit <= 0 {
+		return true
+	}
+	if rank > 0 {
+		limit -= reserve
+		if limit < 1 {
+			limit = 1
+		}
+	}
+	return inFlight < limit
+}
+
+func (l *ScanLimiter) free(w *scanWaiter) {
+	if l.running[w.service]--; l.running[w.service] <= 0 {
+		delete(l.running, w.service)
+	}
+	if w.subnet != "" {
+		if l.subnets[w.subnet]--; l.subnets[w.subnet] <= 0 {
+			delete(l.subnets, w.subnet)
+		}
+	}
+}
+
+func (l *ScanLimiter) remove(w *scanWaiter) {
+	for i, other := range l.waiting {
+		if other == w {
+			l.waiting = append(l.waiting[:i], l.waiting[i+1:]...)
+			return
+		}
+	}
+}
+
+// subnetKey is the network target is limited under, "" for targets that
+// are not addresses.
+func subnetKey(target string, prefix int) string {
+	ip := targetIP(target)
+	if ip == nil {
+		return ""
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		if prefix <= 0 || prefix > 32 {
+			prefix = 32
+		}
+		return (&net.IPNet{IP: ip4.Mask(net.CIDRMask(prefix, 32)), Mask: net.CIDRMask(prefix, 32)}).String()
+	}
+	return (&net.IPNet{IP: ip.Mask(net.CIDRMask(64, 128)), Mask: net.CIDRMask(64, 128)}).String()
+}
@@ -0,0 +1,171 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"backend/domain/models"
+)
+
+// waitQueued blocks until n scans are waiting in l.
+func waitQueued(t *testing.T, l *ScanLimiter, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		l.mu.Lock()
+		queued := len(l.waiting)
+		l.mu.Unlock()
+		if queued == n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("%d scans never queued", n)
+}
+
+func TestScanLimiterLaneOrder(t *testing.T) {
+	tests := []struct {
+		name    string
+		arrival []string
+		want    []string
+	}{
+		{
+			name:    "lanes",
+			arrival: []string{models.PriorityBulk, models.PriorityScheduled, models.PriorityInteractive},
+			want:    []string{models.PriorityInteractive, models.PriorityScheduled, models.PriorityBulk},
+		},
+		{
+			name:    "arrival within a lane",
+			arrival: []string{"bulk-1", models.PriorityInteractive, "bulk-2"},
+			want:    []string{models.PriorityInteractive, "bulk-1", "bulk-2"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := NewScanLimiter(ScanLimits{Default: 1})
+			hold, err := l.Acquire(context.Background(), "nmap_service", "10.0.0.1", models.PriorityInteractive)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			admitted := make(chan string, len(tt.arrival))
+			for i, lane := range tt.arrival {
+				go func(lane string) {
+					// Unknown lanes are bulk, which lets the names tell
+					// scans of one lane apart.
+					release, err := l.Acquire(context.Background(), "nmap_service", "10.0.0.1", lane)
+					if err != nil {
+						t.Error(err)
+						admitted <- ""
+						return
+					}
+					admitted <- lane
+					release()
+				}(lane)
+				waitQueued(t, l, i+1)
+			}
+
+			hold()
+			for i, want := range tt.want {
+				if got := <-admitted; got != want {
+					t.Errorf("admission %d = %q, want %q", i, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestUnderLimit(t *testing.T) {
+	tests := []struct {
+		inFlight, limit, rank, reserve int
+		want                           bool
+	}{
+		{inFlight: 100, limit: 0, rank: 2, reserve: 1, want: true},
+		{inFlight: 2, limit: 3, rank: 0, reserve: 1, want: true},
+		{inFlight: 2, limit: 3, rank: 1, reserve: 1, want: false},
+		{inFlight: 1, limit: 3, rank: 2, reserve: 1, want: true},
+		{inFlight: 0, limit: 2, rank: 2, reserve: 5, want: true},
+		{inFlight: 1, limit: 2, rank: 2, reserve: 5, want: false},
+		{inFlight: 3, limit: 3, rank: 0, reserve: 1, want: false},
+	}
+	for _, tt := range tests {
+		if got := underLimit(tt.inFlight, tt.limit, tt.rank, tt.reserve); got != tt.want {
+			t.Errorf("underLimit(%d, %d, %d, %d) = %v, want %v", tt.inFlight, tt.limit, tt.rank, tt.reserve, got, tt.want)
+		}
+	}
+}
+
+func TestScanLimiterReserve(t *testing.T) {
+	tests := []struct {
+		name   string
+		limits ScanLimits
+		target string
+	}{
+		{name: "scanner", limits: ScanLimits{PerScanner: map[string]int{"arp_service": 3}, Reserve: 1}, target: "scanner.local"},
+		{name: "subnet", limits: ScanLimits{PerSubnet: 3, SubnetPrefix: 24, Reserve: 1}, target: "10.0.5.7"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := NewScanLimiter(tt.limits)
+			for i := 0; i < 2; i++ {
+				if _, err := l.Acquire(context.Background(), "arp_service", tt.target, models.PriorityBulk); err != nil {
+					t.Fatalf("bulk scan %d: %v", i, err)
+				}
+			}
+
+			// The last slot is reserved: bulk waits, interactive gets it.
+			ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+			defer cancel()
+			if _, err := l.Acquire(ctx, "arp_service", tt.target, models.PriorityBulk); !errors.Is(err, context.DeadlineExceeded) {
+				t.Fatalf("bulk scan took the reserved slot: err = %v", err)
+			}
+			release, err := l.Acquire(context.Background(), "arp_service", tt.target, models.PriorityInteractive)
+			if err != nil {
+				t.Fatalf("interactive scan: %v", err)
+			}
+			release()
+			release() // a second release frees nothing
+
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			if len(l.waiting) != 0 {
+				t.Errorf("%d scans left waiting", len(l.waiting))
+			}
+			if n := l.running["arp_service"]; n != 2 {
+				t.Errorf("running = %d, want 2", n)
+			}
+		})
+	}
+}
+
+func TestScanLimiterSubnetsIndependent(t *testing.T) {
+	l := NewScanLimiter(ScanLimits{PerSubnet: 1, SubnetPrefix: 24})
+	if _, err := l.Acquire(context.Background(), "icmp_service", "10.0.5.7", models.PriorityBulk); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := l.Acquire(ctx, "icmp_service", "10.0.6.7", models.PriorityBulk); err != nil {
+		t.Fatalf("a full subnet held up another: %v", err)
+	}
+}
+
+func TestSubnetKey(t *testing.T) {
+	tests := []struct {
+		target string
+		prefix int
+		want   string
+	}{
+		{"10.0.5.7", 24, "10.0.5.0/24"},
+		{"10.0.5.7", 0, "10.0.5.7/32"},
+		{"fd00::1:2", 24, "fd00::/64"},
+		{"router.local", 24, ""},
+	}
+	for _, tt := range tests {
+		if got := subnetKey(tt.target, tt.prefix); got != tt.want {
+			t.Errorf("subnetKey(%q, %d) = %q, want %q", tt.target, tt.prefix, got, tt.want)
+		}
+	}
+}
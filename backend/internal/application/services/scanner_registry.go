@@ -0,0 +1,311 @@
+package services
+
+import (
+	"backend/domain/models"
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrScannerUnavailable = errors.New("no live scanner instance")
+	ErrUnknownScanner     = errors.New("unknown scanner service")
+)
+
+// Plug-in results are stored in pluginCollectionPrefix + the advertised
+// collection name, so a plug-in cannot write into the built-in collections.
+const pluginCollectionPrefix = "plugin_"
+
+var collectionNameRe = regexp.MustCompile(`^[a-z0-9_]{1,64}$`)
+
+const (
+	// Interval assumed for heartbeats that do not state one.
+	defaultHeartbeatInterval = 10 * time.Second
+	// An instance is live until this many heartbeats in a row are missed.
+	heartbeatMisses = 3
+	// Instances silent for this long are dropped from the registry.
+	forgetInstanceAfter = 10 * time.Minute
+)
+
+// PluginStore persists the collection each plug-in service stores its
+// results in; PluginCollection returns "" for an unknown service.
+type PluginStore interface {
+	SavePluginCollection(service, collection string) error
+	PluginCollection(service string) (string, error)
+}
+
+// ScannerRegistry tracks scanner instances from their heartbeats.
+type ScannerRegistry struct {
+	mu        sync.RWMutex
+	instances map[string]*models.ScannerInstance // by instance id
+	// collections caches the plug-in collections known to be stored.
+	collections map[string]string
+	store       PluginStore
+}
+
+func NewScannerRegistry() *ScannerRegistry {
+	return &ScannerRegistry{
+		instances:   make(map[string]*models.ScannerInstance),
+		collections: make(map[string]string),
+	}
+}
+
+// SetPluginStore persists plug-in collections so their history resolves
+// before the plug-in's first heartbeat after a restart.
+func (sr *ScannerRegistry) SetPluginStore(store PluginStore) {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	sr.store = store
+}
+
+// Observe records a heartbeat, and the collection of a plug-in the first
+// time it is seen or when it changes.
+func (sr *ScannerRegistry) Observe(hb models.ScannerHeartbeat) error {
+	if hb.InstanceID == "" || hb.Service == "" {
+		return nil
+	}
+	inst := &models.ScannerInstance{ScannerHeartbeat: hb, LastSeen: time.Now()}
+	sr.mu.Lock()
+	sr.instances[hb.InstanceID] = inst
+	store := sr.store
+	var collection string
+	if !models.BuiltinScanners[hb.Service] && store != nil {
+		if c, err := pluginCollection(inst); err == nil && sr.collections[hb.Service] != c {
+			collection = c
+		}
+	}
+	sr.mu.Unlock()
+
+	if collection == "" {
+		return nil
+	}
+	if err := store.SavePluginCollection(hb.Service, collection); err != nil {
+		return fmt.Errorf("store collection of %s: %w", hb.Service, err)
+	}
+	sr.mu.Lock()
+	sr.collections[hb.Service] = collection
+	sr.mu.Unlock()
+	return nil
+}
+
+// Route picks the site a request for service is sent to. An explicit
+// agent must have a live instance at that site. Otherwise the live,
+// site-tagged instance whose advertised subnet most narrowly contains
+// target wins, falling back to the untagged instances on the shared queue
+// (site ""). ErrScannerUnavailable is returned when nothing can take the
+// request.
+func (sr *ScannerRegistry) Route(service, agent, target string) (string, error) {
+	sr.mu.RLock()
+	defer sr.mu.RUnlock()
+	now := time.Now()
+
+	if agent != "" {
+		for _, inst := range sr.instances {
+			if inst.Service == service && inst.Site == agent && instanceLive(inst, now) {
+				return agent, nil
+			}
+		}
+		return "", fmt.Errorf("%w for %s at site %s", ErrScannerUnavailable, service, agent)
+	}
+
+	ip := targetIP(target)
+	site, bestBits, shared := "", -1, false
+	for _, inst := range sr.instances {
+		if inst.Service != service || !instanceLive(inst, now) {
+			continue
+		}
+		if inst.Site == "" {
+			shared = true
+			continue
+		}
+		if ip == nil {
+			continue
+		}
+		for _, subnet := range inst.Subnets {
+			_, network, err := net.ParseCIDR(subnet)
+			if err != nil || !network.Contains(ip) {
+				continue
+			}
+			if bits, _ := network.Mask.Size(); bits > bestBits {
+				site, bestBits = inst.Site, bits
+			}
+		}
+	}
+	if bestBits >= 0 {
+		return site, nil
+	}
+	if shared {
+		return "", nil
+	}
+	return "", fmt.Errorf("%w for %s", ErrScannerUnavailable, service)
+}
+
+// targetIP is the first address of a scan target: an IP, a CIDR block or
+// an "a.b.c.d-e.f.g.h" range. Host names yield nil.
+func targetIP(target string) net.IP {
+	target = strings.TrimSpace(target)
+	if ip, _, err := net.ParseCIDR(target); err == nil {
+		return ip
+	}
+	if i := strings.IndexByte(target, '-'); i > 0 {
+		target = target[:i]
+	}
+	return net.ParseIP(strings.TrimSpace(target))
+}
+
+// Plugin returns the plug-in contract of service as advertised by its most
+// recent live instance. Built-in scanners are not plug-ins.
+func (sr *ScannerRegistry) Plugin(service string) (models.ScannerPlugin, error) {
+	if models.BuiltinScanners[service] {
+		return models.ScannerPlugin{}, fmt.Errorf("%w: %s is built in", ErrUnknownScanner, service)
+	}
+
+	sr.mu.RLock()
+	defer sr.mu.RUnlock()
+	now := time.Now()
+	var newest *models.ScannerInstance
+	known := false
+	for _, inst := range sr.instances {
+		if inst.Service != service {
+			continue
+		}
+		known = true
+		if instanceLive(inst, now) && (newest == nil || inst.LastSeen.After(newest.LastSeen)) {
+			newest = inst
+		}
+	}
+	if !known {
+		return models.ScannerPlugin{}, fmt.Errorf("%w: %s", ErrUnknownScanner, service)
+	}
+	if newest == nil {
+		return models.ScannerPlugin{}, fmt.Errorf("%w for %s", ErrScannerUnavailable, service)
+	}
+
+	collection, err := pluginCollection(newest)
+	if err != nil {
+		return models.ScannerPlugin{}, err
+	}
+	return models.ScannerPlugin{
+		Service:       service,
+		Queue:         service,
+		ScanMethods:   newest.ScanMethods,
+		OptionsSchema: newest.OptionsSchema,
+		ResultSchema:  newest.ResultSchema,
+		Collection:    collection,
+	}, nil
+}
+
+// PluginCollection returns the collection results of plug-in service are
+// stored in. Unlike Plugin it does not need a live instance: without one
+// it falls back to the stored collection, so history stays reachable while
+// the scanner is down and after a backend restart.
+func (sr *ScannerRegistry) PluginCollection(service string) (string, error) {
+	if models.BuiltinScanners[service] {
+		return "", fmt.Errorf("%w: %s is built in", ErrUnknownScanner, service)
+	}
+
+	sr.mu.RLock()
+	var newest *models.ScannerInstance
+	for _, inst := range sr.instances {
+		if inst.Service == service && (newest == nil || inst.LastSeen.After(newest.LastSeen)) {
+			newest = inst
+		}
+	}
+	cached, store := sr.collections[service], sr.store
+	sr.mu.RUnlock()
+
+	if newest != nil {
+		return pluginCollection(newest)
+	}
+	if cached != "" {
+		return cached, nil
+	}
+	if store != nil {
+		collection, err := store.PluginCollection(service)
+		if err != nil {
+			return "", err
+		}
+		if collection != "" {
+			sr.mu.Lock()
+			sr.collections[service] = collection
+			sr.mu.Unlock()
+			return collection, nil
+		}
+	}
+	return "", fmt.Errorf("%w: %s", ErrUnknownScanner, service)
+}
+
+// pluginCollection is the storage collection advertised by inst, defaulting
+// to the service name.
+func pluginCollection(inst *models.ScannerInstance) (string, error) {
+	collection := inst.Collection
+	if collection == "" {
+		collection = inst.Service
+	}
+	if !collectionNameRe.MatchString(collection) {
+		return "", fmt.Errorf("%s advertises invalid collection %q", inst.Service, collection)
+	}
+	return pluginCollectionPrefix + collection, nil
+}
+
+// Scanners lists the known services with their instances, ordered by
+// service name and hostname.
+func (sr *ScannerRegistry) Scanners() []models.ScannerInfo {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+
+	now := time.Now()
+	byService := make(map[string]*models.ScannerInfo)
+	newest := make(map[string]time.Time)
+	for id, inst := range sr.instances {
+		if now.Sub(inst.LastSeen) > forgetInstanceAfter {
+			delete(sr.instances, id)
+			continue
+		}
+		info, ok := byService[inst.Service]
+		if !ok {
+			info = &models.ScannerInfo{Service: inst.Service, Builtin: models.BuiltinScanners[inst.Service]}
+			byService[inst.Service] = info
+		}
+		entry := *inst
+		entry.Live = instanceLive(inst, now)
+		info.Instances = append(info.Instances, entry)
+		if entry.Live {
+			info.Live = true
+			info.LiveInstances++
+			if entry.Site != "" && !contains(info.Sites, entry.Site) {
+				info.Sites = append(info.Sites, entry.Site)
+			}
+		}
+		// Capabilities come from the instance heard from last.
+		if inst.LastSeen.After(newest[inst.Service]) {
+			newest[inst.Service] = inst.LastSeen
+			info.ScanMethods = inst.ScanMethods
+			info.OptionsSchema = inst.OptionsSchema
+		}
+	}
+
+	out := make([]models.ScannerInfo, 0, len(byService))
+	for _, info := range byService {
+		sort.Strings(info.Sites)
+		sort.Slice(info.Instances, func(i, j int) bool {
+			return info.Instances[i].Hostname < info.Instances[j].Hostname
+		})
+		out = append(out, *info)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Service < out[j].Service })
+	return out
+}
+
+func instanceLive(inst *models.ScannerInstance, now time.Time) bool {
+	interval := time.Duration(inst.IntervalSeconds * float64(time.Second))
+	if interval <= 0 {
+		interval = defaultHeartbeatInterval
+	}
+	return now.Sub(inst.LastSeen) <= heartbeatMisses*interval
+}
@@ -0,0 +1,122 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+var ErrSchemaViolation = errors.New("does not match schema")
+
+// jsonSchema is the subset of JSON Schema scanners advertise their options
+// and results with.
+type jsonSchema struct {
+	Type                 string                 `json:"type"`
+	Required             []string               `json:"required"`
+	Properties           map[string]*jsonSchema `json:"properties"`
+	AdditionalProperties *bool                  `json:"additionalProperties"`
+	Items                *jsonSchema            `json:"items"`
+	Enum                 []interface{}          `json:"enum"`
+	Minimum              *float64               `json:"minimum"`
+	Maximum              *float64               `json:"maximum"`
+}
+
+// ValidateSchema checks value, as decoded by encoding/json, against the
+// JSON schema raw. An empty schema accepts anything. Violations are
+// reported by path and wrap ErrSchemaViolation.
+func ValidateSchema(raw json.RawMessage, value interface{}) error {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil
+	}
+	var schema jsonSchema
+	if err := json.Unmarshal(raw, &schema); err != nil {
+		return fmt.Errorf("invalid schema: %w", err)
+	}
+	var problems []string
+	schema.validate("$", value, &problems)
+	if len(problems) > 0 {
+		return fmt.Errorf("%w: %s", ErrSchemaViolation, strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+func (s *jsonSchema) validate(path string, value interface{}, problems *[]string) {
+	if s == nil {
+		return
+	}
+	fail := func(format string, args ...interface{}) {
+		*problems = append(*problems, path+": "+fmt.Sprintf(format, args...))
+	}
+
+	if s.Type != "" && !schemaTypeMatches(s.Type, value) {
+		fail("expected %s", s.Type)
+		return
+	}
+	if len(s.Enum) > 0 && !enumContains(s.Enum, value) {
+		fail("not one of the allowed values")
+	}
+
+	switch v := value.(type) {
+	case float64:
+		if s.Minimum != nil && v < *s.Minimum {
+			fail("below minimum %v", *s.Minimum)
+		}
+		if s.Maximum != nil && v > *s.Maximum {
+			fail("above maximum %v", *s.Maximum)
+		}
+	case []interface{}:
+		for i, item := range v {
+			s.Items.validate(fmt.Sprintf("%s[%d]", path, i), item, problems)
+		}
+	case map[string]interface{}:
+		for _, name := range s.Required {
+			if _, ok := v[name]; !ok {
+				fail("missing required field %q", name)
+			}
+		}
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			prop, ok := s.Properties[name]
+			if !ok {
+				if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+					fail("unexpected field %q", name)
+				}
+				continue
+			}
+			prop.validate(path+"."+name, v[name], problems)
+		}
+	}
+}
+
+func schemaTypeMatches(typ string, value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return typ == "null"
+	case bool:
+		return typ == "boolean"
+	case string:
+		return typ == "string"
+	case float64:
+		return typ == "number" || (typ == "integer" && v == float64(int64(v)))
+	case []interface{}:
+		return typ == "array"
+	case map[string]interface{}:
+		return typ == "object"
+	}
+	return false
+}
+
+func enumContains(enum []interface{}, value interface{}) bool {
+	for _, e := range enum {
+		if e == value {
+			return true
+		}
+	}
+	return false
+}
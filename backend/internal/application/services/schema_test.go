@@ -0,0 +1,77 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+const optionsSchema = `{
+	"type": "object",
+	"required": ["target"],
+	"additionalProperties": false,
+	"properties": {
+		"target":  {"type": "string"},
+		"ports":   {"type": "array", "items": {"type": "integer", "minimum": 1, "maximum": 65535}},
+		"mode":    {"type": "string", "enum": ["fast", "full"]},
+		"verbose": {"type": "boolean"}
+	}
+}`
+
+func TestValidateSchema(t *testing.T) {
+	tests := []struct {
+		name     string
+		schema   string
+		value    string
+		problems []string // substrings of the error, none when valid
+	}{
+		{name: "valid", schema: optionsSchema, value: `{"target": "10.0.0.1", "ports": [22, 443], "mode": "fast", "verbose": true}`},
+		{name: "empty schema", schema: ``, value: `{"anything": 1}`},
+		{name: "null schema", schema: `null`, value: `[1, "a"]`},
+		{name: "missing required", schema: optionsSchema, value: `{"ports": [22]}`, problems: []string{`$: missing required field "target"`}},
+		{name: "unexpected field", schema: optionsSchema, value: `{"target": "a", "speed": 3}`, problems: []string{`$: unexpected field "speed"`}},
+		{name: "wrong type", schema: optionsSchema, value: `{"target": 7}`, problems: []string{"$.target: expected string"}},
+		{name: "not an object", schema: optionsSchema, value: `"10.0.0.1"`, problems: []string{"$: expected object"}},
+		{name: "integer", schema: optionsSchema, value: `{"target": "a", "ports": [22.5]}`, problems: []string{"$.ports[0]: expected integer"}},
+		{
+			name:     "bounds",
+			schema:   optionsSchema,
+			value:    `{"target": "a", "ports": [0, 22, 70000]}`,
+			problems: []string{"$.ports[0]: below minimum 1", "$.ports[2]: above maximum 65535"},
+		},
+		{name: "enum", schema: optionsSchema, value: `{"target": "a", "mode": "slow"}`, problems: []string{"$.mode: not one of the allowed values"}},
+		{name: "additional allowed by default", schema: `{"type": "object"}`, value: `{"x": null}`},
+		{name: "null type", schema: `{"type": "null"}`, value: `null`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var value interface{}
+			if err := json.Unmarshal([]byte(tt.value), &value); err != nil {
+				t.Fatal(err)
+			}
+			err := ValidateSchema(json.RawMessage(tt.schema), value)
+			if len(tt.problems) == 0 {
+				if err != nil {
+					t.Fatalf("ValidateSchema: %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, ErrSchemaViolation) {
+				t.Fatalf("err = %v, want ErrSchemaViolation", err)
+			}
+			for _, p := range tt.problems {
+				if !strings.Contains(err.Error(), p) {
+					t.Errorf("error %q does not report %q", err, p)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateSchemaInvalidSchema(t *testing.T) {
+	err := ValidateSchema(json.RawMessage(`{"type": 5}`), map[string]interface{}{})
+	if err == nil || errors.Is(err, ErrSchemaViolation) {
+		t.Fatalf("err = %v, want an invalid schema error", err)
+	}
+}
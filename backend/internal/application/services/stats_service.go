@@ -0,0 +1,148 @@
+package services
+
+import (
+	"backend/domain/models"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var ErrInvalidStatsFilter = errors.New("invalid stats filter")
+
+const (
+	defaultStatsWindow = 30 * 24 * time.Hour
+	defaultStatsLimit  = 20
+	maxStatsLimit      = 500
+	// Ranges up to this long are bucketed per hour by default.
+	hourlyStatsWindow = 3 * 24 * time.Hour
+	// Upper bound on the number of points in one time series.
+	maxStatsBuckets = 2000
+)
+
+type StatsRepository interface {
+	StatsOpenPorts(f models.StatsFilter) ([]models.PortStat, error)
+	StatsServices(f models.StatsFilter) ([]models.ServiceStat, error)
+	StatsVendors(f models.StatsFilter) ([]models.BreakdownStat, error)
+	StatsOSFamilies(f models.StatsFilter) ([]models.BreakdownStat, error)
+	StatsHosts(f models.StatsFilter) ([]models.HostsPoint, error)
+	StatsEvents(f models.StatsFilter) ([]models.SeverityPoint, error)
+	StatsScannerVolume(f models.StatsFilter) ([]models.ScannerVolume, error)
+}
+
+// StatsService computes the dashboard aggregations. Every method
+// normalises the filter first: the window defaults to the last 30 days,
+// the bucket to hours for windows up to 3 days and days otherwise.
+type StatsService struct {
+	repo StatsRepository
+}
+
+func NewStatsService(repo StatsRepository) *StatsService {
+	return &StatsService{repo: repo}
+}
+
+// Normalize validates f and fills in the defaults.
+func (ss *StatsService) Normalize(f models.StatsFilter) (models.StatsFilter, error) {
+	if f.Until.IsZero() {
+		f.Until = time.Now()
+	}
+	if f.Since.IsZero() {
+		f.Since = f.Until.Add(-defaultStatsWindow)
+	}
+	if !f.Since.Before(f.Until) {
+		return f, fmt.Errorf("%w: since must be before until", ErrInvalidStatsFilter)
+	}
+
+	if f.Subnet != "" {
+		if _, ok := models.ParseIPv4Intervals(f.Subnet); !ok {
+			return f, fmt.Errorf("%w: subnet %q is not an IPv4 address, CIDR or range, or expands to more than %d ranges", ErrInvalidStatsFilter, f.Subnet, models.MaxIPv4Intervals)
+		}
+	}
+
+	window := f.Until.Sub(f.Since)
+	switch f.Bucket {
+	case "":
+		f.Bucket = models.StatsBucketDay
+		if window <= hourlyStatsWindow {
+			f.Bucket = models.StatsBucketHour
+		}
+	case models.StatsBucketDay:
+	case models.StatsBucketHour:
+		if window > maxStatsBuckets*time.Hour {
+			return f, fmt.Errorf("%w: window too long for hourly buckets", ErrInvalidStatsFilter)
+		}
+	default:
+		return f, fmt.Errorf("%w: bucket must be %q or %q", ErrInvalidStatsFilter, models.StatsBucketHour, models.StatsBucketDay)
+	}
+
+	if f.Limit <= 0 {
+		f.Limit = defaultStatsLimit
+	}
+	if f.Limit > maxStatsLimit {
+		f.Limit = maxStatsLimit
+	}
+	return f, nil
+}
+
+func (ss *StatsService) OpenPorts(f models.StatsFilter) ([]models.PortStat, error) {
+	return statsQuery(ss, f, ss.repo.StatsOpenPorts)
+}
+
+func (ss *StatsService) Services(f models.StatsFilter) ([]models.ServiceStat, error) {
+	return statsQuery(ss, f, ss.repo.StatsServices)
+}
+
+func (ss *StatsService) Vendors(f models.StatsFilter) ([]models.BreakdownStat, error) {
+	return statsQuery(ss, f, ss.repo.StatsVendors)
+}
+
+func (ss *StatsService) OSFamilies(f models.StatsFilter) ([]models.BreakdownStat, error) {
+	return statsQuery(ss, f, ss.repo.StatsOSFamilies)
+}
+
+func (ss *StatsService) Hosts(f models.StatsFilter) ([]models.HostsPoint, error) {
+	return statsQuery(ss, f, ss.repo.StatsHosts)
+}
+
+func (ss *StatsService) Events(f models.StatsFilter) ([]models.SeverityPoint, error) {
+	return statsQuery(ss, f, ss.repo.StatsEvents)
+}
+
+func (ss *StatsService) ScannerVolume(f models.StatsFilter) ([]models.ScannerVolume, error) {
+	return statsQuery(ss, f, ss.repo.StatsScannerVolume)
+}
+
+// Overview runs every aggregation with the same filter.
+func (ss *StatsService) Overview(f models.StatsFilter) (*models.StatsOverview, error) {
+	f, err := ss.Normalize(f)
+	if err != nil {
+		return nil, err
+	}
+
+	o := &models.StatsOverview{Filter: f}
+	steps := []struct {
+		name string
+		run  func() error
+	}{
+		{"ports", func() (err error) { o.Ports, err = ss.repo.StatsOpenPorts(f); return }},
+		{"services", func() (err error) { o.Services, err = ss.repo.StatsServices(f); return }},
+		{"vendors", func() (err error) { o.Vendors, err = ss.repo.StatsVendors(f); return }},
+		{"os families", func() (err error) { o.OS, err = ss.repo.StatsOSFamilies(f); return }},
+		{"hosts", func() (err error) { o.Hosts, err = ss.repo.StatsHosts(f); return }},
+		{"events", func() (err error) { o.Events, err = ss.repo.StatsEvents(f); return }},
+		{"scanners", func() (err error) { o.Scanners, err = ss.repo.StatsScannerVolume(f); return }},
+	}
+	for _, step := range steps {
+		if err := step.run(); err != nil {
+			return nil, fmt.Errorf("stats %s: %w", step.name, err)
+		}
+	}
+	return o, nil
+}
+
+func statsQuery[T any](ss *StatsService, f models.StatsFilter, run func(models.StatsFilter) ([]T, error)) ([]T, error) {
+	f, err := ss.Normalize(f)
+	if err != nil {
+		return nil, err
+	}
+	return run(f)
+}
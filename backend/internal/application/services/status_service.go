@@ -0,0 +1,295 @@
+package services
+
+import (
+	"backend/domain/models"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A scanner queue holding more ready messages than this is reported as
+// degraded: its consumers are not keeping up.
+const scannerBacklogLimit = 100
+
+type StatusRepository interface {
+	Ping() error
+	LastScanResult(scanTypes []string) (time.Time, error)
+}
+
+// StatusBroker is the part of the RabbitMQ publisher the status checks use.
+type StatusBroker interface {
+	Connected() bool
+	InspectQueue(name string) (models.QueueStatus, error)
+}
+
+// StatusRegistry reports the scanner instances heard from, whose
+// placements decide which queues are inspected.
+type StatusRegistry interface {
+	Scanners() []models.ScannerInfo
+}
+
+// StatusService probes the backend's dependencies for /api/status and the
+// readiness probe. The broker is wired in once RabbitMQ is connected;
+// until then AMQP and the scanners are reported down.
+type StatusService struct {
+	repo          StatusRepository
+	minioEndpoint string
+	minioSecure   bool
+	client        *http.Client
+	broker        StatusBroker
+	registry      StatusRegistry
+	mu            sync.RWMutex
+}
+
+// NewStatusService checks MinIO at minioEndpoint (host:port or URL); an
+// empty endpoint leaves MinIO out of the report.
+func NewStatusService(repo StatusRepository, minioEndpoint string) *StatusService {
+	return &StatusService{
+		repo:          repo,
+		minioEndpoint: minioEndpoint,
+		client:        &http.Client{Timeout: 3 * time.Second},
+	}
+}
+
+// SetMinIOTLS probes MinIO over HTTPS, also for an endpoint given as
+// host:port, verifying it with cfg; nil uses the system roots. Call it
+// before the first check.
+func (ss *StatusService) SetMinIOTLS(cfg *tls.Config) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = cfg
+	ss.client.Transport = transport
+	ss.minioSecure = true
+}
+
+// SetBroker wires the RabbitMQ publisher once it is available.
+func (ss *StatusService) SetBroker(broker StatusBroker) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	ss.broker = broker
+}
+
+// SetRegistry reports the site queues and plug-in queues of the live
+// scanner instances besides the built-in shared queues.
+func (ss *StatusService) SetRegistry(registry StatusRegistry) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	ss.registry = registry
+}
+
+func (ss *StatusService) getBroker() StatusBroker {
+	ss.mu.RLock()
+	defer ss.mu.RUnlock()
+	return ss.broker
+}
+
+// Ready reports whether requests can be served: MongoDB answers and the
+// AMQP connection is open. The checks are returned either way.
+func (ss *StatusService) Ready() (bool, []models.ComponentCheck) {
+	checks := []models.ComponentCheck{ss.checkMongo(), ss.checkAMQP()}
+	for _, c := range checks {
+		if c.Status != models.StatusOK {
+			return false, checks
+		}
+	}
+	return true, checks
+}
+
+// Status runs every check. The system is down when MongoDB or RabbitMQ is,
+// degraded when MinIO or any scanner is not ok.
+func (ss *StatusService) Status() *models.SystemStatus {
+	st := &models.SystemStatus{
+		Status:     models.StatusOK,
+		CheckedAt:  time.Now().UTC(),
+		Components: []models.ComponentCheck{ss.checkMongo(), ss.checkAMQP()},
+	}
+	for _, c := range st.Components {
+		if c.Status != models.StatusOK {
+			st.Status = models.StatusDown
+		}
+	}
+
+	minio := ss.checkMinIO()
+	if minio != nil {
+		st.Components = append(st.Components, *minio)
+	}
+
+	st.Scanners = ss.scannerStatuses(minio)
+	if st.Status == models.StatusOK {
+		if minio != nil && minio.Status != models.StatusOK {
+			st.Status = models.StatusDegraded
+		}
+		for _, s := range st.Scanners {
+			if s.Status != models.StatusOK {
+				st.Status = models.StatusDegraded
+			}
+		}
+	}
+	return st
+}
+
+func (ss *StatusService) scannerStatuses(minio *models.ComponentCheck) []models.ScannerStatus {
+	broker := ss.getBroker()
+	connected := broker != nil && broker.Connected()
+
+	var out []models.ScannerStatus
+	for _, scanner := range ss.scannerQueues() {
+		s := models.ScannerStatus{Name: scanner.name, Site: scanner.site, Status: models.StatusOK}
+
+		// Plug-in results have no last-result lookup.
+		if len(scanner.scanTypes) > 0 {
+			if last, err := ss.repo.LastScanResult(scanner.scanTypes); err != nil {
+				s.Error = fmt.Sprintf("last result: %v", err)
+			} else if !last.IsZero() {
+				s.LastResult = &last
+			}
+		}
+
+		queueName := scanner.queue
+		if !connected {
+			s.Status = models.StatusDown
+			s.Error = "rabbitmq not connected"
+		} else if q, err := broker.InspectQueue(queueName); err != nil {
+			s.Status = models.StatusDown
+			s.Error = err.Error()
+		} else {
+			s.Queue = &q
+			switch {
+			case q.Consumers == 0:
+				s.Status = models.StatusDown
+				s.Error = "no consumers on " + queueName
+			case q.Messages > scannerBacklogLimit:
+				s.Status = models.StatusDegraded
+				s.Error = fmt.Sprintf("%d messages waiting on %s", q.Messages, queueName)
+			}
+		}
+
+		// The TCP scanner stores banners in MinIO and cannot work without it.
+		if scanner.name == "tcp" && minio != nil && minio.Status != models.StatusOK && s.Status == models.StatusOK {
+			s.Status = models.StatusDegraded
+			s.Error = "minio unreachable"
+		}
+		out = append(out, s)
+	}
+	return out
+}
+
+// scannerQueue is one queue reported by scannerStatuses.
+type scannerQueue struct {
+	name      string
+	site      string
+	queue     string
+	scanTypes []string
+}
+
+// scannerQueues lists the queues to inspect. Every built-in scanner has its
+// shared queue, unless its live instances are all site-tagged, and the
+// site queue of each site it is live at. Any other service with live
+// instances, a plug-in, is listed under its service name the same way.
+func (ss *StatusService) scannerQueues() []scannerQueue {
+	ss.mu.RLock()
+	registry := ss.registry
+	ss.mu.RUnlock()
+
+	// Live sites per service; "" is the shared queue.
+	placed := make(map[string]map[string]bool)
+	if registry != nil {
+		for _, info := range registry.Scanners() {
+			for _, inst := range info.Instances {
+				if !inst.Live {
+					continue
+				}
+				if placed[info.Service] == nil {
+					placed[info.Service] = make(map[string]bool)
+				}
+				placed[info.Service][inst.Site] = true
+			}
+		}
+	}
+
+	var out []scannerQueue
+	add := func(name, service string, scanTypes []string, sites map[string]bool, alwaysShared bool) {
+		if sites[""] || (alwaysShared && len(sites) == 0) {
+			out = append(out, scannerQueue{name: name, queue: service, scanTypes: scanTypes})
+		}
+		for _, site := range sortedKeys(sites) {
+			if site != "" {
+				out = append(out, scannerQueue{name: name, site: site, queue: models.SiteQueue(service, site), scanTypes: scanTypes})
+			}
+		}
+	}
+	for _, scanner := range models.StatsScanners {
+		service := models.ScannerQueues[scanner.Name]
+		add(scanner.Name, service, scanner.ScanTypes, placed[service], true)
+		delete(placed, service)
+	}
+	for _, service := range sortedKeys(placed) {
+		add(service, service, nil, placed[service], false)
+	}
+	return out
+}
+
+func (ss *StatusService) checkMongo() models.ComponentCheck {
+	return timedCheck("mongodb", ss.repo.Ping)
+}
+
+func (ss *StatusService) checkAMQP() models.ComponentCheck {
+	return timedCheck("rabbitmq", func() error {
+		broker := ss.getBroker()
+		if broker == nil {
+			return errors.New("connecting")
+		}
+		if !broker.Connected() {
+			return errors.New("connection closed")
+		}
+		return nil
+	})
+}
+
+// checkMinIO probes the MinIO liveness endpoint; nil when no endpoint is
+// configured.
+func (ss *StatusService) checkMinIO() *models.ComponentCheck {
+	if ss.minioEndpoint == "" {
+		return nil
+	}
+	url := ss.minioEndpoint
+	if !strings.Contains(url, "://") {
+		if ss.minioSecure {
+			url = "https://" + url
+		} else {
+			url = "http://" + url
+		}
+	}
+	url = strings.TrimRight(url, "/") + "/minio/health/live"
+
+	c := timedCheck("minio", func() error {
+		resp, err := ss.client.Get(url)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("health endpoint returned %s", resp.Status)
+		}
+		return nil
+	})
+	return &c
+}
+
+func timedCheck(name string, check func() error) models.ComponentCheck {
+	start := time.Now()
+	err := check()
+	c := models.ComponentCheck{
+		Name:      name,
+		Status:    models.StatusOK,
+		LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if err != nil {
+		c.Status = models.StatusDown
+		c.Error = err.Error()
+	}
+	return c
+}
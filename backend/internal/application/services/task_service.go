@@ -0,0 +1,221 @@
+package services
+
+import (
+	"backend/domain/models"
+	rabbitmq "backend/internal/infrastructure/messaging"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	defaultTaskLimit = 100
+	maxTaskLimit     = 1000
+)
+
+type TaskRepository interface {
+	CreateTask(task *models.ScanTask) error
+	GetTask(taskID string) (*models.ScanTask, error)
+	FinishTask(taskID string, outcome models.TaskOutcome) error
+	ExpireTasks(cutoff time.Time) (int64, error)
+	FindTasks(q models.TaskQuery) ([]models.ScanTask, int64, error)
+}
+
+// TaskService keeps a durable record of every scan request published: its
+// parameters, who asked for it and how it ended. Results are joined with
+// their task's parameters when stored; tasks pending for longer than the
+// timeout are moved to timed_out.
+type TaskService struct {
+	repo    TaskRepository
+	timeout time.Duration
+
+	mu       sync.RWMutex
+	backend  string
+	onUpdate func(models.TaskUpdate)
+}
+
+// NewTaskService expires tasks pending for longer than timeout; 0 never
+// expires them.
+func NewTaskService(repo TaskRepository, timeout time.Duration) *TaskService {
+	return &TaskService{repo: repo, timeout: timeout}
+}
+
+// SetBackend names this backend instance on the tasks it creates, so any
+// instance can tell where a task's reply will arrive.
+func (ts *TaskService) SetBackend(name string) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	ts.backend = name
+}
+
+// SetUpdateCallback is called whenever a task is created or finished.
+func (ts *TaskService) SetUpdateCallback(callback func(models.TaskUpdate)) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	ts.onUpdate = callback
+}
+
+func (ts *TaskService) notify(update models.TaskUpdate) {
+	ts.mu.RLock()
+	onUpdate := ts.onUpdate
+	ts.mu.RUnlock()
+	if onUpdate != nil {
+		update.At = time.Now()
+		onUpdate(update)
+	}
+}
+
+type requesterKey struct{}
+
+// WithRequester records on ctx who asked for the scans published with it.
+func WithRequester(ctx context.Context, requester models.TaskRequester) context.Context {
+	return context.WithValue(ctx, requesterKey{}, requester)
+}
+
+func requesterFrom(ctx context.Context) *models.TaskRequester {
+	if requester, ok := ctx.Value(requesterKey{}).(models.TaskRequester); ok {
+		return &requester
+	}
+	return nil
+}
+
+// Create records request, about to be published to service, as a pending
+// task. The lane, requested site and requester are taken from ctx. A task
+// that cannot be stored is logged; the scan goes ahead regardless.
+func (ts *TaskService) Create(ctx context.Context, taskID, service string, request interface{}) {
+	ts.create(ctx, taskID, service, request, nil)
+}
+
+// CreatePlugin records options, about to be published to plugin, as a
+// pending task together with the plug-in's contract.
+func (ts *TaskService) CreatePlugin(ctx context.Context, taskID string, plugin models.ScannerPlugin, options map[string]interface{}) {
+	ts.create(ctx, taskID, plugin.Service, options, &plugin)
+}
+
+func (ts *TaskService) create(ctx context.Context, taskID, service string, request interface{}, plugin *models.ScannerPlugin) {
+	params := map[string]interface{}{}
+	if raw, err := json.Marshal(request); err == nil {
+		_ = json.Unmarshal(raw, &params)
+	}
+	method, _ := params["scan_method"].(string)
+
+	ts.mu.RLock()
+	backend := ts.backend
+	ts.mu.RUnlock()
+
+	task := &models.ScanTask{
+		TaskID:     taskID,
+		Service:    service,
+		ScanMethod: method,
+		Priority:   rabbitmq.PriorityFrom(ctx),
+		Agent:      rabbitmq.AgentFrom(ctx),
+		Backend:    backend,
+		Requester:  requesterFrom(ctx),
+		Params:     params,
+		Plugin:     plugin,
+		Status:     models.TaskPending,
+	}
+	if err := ts.repo.CreateTask(task); err != nil {
+		log.Printf("[Tasks] Failed to record task %s: %v", taskID, err)
+	}
+	ts.notify(models.TaskUpdate{TaskID: taskID, Service: service, Status: models.TaskPending, Backend: backend})
+}
+
+// Params decodes the parameters of taskID into request. It reports false
+// when the task is unknown.
+func (ts *TaskService) Params(taskID string, request interface{}) bool {
+	task, err := ts.repo.GetTask(taskID)
+	if err != nil {
+		return false
+	}
+	raw, err := json.Marshal(task.Params)
+	if err != nil {
+		return false
+	}
+	return json.Unmarshal(raw, request) == nil
+}
+
+// Finish records the result of taskID: failed when the scanner reported an
+// error, completed otherwise.
+func (ts *TaskService) Finish(taskID, errMsg string, agent *models.ScanAgent, attempts []models.ScanAttempt) {
+	status := models.TaskCompleted
+	if errMsg != "" {
+		status = models.TaskFailed
+	}
+	ts.finish(taskID, models.TaskOutcome{Status: status, Error: errMsg, ScanAgent: agent, Attempts: attempts})
+}
+
+// Fail records that taskID could not be carried out: timed_out when no
+// reply came in time, failed otherwise.
+func (ts *TaskService) Fail(taskID string, err error) {
+	status := models.TaskFailed
+	if errors.Is(err, rabbitmq.ErrRPCTimeout) {
+		status = models.TaskTimedOut
+	}
+	ts.finish(taskID, models.TaskOutcome{Status: status, Error: err.Error()})
+}
+
+func (ts *TaskService) finish(taskID string, outcome models.TaskOutcome) {
+	if taskID == "" {
+		return
+	}
+	if err := ts.repo.FinishTask(taskID, outcome); err != nil {
+		log.Printf("[Tasks] Failed to record %s for task %s: %v", outcome.Status, taskID, err)
+	}
+	ts.notify(models.TaskUpdate{TaskID: taskID, Status: outcome.Status, Error: outcome.Error})
+}
+
+func (ts *TaskService) Get(taskID string) (*models.ScanTask, error) {
+	return ts.repo.GetTask(taskID)
+}
+
+// Find returns the newest tasks matching q and the total number matching.
+func (ts *TaskService) Find(q models.TaskQuery) ([]models.ScanTask, int64, error) {
+	if q.Limit <= 0 {
+		q.Limit = defaultTaskLimit
+	}
+	if q.Limit > maxTaskLimit {
+		q.Limit = maxTaskLimit
+	}
+	return ts.repo.FindTasks(q)
+}
+
+// Expire moves tasks pending for longer than the timeout to timed_out.
+func (ts *TaskService) Expire() {
+	if ts.timeout <= 0 {
+		return
+	}
+	expired, err := ts.repo.ExpireTasks(time.Now().Add(-ts.timeout))
+	if err != nil {
+		log.Printf("[Tasks] Failed to expire pending tasks: %v", err)
+		return
+	}
+	if expired > 0 {
+		log.Printf("[Tasks] %d pending tasks timed out", expired)
+	}
+}
+
+// Run expires stale tasks every interval until ctx is cancelled.
+func (ts *TaskService) Run(ctx context.Context, interval time.Duration) {
+	if ts.timeout <= 0 {
+		log.Printf("[Tasks] Expiry disabled (TASK_TIMEOUT=0)")
+		return
+	}
+	log.Printf("[Tasks] Pending tasks time out after %s, checking every %s", ts.timeout, interval)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	ts.Expire()
+	for {
+		select {
+		case <-ticker.C:
+			ts.Expire()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package rabbitmq
+
+import (
+	"context"
+	"time"
+
+	"backend/domain/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Streamed results are stored as their chunks arrive: each chunk's devices
+// or results are pushed onto the history record of its task, which the
+// first chunk creates. The sequence numbers already applied are kept in
+// chunk_seqs, so a redelivered chunk is not stored twice. The final reply
+// then only fills in the status and counts.
+
+// EnsureChunkIndexes indexes the ARP and ICMP history by task, which every
+// appended chunk looks its record up by.
+func (r *Repository) EnsureChunkIndexes() error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	for _, coll := range []*mongo.Collection{r.db.ARPCollection(), r.db.ICMPCollection()} {
+		if _, err := coll.Indexes().CreateOne(ctx, mongo.IndexModel{Keys: bson.D{{Key: "task_id", Value: 1}}}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// chunkRecord is where a chunk is stored: the record of its task, the
+// fields that record is created with, and the lists its items are pushed
+// onto. ok is false for chunks with nothing to store.
+func chunkRecord(chunk models.ResultChunk) (filter, insert, push bson.M, ok bool) {
+	filter = bson.M{"task_id": chunk.TaskID}
+	insert = bson.M{"status": "running", "created_at": time.Now()}
+	push = bson.M{}
+	switch {
+	case len(chunk.Devices) > 0:
+		numberARPDevices(chunk.Devices)
+		var online, offline []models.ARPDevice
+		for _, device := range chunk.Devices {
+			if device.Status == "online" {
+				online = append(online, device)
+			} else {
+				offline = append(offline, device)
+			}
+		}
+		if len(online) > 0 {
+			push["online_devices"] = bson.M{"$each": online}
+		}
+		if len(offline) > 0 {
+			push["offline_devices"] = bson.M{"$each": offline}
+		}
+	case len(chunk.Results) > 0:
+		record := models.ICMPHistoryRecord{Results: chunk.Results}
+		normalizeICMPRecord(&record)
+		filter["scan_type"] = "icmp"
+		push["results"] = bson.M{"$each": record.Results}
+	default:
+		return nil, nil, nil, false
+	}
+	push["chunk_seqs"] = chunk.Seq
+	return filter, insert, push, true
+}
+
+// AppendResultChunk stores the devices or results of chunk on the history
+// record of its task. A chunk already applied is skipped.
+func (r *Repository) AppendResultChunk(chunk models.ResultChunk) error {
+	filter, insert, push, ok := chunkRecord(chunk)
+	if !ok {
+		return nil
+	}
+	coll := r.db.ARPCollection()
+	if _, icmp := push["results"]; icmp {
+		coll = r.db.ICMPCollection()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// Create the record first: an upsert filtered on chunk_seqs would
+	// insert a second record for a redelivered chunk.
+	if _, err := coll.UpdateOne(ctx, filter, bson.M{"$setOnInsert": insert}, options.Update().SetUpsert(true)); err != nil {
+		return err
+	}
+	applied := bson.M{"chunk_seqs": bson.M{"$ne": chunk.Seq}}
+	for k, v := range filter {
+		applied[k] = v
+	}
+	_, err := coll.UpdateOne(ctx, applied, bson.M{"$push": push})
+	return err
+}
+
+// DeleteResultChunks drops what the chunks of taskID stored so far, for a
+// failed attempt whose retry streams its result again.
+func (r *Repository) DeleteResultChunks(taskID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if _, err := r.db.ARPCollection().UpdateOne(ctx,
+		bson.M{"task_id": taskID, "chunk_seqs.0": bson.M{"$exists": true}},
+		bson.M{"$unset": bson.M{"online_devices": "", "offline_devices": "", "chunk_seqs": ""}},
+	); err != nil {
+		return err
+	}
+	_, err := r.db.ICMPCollection().UpdateOne(ctx,
+		bson.M{"task_id": taskID, "scan_type": "icmp", "chunk_seqs.0": bson.M{"$exists": true}},
+		bson.M{"$unset": bson.M{"results": "", "chunk_seqs": ""}},
+	)
+	return err
+}
+
+// historyUpdate is the update storing record, a scan's final result, on
+// the history record of its task. Item lists left nil, as in the final
+// reply of a streamed result, keep what its chunks stored.
+func historyUpdate(record interface{}, lists ...string) (bson.M, error) {
+	raw, err := bson.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+	var set bson.M
+	if err := bson.Unmarshal(raw, &set); err != nil {
+		return nil, err
+	}
+	for _, list := range lists {
+		if set[list] == nil {
+			delete(set, list)
+		}
+	}
+	insert := bson.M{"created_at": set["created_at"]}
+	delete(set, "created_at")
+	delete(set, "_id")
+	return bson.M{"$set": set, "$setOnInsert": insert}, nil
+}
@@ -0,0 +1,117 @@
+package rabbitmq
+
+import (
+	"reflect"
+	"testing"
+
+	"backend/domain/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestChunkRecord(t *testing.T) {
+	online := models.ARPDevice{IP: "10.0.0.1", MAC: "00:1b:54:aa:bb:cc", Status: "online"}
+	offline := models.ARPDevice{IP: "10.0.0.2", Status: "offline"}
+	tests := []struct {
+		name   string
+		chunk  models.ResultChunk
+		filter bson.M
+		push   bson.M
+	}{
+		{
+			name:   "online devices",
+			chunk:  models.ResultChunk{TaskID: "t", Seq: 3, Devices: []models.ARPDevice{online}},
+			filter: bson.M{"task_id": "t"},
+			push: bson.M{
+				"online_devices": bson.M{"$each": []models.ARPDevice{{IP: "10.0.0.1", MAC: "00:1b:54:aa:bb:cc", Status: "online", IPNum: 167772161}}},
+				"chunk_seqs":     3,
+			},
+		},
+		{
+			name:   "devices split by status",
+			chunk:  models.ResultChunk{TaskID: "t", Devices: []models.ARPDevice{offline, online}},
+			filter: bson.M{"task_id": "t"},
+			push: bson.M{
+				"online_devices":  bson.M{"$each": []models.ARPDevice{{IP: "10.0.0.1", MAC: "00:1b:54:aa:bb:cc", Status: "online", IPNum: 167772161}}},
+				"offline_devices": bson.M{"$each": []models.ARPDevice{{IP: "10.0.0.2", Status: "offline", IPNum: 167772162}}},
+				"chunk_seqs":      0,
+			},
+		},
+		{
+			name:   "ping results",
+			chunk:  models.ResultChunk{TaskID: "t", Seq: 1, Results: []models.ICMPResult{{Target: "host", Address: "10.0.0.9"}}},
+			filter: bson.M{"task_id": "t", "scan_type": "icmp"},
+			push: bson.M{
+				"results":    bson.M{"$each": []models.ICMPResult{{Target: "host", Address: "10.0.0.9", IPNum: 167772169}}},
+				"chunk_seqs": 1,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter, insert, push, ok := chunkRecord(tt.chunk)
+			if !ok {
+				t.Fatal("chunk not stored")
+			}
+			if !reflect.DeepEqual(filter, tt.filter) {
+				t.Errorf("filter = %v, want %v", filter, tt.filter)
+			}
+			if insert["status"] != "running" {
+				t.Errorf("record created with %v", insert)
+			}
+			if !reflect.DeepEqual(push, tt.push) {
+				t.Errorf("push = %v\nwant   %v", push, tt.push)
+			}
+		})
+	}
+}
+
+func TestChunkRecordEmpty(t *testing.T) {
+	if _, _, _, ok := chunkRecord(models.ResultChunk{TaskID: "t", Done: 10, Total: 20}); ok {
+		t.Error("a chunk with only progress is stored")
+	}
+}
+
+func TestHistoryUpdate(t *testing.T) {
+	tests := []struct {
+		name   string
+		record models.ARPHistoryRecord
+		set    []string
+		unset  []string
+	}{
+		{
+			name:   "whole result",
+			record: models.ARPHistoryRecord{TaskID: "t", Status: "completed", OnlineDevices: []models.ARPDevice{{IP: "10.0.0.1"}}, OfflineDevices: []models.ARPDevice{{IP: "10.0.0.2"}}},
+			set:    []string{"task_id", "status", "online_devices", "offline_devices"},
+			unset:  []string{"devices", "_id", "created_at"},
+		},
+		{
+			name:   "streamed result keeps its chunks",
+			record: models.ARPHistoryRecord{TaskID: "t", Status: "completed", OnlineCount: 3},
+			set:    []string{"task_id", "status", "online_count"},
+			unset:  []string{"devices", "online_devices", "offline_devices", "_id", "created_at"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			update, err := historyUpdate(&tt.record, "online_devices", "offline_devices")
+			if err != nil {
+				t.Fatal(err)
+			}
+			set := update["$set"].(bson.M)
+			for _, key := range tt.set {
+				if _, ok := set[key]; !ok {
+					t.Errorf("%s not set", key)
+				}
+			}
+			for _, key := range tt.unset {
+				if _, ok := set[key]; ok {
+					t.Errorf("%s set to %v", key, set[key])
+				}
+			}
+			if _, ok := update["$setOnInsert"].(bson.M)["created_at"]; !ok {
+				t.Error("created_at not set on insert")
+			}
+		})
+	}
+}
@@ -2,12 +2,15 @@ package rabbitmq
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"log"
 	"time"
 
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"backend/internal/infrastructure/metrics"
 )
 
 type Database struct {
@@ -15,7 +18,9 @@ type Database struct {
 	Database *mongo.Database
 }
 
-func NewDatabase(uri, dbName string) (*Database, error) {
+// NewDatabase connects to uri with up to five attempts. A non-nil tlsConfig
+// enables TLS, and mutual TLS when it carries a client certificate.
+func NewDatabase(uri, dbName string, tlsConfig *tls.Config) (*Database, error) {
 	log.Printf("[MongoDB] Connecting to %s, database=%s", uri, dbName)
 
 	var (
@@ -27,7 +32,11 @@ func NewDatabase(uri, dbName string) (*Database, error) {
 		log.Printf("[MongoDB] Attempt %d/5...", attempt)
 
 		connectCtx, connectCancel := context.WithTimeout(context.Background(), 10*time.Second)
-		c, err := mongo.Connect(connectCtx, options.Client().ApplyURI(uri))
+		opts := options.Client().ApplyURI(uri).SetMonitor(metrics.MongoMonitor())
+		if tlsConfig != nil {
+			opts.SetTLSConfig(tlsConfig)
+		}
+		c, err := mongo.Connect(connectCtx, opts)
 		connectCancel()
 		if err != nil {
 			lastErr = fmt.Errorf("connect: %w", err)
@@ -108,3 +117,27 @@ func (d *Database) TCPCollection() *mongo.Collection {
 func (d *Database) ChangesCollection() *mongo.Collection {
 	return d.Database.Collection("l3_devices")
 }
+
+// SummaryCollection holds the per-day summaries that replace raw history
+// documents once they are older than the retention window.
+func (d *Database) SummaryCollection() *mongo.Collection {
+	return d.Database.Collection("history_summaries")
+}
+
+// TasksCollection holds every scan request published, from pending to its
+// final state.
+func (d *Database) TasksCollection() *mongo.Collection {
+	return d.Database.Collection("scan_tasks")
+}
+
+// PluginsCollection maps each plug-in service to the collection it stores
+// results in, so history stays reachable before its first heartbeat.
+func (d *Database) PluginsCollection() *mongo.Collection {
+	return d.Database.Collection("scanner_plugins")
+}
+
+// PluginCollection holds the results of a plug-in scanner. name is the
+// collection the scanner advertises, already validated by the registry.
+func (d *Database) PluginCollection(name string) *mongo.Collection {
+	return d.Database.Collection(name)
+}
@@ -0,0 +1,184 @@
+package rabbitmq
+
+import (
+	"context"
+	"time"
+
+	"backend/domain/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// EachHistory streams the scanType records matching q, newest first. fn
+// receives a decode function for the current document; the cursor is not
+// materialised, so arbitrarily large histories can be exported.
+func (r *Repository) EachHistory(ctx context.Context, scanType string, q models.HistoryQuery, fn func(decode func(v interface{}) error) error) error {
+	coll, filter, err := r.historyMatch(scanType, q)
+	if err != nil {
+		return err
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}).SetBatchSize(500)
+	if q.Limit > 0 {
+		opts.SetLimit(int64(q.Limit))
+	}
+
+	cursor, err := coll.Find(ctx, filter, opts)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		if err := fn(cursor.Decode); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+// ── Device inventory ──────────────────────────────────────────────────────────
+// The inventory is derived from scan history rather than stored separately:
+// every online ARP device and every host reported by Nmap is an L3 device;
+// ARP devices grouped by MAC are the L2 devices.
+
+// inventorySightings returns pipeline stages producing one {ip, mac, vendor,
+// seen} document per device sighting across ARP and Nmap history.
+func (r *Repository) inventorySightings() mongo.Pipeline {
+	return mongo.Pipeline{
+		{{Key: "$unwind", Value: "$online_devices"}},
+		{{Key: "$project", Value: bson.M{
+			"_id":    0,
+			"ip":     "$online_devices.ip",
+			"ip_num": "$online_devices.ip_num",
+			"mac":    "$online_devices.mac",
+			"vendor": "$online_devices.vendor",
+			"seen":   "$created_at",
+		}}},
+		{{Key: "$unionWith", Value: bson.M{
+			"coll": r.db.NmapTcpUdpCollection().Name(),
+			"pipeline": bson.A{
+				bson.M{"$match": bson.M{
+					"scan_type": bson.M{"$in": bson.A{"nmap_tcp_udp", "nmap_os_detection", "nmap_host_discovery"}},
+					"host":      bson.M{"$nin": bson.A{"", nil}},
+					"status":    bson.M{"$nin": bson.A{"down", "failed"}},
+				}},
+				bson.M{"$project": bson.M{
+					"_id":    0,
+					"ip":     "$host",
+					"ip_num": "$ip_num",
+					"mac":    bson.M{"$ifNull": bson.A{"$mac", ""}},
+					"vendor": bson.M{"$ifNull": bson.A{"$mac_vendor", ""}},
+					"seen":   "$created_at",
+				}},
+			},
+		}}},
+	}
+}
+
+// EachL3Device streams the IP-layer inventory, most recently seen first.
+// q.Target narrows to one IP, q.Since/Until bound last_seen.
+func (r *Repository) EachL3Device(ctx context.Context, q models.HistoryQuery, fn func(models.L3Device) error) error {
+	pipeline := r.inventorySightings()
+	if q.Target != "" {
+		pipeline = append(pipeline, bson.D{{Key: "$match", Value: bson.M{"ip": q.Target}}})
+	}
+	pipeline = append(pipeline, l3DeviceStages()...)
+	pipeline = append(pipeline, inventoryWindow(q)...)
+
+	return r.eachInventory(ctx, pipeline, func(cursor *mongo.Cursor) error {
+		var dev models.L3Device
+		if err := cursor.Decode(&dev); err != nil {
+			return err
+		}
+		return fn(dev)
+	})
+}
+
+// EachL2Device streams the MAC-layer inventory, most recently seen first.
+// q.Target narrows to one MAC, q.Since/Until bound last_seen.
+func (r *Repository) EachL2Device(ctx context.Context, q models.HistoryQuery, fn func(models.L2Device) error) error {
+	pipeline := r.inventorySightings()
+	match := bson.M{"mac": bson.M{"$nin": bson.A{"", nil}}}
+	if q.Target != "" {
+		match["mac"] = q.Target
+	}
+	pipeline = append(pipeline,
+		bson.D{{Key: "$match", Value: match}},
+		bson.D{{Key: "$group", Value: bson.M{
+			"_id":          "$mac",
+			"vendor":       bson.M{"$max": "$vendor"},
+			"ip_addresses": bson.M{"$addToSet": "$ip"},
+			"first_seen":   bson.M{"$min": "$seen"},
+			"last_seen":    bson.M{"$max": "$seen"},
+		}}},
+		bson.D{{Key: "$project", Value: bson.M{
+			"_id": 0, "mac": "$_id", "vendor": 1, "ip_addresses": 1, "first_seen": 1, "last_seen": 1,
+		}}},
+	)
+	pipeline = append(pipeline, inventoryWindow(q)...)
+
+	return r.eachInventory(ctx, pipeline, func(cursor *mongo.Cursor) error {
+		var dev models.L2Device
+		if err := cursor.Decode(&dev); err != nil {
+			return err
+		}
+		return fn(dev)
+	})
+}
+
+// l3DeviceStages groups device sightings into one document per IP.
+func l3DeviceStages() mongo.Pipeline {
+	return mongo.Pipeline{
+		{{Key: "$group", Value: bson.M{
+			"_id":        "$ip",
+			"ip_num":     bson.M{"$max": "$ip_num"},
+			"mac":        bson.M{"$max": "$mac"},
+			"vendor":     bson.M{"$max": "$vendor"},
+			"first_seen": bson.M{"$min": "$seen"},
+			"last_seen":  bson.M{"$max": "$seen"},
+		}}},
+		{{Key: "$project", Value: bson.M{
+			"_id": 0, "ip": "$_id", "ip_num": 1, "mac": 1, "vendor": 1, "first_seen": 1, "last_seen": 1,
+		}}},
+	}
+}
+
+func inventoryWindow(q models.HistoryQuery) mongo.Pipeline {
+	var stages mongo.Pipeline
+	seen := bson.M{}
+	if !q.Since.IsZero() {
+		seen["$gte"] = q.Since
+	}
+	if !q.Until.IsZero() {
+		seen["$lt"] = q.Until
+	}
+	if len(seen) > 0 {
+		stages = append(stages, bson.D{{Key: "$match", Value: bson.M{"last_seen": seen}}})
+	}
+	stages = append(stages, bson.D{{Key: "$sort", Value: bson.M{"last_seen": -1}}})
+	if q.Limit > 0 {
+		stages = append(stages, bson.D{{Key: "$limit", Value: q.Limit}})
+	}
+	return stages
+}
+
+func (r *Repository) eachInventory(ctx context.Context, pipeline mongo.Pipeline, fn func(*mongo.Cursor) error) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+	defer cancel()
+
+	cursor, err := r.db.ARPCollection().Aggregate(ctx, pipeline, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		if err := fn(cursor); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
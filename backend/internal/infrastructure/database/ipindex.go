@@ -0,0 +1,268 @@
+package rabbitmq
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"backend/domain/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ── Normalized IPv4 fields ───────────────────────────────────────────────────
+// Every history record stores the scanned target as an inclusive numeric
+// range (ip_start/ip_end) and concrete addresses as ip_num, so searches by
+// address, CIDR or range are numeric comparisons that do not depend on how
+// the scan target was written ("10.0.5.0/24" vs "10.0.5.1-10.0.5.254").
+
+func ipRange(target string) (int64, int64) {
+	lo, hi, ok := models.ParseIPv4Range(target)
+	if !ok {
+		return 0, 0
+	}
+	return lo, hi
+}
+
+func ipNum(addr string) int64 {
+	n, _ := models.IPv4ToNum(addr)
+	return n
+}
+
+func numberARPDevices(devices []models.ARPDevice) {
+	for i := range devices {
+		devices[i].IPNum = ipNum(devices[i].IP)
+	}
+}
+
+func normalizeARPRecord(record *models.ARPHistoryRecord) {
+	record.IPStart, record.IPEnd = ipRange(record.IPRange)
+	numberARPDevices(record.Devices)
+	numberARPDevices(record.OnlineDevices)
+	numberARPDevices(record.OfflineDevices)
+}
+
+func normalizeICMPRecord(record *models.ICMPHistoryRecord) {
+	record.IPStart, record.IPEnd = ipRange(strings.Join(record.Targets, ","))
+	for i := range record.Results {
+		addr := record.Results[i].Address
+		if addr == "" {
+			addr = record.Results[i].Target
+		}
+		record.Results[i].IPNum = ipNum(addr)
+	}
+}
+
+// ipOverlap matches records whose scanned range overlaps [lo, hi].
+func ipOverlap(lo, hi int64) bson.M {
+	return bson.M{"ip_start": bson.M{"$lte": hi}, "ip_end": bson.M{"$gte": lo}}
+}
+
+func ipBetween(lo, hi int64) bson.M {
+	return bson.M{"$gte": lo, "$lte": hi}
+}
+
+// ipWithin matches documents whose address at path lies in one of the
+// intervals.
+func ipWithin(path string, intervals []models.IPv4Interval) bson.M {
+	if len(intervals) == 1 {
+		return bson.M{path: ipBetween(intervals[0].Lo, intervals[0].Hi)}
+	}
+	or := bson.A{}
+	for _, iv := range intervals {
+		or = append(or, bson.M{path: ipBetween(iv.Lo, iv.Hi)})
+	}
+	return bson.M{"$or": or}
+}
+
+// addFilter adds the conditions of cond to filter. Operators such as $or
+// are combined through $and, so they do not replace one already there.
+func addFilter(filter, cond bson.M) {
+	for k, v := range cond {
+		if strings.HasPrefix(k, "$") {
+			and, _ := filter["$and"].(bson.A)
+			filter["$and"] = append(and, bson.M{k: v})
+			continue
+		}
+		filter[k] = v
+	}
+}
+
+// ipTargetFilter matches records that scanned (part of) target, given as an
+// address, CIDR, range or list, or whose concrete address at numPath falls
+// inside it. Targets that are not IPv4 (host names) fall back to an exact
+// match on exactField.
+func ipTargetFilter(target, exactField, numPath string) bson.M {
+	intervals, ok := models.ParseIPv4Intervals(target)
+	if !ok {
+		return bson.M{exactField: target}
+	}
+	or := bson.A{}
+	for _, iv := range intervals {
+		or = append(or, ipOverlap(iv.Lo, iv.Hi), bson.M{numPath: ipBetween(iv.Lo, iv.Hi)})
+	}
+	return bson.M{"$or": or}
+}
+
+// macPrefixRegex normalizes a MAC prefix in any common notation
+// (00:1B:54, 00-1b-54, 001b.54) to the colon form stored by the ARP scanner.
+// A prefix without hex digits would match every device and is refused.
+func macPrefixRegex(prefix string) (primitive.Regex, error) {
+	hex := strings.Map(func(r rune) rune {
+		switch {
+		case r >= '0' && r <= '9', r >= 'a' && r <= 'f':
+			return r
+		case r >= 'A' && r <= 'F':
+			return r + ('a' - 'A')
+		}
+		return -1
+	}, prefix)
+	if hex == "" {
+		return primitive.Regex{}, &models.QueryError{Field: "mac", Msg: fmt.Sprintf("%q is not a MAC prefix", prefix)}
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(hex); i++ {
+		if i > 0 && i%2 == 0 {
+			b.WriteString("[:-]?")
+		}
+		b.WriteByte(hex[i])
+	}
+	return primitive.Regex{Pattern: "^" + b.String(), Options: "i"}, nil
+}
+
+// EnsureIPIndexes creates the indexes used by range searches and fills the
+// normalized fields of records stored before they existed. It is safe to
+// run on every start; already normalized records are skipped.
+func (r *Repository) EnsureIPIndexes() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	indexes := []struct {
+		coll *mongo.Collection
+		keys bson.D
+	}{
+		{r.db.ARPCollection(), bson.D{{Key: "ip_start", Value: 1}, {Key: "ip_end", Value: 1}}},
+		{r.db.ARPCollection(), bson.D{{Key: "online_devices.ip_num", Value: 1}}},
+		{r.db.ARPCollection(), bson.D{{Key: "offline_devices.ip_num", Value: 1}}},
+		{r.db.ARPCollection(), bson.D{{Key: "online_devices.mac", Value: 1}}},
+		{r.db.NmapTcpUdpCollection(), bson.D{{Key: "scan_type", Value: 1}, {Key: "ip_start", Value: 1}, {Key: "ip_end", Value: 1}}},
+		{r.db.NmapTcpUdpCollection(), bson.D{{Key: "scan_type", Value: 1}, {Key: "ip_num", Value: 1}}},
+		{r.db.TCPCollection(), bson.D{{Key: "ip_num", Value: 1}}},
+	}
+	for _, idx := range indexes {
+		if _, err := idx.coll.Indexes().CreateOne(ctx, mongo.IndexModel{Keys: idx.keys}); err != nil {
+			return err
+		}
+	}
+
+	numeric := primitive.Regex{Pattern: `^\d`}
+	missing := bson.M{"$exists": false}
+
+	arp, err := r.backfillIPs(ctx, r.db.ARPCollection(), bson.M{
+		"$or": bson.A{
+			bson.M{"ip_start": missing, "ip_range": numeric},
+			bson.M{"online_devices.0": bson.M{"$exists": true}, "online_devices.ip_num": missing},
+		},
+	}, func(cursor *mongo.Cursor) (bson.M, error) {
+		var rec models.ARPHistoryRecord
+		if err := cursor.Decode(&rec); err != nil {
+			return nil, err
+		}
+		normalizeARPRecord(&rec)
+		set := bson.M{
+			"ip_start": rec.IPStart, "ip_end": rec.IPEnd,
+			"online_devices": rec.OnlineDevices, "offline_devices": rec.OfflineDevices,
+		}
+		if rec.Devices != nil {
+			set["devices"] = rec.Devices
+		}
+		return set, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	icmp, err := r.backfillIPs(ctx, r.db.ICMPCollection(), bson.M{
+		"scan_type": "icmp", "ip_start": missing, "targets": numeric,
+	}, func(cursor *mongo.Cursor) (bson.M, error) {
+		var rec models.ICMPHistoryRecord
+		if err := cursor.Decode(&rec); err != nil {
+			return nil, err
+		}
+		normalizeICMPRecord(&rec)
+		return bson.M{"ip_start": rec.IPStart, "ip_end": rec.IPEnd, "results": rec.Results}, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	nmap, err := r.backfillIPs(ctx, r.db.NmapTcpUdpCollection(), bson.M{
+		"scan_type": bson.M{"$in": bson.A{"nmap_tcp_udp", "nmap_os_detection", "nmap_host_discovery"}},
+		"ip_start":  missing,
+		"ip_num":    missing,
+		"$or":       bson.A{bson.M{"ip": numeric}, bson.M{"host": numeric}},
+	}, func(cursor *mongo.Cursor) (bson.M, error) {
+		var rec struct {
+			IP   string `bson:"ip"`
+			Host string `bson:"host"`
+		}
+		if err := cursor.Decode(&rec); err != nil {
+			return nil, err
+		}
+		start, end := ipRange(rec.IP)
+		return bson.M{"ip_start": start, "ip_end": end, "ip_num": ipNum(rec.Host)}, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	tcp, err := r.backfillIPs(ctx, r.db.TCPCollection(), bson.M{
+		"ip_num": missing, "host": numeric,
+	}, func(cursor *mongo.Cursor) (bson.M, error) {
+		var rec struct {
+			Host string `bson:"host"`
+		}
+		if err := cursor.Decode(&rec); err != nil {
+			return nil, err
+		}
+		return bson.M{"ip_num": ipNum(rec.Host)}, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if arp+icmp+nmap+tcp > 0 {
+		log.Printf("[Database] Normalized IP fields: arp=%d icmp=%d nmap=%d tcp=%d", arp, icmp, nmap, tcp)
+	}
+	return nil
+}
+
+// backfillIPs applies the $set computed by fields to every document
+// matching filter and returns how many were updated.
+func (r *Repository) backfillIPs(ctx context.Context, coll *mongo.Collection, filter bson.M, fields func(*mongo.Cursor) (bson.M, error)) (int, error) {
+	cursor, err := coll.Find(ctx, filter, options.Find().SetBatchSize(500))
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	updated := 0
+	for cursor.Next(ctx) {
+		set, err := fields(cursor)
+		if err != nil {
+			return updated, err
+		}
+		id := cursor.Current.Lookup("_id")
+		if _, err := coll.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": set}); err != nil {
+			return updated, err
+		}
+		updated++
+	}
+	return updated, cursor.Err()
+}
@@ -0,0 +1,91 @@
+package rabbitmq
+
+import (
+	"errors"
+	"reflect"
+	"regexp"
+	"testing"
+
+	"backend/domain/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestMACPrefixRegex(t *testing.T) {
+	tests := []struct {
+		prefix  string
+		matches []string
+		misses  []string
+	}{
+		{"00:1B:54", []string{"00:1b:54:aa:bb:cc", "00-1B-54-AA-BB-CC"}, []string{"00:1b:55:aa:bb:cc", "10:00:1b:54:00:00"}},
+		{"00-1b-54", []string{"00:1b:54:aa:bb:cc"}, nil},
+		{"001b.54", []string{"00:1b:54:aa:bb:cc"}, nil},
+		{"001b5", []string{"00:1b:5f:aa:bb:cc"}, []string{"00:1b:60:aa:bb:cc"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.prefix, func(t *testing.T) {
+			re, err := macPrefixRegex(tt.prefix)
+			if err != nil {
+				t.Fatalf("macPrefixRegex: %v", err)
+			}
+			compiled := regexp.MustCompile("(?i)" + re.Pattern)
+			for _, mac := range tt.matches {
+				if !compiled.MatchString(mac) {
+					t.Errorf("%s does not match %s", re.Pattern, mac)
+				}
+			}
+			for _, mac := range tt.misses {
+				if compiled.MatchString(mac) {
+					t.Errorf("%s matches %s", re.Pattern, mac)
+				}
+			}
+		})
+	}
+}
+
+func TestMACPrefixRegexEmpty(t *testing.T) {
+	for _, prefix := range []string{"zz", "-", ":::", ""} {
+		_, err := macPrefixRegex(prefix)
+		var qerr *models.QueryError
+		if !errors.As(err, &qerr) {
+			t.Errorf("%q: err = %v, want a QueryError", prefix, err)
+		}
+	}
+}
+
+func TestIPTargetFilter(t *testing.T) {
+	tests := []struct {
+		target string
+		want   bson.M
+	}{
+		{"scanner.local", bson.M{"ip_range": "scanner.local"}},
+		{"10.0.5.0/24", bson.M{"$or": bson.A{
+			ipOverlap(167773440, 167773695), bson.M{"online_devices.ip_num": ipBetween(167773440, 167773695)},
+		}}},
+		{"10.0.0.1,10.255.0.1", bson.M{"$or": bson.A{
+			ipOverlap(167772161, 167772161), bson.M{"online_devices.ip_num": ipBetween(167772161, 167772161)},
+			ipOverlap(184483841, 184483841), bson.M{"online_devices.ip_num": ipBetween(184483841, 184483841)},
+		}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			if got := ipTargetFilter(tt.target, "ip_range", "online_devices.ip_num"); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAddFilter(t *testing.T) {
+	filter := bson.M{"scan_type": "tcp", "$or": bson.A{bson.M{"a": 1}}}
+	addFilter(filter, bson.M{"$or": bson.A{bson.M{"b": 2}}, "ip_num": 3})
+	want := bson.M{
+		"scan_type": "tcp",
+		"$or":       bson.A{bson.M{"a": 1}},
+		"$and":      bson.A{bson.M{"$or": bson.A{bson.M{"b": 2}}}},
+		"ip_num":    3,
+	}
+	if !reflect.DeepEqual(filter, want) {
+		t.Errorf("got %v, want %v", filter, want)
+	}
+}
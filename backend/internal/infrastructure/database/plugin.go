@@ -0,0 +1,92 @@
+package rabbitmq
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"backend/domain/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// SavePluginCollection records the collection plug-in service stores its
+// results in.
+func (r *Repository) SavePluginCollection(service, collection string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := r.db.PluginsCollection().UpdateOne(ctx,
+		bson.M{"_id": service},
+		bson.M{"$set": bson.M{"collection": collection, "updated_at": time.Now()}},
+		options.Update().SetUpsert(true))
+	return err
+}
+
+// PluginCollection returns the collection recorded for plug-in service, or
+// "" when none is.
+func (r *Repository) PluginCollection(service string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var doc struct {
+		Collection string `bson:"collection"`
+	}
+	err := r.db.PluginsCollection().FindOne(ctx, bson.M{"_id": service}).Decode(&doc)
+	if err == mongo.ErrNoDocuments {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return doc.Collection, nil
+}
+
+// SavePluginHistory stores one plug-in scanner result in collection.
+func (r *Repository) SavePluginHistory(collection string, record *models.PluginHistoryRecord) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	record.CreatedAt = time.Now()
+	_, err := r.db.PluginCollection(collection).InsertOne(ctx, record)
+	return err
+}
+
+// GetPluginHistory returns the newest results stored in collection.
+func (r *Repository) GetPluginHistory(collection string, limit int) ([]models.PluginHistoryRecord, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}})
+	if limit > 0 {
+		opts.SetLimit(int64(limit))
+	}
+
+	cursor, err := r.db.PluginCollection(collection).Find(ctx, bson.D{}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	records := []models.PluginHistoryRecord{}
+	if err = cursor.All(ctx, &records); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+func (r *Repository) DeletePluginHistory(collection string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	result, err := r.db.PluginCollection(collection).DeleteMany(ctx, bson.D{})
+	if err != nil {
+		log.Printf("Error deleting %s history: %v", collection, err)
+		return err
+	}
+
+	log.Printf("Deleted %d %s history records", result.DeletedCount, collection)
+	return nil
+}
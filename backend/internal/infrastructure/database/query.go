@@ -0,0 +1,729 @@
+package rabbitmq
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"backend/domain/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ── Query language → MongoDB ─────────────────────────────────────────────────
+// Parsed queries (see services.ParseQuery) are compiled per scope into a
+// MongoDB filter. Fields are mapped to document paths; two groups of fields
+// need to match within the same array element and are compiled together:
+//   - nmap port, state, service and proto refer to the same port, although
+//     port_info stores them as parallel arrays ($expr over the indexes);
+//   - ARP ip, net, mac and vendor refer to the same online device
+//     ($elemMatch).
+
+type queryScope struct {
+	historyType string // historyKinds key; empty for the inventory
+	paths       map[string]string
+	text        []string // paths searched by bare words
+	seenField   string
+	newRecords  func() interface{} // pointer to an empty slice of records
+}
+
+const arpDevicePrefix = "online_devices."
+
+// nmapPortPaths maps port fields to their arrays in port_info, which are
+// stored under the driver's default (lower-cased field name) keys.
+var nmapPortPaths = map[string]string{
+	"port":    "allports",
+	"state":   "state",
+	"service": "servicename",
+	"proto":   "protocols",
+}
+
+var queryScopeDefs = map[string]queryScope{
+	models.QueryScopeNmap: {
+		historyType: "nmap_tcp_udp",
+		paths: map[string]string{
+			"ip": "host", "net": "ip_num", "mac": "mac", "vendor": "mac_vendor",
+			"status": "status", "source": "source", "task": "task_id",
+		},
+		text:       []string{"host", "port_info.servicename", "mac_vendor"},
+		seenField:  "created_at",
+		newRecords: func() interface{} { return &[]models.NmapTcpUdpHistoryRecord{} },
+	},
+	models.QueryScopeOS: {
+		historyType: "nmap_os_detection",
+		paths: map[string]string{
+			"ip": "host", "net": "ip_num", "os.name": "name", "os.family": "family",
+			"os.vendor": "vendor", "os.type": "type", "os.accuracy": "accuracy",
+			"status": "status", "source": "source", "task": "task_id",
+		},
+		text:       []string{"host", "name", "family", "vendor"},
+		seenField:  "created_at",
+		newRecords: func() interface{} { return &[]models.NmapOsDetectionHistoryRecord{} },
+	},
+	models.QueryScopeARP: {
+		historyType: "arp",
+		paths: map[string]string{
+			"ip": arpDevicePrefix + "ip", "net": arpDevicePrefix + "ip_num",
+			"mac": arpDevicePrefix + "mac", "vendor": arpDevicePrefix + "vendor",
+			"iface": "interface_name", "status": "status", "task": "task_id",
+		},
+		text:       []string{"ip_range", "interface_name", "online_devices.ip", "online_devices.mac", "online_devices.vendor"},
+		seenField:  "created_at",
+		newRecords: func() interface{} { return &[]models.ARPHistoryRecord{} },
+	},
+	models.QueryScopeTCP: {
+		historyType: "tcp",
+		paths: map[string]string{
+			"ip": "host", "net": "ip_num", "port": "port", "banner": "decoded_text",
+			"status": "status", "task": "task_id",
+		},
+		text:       []string{"host", "decoded_text"},
+		seenField:  "created_at",
+		newRecords: func() interface{} { return &[]models.TCPHistoryRecord{} },
+	},
+	models.QueryScopeInventory: {
+		paths: map[string]string{
+			"ip": "ip", "net": "ip_num", "mac": "mac", "vendor": "vendor",
+		},
+		text:       []string{"ip", "mac", "vendor"},
+		seenField:  "last_seen",
+		newRecords: func() interface{} { return &[]models.L3Device{} },
+	},
+}
+
+// arpDeviceScope matches the ARP devices themselves, after they have been
+// unwound from their records.
+var arpDeviceScope = queryScope{
+	paths: map[string]string{"ip": "ip", "net": "ip_num", "mac": "mac", "vendor": "vendor"},
+}
+
+// RunQuery runs q in scope and returns up to limit records, newest first,
+// together with the total number of matches. hosts, when not nil, limits
+// the records to those addresses.
+func (r *Repository) RunQuery(scope string, q *models.Query, hosts []int64, limit int) (interface{}, int, error) {
+	def, filter, err := scopeFilter(scope, q, hosts)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	records := def.newRecords()
+	if def.historyType == "" {
+		total, err := r.runInventoryQuery(ctx, filter, limit, records)
+		return records, total, err
+	}
+
+	coll, base, err := r.historyMatch(def.historyType, models.HistoryQuery{})
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(base) > 0 {
+		filter = bson.M{"$and": bson.A{base, filter}}
+	}
+
+	total, err := coll.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+	opts := options.Find().SetSort(bson.D{{Key: def.seenField, Value: -1}}).SetLimit(int64(limit))
+	cursor, err := coll.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	if err := cursor.All(ctx, records); err != nil {
+		return nil, 0, err
+	}
+	return records, int(total), nil
+}
+
+// QueryHosts returns up to limit distinct addresses (ip_num) of the hosts
+// matching q in scope, limited to hosts when not nil. It is used to join
+// the parts of a query spanning several scopes.
+func (r *Repository) QueryHosts(scope string, q *models.Query, hosts []int64, limit int) ([]int64, error) {
+	def, filter, err := scopeFilter(scope, q, hosts)
+	if err != nil {
+		return nil, err
+	}
+	numPath := def.paths["net"]
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var coll *mongo.Collection
+	var pipeline mongo.Pipeline
+	switch {
+	case def.historyType == "":
+		coll = r.db.ARPCollection()
+		pipeline = append(r.inventorySightings(), l3DeviceStages()...)
+		pipeline = append(pipeline, bson.D{{Key: "$match", Value: filter}})
+
+	case strings.HasPrefix(numPath, arpDevicePrefix):
+		// The record filter selects scans with a matching device; the
+		// device terms are applied again to each device to keep only
+		// the matching ones.
+		var devices models.Query
+		for _, t := range q.Terms {
+			if _, ok := arpDeviceScope.paths[t.Field]; ok {
+				devices.Terms = append(devices.Terms, t)
+			}
+		}
+		deviceFilter, err := compileQuery(arpDeviceScope, &devices, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		if hosts != nil {
+			deviceFilter = bson.M{"$and": bson.A{deviceFilter, bson.M{"ip_num": bson.M{"$in": hosts}}}}
+		}
+		var base bson.M
+		coll, base, err = r.historyMatch(def.historyType, models.HistoryQuery{})
+		if err != nil {
+			return nil, err
+		}
+		pipeline = mongo.Pipeline{
+			{{Key: "$match", Value: bson.M{"$and": bson.A{base, filter}}}},
+			{{Key: "$unwind", Value: "$online_devices"}},
+			{{Key: "$replaceRoot", Value: bson.M{"newRoot": "$online_devices"}}},
+			{{Key: "$match", Value: deviceFilter}},
+		}
+		numPath = "ip_num"
+
+	default:
+		var base bson.M
+		coll, base, err = r.historyMatch(def.historyType, models.HistoryQuery{})
+		if err != nil {
+			return nil, err
+		}
+		pipeline = mongo.Pipeline{{{Key: "$match", Value: bson.M{"$and": bson.A{base, filter}}}}}
+	}
+
+	// Host names are not numbered (ip_num 0) and cannot be joined.
+	pipeline = append(pipeline,
+		bson.D{{Key: "$match", Value: bson.M{numPath: bson.M{"$gt": 0}}}},
+		bson.D{{Key: "$group", Value: bson.M{"_id": "$" + numPath}}},
+		bson.D{{Key: "$limit", Value: limit}},
+	)
+	cursor, err := coll.Aggregate(ctx, pipeline, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return nil, err
+	}
+	var rows []struct {
+		ID int64 `bson:"_id"`
+	}
+	if err := cursor.All(ctx, &rows); err != nil {
+		return nil, err
+	}
+	out := make([]int64, len(rows))
+	for i, row := range rows {
+		out[i] = row.ID
+	}
+	return out, nil
+}
+
+// scopeFilter compiles q for scope, limited to hosts when not nil.
+func scopeFilter(scope string, q *models.Query, hosts []int64) (queryScope, bson.M, error) {
+	def, ok := queryScopeDefs[scope]
+	if !ok {
+		return def, nil, &models.QueryError{Msg: fmt.Sprintf("unknown scope %q", scope)}
+	}
+	filter, err := compileQuery(def, q, time.Now())
+	if err != nil {
+		return def, nil, err
+	}
+	if hosts != nil {
+		filter = bson.M{"$and": bson.A{filter, bson.M{def.paths["net"]: bson.M{"$in": hosts}}}}
+	}
+	return def, filter, nil
+}
+
+func (r *Repository) runInventoryQuery(ctx context.Context, filter bson.M, limit int, records interface{}) (int, error) {
+	pipeline := append(r.inventorySightings(), l3DeviceStages()...)
+	pipeline = append(pipeline,
+		bson.D{{Key: "$match", Value: filter}},
+		bson.D{{Key: "$facet", Value: bson.M{
+			"records": bson.A{
+				bson.M{"$sort": bson.M{"last_seen": -1}},
+				bson.M{"$limit": limit},
+			},
+			"total": bson.A{bson.M{"$count": "n"}},
+		}}},
+	)
+
+	cursor, err := r.db.ARPCollection().Aggregate(ctx, pipeline, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	var out []struct {
+		Records bson.RawValue `bson:"records"`
+		Total   []struct {
+			N int `bson:"n"`
+		} `bson:"total"`
+	}
+	if err := cursor.All(ctx, &out); err != nil {
+		return 0, err
+	}
+	if len(out) == 0 {
+		return 0, nil
+	}
+	if err := out[0].Records.Unmarshal(records); err != nil {
+		return 0, err
+	}
+	if len(out[0].Total) == 0 {
+		return 0, nil
+	}
+	return out[0].Total[0].N, nil
+}
+
+// SuggestQueryValues returns up to limit distinct values of field in scope
+// that start with prefix (case-insensitive).
+func (r *Repository) SuggestQueryValues(scope string, field models.QueryField, prefix string, limit int) ([]string, error) {
+	def, ok := queryScopeDefs[scope]
+	if !ok {
+		return nil, &models.QueryError{Msg: fmt.Sprintf("unknown scope %q", scope)}
+	}
+	path, ok := def.paths[field.Name]
+	if sub, isPort := nmapPortPaths[field.Name]; isPort && scope == models.QueryScopeNmap {
+		path, ok = "port_info."+sub, true
+	}
+	if !ok {
+		return nil, &models.QueryError{Field: field.Name, Msg: "not available in scope " + scope}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var values []interface{}
+	var err error
+	if def.historyType == "" {
+		values, err = r.distinctInventory(ctx, path)
+	} else {
+		coll, base, herr := r.historyMatch(def.historyType, models.HistoryQuery{})
+		if herr != nil {
+			return nil, herr
+		}
+		if prefix != "" {
+			base[path] = primitive.Regex{Pattern: "^" + regexp.QuoteMeta(prefix), Options: "i"}
+		}
+		values, err = coll.Distinct(ctx, path, base)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	out := []string{}
+	seen := map[string]bool{}
+	for _, v := range values {
+		s := fmt.Sprint(v)
+		if s == "" || seen[s] || !strings.HasPrefix(strings.ToLower(s), prefix) {
+			continue
+		}
+		seen[s] = true
+		out = append(out, s)
+	}
+	sort.Strings(out)
+	if len(out) > limit {
+		out = out[:limit]
+	}
+	return out, nil
+}
+
+func (r *Repository) distinctInventory(ctx context.Context, path string) ([]interface{}, error) {
+	pipeline := append(r.inventorySightings(),
+		bson.D{{Key: "$group", Value: bson.M{"_id": "$" + path}}},
+		bson.D{{Key: "$limit", Value: 10000}},
+	)
+	cursor, err := r.db.ARPCollection().Aggregate(ctx, pipeline, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return nil, err
+	}
+	var rows []struct {
+		ID interface{} `bson:"_id"`
+	}
+	if err := cursor.All(ctx, &rows); err != nil {
+		return nil, err
+	}
+	values := make([]interface{}, 0, len(rows))
+	for _, row := range rows {
+		if row.ID != nil {
+			values = append(values, row.ID)
+		}
+	}
+	return values, nil
+}
+
+// compileQuery turns q into a filter for one scope. now anchors relative
+// times such as seen:<7d.
+func compileQuery(def queryScope, q *models.Query, now time.Time) (bson.M, error) {
+	var and bson.A
+	var portPos, portNeg []bson.M // nmap per-port predicates
+	var devPos, devNeg []bson.M   // ARP per-device conditions
+
+	for _, t := range q.Terms {
+		field, _ := models.LookupQueryField(t.Field)
+
+		if sub, ok := nmapPortPaths[t.Field]; ok && def.historyType == "nmap_tcp_udp" {
+			pred, err := termExpr(field, t, "$$"+sub, now)
+			if err != nil {
+				return nil, err
+			}
+			if t.Negate {
+				portNeg = append(portNeg, pred)
+			} else {
+				portPos = append(portPos, pred)
+				if nums := exactPorts(t); len(nums) > 0 {
+					and = append(and, bson.M{"port_info.allports": bson.M{"$in": nums}})
+				}
+			}
+			continue
+		}
+
+		path, ok := def.paths[t.Field]
+		if t.Field == "seen" {
+			path, ok = def.seenField, true
+		}
+		if !ok {
+			return nil, &models.QueryError{Field: t.Field, Msg: "not available in this scope"}
+		}
+
+		// TCP ports are stored as strings; compare them numerically.
+		if field.Type == models.QueryTypeNumber && def.historyType == "tcp" {
+			pred, err := termExpr(field, t, bson.M{"$convert": bson.M{"input": "$" + path, "to": "int", "onError": -1, "onNull": -1}}, now)
+			if err != nil {
+				return nil, err
+			}
+			and = append(and, negateIf(t.Negate, bson.M{"$expr": pred}))
+			continue
+		}
+
+		if strings.HasPrefix(path, arpDevicePrefix) {
+			cond, err := termFilter(field, t, strings.TrimPrefix(path, arpDevicePrefix), now)
+			if err != nil {
+				return nil, err
+			}
+			if t.Negate {
+				devNeg = append(devNeg, cond)
+			} else {
+				devPos = append(devPos, cond)
+			}
+			continue
+		}
+
+		cond, err := termFilter(field, t, path, now)
+		if err != nil {
+			return nil, err
+		}
+		and = append(and, negateIf(t.Negate, cond))
+	}
+
+	// Negated port/device terms constrain the matched port/device when there
+	// are positive ones; on their own they exclude records with any match.
+	if len(portPos) > 0 {
+		preds := bson.A{}
+		for _, p := range portPos {
+			preds = append(preds, p)
+		}
+		for _, p := range portNeg {
+			preds = append(preds, bson.M{"$not": bson.A{p}})
+		}
+		and = append(and, anyNmapPort(bson.M{"$and": preds}))
+	} else {
+		for _, p := range portNeg {
+			and = append(and, bson.M{"$nor": bson.A{anyNmapPort(p)}})
+		}
+	}
+	if len(devPos) > 0 {
+		conds := bson.A{}
+		for _, c := range devPos {
+			conds = append(conds, c)
+		}
+		for _, c := range devNeg {
+			conds = append(conds, bson.M{"$nor": bson.A{c}})
+		}
+		and = append(and, bson.M{"online_devices": bson.M{"$elemMatch": bson.M{"$and": conds}}})
+	} else {
+		for _, c := range devNeg {
+			and = append(and, bson.M{"$nor": bson.A{bson.M{"online_devices": bson.M{"$elemMatch": c}}}})
+		}
+	}
+
+	for _, word := range q.Text {
+		re := primitive.Regex{Pattern: regexp.QuoteMeta(word), Options: "i"}
+		or := bson.A{}
+		for _, path := range def.text {
+			or = append(or, bson.M{path: re})
+		}
+		and = append(and, bson.M{"$or": or})
+	}
+
+	if len(and) == 0 {
+		return bson.M{}, nil
+	}
+	return bson.M{"$and": and}, nil
+}
+
+// anyNmapPort matches records where pred holds for at least one port. pred
+// may refer to $$allports, $$state, $$servicename and $$protocols, bound to
+// the values of one port.
+func anyNmapPort(pred bson.M) bson.M {
+	at := func(array string) bson.M {
+		return bson.M{"$arrayElemAt": bson.A{"$$pi." + array, "$$i"}}
+	}
+	perPort := bson.M{"$map": bson.M{
+		"input": bson.M{"$range": bson.A{0, bson.M{"$size": bson.M{"$ifNull": bson.A{"$$pi.allports", bson.A{}}}}}},
+		"as":    "i",
+		"in": bson.M{"$let": bson.M{
+			"vars": bson.M{
+				"allports":    at("allports"),
+				"state":       at("state"),
+				"servicename": at("servicename"),
+				"protocols":   at("protocols"),
+			},
+			"in": pred,
+		}},
+	}}
+	return bson.M{"$expr": bson.M{"$anyElementTrue": bson.A{bson.M{"$map": bson.M{
+		"input": bson.M{"$ifNull": bson.A{"$port_info", bson.A{}}},
+		"as":    "pi",
+		"in":    bson.M{"$anyElementTrue": bson.A{perPort}},
+	}}}}}
+}
+
+func negateIf(negate bool, cond bson.M) bson.M {
+	if negate {
+		return bson.M{"$nor": bson.A{cond}}
+	}
+	return cond
+}
+
+// termFilter compiles t into a query-language filter on path.
+func termFilter(field models.QueryField, t models.QueryTerm, path string, now time.Time) (bson.M, error) {
+	or := bson.A{}
+	for _, v := range t.Values {
+		if field.Type == models.QueryTypeCIDR {
+			intervals, ok := models.ParseIPv4Intervals(v)
+			if !ok {
+				return nil, &models.QueryError{Field: field.Name, Msg: fmt.Sprintf("%q is not an IPv4 network or range", v)}
+			}
+			for _, iv := range intervals {
+				or = append(or, bson.M{path: ipBetween(iv.Lo, iv.Hi)})
+			}
+			continue
+		}
+		cond, err := valueCond(field, t.Op, v, now)
+		if err != nil {
+			return nil, err
+		}
+		or = append(or, bson.M{path: cond})
+	}
+	if len(or) == 1 {
+		return or[0].(bson.M), nil
+	}
+	return bson.M{"$or": or}, nil
+}
+
+// valueCond compiles one value into the condition applied to a field path.
+// Networks are compiled by termFilter, as one condition per interval.
+func valueCond(field models.QueryField, op, v string, now time.Time) (interface{}, error) {
+	switch field.Type {
+	case models.QueryTypeNumber:
+		if op == ":" {
+			if lo, hi, ok := parseNumberRange(v); ok {
+				return bson.M{"$gte": lo, "$lte": hi}, nil
+			}
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, &models.QueryError{Field: field.Name, Msg: fmt.Sprintf("%q is not a number", v)}
+		}
+		if op == ":" {
+			return n, nil
+		}
+		return bson.M{compareOps[op]: n}, nil
+
+	case models.QueryTypeTime:
+		lo, hi, err := timeBounds(field.Name, op, v, now)
+		if err != nil {
+			return nil, err
+		}
+		cond := bson.M{}
+		if !lo.IsZero() {
+			cond["$gte"] = lo
+		}
+		if !hi.IsZero() {
+			cond["$lt"] = hi
+		}
+		return cond, nil
+
+	case models.QueryTypeIP:
+		if strings.Contains(v, "*") {
+			return primitive.Regex{Pattern: wildcardRegex(v)}, nil
+		}
+		if net.ParseIP(v) == nil {
+			return nil, &models.QueryError{Field: field.Name, Msg: fmt.Sprintf("%q is not an IP address", v)}
+		}
+		return v, nil
+
+	case models.QueryTypeMAC:
+		if strings.Contains(v, "*") {
+			return primitive.Regex{Pattern: wildcardRegex(v), Options: "i"}, nil
+		}
+		return primitive.Regex{Pattern: "^" + regexp.QuoteMeta(v) + "$", Options: "i"}, nil
+
+	case models.QueryTypeEnum:
+		return primitive.Regex{Pattern: "^" + regexp.QuoteMeta(v) + "$", Options: "i"}, nil
+	}
+
+	// QueryTypeString
+	if strings.Contains(v, "*") {
+		return primitive.Regex{Pattern: wildcardRegex(v), Options: "i"}, nil
+	}
+	return primitive.Regex{Pattern: regexp.QuoteMeta(v), Options: "i"}, nil
+}
+
+var compareOps = map[string]string{"<": "$lt", "<=": "$lte", ">": "$gt", ">=": "$gte"}
+
+// termExpr compiles t into an aggregation expression over value, used where
+// a plain filter cannot express the match (per-port nmap fields, TCP ports
+// stored as strings).
+func termExpr(field models.QueryField, t models.QueryTerm, value interface{}, now time.Time) (bson.M, error) {
+	or := bson.A{}
+	for _, v := range t.Values {
+		var e bson.M
+		switch field.Type {
+		case models.QueryTypeNumber:
+			if lo, hi, ok := parseNumberRange(v); ok && t.Op == ":" {
+				e = bson.M{"$and": bson.A{bson.M{"$gte": bson.A{value, lo}}, bson.M{"$lte": bson.A{value, hi}}}}
+				break
+			}
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return nil, &models.QueryError{Field: field.Name, Msg: fmt.Sprintf("%q is not a number", v)}
+			}
+			op := "$eq"
+			if t.Op != ":" {
+				op = compareOps[t.Op]
+			}
+			e = bson.M{op: bson.A{value, n}}
+		case models.QueryTypeEnum:
+			e = bson.M{"$eq": bson.A{bson.M{"$toLower": bson.M{"$ifNull": bson.A{value, ""}}}, strings.ToLower(v)}}
+		default:
+			pattern := regexp.QuoteMeta(v)
+			if strings.Contains(v, "*") {
+				pattern = wildcardRegex(v)
+			}
+			e = bson.M{"$regexMatch": bson.M{"input": bson.M{"$ifNull": bson.A{value, ""}}, "regex": pattern, "options": "i"}}
+		}
+		or = append(or, e)
+	}
+	if len(or) == 1 {
+		return or[0].(bson.M), nil
+	}
+	return bson.M{"$or": or}, nil
+}
+
+// exactPorts returns the plain port numbers of a port term, used as an
+// index-friendly prefilter before the per-port $expr.
+func exactPorts(t models.QueryTerm) []int {
+	if t.Field != "port" || t.Op != ":" {
+		return nil
+	}
+	var nums []int
+	for _, v := range t.Values {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return nil // ranges: no prefilter
+		}
+		nums = append(nums, n)
+	}
+	return nums
+}
+
+func parseNumberRange(v string) (int, int, bool) {
+	lo, hi, found := strings.Cut(v, "-")
+	if !found {
+		return 0, 0, false
+	}
+	a, errA := strconv.Atoi(lo)
+	b, errB := strconv.Atoi(hi)
+	if errA != nil || errB != nil || a > b {
+		return 0, 0, false
+	}
+	return a, b, true
+}
+
+// timeBounds resolves a time value into [lo, hi). Ages (7d, 12h) count back
+// from now, so seen:<7d means "newer than 7 days"; dates compare directly.
+func timeBounds(name, op, v string, now time.Time) (time.Time, time.Time, error) {
+	if age, ok := parseQueryAge(v); ok {
+		at := now.Add(-age)
+		switch op {
+		case ":", "<", "<=":
+			return at, time.Time{}, nil
+		default:
+			return time.Time{}, at, nil
+		}
+	}
+
+	var at time.Time
+	dateOnly := false
+	if t, err := time.Parse("2006-01-02", v); err == nil {
+		at, dateOnly = t, true
+	} else if t, err := time.Parse(time.RFC3339, v); err == nil {
+		at = t
+	} else {
+		return time.Time{}, time.Time{}, &models.QueryError{Field: name, Msg: fmt.Sprintf("%q is neither an age (7d, 12h) nor a date (2006-01-02)", v)}
+	}
+
+	end := at
+	if dateOnly {
+		end = at.Add(24 * time.Hour)
+	}
+	switch op {
+	case ":":
+		if !dateOnly {
+			end = at.Add(time.Second)
+		}
+		return at, end, nil
+	case "<":
+		return time.Time{}, at, nil
+	case "<=":
+		return time.Time{}, end, nil
+	case ">":
+		return end, time.Time{}, nil
+	default: // ">="
+		return at, time.Time{}, nil
+	}
+}
+
+func parseQueryAge(v string) (time.Duration, bool) {
+	if strings.HasSuffix(v, "d") {
+		if n, err := strconv.Atoi(strings.TrimSuffix(v, "d")); err == nil && n >= 0 {
+			return time.Duration(n) * 24 * time.Hour, true
+		}
+		return 0, false
+	}
+	if d, err := time.ParseDuration(v); err == nil && d >= 0 {
+		return d, true
+	}
+	return 0, false
+}
+
+// wildcardRegex converts a value with * wildcards into an anchored regex.
+func wildcardRegex(v string) string {
+	parts := strings.Split(v, "*")
+	for i, p := range parts {
+		parts[i] = regexp.QuoteMeta(p)
+	}
+	return "^" + strings.Join(parts, ".*") + "$"
+}
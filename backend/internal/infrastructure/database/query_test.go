@@ -0,0 +1,194 @@
+package rabbitmq
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"backend/domain/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var queryNow = time.Date(2026, 5, 10, 12, 0, 0, 0, time.UTC)
+
+func terms(t ...models.QueryTerm) *models.Query {
+	return &models.Query{Terms: t}
+}
+
+func term(field, op string, values ...string) models.QueryTerm {
+	return models.QueryTerm{Field: field, Op: op, Values: values}
+}
+
+func TestCompileQuery(t *testing.T) {
+	tests := []struct {
+		name  string
+		scope string
+		q     *models.Query
+		want  bson.M
+	}{
+		{
+			name:  "exact port in tcp",
+			scope: models.QueryScopeTCP,
+			q:     terms(term("port", ":", "22")),
+			want: bson.M{"$and": bson.A{bson.M{"$expr": bson.M{"$eq": bson.A{
+				bson.M{"$convert": bson.M{"input": "$port", "to": "int", "onError": -1, "onNull": -1}}, 22,
+			}}}}},
+		},
+		{
+			name:  "banner substring",
+			scope: models.QueryScopeTCP,
+			q:     terms(term("banner", ":", "OpenSSH_7")),
+			want:  bson.M{"$and": bson.A{bson.M{"decoded_text": primitive.Regex{Pattern: "OpenSSH_7", Options: "i"}}}},
+		},
+		{
+			name:  "os family",
+			scope: models.QueryScopeOS,
+			q:     terms(term("os.family", ":", "Linux")),
+			want:  bson.M{"$and": bson.A{bson.M{"family": primitive.Regex{Pattern: "Linux", Options: "i"}}}},
+		},
+		{
+			name:  "seen newer than 7 days",
+			scope: models.QueryScopeOS,
+			q:     terms(term("seen", "<", "7d")),
+			want:  bson.M{"$and": bson.A{bson.M{"created_at": bson.M{"$gte": queryNow.Add(-7 * 24 * time.Hour)}}}},
+		},
+		{
+			name:  "seen after a date",
+			scope: models.QueryScopeInventory,
+			q:     terms(term("seen", ">", "2024-01-01")),
+			want:  bson.M{"$and": bson.A{bson.M{"last_seen": bson.M{"$gte": time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)}}}},
+		},
+		{
+			name:  "negated status",
+			scope: models.QueryScopeTCP,
+			q:     terms(models.QueryTerm{Field: "status", Op: ":", Values: []string{"failed"}, Negate: true}),
+			want:  bson.M{"$and": bson.A{bson.M{"$nor": bson.A{bson.M{"status": primitive.Regex{Pattern: "^failed$", Options: "i"}}}}}},
+		},
+		{
+			name:  "ARP device terms share one device",
+			scope: models.QueryScopeARP,
+			q:     terms(term("mac", ":", "00:1b:54:*"), term("vendor", ":", "Cisco")),
+			want: bson.M{"$and": bson.A{bson.M{"online_devices": bson.M{"$elemMatch": bson.M{"$and": bson.A{
+				bson.M{"mac": primitive.Regex{Pattern: "^00:1b:54:.*$", Options: "i"}},
+				bson.M{"vendor": primitive.Regex{Pattern: "Cisco", Options: "i"}},
+			}}}}}},
+		},
+		{
+			name:  "net list keeps the gaps",
+			scope: models.QueryScopeTCP,
+			q:     terms(term("net", ":", "10.0.0.1,10.255.0.1")),
+			want: bson.M{"$and": bson.A{bson.M{"$or": bson.A{
+				bson.M{"ip_num": ipBetween(167772161, 167772161)},
+				bson.M{"ip_num": ipBetween(184483841, 184483841)},
+			}}}},
+		},
+		{
+			name:  "no terms",
+			scope: models.QueryScopeNmap,
+			q:     &models.Query{},
+			want:  bson.M{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := compileQuery(queryScopeDefs[tt.scope], tt.q, queryNow)
+			if err != nil {
+				t.Fatalf("compileQuery: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("filter = %v\nwant     %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestCompileQueryExampleParts compiles the parts the example query is
+// split into, and checks the fields of each part land on that scope's
+// paths.
+func TestCompileQueryExampleParts(t *testing.T) {
+	net := term("net", ":", "10.1.0.0/16")
+	seen := term("seen", "<", "7d")
+	tests := []struct {
+		scope string
+		q     *models.Query
+		paths []string
+	}{
+		{
+			scope: models.QueryScopeNmap,
+			q:     terms(term("port", ":", "22"), term("state", ":", "open"), term("vendor", ":", "Cisco"), net, seen),
+			paths: []string{"port_info.allports", "$$allports", "$$state", "mac_vendor", "ip_num", "created_at"},
+		},
+		{
+			scope: models.QueryScopeOS,
+			q:     terms(term("os.family", ":", "Linux"), net, seen),
+			paths: []string{"family", "ip_num", "created_at"},
+		},
+		{
+			scope: models.QueryScopeTCP,
+			q:     terms(term("banner", ":", "OpenSSH_7"), net, seen),
+			paths: []string{"decoded_text", "ip_num", "created_at"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.scope, func(t *testing.T) {
+			got, err := compileQuery(queryScopeDefs[tt.scope], tt.q, queryNow)
+			if err != nil {
+				t.Fatalf("compileQuery: %v", err)
+			}
+			dump := strings.Join(filterKeys(got), " ")
+			for _, path := range tt.paths {
+				if !strings.Contains(" "+dump+" ", " "+path+" ") {
+					t.Errorf("filter does not use %s: %v", path, got)
+				}
+			}
+		})
+	}
+}
+
+func TestCompileQueryErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		scope string
+		q     *models.Query
+		field string
+	}{
+		{"field of another scope", models.QueryScopeNmap, terms(term("os.family", ":", "Linux")), "os.family"},
+		{"bad port", models.QueryScopeTCP, terms(term("port", ":", "ssh")), "port"},
+		{"bad network", models.QueryScopeOS, terms(term("net", ":", "10.1.0.0/33")), "net"},
+		{"bad age", models.QueryScopeOS, terms(term("seen", "<", "7w")), "seen"},
+		{"bad address", models.QueryScopeInventory, terms(term("ip", ":", "10.1.2")), "ip"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := compileQuery(queryScopeDefs[tt.scope], tt.q, queryNow)
+			var qerr *models.QueryError
+			if !errors.As(err, &qerr) || qerr.Field != tt.field {
+				t.Fatalf("err = %v, want a QueryError on %s", err, tt.field)
+			}
+		})
+	}
+}
+
+// filterKeys lists the keys and string values anywhere in a filter.
+func filterKeys(v interface{}) []string {
+	var out []string
+	switch v := v.(type) {
+	case bson.M:
+		for k, sub := range v {
+			out = append(out, k)
+			out = append(out, filterKeys(sub)...)
+		}
+	case bson.A:
+		for _, sub := range v {
+			out = append(out, filterKeys(sub)...)
+		}
+	case string:
+		out = append(out, strings.TrimPrefix(v, "$"))
+		out = append(out, v)
+	}
+	return out
+}
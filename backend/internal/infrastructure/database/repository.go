@@ -2,13 +2,17 @@ package rabbitmq
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
+	"regexp"
 	"time"
 
 	"backend/domain/models"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
@@ -25,10 +29,15 @@ func (r *Repository) SaveARPHistory(record *models.ARPHistoryRecord) error {
 	defer cancel()
 
 	record.CreatedAt = time.Now()
-	_, err := r.db.ARPCollection().UpdateOne(
+	normalizeARPRecord(record)
+	update, err := historyUpdate(record, "online_devices", "offline_devices")
+	if err != nil {
+		return err
+	}
+	_, err = r.db.ARPCollection().UpdateOne(
 		ctx,
 		bson.M{"task_id": record.TaskID},
-		bson.M{"$setOnInsert": record},
+		update,
 		options.Update().SetUpsert(true),
 	)
 	if err != nil {
@@ -70,7 +79,51 @@ func (r *Repository) GetARPHistoryByIPRange(ipRange string, limit int) ([]models
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	filter := bson.M{"ip_range": ipRange}
+	filter := ipTargetFilter(ipRange, "ip_range", "online_devices.ip_num")
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}})
+	if limit > 0 {
+		opts.SetLimit(int64(limit))
+	}
+	cursor, err := r.db.ARPCollection().Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var records []models.ARPHistoryRecord
+	if err = cursor.All(ctx, &records); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// SearchARPHistory returns the ARP scans matching q, newest first.
+func (r *Repository) SearchARPHistory(q models.ARPSearchQuery, limit int) ([]models.ARPHistoryRecord, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.M{}
+	if q.IPRange != "" {
+		filter = ipTargetFilter(q.IPRange, "ip_range", "online_devices.ip_num")
+	}
+	if q.Interface != "" {
+		filter["interface_name"] = q.Interface
+	}
+	device := bson.M{}
+	if q.MACPrefix != "" {
+		re, err := macPrefixRegex(q.MACPrefix)
+		if err != nil {
+			return nil, err
+		}
+		device["mac"] = re
+	}
+	if q.Vendor != "" {
+		device["vendor"] = primitive.Regex{Pattern: regexp.QuoteMeta(q.Vendor), Options: "i"}
+	}
+	if len(device) > 0 {
+		filter["online_devices"] = bson.M{"$elemMatch": device}
+	}
+
 	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}})
 	if limit > 0 {
 		opts.SetLimit(int64(limit))
@@ -89,7 +142,7 @@ func (r *Repository) GetARPHistoryByIPRange(ipRange string, limit int) ([]models
 }
 
 func (r *Repository) GetARPHistoryByID(id string) (*models.ARPHistoryRecord, error) {
-	objID, err := primitive.ObjectIDFromHex(id)
+	objID, err := recordID(id)
 	if err != nil {
 		return nil, err
 	}
@@ -99,7 +152,7 @@ func (r *Repository) GetARPHistoryByID(id string) (*models.ARPHistoryRecord, err
 	var rec models.ARPHistoryRecord
 	err = r.db.ARPCollection().FindOne(ctx, bson.M{"_id": objID}).Decode(&rec)
 	if err != nil {
-		return nil, err
+		return nil, notFound(err)
 	}
 	return &rec, nil
 }
@@ -124,10 +177,15 @@ func (r *Repository) SaveICMPHistory(record *models.ICMPHistoryRecord) error {
 
 	record.ScanType = "icmp"
 	record.CreatedAt = time.Now()
-	_, err := r.db.ICMPCollection().UpdateOne(
+	normalizeICMPRecord(record)
+	update, err := historyUpdate(record, "results")
+	if err != nil {
+		return err
+	}
+	_, err = r.db.ICMPCollection().UpdateOne(
 		ctx,
 		bson.M{"task_id": record.TaskID, "scan_type": "icmp"},
-		bson.M{"$setOnInsert": record},
+		update,
 		options.Update().SetUpsert(true),
 	)
 	if err != nil {
@@ -183,7 +241,15 @@ func (r *Repository) GetICMPHistoryByTargets(targets []string, limit int) ([]mod
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	filter := bson.M{"scan_type": "icmp", "targets": bson.M{"$in": targets}}
+	anyTarget := bson.A{bson.M{"targets": bson.M{"$in": targets}}}
+	for _, target := range targets {
+		if intervals, ok := models.ParseIPv4Intervals(target); ok {
+			for _, iv := range intervals {
+				anyTarget = append(anyTarget, ipOverlap(iv.Lo, iv.Hi), bson.M{"results.ip_num": ipBetween(iv.Lo, iv.Hi)})
+			}
+		}
+	}
+	filter := bson.M{"scan_type": "icmp", "$or": anyTarget}
 	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}})
 	if limit > 0 {
 		opts.SetLimit(int64(limit))
@@ -202,7 +268,7 @@ func (r *Repository) GetICMPHistoryByTargets(targets []string, limit int) ([]mod
 }
 
 func (r *Repository) GetICMPHistoryByID(id string) (*models.ICMPHistoryRecord, error) {
-	objID, err := primitive.ObjectIDFromHex(id)
+	objID, err := recordID(id)
 	if err != nil {
 		return nil, err
 	}
@@ -212,7 +278,7 @@ func (r *Repository) GetICMPHistoryByID(id string) (*models.ICMPHistoryRecord, e
 	var rec models.ICMPHistoryRecord
 	err = r.db.ICMPCollection().FindOne(ctx, bson.M{"_id": objID, "scan_type": "icmp"}).Decode(&rec)
 	if err != nil {
-		return nil, err
+		return nil, notFound(err)
 	}
 	return &rec, nil
 }
@@ -222,7 +288,12 @@ func (r *Repository) SaveNmapTcpUdpHistory(record *models.NmapTcpUdpHistoryRecor
 	defer cancel()
 
 	record.ScanType = "nmap_tcp_udp"
-	record.CreatedAt = time.Now()
+	// Imported records keep their original scan time.
+	if record.CreatedAt.IsZero() {
+		record.CreatedAt = time.Now()
+	}
+	record.IPStart, record.IPEnd = ipRange(record.IP)
+	record.IPNum = ipNum(record.Host)
 	_, err := r.db.NmapTcpUdpCollection().UpdateOne(
 		ctx,
 		bson.M{"task_id": record.TaskID, "scan_type": "nmap_tcp_udp"},
@@ -282,7 +353,10 @@ func (r *Repository) GetNmapTcpUdpHistoryByIP(ip string, limit int) ([]models.Nm
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	filter := bson.M{"scan_type": "nmap_tcp_udp", "ip": ip}
+	filter := bson.M{"scan_type": "nmap_tcp_udp"}
+	for k, v := range ipTargetFilter(ip, "ip", "ip_num") {
+		filter[k] = v
+	}
 	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}})
 	if limit > 0 {
 		opts.SetLimit(int64(limit))
@@ -301,7 +375,7 @@ func (r *Repository) GetNmapTcpUdpHistoryByIP(ip string, limit int) ([]models.Nm
 }
 
 func (r *Repository) GetNmapTcpUdpHistoryByID(id string) (*models.NmapTcpUdpHistoryRecord, error) {
-	objID, err := primitive.ObjectIDFromHex(id)
+	objID, err := recordID(id)
 	if err != nil {
 		return nil, err
 	}
@@ -311,7 +385,7 @@ func (r *Repository) GetNmapTcpUdpHistoryByID(id string) (*models.NmapTcpUdpHist
 	var rec models.NmapTcpUdpHistoryRecord
 	err = r.db.NmapTcpUdpCollection().FindOne(ctx, bson.M{"_id": objID, "scan_type": "nmap_tcp_udp"}).Decode(&rec)
 	if err != nil {
-		return nil, err
+		return nil, notFound(err)
 	}
 	return &rec, nil
 }
@@ -321,7 +395,12 @@ func (r *Repository) SaveNmapOsDetectionHistory(record *models.NmapOsDetectionHi
 	defer cancel()
 
 	record.ScanType = "nmap_os_detection"
-	record.CreatedAt = time.Now()
+	// Imported records keep their original scan time.
+	if record.CreatedAt.IsZero() {
+		record.CreatedAt = time.Now()
+	}
+	record.IPStart, record.IPEnd = ipRange(record.IP)
+	record.IPNum = ipNum(record.Host)
 	_, err := r.db.NmapOsDetectionCollection().UpdateOne(
 		ctx,
 		bson.M{"task_id": record.TaskID, "scan_type": "nmap_os_detection"},
@@ -381,7 +460,10 @@ func (r *Repository) GetNmapOsDetectionHistoryByIP(ip string, limit int) ([]mode
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	filter := bson.M{"scan_type": "nmap_os_detection", "ip": ip}
+	filter := bson.M{"scan_type": "nmap_os_detection"}
+	for k, v := range ipTargetFilter(ip, "ip", "ip_num") {
+		filter[k] = v
+	}
 	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}})
 	if limit > 0 {
 		opts.SetLimit(int64(limit))
@@ -400,7 +482,7 @@ func (r *Repository) GetNmapOsDetectionHistoryByIP(ip string, limit int) ([]mode
 }
 
 func (r *Repository) GetNmapOsDetectionHistoryByID(id string) (*models.NmapOsDetectionHistoryRecord, error) {
-	objID, err := primitive.ObjectIDFromHex(id)
+	objID, err := recordID(id)
 	if err != nil {
 		return nil, err
 	}
@@ -410,7 +492,7 @@ func (r *Repository) GetNmapOsDetectionHistoryByID(id string) (*models.NmapOsDet
 	var rec models.NmapOsDetectionHistoryRecord
 	err = r.db.NmapOsDetectionCollection().FindOne(ctx, bson.M{"_id": objID, "scan_type": "nmap_os_detection"}).Decode(&rec)
 	if err != nil {
-		return nil, err
+		return nil, notFound(err)
 	}
 	return &rec, nil
 }
@@ -421,6 +503,8 @@ func (r *Repository) SaveNmapHostDiscoveryHistory(record *models.NmapHostDiscove
 
 	record.ScanType = "nmap_host_discovery"
 	record.CreatedAt = time.Now()
+	record.IPStart, record.IPEnd = ipRange(record.IP)
+	record.IPNum = ipNum(record.Host)
 	_, err := r.db.NmapHostDiscoveryCollection().UpdateOne(
 		ctx,
 		bson.M{"task_id": record.TaskID, "scan_type": "nmap_host_discovery"},
@@ -480,7 +564,10 @@ func (r *Repository) GetNmapHostDiscoveryHistoryByIP(ip string, limit int) ([]mo
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	filter := bson.M{"scan_type": "nmap_host_discovery", "ip": ip}
+	filter := bson.M{"scan_type": "nmap_host_discovery"}
+	for k, v := range ipTargetFilter(ip, "ip", "ip_num") {
+		filter[k] = v
+	}
 	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}})
 	if limit > 0 {
 		opts.SetLimit(int64(limit))
@@ -499,7 +586,7 @@ func (r *Repository) GetNmapHostDiscoveryHistoryByIP(ip string, limit int) ([]mo
 }
 
 func (r *Repository) GetNmapHostDiscoveryHistoryByID(id string) (*models.NmapHostDiscoveryHistoryRecord, error) {
-	objID, err := primitive.ObjectIDFromHex(id)
+	objID, err := recordID(id)
 	if err != nil {
 		return nil, err
 	}
@@ -509,7 +596,7 @@ func (r *Repository) GetNmapHostDiscoveryHistoryByID(id string) (*models.NmapHos
 	var rec models.NmapHostDiscoveryHistoryRecord
 	err = r.db.NmapHostDiscoveryCollection().FindOne(ctx, bson.M{"_id": objID, "scan_type": "nmap_host_discovery"}).Decode(&rec)
 	if err != nil {
-		return nil, err
+		return nil, notFound(err)
 	}
 	return &rec, nil
 }
@@ -520,6 +607,7 @@ func (r *Repository) SaveTCPHistory(record *models.TCPHistoryRecord) error {
 
 	record.ScanType = "tcp"
 	record.CreatedAt = time.Now()
+	record.IPNum = ipNum(record.Host)
 	_, err := r.db.TCPCollection().UpdateOne(
 		ctx,
 		bson.M{"task_id": record.TaskID, "scan_type": "tcp"},
@@ -566,7 +654,9 @@ func (r *Repository) GetTCPHistoryByHostPort(host, port string, limit int) ([]mo
 	defer cancel()
 
 	filter := bson.M{"scan_type": "tcp"}
-	if host != "" {
+	if intervals, ok := models.ParseIPv4Intervals(host); ok {
+		addFilter(filter, ipWithin("ip_num", intervals))
+	} else if host != "" {
 		filter["host"] = host
 	}
 	if port != "" {
@@ -590,7 +680,7 @@ func (r *Repository) GetTCPHistoryByHostPort(host, port string, limit int) ([]mo
 }
 
 func (r *Repository) GetTCPHistoryByID(id string) (*models.TCPHistoryRecord, error) {
-	objID, err := primitive.ObjectIDFromHex(id)
+	objID, err := recordID(id)
 	if err != nil {
 		return nil, err
 	}
@@ -600,7 +690,7 @@ func (r *Repository) GetTCPHistoryByID(id string) (*models.TCPHistoryRecord, err
 	var rec models.TCPHistoryRecord
 	err = r.db.TCPCollection().FindOne(ctx, bson.M{"_id": objID, "scan_type": "tcp"}).Decode(&rec)
 	if err != nil {
-		return nil, err
+		return nil, notFound(err)
 	}
 	return &rec, nil
 }
@@ -686,3 +776,57 @@ func (r *Repository) DeleteChangeEvents() error {
 	return nil
 }
 
+
+// GetHistoryScanType reports which history type the record with the given
+// id belongs to ("arp", "icmp", "nmap_tcp_udp", … or "tcp").
+func (r *Repository) GetHistoryScanType(id string) (string, error) {
+	objID, err := recordID(id)
+	if err != nil {
+		return "", err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var doc struct {
+		ScanType string `bson:"scan_type"`
+	}
+	proj := options.FindOne().SetProjection(bson.M{"scan_type": 1})
+
+	err = r.db.ARPCollection().FindOne(ctx, bson.M{"_id": objID}, proj).Decode(&doc)
+	if err == nil {
+		return "arp", nil
+	} else if err != mongo.ErrNoDocuments {
+		return "", err
+	}
+
+	err = r.db.NmapTcpUdpCollection().FindOne(ctx, bson.M{"_id": objID, "scan_type": bson.M{"$ne": "change_event"}}, proj).Decode(&doc)
+	if err == nil {
+		return doc.ScanType, nil
+	} else if err != mongo.ErrNoDocuments {
+		return "", err
+	}
+
+	err = r.db.TCPCollection().FindOne(ctx, bson.M{"_id": objID}, proj).Decode(&doc)
+	if err != nil {
+		return "", notFound(err)
+	}
+	return "tcp", nil
+}
+
+// recordID parses a record id; an id that cannot name a record is reported
+// as models.ErrNotFound.
+func recordID(id string) (primitive.ObjectID, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return primitive.NilObjectID, fmt.Errorf("%w: invalid id %q", models.ErrNotFound, id)
+	}
+	return objID, nil
+}
+
+// notFound maps mongo.ErrNoDocuments to models.ErrNotFound.
+func notFound(err error) error {
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return models.ErrNotFound
+	}
+	return err
+}
@@ -0,0 +1,375 @@
+package rabbitmq
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"backend/domain/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// historyKind describes where the history of one scan type lives and which
+// field identifies what was scanned.
+type historyKind struct {
+	collection  func(*Database) *mongo.Collection
+	scanType    string // "scan_type" discriminator; empty for ARP (own collection)
+	targetField string
+}
+
+var historyKinds = map[string]historyKind{
+	"arp":                 {(*Database).ARPCollection, "", "ip_range"},
+	"icmp":                {(*Database).ICMPCollection, "icmp", "targets"},
+	"nmap_tcp_udp":        {(*Database).NmapTcpUdpCollection, "nmap_tcp_udp", "ip"},
+	"nmap_os_detection":   {(*Database).NmapOsDetectionCollection, "nmap_os_detection", "ip"},
+	"nmap_host_discovery": {(*Database).NmapHostDiscoveryCollection, "nmap_host_discovery", "ip"},
+	"tcp":                 {(*Database).TCPCollection, "tcp", "host"},
+}
+
+// historyQuery builds the collection and filter matching f for scanType.
+// An empty filter matches every record of that scan type.
+func (r *Repository) historyQuery(scanType string, f models.HistoryDeleteFilter) (*mongo.Collection, bson.M, error) {
+	coll, filter, err := r.historyMatch(scanType, models.HistoryQuery{Target: f.Target, Until: f.OlderThan})
+	if err != nil {
+		return nil, nil, err
+	}
+	if f.ID != "" {
+		objID, err := primitive.ObjectIDFromHex(f.ID)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid id %q: %w", f.ID, err)
+		}
+		filter["_id"] = objID
+	}
+	return coll, filter, nil
+}
+
+// historyMatch builds the collection and filter matching q for scanType.
+func (r *Repository) historyMatch(scanType string, q models.HistoryQuery) (*mongo.Collection, bson.M, error) {
+	kind, ok := historyKinds[scanType]
+	if !ok {
+		return nil, nil, fmt.Errorf("unknown scan type %q", scanType)
+	}
+
+	filter := bson.M{}
+	if kind.scanType != "" {
+		filter["scan_type"] = kind.scanType
+	}
+	if q.Target != "" {
+		filter[kind.targetField] = q.Target
+	}
+	created := bson.M{}
+	if !q.Since.IsZero() {
+		created["$gte"] = q.Since
+	}
+	if !q.Until.IsZero() {
+		created["$lt"] = q.Until
+	}
+	if len(created) > 0 {
+		filter["created_at"] = created
+	}
+	return kind.collection(r.db), filter, nil
+}
+
+// DeleteHistoryWhere removes the records of scanType selected by f and
+// returns how many were deleted.
+func (r *Repository) DeleteHistoryWhere(scanType string, f models.HistoryDeleteFilter) (int64, error) {
+	coll, filter, err := r.historyQuery(scanType, f)
+	if err != nil {
+		return 0, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	result, err := coll.DeleteMany(ctx, filter)
+	if err != nil {
+		log.Printf("Error deleting %s history: %v", scanType, err)
+		return 0, err
+	}
+
+	log.Printf("Deleted %d %s history records (filter=%v)", result.DeletedCount, scanType, filter)
+	return result.DeletedCount, nil
+}
+
+// GetTCPObjectKeysWhere returns the MinIO object keys referenced by the TCP
+// history records selected by f, so they can be purged alongside them.
+func (r *Repository) GetTCPObjectKeysWhere(f models.HistoryDeleteFilter) ([]string, error) {
+	coll, filter, err := r.historyQuery("tcp", f)
+	if err != nil {
+		return nil, err
+	}
+	filter["hex_object_key"] = bson.M{"$nin": bson.A{"", nil}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	values, err := coll.Distinct(ctx, "hex_object_key", filter)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(values))
+	for _, v := range values {
+		if s, ok := v.(string); ok && s != "" {
+			keys = append(keys, s)
+		}
+	}
+	return keys, nil
+}
+
+// compactionBatchField marks the raw records claimed by one compaction run.
+// Summaries list the batches they count, so a batch whose records were
+// merged but not deleted is not counted again when a later run retries it.
+const compactionBatchField = "compaction_batch"
+
+// compactionSteps are the steps of one CompactHistoryBefore run.
+type compactionSteps interface {
+	// pendingBatches returns batches claimed by earlier runs that still
+	// have raw records, because their delete failed.
+	pendingBatches() ([]primitive.ObjectID, error)
+	// claim marks every unclaimed record before the cutoff with batch.
+	claim(batch primitive.ObjectID) (int64, error)
+	// summarize merges the records of batch into the daily summaries,
+	// unless the summaries already count it.
+	summarize(batch primitive.ObjectID) error
+	// remove deletes the records of batch.
+	remove(batch primitive.ObjectID) (int64, error)
+}
+
+// compact finishes the batches left by earlier runs, then claims, merges and
+// deletes the records of a new one. It returns how many records were deleted.
+func compact(steps compactionSteps, batch primitive.ObjectID) (int64, error) {
+	batches, err := steps.pendingBatches()
+	if err != nil {
+		return 0, err
+	}
+	claimed, err := steps.claim(batch)
+	if err != nil {
+		return 0, err
+	}
+	if claimed > 0 {
+		batches = append(batches, batch)
+	}
+
+	var deleted int64
+	for _, b := range batches {
+		if err := steps.summarize(b); err != nil {
+			return deleted, err
+		}
+		n, err := steps.remove(b)
+		deleted += n
+		if err != nil {
+			return deleted, err
+		}
+	}
+	return deleted, nil
+}
+
+// CompactHistoryBefore folds every raw record of scanType created before
+// cutoff into per-day summaries and then deletes the raw records.
+// Running it repeatedly is safe: summaries for the same day are accumulated,
+// and records are counted once even if an earlier run failed to delete them.
+func (r *Repository) CompactHistoryBefore(scanType string, cutoff time.Time) (int64, error) {
+	kind, ok := historyKinds[scanType]
+	if !ok {
+		return 0, fmt.Errorf("unknown scan type %q", scanType)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	_, err := r.db.SummaryCollection().Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "scan_type", Value: 1}, {Key: "target", Value: 1}, {Key: "day", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return 0, fmt.Errorf("ensure summary index: %w", err)
+	}
+	coll := kind.collection(r.db)
+	_, err = coll.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: compactionBatchField, Value: 1}},
+		Options: options.Index().SetSparse(true),
+	})
+	if err != nil {
+		return 0, fmt.Errorf("ensure %s index: %w", compactionBatchField, err)
+	}
+
+	steps := &mongoCompaction{
+		ctx:      ctx,
+		coll:     coll,
+		summary:  r.db.SummaryCollection().Name(),
+		scanType: scanType,
+		kind:     kind,
+		cutoff:   cutoff,
+	}
+	deleted, err := compact(steps, primitive.NewObjectID())
+	if err != nil {
+		log.Printf("Error compacting %s history: %v", scanType, err)
+		return deleted, err
+	}
+	log.Printf("Compacted %d %s history records older than %s", deleted, scanType, cutoff.Format(time.RFC3339))
+	return deleted, nil
+}
+
+// mongoCompaction runs the compaction steps against the history collection
+// of one scan type.
+type mongoCompaction struct {
+	ctx      context.Context
+	coll     *mongo.Collection
+	summary  string
+	scanType string
+	kind     historyKind
+	cutoff   time.Time
+}
+
+// filter matches the records of the scan type, narrowed by extra.
+func (c *mongoCompaction) filter(extra bson.M) bson.M {
+	filter := bson.M{}
+	if c.kind.scanType != "" {
+		filter["scan_type"] = c.kind.scanType
+	}
+	for k, v := range extra {
+		filter[k] = v
+	}
+	return filter
+}
+
+func (c *mongoCompaction) pendingBatches() ([]primitive.ObjectID, error) {
+	values, err := c.coll.Distinct(c.ctx, compactionBatchField, c.filter(bson.M{
+		compactionBatchField: bson.M{"$exists": true},
+	}))
+	if err != nil {
+		return nil, fmt.Errorf("find pending %s batches: %w", c.scanType, err)
+	}
+	batches := make([]primitive.ObjectID, 0, len(values))
+	for _, v := range values {
+		if id, ok := v.(primitive.ObjectID); ok {
+			batches = append(batches, id)
+		}
+	}
+	return batches, nil
+}
+
+func (c *mongoCompaction) claim(batch primitive.ObjectID) (int64, error) {
+	result, err := c.coll.UpdateMany(c.ctx, c.filter(bson.M{
+		"created_at":         bson.M{"$lt": c.cutoff},
+		compactionBatchField: bson.M{"$exists": false},
+	}), bson.M{"$set": bson.M{compactionBatchField: batch}})
+	if err != nil {
+		return 0, fmt.Errorf("claim %s history: %w", c.scanType, err)
+	}
+	return result.ModifiedCount, nil
+}
+
+func (c *mongoCompaction) summarize(batch primitive.ObjectID) error {
+	pipeline := summaryPipeline(c.scanType, c.kind, c.filter(bson.M{compactionBatchField: batch}), batch, c.summary)
+	cursor, err := c.coll.Aggregate(c.ctx, pipeline)
+	if err != nil {
+		return fmt.Errorf("summarise %s history: %w", c.scanType, err)
+	}
+	return cursor.Close(c.ctx)
+}
+
+func (c *mongoCompaction) remove(batch primitive.ObjectID) (int64, error) {
+	result, err := c.coll.DeleteMany(c.ctx, c.filter(bson.M{compactionBatchField: batch}))
+	if err != nil {
+		return 0, fmt.Errorf("delete compacted %s history: %w", c.scanType, err)
+	}
+	return result.DeletedCount, nil
+}
+
+// summaryPipeline groups the records selected by match per target and day
+// and merges them into the summaries collection named into. A summary that
+// already lists batch is left as it is.
+func summaryPipeline(scanType string, kind historyKind, match bson.M, batch primitive.ObjectID, into string) mongo.Pipeline {
+	target := "$" + kind.targetField
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+	}
+	if kind.targetField == "targets" {
+		// ICMP records hold several targets — summarise each one separately.
+		pipeline = append(pipeline, bson.D{{Key: "$unwind", Value: bson.M{
+			"path": target, "preserveNullAndEmptyArrays": true,
+		}}})
+	}
+	counted := bson.M{"$in": bson.A{batch, bson.M{"$ifNull": bson.A{"$batches", bson.A{}}}}}
+	return append(pipeline,
+		bson.D{{Key: "$group", Value: bson.M{
+			"_id": bson.M{
+				"target": target,
+				"day": bson.M{"$dateFromString": bson.M{"dateString": bson.M{
+					"$dateToString": bson.M{"format": "%Y-%m-%d", "date": "$created_at"},
+				}}},
+			},
+			"scan_count": bson.M{"$sum": 1},
+			"completed":  bson.M{"$sum": bson.M{"$cond": bson.A{bson.M{"$eq": bson.A{"$status", "completed"}}, 1, 0}}},
+			"failed":     bson.M{"$sum": bson.M{"$cond": bson.A{bson.M{"$eq": bson.A{"$status", "failed"}}, 1, 0}}},
+			"first_scan": bson.M{"$min": "$created_at"},
+			"last_scan":  bson.M{"$max": "$created_at"},
+		}}},
+		bson.D{{Key: "$project", Value: bson.M{
+			"_id":        0,
+			"scan_type":  bson.M{"$literal": scanType},
+			"target":     bson.M{"$ifNull": bson.A{"$_id.target", ""}},
+			"day":        "$_id.day",
+			"scan_count": 1,
+			"completed":  1,
+			"failed":     1,
+			"first_scan": 1,
+			"last_scan":  1,
+			"batches":    bson.A{batch},
+		}}},
+		bson.D{{Key: "$merge", Value: bson.M{
+			"into": into,
+			"on":   bson.A{"scan_type", "target", "day"},
+			"whenMatched": bson.A{bson.M{"$replaceWith": bson.M{"$cond": bson.A{
+				counted,
+				"$$ROOT",
+				bson.M{"$mergeObjects": bson.A{"$$ROOT", bson.M{
+					"scan_count": bson.M{"$add": bson.A{"$scan_count", "$$new.scan_count"}},
+					"completed":  bson.M{"$add": bson.A{"$completed", "$$new.completed"}},
+					"failed":     bson.M{"$add": bson.A{"$failed", "$$new.failed"}},
+					"first_scan": bson.M{"$min": bson.A{"$first_scan", "$$new.first_scan"}},
+					"last_scan":  bson.M{"$max": bson.A{"$last_scan", "$$new.last_scan"}},
+					"batches":    bson.M{"$concatArrays": bson.A{bson.M{"$ifNull": bson.A{"$batches", bson.A{}}}, bson.A{batch}}},
+				}}},
+			}}}},
+			"whenNotMatched": "insert",
+		}}},
+	)
+}
+
+// GetHistorySummaries returns daily summaries, newest day first, optionally
+// narrowed to one scan type and/or target.
+func (r *Repository) GetHistorySummaries(scanType, target string, limit int) ([]models.HistoryDailySummary, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.M{}
+	if scanType != "" {
+		filter["scan_type"] = scanType
+	}
+	if target != "" {
+		filter["target"] = target
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "day", Value: -1}})
+	if limit > 0 {
+		opts.SetLimit(int64(limit))
+	}
+
+	cursor, err := r.db.SummaryCollection().Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var records []models.HistoryDailySummary
+	if err = cursor.All(ctx, &records); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
@@ -0,0 +1,136 @@
+package rabbitmq
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// fakeCompaction keeps raw records as their batch (zero when unclaimed) and the
+// summary as a count plus the batches it includes, the way summaryPipeline
+// merges them.
+type fakeCompaction struct {
+	records    []primitive.ObjectID
+	counted    map[primitive.ObjectID]bool
+	count      int
+	failRemove bool
+}
+
+func (f *fakeCompaction) pendingBatches() ([]primitive.ObjectID, error) {
+	seen := map[primitive.ObjectID]bool{}
+	var batches []primitive.ObjectID
+	for _, b := range f.records {
+		if !b.IsZero() && !seen[b] {
+			seen[b] = true
+			batches = append(batches, b)
+		}
+	}
+	return batches, nil
+}
+
+func (f *fakeCompaction) claim(batch primitive.ObjectID) (int64, error) {
+	var n int64
+	for i, b := range f.records {
+		if b.IsZero() {
+			f.records[i] = batch
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (f *fakeCompaction) summarize(batch primitive.ObjectID) error {
+	if f.counted[batch] {
+		return nil
+	}
+	f.counted[batch] = true
+	for _, b := range f.records {
+		if b == batch {
+			f.count++
+		}
+	}
+	return nil
+}
+
+func (f *fakeCompaction) remove(batch primitive.ObjectID) (int64, error) {
+	if f.failRemove {
+		return 0, errors.New("delete failed")
+	}
+	var kept []primitive.ObjectID
+	for _, b := range f.records {
+		if b != batch {
+			kept = append(kept, b)
+		}
+	}
+	n := int64(len(f.records) - len(kept))
+	f.records = kept
+	return n, nil
+}
+
+func TestCompactRetriesFailedDelete(t *testing.T) {
+	f := &fakeCompaction{records: make([]primitive.ObjectID, 3), counted: map[primitive.ObjectID]bool{}, failRemove: true}
+	if _, err := compact(f, primitive.NewObjectID()); err == nil {
+		t.Fatal("failed delete not reported")
+	}
+	if f.count != 3 {
+		t.Fatalf("count after first run = %d, want 3", f.count)
+	}
+
+	// Records scanned between the runs are compacted along with the old batch.
+	f.records = append(f.records, primitive.ObjectID{}, primitive.ObjectID{})
+	f.failRemove = false
+	deleted, err := compact(f, primitive.NewObjectID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 5 || len(f.records) != 0 {
+		t.Errorf("deleted %d, %d records left; want 5 and 0", deleted, len(f.records))
+	}
+	if f.count != 5 {
+		t.Errorf("count = %d, want every record counted once (5)", f.count)
+	}
+
+	// Nothing left: a re-run claims no batch.
+	if deleted, err := compact(f, primitive.NewObjectID()); err != nil || deleted != 0 || len(f.counted) != 2 {
+		t.Errorf("re-run deleted %d (%v) with %d batches", deleted, err, len(f.counted))
+	}
+}
+
+func TestSummaryPipeline(t *testing.T) {
+	batch := primitive.NewObjectID()
+	match := bson.M{"scan_type": "icmp", compactionBatchField: batch}
+	pipeline := summaryPipeline("icmp", historyKinds["icmp"], match, batch, "history_summaries")
+
+	if got := pipeline[0][0]; got.Key != "$match" || !reflect.DeepEqual(got.Value, match) {
+		t.Errorf("first stage = %v, want $match on the batch", got)
+	}
+	if got := pipeline[1][0].Key; got != "$unwind" {
+		t.Errorf("icmp targets not unwound: second stage is %s", got)
+	}
+
+	project := pipeline[len(pipeline)-2][0].Value.(bson.M)
+	if !reflect.DeepEqual(project["batches"], bson.A{batch}) {
+		t.Errorf("summary batches = %v, want [%v]", project["batches"], batch)
+	}
+
+	merge := pipeline[len(pipeline)-1][0].Value.(bson.M)
+	replace := merge["whenMatched"].(bson.A)[0].(bson.M)["$replaceWith"].(bson.M)["$cond"].(bson.A)
+	counted := replace[0].(bson.M)["$in"].(bson.A)
+	if counted[0] != batch {
+		t.Errorf("merge checks %v, want batch %v", counted[0], batch)
+	}
+	if replace[1] != "$$ROOT" {
+		t.Errorf("a summary that counts the batch becomes %v, want it unchanged", replace[1])
+	}
+}
+
+func TestCompactHistoryBeforeUnknownType(t *testing.T) {
+	r := &Repository{}
+	if _, err := r.CompactHistoryBefore("nope", time.Now()); err == nil {
+		t.Error("unknown scan type accepted")
+	}
+}
@@ -0,0 +1,480 @@
+package rabbitmq
+
+import (
+	"context"
+	"time"
+
+	"backend/domain/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ── Dashboard statistics ─────────────────────────────────────────────────────
+// Every aggregation takes a models.StatsFilter: Since/Until bound created_at
+// and Subnet restricts hosts by their numeric address (ip_num), so the
+// filters hit the same indexes as the history search.
+
+// statsMatch is the $match of scanType records inside the filter window.
+func (r *Repository) statsMatch(scanType string, f models.StatsFilter) (*mongo.Collection, bson.M, error) {
+	return r.historyMatch(scanType, models.HistoryQuery{Since: f.Since, Until: f.Until})
+}
+
+// statsSubnet returns the address intervals of f.Subnet; ok is false when
+// no subnet filter applies.
+func statsSubnet(f models.StatsFilter) ([]models.IPv4Interval, bool) {
+	if f.Subnet == "" {
+		return nil, false
+	}
+	return models.ParseIPv4Intervals(f.Subnet)
+}
+
+// statsBucket truncates the date at path to the start of its hour or UTC day.
+func statsBucket(bucket, path string) bson.M {
+	parts := bson.M{
+		"year":  bson.M{"$year": path},
+		"month": bson.M{"$month": path},
+		"day":   bson.M{"$dayOfMonth": path},
+	}
+	if bucket == models.StatsBucketHour {
+		parts["hour"] = bson.M{"$hour": path}
+	}
+	return bson.M{"$dateFromParts": parts}
+}
+
+// ipNumExpr computes the numeric IPv4 address of the string at path, or -1
+// for anything that is not a dotted quad. Change events only carry the
+// target as text.
+func ipNumExpr(path string) bson.M {
+	octet := func(i int) bson.M {
+		return bson.M{"$convert": bson.M{
+			"input": bson.M{"$arrayElemAt": bson.A{"$$o", i}}, "to": "long", "onError": nil, "onNull": nil,
+		}}
+	}
+	return bson.M{"$let": bson.M{
+		"vars": bson.M{"o": bson.M{"$split": bson.A{bson.M{"$ifNull": bson.A{path, ""}}, "."}}},
+		"in": bson.M{"$cond": bson.A{
+			bson.M{"$eq": bson.A{bson.M{"$size": "$$o"}, 4}},
+			bson.M{"$ifNull": bson.A{bson.M{"$add": bson.A{
+				bson.M{"$multiply": bson.A{octet(0), 1 << 24}},
+				bson.M{"$multiply": bson.A{octet(1), 1 << 16}},
+				bson.M{"$multiply": bson.A{octet(2), 1 << 8}},
+				octet(3),
+			}}, -1}},
+			-1,
+		}},
+	}}
+}
+
+func (r *Repository) aggregateStats(coll *mongo.Collection, pipeline mongo.Pipeline, out interface{}) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	cursor, err := coll.Aggregate(ctx, pipeline, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return err
+	}
+	return cursor.All(ctx, out)
+}
+
+// openPortStages flattens nmap TCP/UDP records into one {host, port,
+// protocol, service} document per open port.
+func (r *Repository) openPortStages(f models.StatsFilter) (*mongo.Collection, mongo.Pipeline, error) {
+	coll, match, err := r.statsMatch("nmap_tcp_udp", f)
+	if err != nil {
+		return nil, nil, err
+	}
+	match["host"] = bson.M{"$nin": bson.A{"", nil}}
+	if subnet, ok := statsSubnet(f); ok {
+		addFilter(match, ipWithin("ip_num", subnet))
+	}
+	at := func(array string) bson.M {
+		return bson.M{"$arrayElemAt": bson.A{"$pi." + array, "$idx"}}
+	}
+	return coll, mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$unwind", Value: "$port_info"}},
+		{{Key: "$project", Value: bson.M{
+			"host": 1,
+			"pi":   "$port_info",
+			"idx":  bson.M{"$range": bson.A{0, bson.M{"$size": bson.M{"$ifNull": bson.A{"$port_info.allports", bson.A{}}}}}},
+		}}},
+		{{Key: "$unwind", Value: "$idx"}},
+		{{Key: "$project", Value: bson.M{
+			"host":     1,
+			"port":     at("allports"),
+			"protocol": bson.M{"$ifNull": bson.A{at("protocols"), ""}},
+			"state":    at("state"),
+			"service":  bson.M{"$ifNull": bson.A{at("servicename"), ""}},
+		}}},
+		{{Key: "$match", Value: bson.M{"state": "open"}}},
+	}, nil
+}
+
+// StatsOpenPorts returns the open ports by number of distinct hosts.
+func (r *Repository) StatsOpenPorts(f models.StatsFilter) ([]models.PortStat, error) {
+	coll, pipeline, err := r.openPortStages(f)
+	if err != nil {
+		return nil, err
+	}
+	pipeline = append(pipeline,
+		bson.D{{Key: "$group", Value: bson.M{
+			"_id":   bson.M{"port": "$port", "protocol": "$protocol"},
+			"hosts": bson.M{"$addToSet": "$host"},
+		}}},
+		bson.D{{Key: "$project", Value: bson.M{
+			"_id": 0, "port": "$_id.port", "protocol": "$_id.protocol", "hosts": bson.M{"$size": "$hosts"},
+		}}},
+		bson.D{{Key: "$sort", Value: bson.D{{Key: "hosts", Value: -1}, {Key: "port", Value: 1}}}},
+		bson.D{{Key: "$limit", Value: f.Limit}},
+	)
+
+	stats := []models.PortStat{}
+	if err := r.aggregateStats(coll, pipeline, &stats); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// StatsServices returns the detected services on open ports by number of
+// distinct hosts.
+func (r *Repository) StatsServices(f models.StatsFilter) ([]models.ServiceStat, error) {
+	coll, pipeline, err := r.openPortStages(f)
+	if err != nil {
+		return nil, err
+	}
+	pipeline = append(pipeline,
+		bson.D{{Key: "$match", Value: bson.M{"service": bson.M{"$ne": ""}}}},
+		bson.D{{Key: "$group", Value: bson.M{
+			"_id":   "$service",
+			"hosts": bson.M{"$addToSet": "$host"},
+			"ports": bson.M{"$addToSet": "$port"},
+		}}},
+		bson.D{{Key: "$project", Value: bson.M{
+			"_id": 0, "service": "$_id", "hosts": bson.M{"$size": "$hosts"}, "ports": bson.M{"$size": "$ports"},
+		}}},
+		bson.D{{Key: "$sort", Value: bson.D{{Key: "hosts", Value: -1}, {Key: "service", Value: 1}}}},
+		bson.D{{Key: "$limit", Value: f.Limit}},
+	)
+
+	stats := []models.ServiceStat{}
+	if err := r.aggregateStats(coll, pipeline, &stats); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// breakdownStages counts documents per name, labelling empty names
+// "Unknown", largest first.
+func breakdownStages(name string, limit int) mongo.Pipeline {
+	return mongo.Pipeline{
+		{{Key: "$group", Value: bson.M{
+			"_id": bson.M{"$cond": bson.A{
+				bson.M{"$gt": bson.A{name, ""}}, name, "Unknown",
+			}},
+			"count": bson.M{"$sum": 1},
+		}}},
+		{{Key: "$project", Value: bson.M{"_id": 0, "name": "$_id", "count": 1}}},
+		{{Key: "$sort", Value: bson.D{{Key: "count", Value: -1}, {Key: "name", Value: 1}}}},
+		{{Key: "$limit", Value: limit}},
+	}
+}
+
+// StatsVendors returns the vendors of the distinct MAC addresses seen
+// online by ARP scans. Each device counts once, under its latest vendor.
+func (r *Repository) StatsVendors(f models.StatsFilter) ([]models.BreakdownStat, error) {
+	coll, match, err := r.statsMatch("arp", f)
+	if err != nil {
+		return nil, err
+	}
+	device := bson.M{"online_devices.mac": bson.M{"$nin": bson.A{"", nil}}}
+	if subnet, ok := statsSubnet(f); ok {
+		addFilter(match, ipWithin("online_devices.ip_num", subnet))
+		addFilter(device, ipWithin("online_devices.ip_num", subnet))
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$sort", Value: bson.M{"created_at": -1}}},
+		{{Key: "$unwind", Value: "$online_devices"}},
+		{{Key: "$match", Value: device}},
+		{{Key: "$group", Value: bson.M{
+			"_id":    bson.M{"$toLower": "$online_devices.mac"},
+			"vendor": bson.M{"$first": "$online_devices.vendor"},
+		}}},
+	}
+	pipeline = append(pipeline, breakdownStages("$vendor", f.Limit)...)
+
+	stats := []models.BreakdownStat{}
+	if err := r.aggregateStats(coll, pipeline, &stats); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// StatsOSFamilies returns the OS families of the distinct hosts with an OS
+// detection result. Each host counts once, under its latest best match.
+func (r *Repository) StatsOSFamilies(f models.StatsFilter) ([]models.BreakdownStat, error) {
+	coll, match, err := r.statsMatch("nmap_os_detection", f)
+	if err != nil {
+		return nil, err
+	}
+	match["host"] = bson.M{"$nin": bson.A{"", nil}}
+	match["status"] = bson.M{"$ne": "failed"}
+	if subnet, ok := statsSubnet(f); ok {
+		addFilter(match, ipWithin("ip_num", subnet))
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$sort", Value: bson.D{{Key: "created_at", Value: -1}, {Key: "accuracy", Value: -1}}}},
+		{{Key: "$group", Value: bson.M{"_id": "$host", "family": bson.M{"$first": "$family"}}}},
+	}
+	pipeline = append(pipeline, breakdownStages("$family", f.Limit)...)
+
+	stats := []models.BreakdownStat{}
+	if err := r.aggregateStats(coll, pipeline, &stats); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// StatsHosts returns the hosts seen up and down per time bucket, from ARP
+// devices, ICMP results and Nmap host discovery.
+func (r *Repository) StatsHosts(f models.StatsFilter) ([]models.HostsPoint, error) {
+	arpColl, arpMatch, err := r.statsMatch("arp", f)
+	if err != nil {
+		return nil, err
+	}
+	icmpColl, icmpMatch, err := r.statsMatch("icmp", f)
+	if err != nil {
+		return nil, err
+	}
+	hdColl, hdMatch, err := r.statsMatch("nmap_host_discovery", f)
+	if err != nil {
+		return nil, err
+	}
+	hdMatch["host"] = bson.M{"$nin": bson.A{"", nil}}
+	hdMatch["status"] = bson.M{"$in": bson.A{"up", "down"}}
+
+	// Cheap per-record prefilters; the exact subnet match is per host below.
+	var subnet bson.M
+	if intervals, ok := statsSubnet(f); ok {
+		subnet = ipWithin("ip_num", intervals)
+		addFilter(arpMatch, bson.M{"$or": bson.A{
+			ipWithin("online_devices.ip_num", intervals), ipWithin("offline_devices.ip_num", intervals),
+		}})
+		addFilter(icmpMatch, ipWithin("results.ip_num", intervals))
+		addFilter(hdMatch, ipWithin("ip_num", intervals))
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: arpMatch}},
+		{{Key: "$project", Value: bson.M{
+			"created_at": 1,
+			"devices": bson.M{"$concatArrays": bson.A{
+				bson.M{"$ifNull": bson.A{"$online_devices", bson.A{}}},
+				bson.M{"$ifNull": bson.A{"$offline_devices", bson.A{}}},
+			}},
+		}}},
+		{{Key: "$unwind", Value: "$devices"}},
+		{{Key: "$project", Value: bson.M{
+			"_id":    0,
+			"ip":     "$devices.ip",
+			"ip_num": "$devices.ip_num",
+			"up":     bson.M{"$eq": bson.A{"$devices.status", "online"}},
+			"t":      "$created_at",
+		}}},
+		{{Key: "$unionWith", Value: bson.M{
+			"coll": icmpColl.Name(),
+			"pipeline": bson.A{
+				bson.M{"$match": icmpMatch},
+				bson.M{"$unwind": "$results"},
+				bson.M{"$project": bson.M{
+					"_id":    0,
+					"ip":     bson.M{"$cond": bson.A{bson.M{"$gt": bson.A{"$results.address", ""}}, "$results.address", "$results.target"}},
+					"ip_num": "$results.ip_num",
+					"up":     bson.M{"$gt": bson.A{"$results.packetsreceived", 0}},
+					"t":      "$created_at",
+				}},
+			},
+		}}},
+		{{Key: "$unionWith", Value: bson.M{
+			"coll": hdColl.Name(),
+			"pipeline": bson.A{
+				bson.M{"$match": hdMatch},
+				bson.M{"$project": bson.M{
+					"_id":    0,
+					"ip":     "$host",
+					"ip_num": "$ip_num",
+					"up":     bson.M{"$eq": bson.A{"$status", "up"}},
+					"t":      "$created_at",
+				}},
+			},
+		}}},
+	}
+	if subnet != nil {
+		pipeline = append(pipeline, bson.D{{Key: "$match", Value: subnet}})
+	}
+	pipeline = append(pipeline,
+		bson.D{{Key: "$group", Value: bson.M{
+			"_id": bson.M{"t": statsBucket(f.Bucket, "$t"), "ip": "$ip"},
+			"up":  bson.M{"$max": "$up"},
+		}}},
+		bson.D{{Key: "$group", Value: bson.M{
+			"_id":  "$_id.t",
+			"up":   bson.M{"$sum": bson.M{"$cond": bson.A{"$up", 1, 0}}},
+			"down": bson.M{"$sum": bson.M{"$cond": bson.A{"$up", 0, 1}}},
+		}}},
+		bson.D{{Key: "$project", Value: bson.M{"_id": 0, "time": "$_id", "up": 1, "down": 1}}},
+		bson.D{{Key: "$sort", Value: bson.M{"time": 1}}},
+	)
+
+	points := []models.HostsPoint{}
+	if err := r.aggregateStats(arpColl, pipeline, &points); err != nil {
+		return nil, err
+	}
+	return points, nil
+}
+
+// StatsEvents returns the number of change events per severity and UTC day.
+func (r *Repository) StatsEvents(f models.StatsFilter) ([]models.SeverityPoint, error) {
+	match := bson.M{"scan_type": "change_event"}
+	created := bson.M{}
+	if !f.Since.IsZero() {
+		created["$gte"] = f.Since
+	}
+	if !f.Until.IsZero() {
+		created["$lt"] = f.Until
+	}
+	if len(created) > 0 {
+		match["created_at"] = created
+	}
+	if subnet, ok := statsSubnet(f); ok {
+		ip := ipNumExpr("$target")
+		within := bson.A{}
+		for _, iv := range subnet {
+			within = append(within, bson.M{"$and": bson.A{
+				bson.M{"$gte": bson.A{ip, iv.Lo}},
+				bson.M{"$lte": bson.A{ip, iv.Hi}},
+			}})
+		}
+		match["$expr"] = bson.M{"$or": within}
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$group", Value: bson.M{
+			"_id": bson.M{
+				"day": statsBucket(models.StatsBucketDay, "$created_at"),
+				"severity": bson.M{"$cond": bson.A{
+					bson.M{"$gt": bson.A{"$severity", ""}}, "$severity", "UNKNOWN",
+				}},
+			},
+			"count": bson.M{"$sum": 1},
+		}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":        "$_id.day",
+			"severities": bson.M{"$push": bson.M{"k": "$_id.severity", "v": "$count"}},
+			"total":      bson.M{"$sum": "$count"},
+		}}},
+		{{Key: "$project", Value: bson.M{
+			"_id": 0, "day": "$_id", "severities": bson.M{"$arrayToObject": "$severities"}, "total": 1,
+		}}},
+		{{Key: "$sort", Value: bson.M{"day": 1}}},
+	}
+
+	points := []models.SeverityPoint{}
+	if err := r.aggregateStats(r.db.ChangesCollection(), pipeline, &points); err != nil {
+		return nil, err
+	}
+	return points, nil
+}
+
+// StatsScannerVolume returns, per scanner service, the distinct tasks,
+// result records and failed records in the window, with a per-bucket
+// series. A subnet matches scans whose target overlaps it or that found a
+// host inside it.
+func (r *Repository) StatsScannerVolume(f models.StatsFilter) ([]models.ScannerVolume, error) {
+	numPaths := map[string]string{
+		"arp":  "online_devices.ip_num",
+		"icmp": "results.ip_num",
+		"tcp":  "ip_num",
+	}
+
+	volumes := make([]models.ScannerVolume, 0, len(models.StatsScanners))
+	for _, scanner := range models.StatsScanners {
+		coll, match, err := r.statsMatch(scanner.ScanTypes[0], f)
+		if err != nil {
+			return nil, err
+		}
+		if len(scanner.ScanTypes) > 1 {
+			match["scan_type"] = bson.M{"$in": scanner.ScanTypes}
+		}
+		if f.Subnet != "" {
+			numPath, ok := numPaths[scanner.Name]
+			if !ok {
+				numPath = "ip_num"
+			}
+			for k, v := range ipTargetFilter(f.Subnet, historyKinds[scanner.ScanTypes[0]].targetField, numPath) {
+				match[k] = v
+			}
+		}
+
+		perTask := func(key interface{}) bson.D {
+			return bson.D{{Key: "$group", Value: bson.M{
+				"_id":     key,
+				"records": bson.M{"$sum": 1},
+				"failed":  bson.M{"$sum": bson.M{"$cond": bson.A{bson.M{"$eq": bson.A{"$status", "failed"}}, 1, 0}}},
+			}}}
+		}
+		totals := bson.M{
+			"scans":   bson.M{"$sum": 1},
+			"records": bson.M{"$sum": "$records"},
+			"failed":  bson.M{"$sum": "$failed"},
+		}
+		seriesGroup := bson.M{"_id": "$_id.t"}
+		totalGroup := bson.M{"_id": nil}
+		for k, v := range totals {
+			seriesGroup[k] = v
+			totalGroup[k] = v
+		}
+
+		pipeline := mongo.Pipeline{
+			{{Key: "$match", Value: match}},
+			{{Key: "$facet", Value: bson.M{
+				"series": bson.A{
+					perTask(bson.M{"t": statsBucket(f.Bucket, "$created_at"), "task": "$task_id"}),
+					bson.M{"$group": seriesGroup},
+					bson.M{"$project": bson.M{"_id": 0, "time": "$_id", "scans": 1, "records": 1, "failed": 1}},
+					bson.M{"$sort": bson.M{"time": 1}},
+				},
+				"total": bson.A{
+					perTask("$task_id"),
+					bson.M{"$group": totalGroup},
+				},
+			}}},
+		}
+
+		var out []struct {
+			Series []models.VolumePoint `bson:"series"`
+			Total  []models.VolumePoint `bson:"total"`
+		}
+		if err := r.aggregateStats(coll, pipeline, &out); err != nil {
+			return nil, err
+		}
+
+		volume := models.ScannerVolume{Scanner: scanner.Name, Series: []models.VolumePoint{}}
+		if len(out) > 0 {
+			volume.Series = append(volume.Series, out[0].Series...)
+			if len(out[0].Total) > 0 {
+				volume.Scans = out[0].Total[0].Scans
+				volume.Records = out[0].Total[0].Records
+				volume.Failed = out[0].Total[0].Failed
+			}
+		}
+		volumes = append(volumes, volume)
+	}
+	return volumes, nil
+}
@@ -0,0 +1,53 @@
+package rabbitmq
+
+import (
+	"context"
+	"time"
+
+	"backend/domain/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Ping checks that the primary answers within a few seconds.
+func (r *Repository) Ping() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	return r.db.Client.Ping(ctx, nil)
+}
+
+// LastScanResult returns the creation time of the newest record of any of
+// scanTypes that is not marked failed; the zero time if there is none.
+func (r *Repository) LastScanResult(scanTypes []string) (time.Time, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var last time.Time
+	for _, scanType := range scanTypes {
+		coll, filter, err := r.historyMatch(scanType, models.HistoryQuery{})
+		if err != nil {
+			return time.Time{}, err
+		}
+		filter["status"] = bson.M{"$ne": "failed"}
+
+		var doc struct {
+			CreatedAt time.Time `bson:"created_at"`
+		}
+		opts := options.FindOne().
+			SetSort(bson.D{{Key: "created_at", Value: -1}}).
+			SetProjection(bson.M{"created_at": 1})
+		err = coll.FindOne(ctx, filter, opts).Decode(&doc)
+		if err == mongo.ErrNoDocuments {
+			continue
+		}
+		if err != nil {
+			return time.Time{}, err
+		}
+		if doc.CreatedAt.After(last) {
+			last = doc.CreatedAt
+		}
+	}
+	return last, nil
+}
@@ -0,0 +1,152 @@
+package rabbitmq
+
+import (
+	"context"
+	"regexp"
+	"time"
+
+	"backend/domain/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// taskTargetParams are the request parameters naming what a scan targets.
+var taskTargetParams = []string{"params.ip", "params.ip_range", "params.host", "params.targets", "params.target"}
+
+// EnsureTaskIndexes creates the unique task id index and the indexes
+// behind task queries and expiry.
+func (r *Repository) EnsureTaskIndexes() error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	_, err := r.db.TasksCollection().Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "task_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "service", Value: 1}, {Key: "created_at", Value: -1}}},
+	})
+	return err
+}
+
+// CreateTask stores a new task; CreatedAt and UpdatedAt are set here.
+func (r *Repository) CreateTask(task *models.ScanTask) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	task.CreatedAt = time.Now()
+	task.UpdatedAt = task.CreatedAt
+	_, err := r.db.TasksCollection().InsertOne(ctx, task)
+	return err
+}
+
+// GetTask returns the task with taskID, or mongo.ErrNoDocuments.
+func (r *Repository) GetTask(taskID string) (*models.ScanTask, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var task models.ScanTask
+	if err := r.db.TasksCollection().FindOne(ctx, bson.M{"task_id": taskID}).Decode(&task); err != nil {
+		return nil, err
+	}
+	return &task, nil
+}
+
+// FinishTask records the outcome of taskID. A result arriving after the
+// task timed out still completes it.
+func (r *Repository) FinishTask(taskID string, outcome models.TaskOutcome) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	set := bson.M{
+		"status":     outcome.Status,
+		"error":      outcome.Error,
+		"updated_at": now,
+	}
+	if outcome.Status != models.TaskPending {
+		set["completed_at"] = now
+	}
+	if outcome.ScanAgent != nil {
+		set["scan_agent"] = outcome.ScanAgent
+	}
+	if len(outcome.Attempts) > 0 {
+		set["attempts"] = outcome.Attempts
+	}
+	_, err := r.db.TasksCollection().UpdateOne(ctx, bson.M{"task_id": taskID}, bson.M{"$set": set})
+	return err
+}
+
+// ExpireTasks moves tasks still pending since before cutoff to timed_out.
+func (r *Repository) ExpireTasks(cutoff time.Time) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	result, err := r.db.TasksCollection().UpdateMany(ctx,
+		bson.M{"status": models.TaskPending, "created_at": bson.M{"$lt": cutoff}},
+		bson.M{"$set": bson.M{
+			"status":       models.TaskTimedOut,
+			"error":        "no result received",
+			"updated_at":   now,
+			"completed_at": now,
+		}},
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.ModifiedCount, nil
+}
+
+// FindTasks returns the newest tasks matching q and how many match in all.
+func (r *Repository) FindTasks(q models.TaskQuery) ([]models.ScanTask, int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.M{}
+	if q.Status != "" {
+		filter["status"] = q.Status
+	}
+	if q.Service != "" {
+		filter["service"] = q.Service
+	}
+	created := bson.M{}
+	if !q.Since.IsZero() {
+		created["$gte"] = q.Since
+	}
+	if !q.Until.IsZero() {
+		created["$lt"] = q.Until
+	}
+	if len(created) > 0 {
+		filter["created_at"] = created
+	}
+	if q.Target != "" {
+		prefix := bson.M{"$regex": "^" + regexp.QuoteMeta(q.Target)}
+		or := bson.A{}
+		for _, field := range taskTargetParams {
+			or = append(or, bson.M{field: prefix})
+		}
+		filter["$or"] = or
+	}
+
+	total, err := r.db.TasksCollection().CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}})
+	if q.Limit > 0 {
+		opts.SetLimit(int64(q.Limit))
+	}
+	cursor, err := r.db.TasksCollection().Find(ctx, filter, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	tasks := []models.ScanTask{}
+	if err := cursor.All(ctx, &tasks); err != nil {
+		return nil, 0, err
+	}
+	return tasks, total, nil
+}
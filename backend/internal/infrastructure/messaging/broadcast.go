@@ -0,0 +1,52 @@
+package rabbitmq
+
+import (
+	"backend/domain/models"
+	"encoding/json"
+	"fmt"
+
+	"github.com/streadway/amqp"
+)
+
+// BroadcastExchange is the fanout exchange every backend instance both
+// publishes its events to and consumes from, so that WebSocket clients
+// hear of changes, task updates and results whichever instance they are
+// connected to.
+const BroadcastExchange = "backend_broadcast"
+
+// Broadcast sends event to every backend instance, this one included.
+// Events are transient: instances that are down miss them.
+func (p *RPCScannerPublisher) Broadcast(event models.BroadcastEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return p.channel.Publish(BroadcastExchange, "", false, false, amqp.Publishing{
+		ContentType: "application/json",
+		Body:        body,
+	})
+}
+
+// ConsumeBroadcasts subscribes to BroadcastExchange through an exclusive
+// queue, so this instance receives every event.
+func (p *RPCScannerPublisher) ConsumeBroadcasts() (<-chan amqp.Delivery, error) {
+	ch, err := p.conn.Channel()
+	if err != nil {
+		return nil, fmt.Errorf("ConsumeBroadcasts: open channel: %w", err)
+	}
+	q, err := ch.QueueDeclare("", false, true, true, false, nil)
+	if err != nil {
+		ch.Close()
+		return nil, fmt.Errorf("ConsumeBroadcasts: declare queue: %w", err)
+	}
+	if err := ch.QueueBind(q.Name, "", BroadcastExchange, false, nil); err != nil {
+		ch.Close()
+		return nil, fmt.Errorf("ConsumeBroadcasts: bind queue: %w", err)
+	}
+	msgs, err := ch.Consume(q.Name, "", true, true, false, false, nil)
+	if err != nil {
+		ch.Close()
+		return nil, fmt.Errorf("ConsumeBroadcasts: consume: %w", err)
+	}
+	return msgs, nil
+}
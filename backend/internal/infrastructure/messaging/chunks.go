@@ -0,0 +1,86 @@
+package rabbitmq
+
+import (
+	"backend/domain/models"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+
+	"github.com/streadway/amqp"
+)
+
+// headerResultChunk marks a reply as one chunk of a streamed result; its
+// value is the chunk's sequence number.
+const headerResultChunk = "x-result-chunk"
+
+// ChunkStore stores the items of each chunk of a streamed result as it
+// arrives, on the history record of its task, so a large result is never
+// held or written as one document.
+type ChunkStore interface {
+	AppendResultChunk(chunk models.ResultChunk) error
+	DeleteResultChunks(taskID string) error
+}
+
+// SetChunkStore enables streamed results. Without a store chunks are only
+// broadcast and the stored result has only what its final reply lists.
+func (p *RPCScannerPublisher) SetChunkStore(store ChunkStore) {
+	p.chunks = store
+}
+
+// isChunk reports whether msg is one chunk of a streamed result.
+func isChunk(msg amqp.Delivery) bool {
+	_, ok := msg.Headers[headerResultChunk]
+	return ok
+}
+
+// handleChunk stores one chunk of a streamed result and broadcasts it,
+// with the hosts it adds to the inventory, to the clients subscribed to
+// its task. A chunk that could not be stored is returned as an error and
+// delivered again; storing is idempotent per sequence number.
+func (p *RPCScannerPublisher) handleChunk(ctx context.Context, msg amqp.Delivery) error {
+	var chunk models.ResultChunk
+	if err := json.Unmarshal(msg.Body, &chunk); err != nil {
+		return fmt.Errorf("%w: result chunk: %v", errMalformedReply, err)
+	}
+	if chunk.TaskID == "" {
+		return fmt.Errorf("%w: result chunk without task_id", errMalformedReply)
+	}
+	if p.chunks != nil {
+		if err := p.chunks.AppendResultChunk(chunk); err != nil {
+			return fmt.Errorf("store chunk %d of task %s: %w", chunk.Seq, chunk.TaskID, err)
+		}
+	}
+	if err := p.Broadcast(models.BroadcastEvent{Type: models.BroadcastChunk, Chunk: &chunk}); err != nil {
+		slog.ErrorContext(ctx, "failed to broadcast result chunk", "task_id", chunk.TaskID, "error", err)
+	}
+	found := &models.Response{TaskID: chunk.TaskID, Result: models.ARPResponse{TaskID: chunk.TaskID, Devices: chunk.Devices}}
+	if update := inventoryUpdate(found); update != nil {
+		if err := p.Broadcast(models.BroadcastEvent{Type: models.BroadcastInventory, Inventory: update}); err != nil {
+			slog.ErrorContext(ctx, "failed to broadcast inventory update", "task_id", chunk.TaskID, "error", err)
+		}
+	}
+	return nil
+}
+
+// dropChunks discards what the chunks of a failed attempt stored, before
+// its retry streams the result again.
+func (p *RPCScannerPublisher) dropChunks(ctx context.Context, taskID string) {
+	if p.chunks == nil || taskID == "" {
+		return
+	}
+	if err := p.chunks.DeleteResultChunks(taskID); err != nil {
+		slog.ErrorContext(ctx, "failed to drop result chunks", "task_id", taskID, "error", err)
+	}
+}
+
+// hasField reports whether the JSON object body has key. The final reply
+// of a streamed result lists no items, so its type is told by its fields.
+func hasField(body []byte, key string) bool {
+	var fields map[string]json.RawMessage
+	if json.Unmarshal(body, &fields) != nil {
+		return false
+	}
+	_, ok := fields[key]
+	return ok
+}
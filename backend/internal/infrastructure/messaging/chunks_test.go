@@ -0,0 +1,96 @@
+package rabbitmq
+
+import (
+	"errors"
+	"testing"
+
+	"backend/domain/models"
+
+	"github.com/streadway/amqp"
+)
+
+func TestParseStreamedFinalReply(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string // %T of the result
+	}{
+		{
+			name: "arp",
+			body: `{"task_id": "t", "status": "completed", "devices": null, "online_devices": null, "offline_devices": null, "total_count": 65534, "online_count": 40, "offline_count": 65494, "chunks": 3}`,
+			want: "models.ARPResponse",
+		},
+		{
+			name: "icmp",
+			body: `{"task_id": "t", "status": "completed", "results": null, "chunks": 2}`,
+			want: "models.ICMPResponse",
+		},
+		{
+			name: "arp not streamed",
+			body: `{"task_id": "t", "status": "completed", "devices": [{"ip": "10.0.0.1", "status": "online"}], "online_devices": [{"ip": "10.0.0.1", "status": "online"}], "total_count": 1, "online_count": 1}`,
+			want: "models.ARPResponse",
+		},
+	}
+	p := &RPCScannerPublisher{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response, err := p.parseResponse([]byte(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got string
+			switch response.Result.(type) {
+			case models.ARPResponse:
+				got = "models.ARPResponse"
+			case models.ICMPResponse:
+				got = "models.ICMPResponse"
+			default:
+				got = "other"
+			}
+			if got != tt.want {
+				t.Errorf("parsed as %T, want %s", response.Result, tt.want)
+			}
+		})
+	}
+}
+
+func TestHasField(t *testing.T) {
+	tests := []struct {
+		body string
+		key  string
+		want bool
+	}{
+		{`{"results": null}`, "results", true},
+		{`{"online_count": 0}`, "results", false},
+		{`[1, 2]`, "results", false},
+		{`not json`, "results", false},
+	}
+	for _, tt := range tests {
+		if got := hasField([]byte(tt.body), tt.key); got != tt.want {
+			t.Errorf("hasField(%s, %q) = %v, want %v", tt.body, tt.key, got, tt.want)
+		}
+	}
+}
+
+// failingChunks is a ChunkStore that cannot store.
+type failingChunks struct{ err error }
+
+func (f failingChunks) AppendResultChunk(models.ResultChunk) error { return f.err }
+func (f failingChunks) DeleteResultChunks(string) error            { return f.err }
+
+func TestHandleChunkErrors(t *testing.T) {
+	storeErr := errors.New("mongo unreachable")
+	p := &RPCScannerPublisher{}
+	p.SetChunkStore(failingChunks{storeErr})
+	headers := amqp.Table{headerResultChunk: int32(1)}
+
+	err := p.handleReply(amqp.Delivery{Headers: headers, Body: []byte(`{"task_id": "t", "seq": 1, "devices": [{"ip": "10.0.0.1"}]}`)})
+	if !errors.Is(err, storeErr) || errors.Is(err, errMalformedReply) {
+		t.Errorf("store failure: err = %v, want it requeued", err)
+	}
+	for _, body := range []string{`not json`, `{"seq": 1}`} {
+		if err := p.handleReply(amqp.Delivery{Headers: headers, Body: []byte(body)}); !errors.Is(err, errMalformedReply) {
+			t.Errorf("chunk %s: err = %v, want it dead-lettered", body, err)
+		}
+	}
+}
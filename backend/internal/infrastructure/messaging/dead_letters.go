@@ -0,0 +1,169 @@
+package rabbitmq
+
+import (
+	"backend/domain/models"
+	"backend/internal/infrastructure/telemetry"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/streadway/amqp"
+)
+
+// ErrDeadLetterNotFound is returned when no message on DeadLetterQueue has
+// the requested id.
+var ErrDeadLetterNotFound = errors.New("dead letter not found")
+
+// DeadLetters lists up to limit messages on DeadLetterQueue, oldest first,
+// without removing them: they are fetched unacknowledged on a channel of
+// their own and go back to the queue when it closes.
+func (p *RPCScannerPublisher) DeadLetters(limit int) ([]models.DeadLetter, error) {
+	ch, err := p.deadLetterChannel()
+	if err != nil {
+		return nil, err
+	}
+	defer ch.Close()
+
+	letters := []models.DeadLetter{}
+	for len(letters) < limit {
+		msg, ok, err := ch.Get(DeadLetterQueue, false)
+		if err != nil {
+			return nil, fmt.Errorf("DeadLetters: %w", err)
+		}
+		if !ok {
+			break
+		}
+		letters = append(letters, deadLetterFrom(msg))
+	}
+	return letters, nil
+}
+
+// DiscardDeadLetter removes the message with the given id from
+// DeadLetterQueue.
+func (p *RPCScannerPublisher) DiscardDeadLetter(id string) (models.DeadLetter, error) {
+	var letter models.DeadLetter
+	err := p.takeDeadLetter(id, func(msg amqp.Delivery) error {
+		letter = deadLetterFrom(msg)
+		return nil
+	})
+	return letter, err
+}
+
+// ReplayDeadLetter takes the message with the given id off DeadLetterQueue
+// and publishes it again to the scanner it was meant for, as a new RPC
+// under its original task id. The result is stored like that of any scan
+// and returned. A replay that fails is parked again with the new reason.
+// Plug-in results come back as generic responses.
+func (p *RPCScannerPublisher) ReplayDeadLetter(ctx context.Context, id string) (*models.Response, error) {
+	var msg amqp.Delivery
+	err := p.takeDeadLetter(id, func(m amqp.Delivery) error {
+		msg = m
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	letter := deadLetterFrom(msg)
+
+	// Site queues are named "<service>.<site>".
+	service, site, _ := strings.Cut(letter.Queue, ".")
+	ctx = telemetry.Extract(ctx, msg.Headers)
+	if site != "" {
+		ctx = WithAgent(ctx, site)
+	}
+	slog.InfoContext(ctx, "replaying dead letter", "id", letter.ID, "queue", letter.Queue, "reason", letter.Reason)
+
+	response, err := p.publishRPC(ctx, service, json.RawMessage(msg.Body))
+	if err != nil {
+		reason := "replay failed: " + err.Error()
+		if perr := p.deadLetter(letter.Queue, msg.Body, msg.Headers, reason, letter.Attempts); perr != nil {
+			slog.ErrorContext(ctx, "failed to park dead letter again", "id", letter.ID, "error", perr)
+		}
+		return nil, err
+	}
+	return response, nil
+}
+
+// takeDeadLetter finds the message with the given id, passes it to fn and
+// acknowledges it if fn succeeds. Messages passed over are requeued when
+// the channel closes.
+func (p *RPCScannerPublisher) takeDeadLetter(id string, fn func(amqp.Delivery) error) error {
+	ch, err := p.deadLetterChannel()
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+
+	for {
+		msg, ok, err := ch.Get(DeadLetterQueue, false)
+		if err != nil {
+			return fmt.Errorf("takeDeadLetter: %w", err)
+		}
+		if !ok {
+			return fmt.Errorf("%w: %s", ErrDeadLetterNotFound, id)
+		}
+		if deadLetterID(msg) != id {
+			continue
+		}
+		if err := fn(msg); err != nil {
+			return err
+		}
+		return msg.Ack(false)
+	}
+}
+
+func (p *RPCScannerPublisher) deadLetterChannel() (*amqp.Channel, error) {
+	ch, err := p.conn.Channel()
+	if err != nil {
+		return nil, fmt.Errorf("dead letters: open channel: %w", err)
+	}
+	if _, err := ch.QueueDeclare(DeadLetterQueue, true, false, false, false, nil); err != nil {
+		ch.Close()
+		return nil, fmt.Errorf("dead letters: declare %q: %w", DeadLetterQueue, err)
+	}
+	return ch, nil
+}
+
+// deadLetterID is the message id, or for messages parked without one a
+// digest of their body.
+func deadLetterID(msg amqp.Delivery) string {
+	if msg.MessageId != "" {
+		return msg.MessageId
+	}
+	return fmt.Sprintf("%x", sha256.Sum256(msg.Body))[:32]
+}
+
+func deadLetterFrom(msg amqp.Delivery) models.DeadLetter {
+	letter := models.DeadLetter{
+		ID:             deadLetterID(msg),
+		DeadLetteredAt: msg.Timestamp,
+		Body:           msg.Body,
+	}
+	letter.Queue, _ = msg.Headers[headerOriginalQueue].(string)
+	letter.Reason, _ = msg.Headers[headerDeadLetterReason].(string)
+	letter.TaskID, _ = msg.Headers[telemetry.TaskIDHeader].(string)
+	switch n := msg.Headers[headerRetryAttempt].(type) {
+	case int32:
+		letter.Attempts = int(n)
+	case int64:
+		letter.Attempts = int(n)
+	}
+
+	if !json.Valid(msg.Body) {
+		letter.Body, _ = json.Marshal(string(msg.Body))
+		return letter
+	}
+	if letter.TaskID == "" {
+		var body struct {
+			TaskID string `json:"task_id"`
+		}
+		if json.Unmarshal(msg.Body, &body) == nil {
+			letter.TaskID = body.TaskID
+		}
+	}
+	return letter
+}
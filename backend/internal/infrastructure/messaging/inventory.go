@@ -0,0 +1,55 @@
+package rabbitmq
+
+import (
+	"backend/domain/models"
+	"time"
+)
+
+// inventoryUpdate returns the hosts response adds to the device inventory,
+// or nil if it adds none. As in the inventory itself, ARP scans contribute
+// their online devices and nmap scans the hosts not found down.
+func inventoryUpdate(response *models.Response) *models.InventoryUpdate {
+	update := &models.InventoryUpdate{TaskID: response.TaskID, At: time.Now()}
+	switch result := response.Result.(type) {
+	case models.ARPResponse:
+		update.Layer = "l2"
+		for _, device := range append(result.OnlineDevices, result.Devices...) {
+			if device.Status == "online" && device.IP != "" {
+				update.Hosts = append(update.Hosts, device.IP)
+			}
+		}
+	case models.NmapTcpUdpResponse:
+		update.Layer = "l3"
+		update.Hosts = upHost(result.Host, result.Status)
+	case models.NmapOsDetectionResponse:
+		update.Layer = "l3"
+		update.Hosts = upHost(result.Host, result.Status)
+	case models.NmapHostDiscoveryResponse:
+		update.Layer = "l3"
+		update.Hosts = upHost(result.Host, result.Status)
+	}
+	if len(update.Hosts) == 0 {
+		return nil
+	}
+	update.Hosts = dedupe(update.Hosts)
+	return update
+}
+
+func upHost(host, status string) []string {
+	if host == "" || status == "down" || status == "failed" {
+		return nil
+	}
+	return []string{host}
+}
+
+func dedupe(values []string) []string {
+	seen := make(map[string]bool, len(values))
+	out := values[:0]
+	for _, v := range values {
+		if !seen[v] {
+			seen[v] = true
+			out = append(out, v)
+		}
+	}
+	return out
+}
@@ -0,0 +1,40 @@
+package rabbitmq
+
+import (
+	"fmt"
+
+	"github.com/streadway/amqp"
+)
+
+// ProgressExchange is the topic exchange scanners report the progress of
+// running scans on, with the task id as routing key.
+const ProgressExchange = "scan_progress"
+
+// ConsumeProgress subscribes to every progress event through an exclusive
+// queue. Each backend instance relays them to its own WebSocket clients,
+// so they need not go through the broadcast exchange.
+func (p *RPCScannerPublisher) ConsumeProgress() (<-chan amqp.Delivery, error) {
+	ch, err := p.conn.Channel()
+	if err != nil {
+		return nil, fmt.Errorf("ConsumeProgress: open channel: %w", err)
+	}
+	if err := ch.ExchangeDeclare(ProgressExchange, "topic", true, false, false, false, nil); err != nil {
+		ch.Close()
+		return nil, fmt.Errorf("ConsumeProgress: declare exchange: %w", err)
+	}
+	q, err := ch.QueueDeclare("", false, true, true, false, nil)
+	if err != nil {
+		ch.Close()
+		return nil, fmt.Errorf("ConsumeProgress: declare queue: %w", err)
+	}
+	if err := ch.QueueBind(q.Name, "#", ProgressExchange, false, nil); err != nil {
+		ch.Close()
+		return nil, fmt.Errorf("ConsumeProgress: bind queue: %w", err)
+	}
+	msgs, err := ch.Consume(q.Name, "", true, true, false, false, nil)
+	if err != nil {
+		ch.Close()
+		return nil, fmt.Errorf("ConsumeProgress: consume: %w", err)
+	}
+	return msgs, nil
+}
@@ -0,0 +1,180 @@
+package rabbitmq
+
+import (
+	"backend/domain/models"
+	"backend/internal/infrastructure/telemetry"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// replyPrefetch bounds the replies delivered to the backend but not yet
+// stored and acknowledged.
+const replyPrefetch = 32
+
+// replyRequeueDelay is how long a reply that could not be stored waits
+// before it is requeued, so an unreachable database is not polled in a
+// tight loop.
+const replyRequeueDelay = time.Second
+
+// errMalformedReply marks replies that can never be handled; they are
+// dead-lettered instead of requeued.
+var errMalformedReply = errors.New("malformed reply")
+
+// PluginResolver recovers the plug-in a task was published to and the
+// options it carried, so that plug-in replies nobody waits for any more
+// can still be decoded.
+type PluginResolver interface {
+	TaskPlugin(taskID string) (*models.ScannerPlugin, map[string]interface{}, bool)
+}
+
+// startReplyConsumer consumes the backend's reply queue. The queue is
+// durable and replies are acknowledged only once handled, so replies sent
+// while the backend restarts wait in the queue and are processed when it
+// is back, matched to their task by id. A reply that could not be stored
+// is requeued; one that cannot be parsed goes to DeadLetterQueue.
+func (p *RPCScannerPublisher) startReplyConsumer() error {
+	ch, err := p.conn.Channel()
+	if err != nil {
+		return fmt.Errorf("open reply channel: %w", err)
+	}
+	if _, err := ch.QueueDeclare(p.replyQueue, true, false, false, false, nil); err != nil {
+		ch.Close()
+		return fmt.Errorf("declare reply queue %q: %w", p.replyQueue, err)
+	}
+	if err := ch.Qos(replyPrefetch, 0, false); err != nil {
+		ch.Close()
+		return fmt.Errorf("set reply prefetch: %w", err)
+	}
+	msgs, err := ch.Consume(p.replyQueue, "", false, false, false, false, nil)
+	if err != nil {
+		ch.Close()
+		return fmt.Errorf("consume reply queue %q: %w", p.replyQueue, err)
+	}
+
+	go func() {
+		for msg := range msgs {
+			p.settleReply(msg, p.handleReply(msg))
+		}
+		slog.Warn("reply queue delivery channel closed", "queue", p.replyQueue)
+	}()
+
+	return nil
+}
+
+// settleReply acknowledges msg once handled, dead-letters it when it is
+// malformed and requeues it otherwise.
+func (p *RPCScannerPublisher) settleReply(msg amqp.Delivery, err error) {
+	switch {
+	case err == nil:
+		msg.Ack(false)
+	case errors.Is(err, errMalformedReply):
+		slog.Error("dead-lettering scanner reply", "error", err, "bytes", len(msg.Body))
+		if err := p.deadLetter(p.replyQueue, msg.Body, msg.Headers, err.Error(), 0); err != nil {
+			slog.Error("failed to dead-letter scanner reply", "error", err)
+			msg.Nack(false, true)
+			return
+		}
+		msg.Ack(false)
+	default:
+		slog.Error("failed to handle scanner reply, requeueing", "error", err)
+		time.Sleep(replyRequeueDelay)
+		msg.Nack(false, true)
+	}
+}
+
+// handleReply decodes msg, schedules a retry if it reports a retryable
+// failure, and otherwise hands the result to the response callback and to
+// the publisher waiting for it. A reply nobody waits for any more (the
+// wait timed out, or the backend restarted since publishing) is still
+// stored. Either way the stored result is broadcast to every backend
+// instance for the WebSocket clients subscribed to its task, along with
+// the hosts it adds to the inventory. An error means the reply was not
+// stored.
+func (p *RPCScannerPublisher) handleReply(msg amqp.Delivery) error {
+	ctx := telemetry.Extract(context.Background(), msg.Headers)
+	if isChunk(msg) {
+		return p.handleChunk(ctx, msg)
+	}
+	body := msg.Body
+
+	p.mu.Lock()
+	pending, waiting := p.replies[msg.CorrelationId]
+	p.mu.Unlock()
+	if !waiting {
+		pending = p.latePending(body)
+	}
+
+	var response *models.Response
+	var err error
+	if pending.plugin != nil {
+		response, err = p.parsePluginResponse(pending, body)
+	} else {
+		response, err = p.parseResponse(body)
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %v", errMalformedReply, err)
+	}
+	if p.scheduleRetry(ctx, pending, msg) {
+		p.dropChunks(ctx, response.TaskID)
+		return nil
+	}
+	response.Agent = replyAgent(msg.Headers)
+	response.Attempts = pending.attempts
+	if p.onResponse != nil {
+		if err := p.onResponse(response); err != nil {
+			return fmt.Errorf("store response of task %s: %w", response.TaskID, err)
+		}
+	}
+
+	if err := p.Broadcast(models.BroadcastEvent{Type: models.BroadcastResult, Response: response}); err != nil {
+		slog.ErrorContext(ctx, "failed to broadcast scan response", "task_id", response.TaskID, "error", err)
+	}
+	if update := inventoryUpdate(response); update != nil {
+		if err := p.Broadcast(models.BroadcastEvent{Type: models.BroadcastInventory, Inventory: update}); err != nil {
+			slog.ErrorContext(ctx, "failed to broadcast inventory update", "task_id", response.TaskID, "error", err)
+		}
+	}
+
+	if waiting {
+		pending.ch <- response
+		return nil
+	}
+	slog.InfoContext(ctx, "stored late scan response", "task_id", response.TaskID)
+	return nil
+}
+
+// latePending stands in for the pendingReply of a reply nobody waits for.
+// Plug-in replies are recognised through the resolver by their task_id;
+// without one they are decoded as generic responses.
+func (p *RPCScannerPublisher) latePending(body []byte) *pendingReply {
+	pending := &pendingReply{}
+	if p.plugins == nil {
+		return pending
+	}
+	var reply struct {
+		TaskID string `json:"task_id"`
+	}
+	if json.Unmarshal(body, &reply) != nil || reply.TaskID == "" {
+		return pending
+	}
+	if plugin, options, ok := p.plugins.TaskPlugin(reply.TaskID); ok {
+		pending.plugin, pending.options = plugin, options
+	}
+	return pending
+}
+
+// SetPluginResolver lets late plug-in replies be decoded as such.
+func (p *RPCScannerPublisher) SetPluginResolver(resolver PluginResolver) {
+	p.plugins = resolver
+}
+
+// ReplyQueue is the queue scanners send this backend's replies to.
+func (p *RPCScannerPublisher) ReplyQueue() string {
+	return p.replyQueue
+}
@@ -0,0 +1,46 @@
+package rabbitmq
+
+import (
+	"errors"
+	"testing"
+
+	"backend/domain/models"
+
+	"github.com/streadway/amqp"
+)
+
+// acknowledger records how a delivery was settled.
+type acknowledger struct {
+	acked, requeued bool
+}
+
+func (a *acknowledger) Ack(tag uint64, multiple bool) error { a.acked = true; return nil }
+func (a *acknowledger) Nack(tag uint64, multiple, requeue bool) error {
+	a.requeued = requeue
+	return nil
+}
+func (a *acknowledger) Reject(tag uint64, requeue bool) error { a.requeued = requeue; return nil }
+
+func TestHandleReplyErrors(t *testing.T) {
+	storeErr := errors.New("mongo unreachable")
+	p := &RPCScannerPublisher{replies: map[string]*pendingReply{}}
+	p.SetResponseCallback(func(*models.Response) error { return storeErr })
+
+	err := p.handleReply(amqp.Delivery{Body: []byte(`{"task_id": "t", "status": "completed", "results": [{"target": "10.0.0.1"}]}`)})
+	if !errors.Is(err, storeErr) || errors.Is(err, errMalformedReply) {
+		t.Errorf("store failure: err = %v, want it requeued", err)
+	}
+
+	err = p.handleReply(amqp.Delivery{Body: []byte(`not json`)})
+	if !errors.Is(err, errMalformedReply) {
+		t.Errorf("unparsable body: err = %v, want it dead-lettered", err)
+	}
+}
+
+func TestSettleReplyAcksHandled(t *testing.T) {
+	ack := &acknowledger{}
+	(&RPCScannerPublisher{}).settleReply(amqp.Delivery{Acknowledger: ack}, nil)
+	if !ack.acked || ack.requeued {
+		t.Errorf("handled reply: %+v, want it acknowledged", ack)
+	}
+}
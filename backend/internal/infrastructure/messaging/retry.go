@@ -0,0 +1,210 @@
+package rabbitmq
+
+import (
+	"backend/domain/models"
+	"backend/internal/infrastructure/metrics"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// RetryPolicy decides which failed scans are published again and when.
+// Attempt n (1-based) is retried after BaseDelay·2^(n-1), capped at
+// MaxDelay, as long as n < MaxAttempts and the error falls in one of
+// Classes. MaxAttempts of 1 or less turns retries off.
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	Classes     []string
+}
+
+// RetryErrorClasses maps each retryable error class to the fragments of
+// scanner error messages that belong to it.
+var RetryErrorClasses = map[string][]string{
+	"timeout":     {"timeout", "timed out", "deadline exceeded"},
+	"resolve":     {"resolve", "no such host", "temporary failure in name resolution"},
+	"network":     {"connection refused", "connection reset", "no route to host", "network is unreachable"},
+	"process":     {"exit status", "signal: killed"},
+	"unavailable": {"resource temporarily unavailable", "too many open files"},
+}
+
+// DefaultRetryPolicy retries every known error class up to three attempts.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts: 3,
+		BaseDelay:   2 * time.Second,
+		MaxDelay:    30 * time.Second,
+		Classes:     []string{"timeout", "resolve", "network", "process", "unavailable"},
+	}
+}
+
+// Classify returns the retryable class errMsg falls in, or "" when the
+// error is not retryable under p.
+func (p RetryPolicy) Classify(errMsg string) string {
+	errMsg = strings.ToLower(errMsg)
+	if errMsg == "" {
+		return ""
+	}
+	for _, class := range p.Classes {
+		for _, fragment := range RetryErrorClasses[class] {
+			if strings.Contains(errMsg, fragment) {
+				return class
+			}
+		}
+	}
+	return ""
+}
+
+// Delay is the backoff before retrying after the given failed attempt.
+func (p RetryPolicy) Delay(attempt int) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < attempt && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	return delay
+}
+
+// retryQueue names the delay queue holding retries of queueName for delay.
+// Messages expire from it back into queueName; the delay is part of the
+// name because a queue's TTL cannot change once declared.
+func retryQueue(queueName string, delay time.Duration) string {
+	return fmt.Sprintf("%s.retry.%dms", queueName, delay.Milliseconds())
+}
+
+// replyError is the error a scanner reported in its reply, if any. Every
+// scanner result carries it as a top-level "error" field.
+func replyError(body []byte) string {
+	var reply struct {
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(body, &reply); err != nil {
+		return ""
+	}
+	return reply.Error
+}
+
+// scheduleRetry publishes the request of pending again through its delay
+// queue when msg reports a retryable error and attempts remain, recording
+// the failed attempt. It reports whether the reply was absorbed by a
+// retry. A retryable failure on the last attempt is copied to
+// DeadLetterQueue and returned to the caller as the final result; one in
+// a late reply, whose request is gone, is recorded as a failed attempt.
+func (p *RPCScannerPublisher) scheduleRetry(ctx context.Context, pending *pendingReply, msg amqp.Delivery) bool {
+	if p.retryPolicy.MaxAttempts <= 1 {
+		return false
+	}
+	errMsg := replyError(msg.Body)
+	class := p.retryPolicy.Classify(errMsg)
+	if class == "" {
+		return false
+	}
+	if pending.body == nil {
+		// A late reply: the request is not held any more and cannot be
+		// published again, so the task is stored as failed with the class.
+		slog.WarnContext(ctx, "retryable failure of a late reply is not retried", "class", class, "error", errMsg)
+		pending.attempts = append(pending.attempts, models.ScanAttempt{
+			Attempt:  len(pending.attempts) + 1,
+			Error:    errMsg,
+			Class:    class,
+			Agent:    replyAgent(msg.Headers),
+			FailedAt: time.Now(),
+		})
+		return false
+	}
+
+	attempt := len(pending.attempts) + 1
+	if attempt >= p.retryPolicy.MaxAttempts {
+		reason := fmt.Sprintf("retries exhausted after %d attempts: %s", attempt, errMsg)
+		if err := p.deadLetter(pending.queue, pending.body, pending.headers, reason, attempt); err != nil {
+			slog.ErrorContext(ctx, "failed to dead-letter scan request", "queue", pending.queue, "error", err)
+		}
+		return false
+	}
+
+	delay := p.retryPolicy.Delay(attempt)
+	if err := p.publishRetry(pending, attempt+1, delay); err != nil {
+		slog.ErrorContext(ctx, "failed to schedule retry", "queue", pending.queue, "attempt", attempt, "error", err)
+		return false
+	}
+	pending.attempts = append(pending.attempts, models.ScanAttempt{
+		Attempt:    attempt,
+		Error:      errMsg,
+		Class:      class,
+		Agent:      replyAgent(msg.Headers),
+		FailedAt:   time.Now(),
+		RetryAfter: delay.String(),
+	})
+	metrics.ScanRetries.WithLabelValues(pending.queue, class).Inc()
+	slog.WarnContext(ctx, "scan failed, retry scheduled", "queue", pending.queue, "attempt", attempt, "class", class, "delay", delay.String(), "error", errMsg)
+
+	select {
+	case pending.retried <- delay:
+	default:
+	}
+	return true
+}
+
+// publishRetry parks the request of pending in the delay queue for delay.
+// When its TTL expires the broker dead-letters it back to the scanner
+// queue, still carrying the correlation id and reply address of the
+// original RPC.
+func (p *RPCScannerPublisher) publishRetry(pending *pendingReply, attempt int, delay time.Duration) error {
+	name := retryQueue(pending.queue, delay)
+	_, err := p.channel.QueueDeclare(name, true, false, false, false, amqp.Table{
+		"x-message-ttl":             delay.Milliseconds(),
+		"x-dead-letter-exchange":    "",
+		"x-dead-letter-routing-key": pending.queue,
+	})
+	if err != nil {
+		return fmt.Errorf("declare retry queue %q: %w", name, err)
+	}
+
+	headers := amqp.Table{}
+	for k, v := range pending.headers {
+		headers[k] = v
+	}
+	headers[headerRetryAttempt] = int32(attempt)
+
+	return p.channel.Publish("", name, false, false, amqp.Publishing{
+		ContentType:   "application/json",
+		CorrelationId: pending.correlationID,
+		ReplyTo:       p.replyQueue,
+		Priority:      pending.priority,
+		DeliveryMode:  amqp.Persistent,
+		Headers:       headers,
+		Body:          pending.body,
+	})
+}
+
+// deadLetter parks a request for queue on DeadLetterQueue with the reason
+// it was given up on.
+func (p *RPCScannerPublisher) deadLetter(queue string, body []byte, header amqp.Table, reason string, attempts int) error {
+	if _, err := p.channel.QueueDeclare(DeadLetterQueue, true, false, false, false, nil); err != nil {
+		return fmt.Errorf("declare %q: %w", DeadLetterQueue, err)
+	}
+	headers := amqp.Table{}
+	for k, v := range header {
+		headers[k] = v
+	}
+	headers[headerDeadLetterReason] = reason
+	headers[headerOriginalQueue] = queue
+	headers[headerRetryAttempt] = int32(attempts)
+
+	return p.channel.Publish("", DeadLetterQueue, false, false, amqp.Publishing{
+		ContentType:  "application/json",
+		MessageId:    generateCorrelationID(),
+		Timestamp:    time.Now(),
+		DeliveryMode: amqp.Persistent,
+		Headers:      headers,
+		Body:         body,
+	})
+}
@@ -0,0 +1,98 @@
+package rabbitmq
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+func TestRetryPolicyClassify(t *testing.T) {
+	policy := DefaultRetryPolicy()
+	tests := []struct {
+		errMsg string
+		class  string
+	}{
+		{"", ""},
+		{"ARP resolve failed for 200 of 254 addresses: sendto: no buffer space available", "resolve"},
+		{"exit status 1", "process"},
+		{"nmap scan timed out", "timeout"},
+		{"nmap could not resolve a name", "resolve"},
+		{"dial tcp 10.0.0.1:22: i/o timeout", "timeout"},
+		{"dial tcp 10.0.0.1:22: connect: Connection Refused", "network"},
+		{"context deadline exceeded", "timeout"},
+		{"socket: too many open files", "unavailable"},
+		{"invalid scan method \"x\"", ""},
+		{"interface not found: route ip+net: no such network interface", ""},
+	}
+	for _, tt := range tests {
+		if got := policy.Classify(tt.errMsg); got != tt.class {
+			t.Errorf("Classify(%q) = %q, want %q", tt.errMsg, got, tt.class)
+		}
+	}
+}
+
+func TestRetryPolicyClassifyRestrictedClasses(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 3, Classes: []string{"timeout"}}
+	if got := policy.Classify("exit status 1"); got != "" {
+		t.Errorf("Classify = %q for a class outside the policy", got)
+	}
+	if got := policy.Classify("nmap scan timed out"); got != "timeout" {
+		t.Errorf("Classify = %q, want timeout", got)
+	}
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	policy := RetryPolicy{BaseDelay: 2 * time.Second, MaxDelay: 30 * time.Second}
+	tests := []struct {
+		attempt int
+		delay   time.Duration
+	}{
+		{1, 2 * time.Second},
+		{2, 4 * time.Second},
+		{3, 8 * time.Second},
+		{4, 16 * time.Second},
+		{5, 30 * time.Second},
+		{40, 30 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := policy.Delay(tt.attempt); got != tt.delay {
+			t.Errorf("Delay(%d) = %s, want %s", tt.attempt, got, tt.delay)
+		}
+	}
+}
+
+func TestRetryQueueName(t *testing.T) {
+	if got := retryQueue("nmap_service", 4*time.Second); got != "nmap_service.retry.4000ms" {
+		t.Errorf("retryQueue = %q", got)
+	}
+}
+
+func TestReplyError(t *testing.T) {
+	tests := []struct {
+		body string
+		want string
+	}{
+		{`{"task_id": "t", "status": "failed", "error": "exit status 1"}`, "exit status 1"},
+		{`{"task_id": "t", "status": "completed"}`, ""},
+		{`not json`, ""},
+	}
+	for _, tt := range tests {
+		if got := replyError([]byte(tt.body)); got != tt.want {
+			t.Errorf("replyError(%s) = %q, want %q", tt.body, got, tt.want)
+		}
+	}
+}
+
+func TestScheduleRetryLateReply(t *testing.T) {
+	p := &RPCScannerPublisher{retryPolicy: DefaultRetryPolicy()}
+	pending := &pendingReply{}
+	msg := amqp.Delivery{Body: []byte(`{"task_id": "t", "error": "nmap scan timed out"}`)}
+	if p.scheduleRetry(context.Background(), pending, msg) {
+		t.Fatal("late reply absorbed by a retry it cannot publish")
+	}
+	if len(pending.attempts) != 1 || pending.attempts[0].Class != "timeout" {
+		t.Errorf("attempts = %+v, want the failure recorded with its class", pending.attempts)
+	}
+}
@@ -0,0 +1,45 @@
+package rabbitmq
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrShuttingDown is returned for requests published once the backend has
+// begun shutting down.
+var ErrShuttingDown = errors.New("backend is shutting down")
+
+// Drain makes the publisher refuse new requests with ErrShuttingDown;
+// requests already published wait for their reply as usual.
+func (p *RPCScannerPublisher) Drain() {
+	p.mu.Lock()
+	p.draining = true
+	p.mu.Unlock()
+}
+
+// Wait returns once no request is waiting for its reply any more, or with
+// the error of ctx when it is done first.
+func (p *RPCScannerPublisher) Wait(ctx context.Context) error {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		p.mu.Lock()
+		pending := len(p.replies)
+		p.mu.Unlock()
+		if pending == 0 {
+			return nil
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Close closes the AMQP connection and with it every channel and consumer.
+// Replies not yet acknowledged stay in the reply queue for the next start.
+func (p *RPCScannerPublisher) Close() error {
+	return p.conn.Close()
+}
@@ -0,0 +1,130 @@
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/event"
+)
+
+const namespace = "backend"
+
+var (
+	// RPCDuration is the time from publishing a scan request until its
+	// reply arrives (or the wait is abandoned), per queue and outcome:
+	// "ok", "timeout", "error", "unavailable" (no live scanner) or "limited"
+	// (gave up waiting for a concurrency slot).
+	RPCDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "rpc_duration_seconds",
+		Help:      "Latency of scanner RPCs by queue and outcome.",
+		Buckets:   []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 20, 30, 60},
+	}, []string{"queue", "outcome"})
+
+	RPCTimeouts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rpc_timeouts_total",
+		Help:      "Scanner RPCs that got no reply in time, by queue.",
+	}, []string{"queue"})
+
+	ScanRetries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "scan_retries_total",
+		Help:      "Failed scans published again, by queue and error class.",
+	}, []string{"queue", "class"})
+
+	// ScanSlotWait is the time a scan waited for a slot under the
+	// backend's per-scanner and per-subnet limits, by queue and lane.
+	ScanSlotWait = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "scan_slot_wait_seconds",
+		Help:      "Time scans waited for a concurrency slot, by queue and priority.",
+		Buckets:   []float64{0.01, 0.1, 0.5, 1, 5, 15, 30, 60, 300, 900},
+	}, []string{"queue", "priority"})
+
+	RPCInFlight = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "rpc_in_flight",
+		Help:      "Scanner RPCs waiting for a reply, by queue.",
+	}, []string{"queue"})
+
+	WebSocketClients = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "websocket_clients",
+		Help:      "WebSocket clients registered with the hub.",
+	})
+
+	SSESubscribers = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "sse_subscribers",
+		Help:      "Open /api/changes/stream connections.",
+	})
+
+	MongoDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "mongo_command_duration_seconds",
+		Help:      "Latency of MongoDB commands by command, collection and outcome.",
+		Buckets:   []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 10},
+	}, []string{"command", "collection", "outcome"})
+)
+
+// Handler serves the registered metrics in the Prometheus text format.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// ObserveRPC records one finished RPC started at start.
+func ObserveRPC(queue, outcome string, start time.Time) {
+	RPCDuration.WithLabelValues(queue, outcome).Observe(time.Since(start).Seconds())
+	if outcome == "timeout" {
+		RPCTimeouts.WithLabelValues(queue).Inc()
+	}
+}
+
+// MongoMonitor returns a command monitor recording MongoDB latency. The
+// collection is taken from the started event, which carries the command
+// document; the finished events only carry the request id.
+func MongoMonitor() *event.CommandMonitor {
+	var collections sync.Map // request id → collection name
+
+	finished := func(e event.CommandFinishedEvent, outcome string) {
+		collection := ""
+		if v, ok := collections.LoadAndDelete(e.RequestID); ok {
+			collection = v.(string)
+		}
+		MongoDuration.WithLabelValues(e.CommandName, collection, outcome).Observe(e.Duration.Seconds())
+	}
+
+	return &event.CommandMonitor{
+		Started: func(_ context.Context, e *event.CommandStartedEvent) {
+			collections.Store(e.RequestID, commandCollection(e.Command, e.CommandName))
+		},
+		Succeeded: func(_ context.Context, e *event.CommandSucceededEvent) {
+			finished(e.CommandFinishedEvent, "ok")
+		},
+		Failed: func(_ context.Context, e *event.CommandFailedEvent) {
+			finished(e.CommandFinishedEvent, "error")
+		},
+	}
+}
+
+// commandCollection returns the collection a CRUD command targets: the
+// value of its first element, e.g. {find: "l3_devices", …}. Commands that
+// do not target a collection (ping, hello, …) yield "".
+func commandCollection(cmd bson.Raw, name string) string {
+	elem, err := cmd.IndexErr(0)
+	if err != nil || elem.Key() != name {
+		return ""
+	}
+	coll, ok := elem.Value().StringValueOK()
+	if !ok {
+		return ""
+	}
+	return coll
+}
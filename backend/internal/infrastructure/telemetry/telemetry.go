@@ -0,0 +1,143 @@
+package telemetry
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/streadway/amqp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TaskIDHeader is the AMQP header carrying the task id next to the W3C
+// trace context (traceparent / tracestate), so a scan can be followed from
+// the WebSocket request through the scanner and back.
+const TaskIDHeader = "task_id"
+
+const tracerName = "backend"
+
+type taskIDKey struct{}
+
+// WithTaskID returns ctx carrying taskID; log records written with ctx
+// and AMQP messages published with it carry the task id.
+func WithTaskID(ctx context.Context, taskID string) context.Context {
+	if taskID == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, taskIDKey{}, taskID)
+}
+
+// TaskID returns the task id carried by ctx, if any.
+func TaskID(ctx context.Context) string {
+	id, _ := ctx.Value(taskIDKey{}).(string)
+	return id
+}
+
+// Tracer returns the backend tracer.
+func Tracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
+
+// SetupLogging makes JSON the format of every log line, including those
+// written through the standard log package. Records written with a
+// context get its task_id, trace_id and span_id.
+func SetupLogging(service string) {
+	handler := slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelInfo})
+	slog.SetDefault(slog.New(contextHandler{handler}).With("service", service))
+}
+
+// SetupTracing exports spans to the OTLP/HTTP endpoint (e.g.
+// http://otel-collector:4318). With an empty endpoint no spans are
+// recorded, but trace context is still propagated. The returned function
+// flushes pending spans.
+func SetupTracing(ctx context.Context, service, endpoint string) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	if endpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(endpoint))
+	if err != nil {
+		return nil, fmt.Errorf("otlp exporter: %w", err)
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(service)))
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// ── AMQP propagation ─────────────────────────────────────────────────────────
+
+// headerCarrier adapts AMQP headers to the OpenTelemetry propagators.
+type headerCarrier amqp.Table
+
+func (c headerCarrier) Get(key string) string {
+	v, _ := c[key].(string)
+	return v
+}
+
+func (c headerCarrier) Set(key, value string) { c[key] = value }
+
+func (c headerCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// Inject writes the trace context and task id of ctx into headers.
+func Inject(ctx context.Context, headers amqp.Table) {
+	otel.GetTextMapPropagator().Inject(ctx, headerCarrier(headers))
+	if id := TaskID(ctx); id != "" {
+		headers[TaskIDHeader] = id
+	}
+}
+
+// Extract returns ctx extended with the trace context and task id found
+// in headers.
+func Extract(ctx context.Context, headers amqp.Table) context.Context {
+	if headers == nil {
+		return ctx
+	}
+	ctx = otel.GetTextMapPropagator().Extract(ctx, headerCarrier(headers))
+	if id, ok := headers[TaskIDHeader].(string); ok {
+		ctx = WithTaskID(ctx, id)
+	}
+	return ctx
+}
+
+// ── Logging ──────────────────────────────────────────────────────────────────
+
+// contextHandler adds the task and trace ids of the record's context.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := TaskID(ctx); id != "" {
+		r.AddAttrs(slog.String("task_id", id))
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		r.AddAttrs(slog.String("trace_id", sc.TraceID().String()), slog.String("span_id", sc.SpanID().String()))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"backend/domain/models"
+	"backend/internal/infrastructure/metrics"
 )
 
 // ChangesRepository is the minimal interface the handler needs.
@@ -18,6 +19,12 @@ type ChangesRepository interface {
 	DeleteChangeEvents() error
 }
 
+// How often StreamChanges polls for new events and pings an idle stream.
+var (
+	ssePollInterval = 5 * time.Second
+	sseKeepalive    = 30 * time.Second
+)
+
 // ChangesHandler serves change-detection endpoints.
 type ChangesHandler struct {
 	repo ChangesRepository
@@ -75,6 +82,9 @@ func (h *ChangesHandler) StreamChanges(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "streaming not supported", http.StatusInternalServerError)
 		return
 	}
+	// The stream lasts as long as the client listens: lift the server's
+	// write timeout. It ends when the server shuts down.
+	liftWriteDeadline(w, "[SSE]")
 
 	w.Header().Set("Content-Type",                "text/event-stream")
 	w.Header().Set("Cache-Control",               "no-cache")
@@ -82,6 +92,9 @@ func (h *ChangesHandler) StreamChanges(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("X-Accel-Buffering",           "no") // nginx: disable response buffer
 
+	metrics.SSESubscribers.Inc()
+	defer metrics.SSESubscribers.Dec()
+
 	// ── initial burst: last 24 hours ────────────────────────────────────────
 	since := time.Now().Add(-24 * time.Hour)
 	initial, err := h.repo.GetChangeEvents(500, "")
@@ -102,8 +115,8 @@ func (h *ChangesHandler) StreamChanges(w http.ResponseWriter, r *http.Request) {
 	// ── poll for new events every 5 s ───────────────────────────────────────
 	lastCheck := time.Now()
 
-	poll    := time.NewTicker(5 * time.Second)
-	keepalive := time.NewTicker(30 * time.Second)
+	poll    := time.NewTicker(ssePollInterval)
+	keepalive := time.NewTicker(sseKeepalive)
 	defer poll.Stop()
 	defer keepalive.Stop()
 
@@ -137,6 +150,14 @@ func (h *ChangesHandler) StreamChanges(w http.ResponseWriter, r *http.Request) {
 
 // ── helpers ──────────────────────────────────────────────────────────────────
 
+// liftWriteDeadline removes the server's WriteTimeout from a streamed
+// response, which would otherwise cut it off mid-stream.
+func liftWriteDeadline(w http.ResponseWriter, logPrefix string) {
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+		log.Printf("%s cannot lift the write deadline, the stream ends at the server's write timeout: %v", logPrefix, err)
+	}
+}
+
 func writeJSON(w http.ResponseWriter, code int, v interface{}) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(code)
@@ -0,0 +1,160 @@
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"backend/domain/models"
+	rabbitmq "backend/internal/infrastructure/messaging"
+)
+
+const defaultDeadLetterLimit = 100
+
+// DeadLetterQueue is the broker side of the dead-letter endpoints.
+type DeadLetterQueue interface {
+	DeadLetters(limit int) ([]models.DeadLetter, error)
+	ReplayDeadLetter(ctx context.Context, id string) (*models.Response, error)
+	DiscardDeadLetter(id string) (models.DeadLetter, error)
+}
+
+// DeadLettersHandler inspects, replays and discards the scan requests on
+// the dead-letter queue. The queue is wired in once RabbitMQ is connected;
+// until then every endpoint answers 503.
+type DeadLettersHandler struct {
+	mu    sync.RWMutex
+	queue DeadLetterQueue
+}
+
+func NewDeadLettersHandler() *DeadLettersHandler {
+	return &DeadLettersHandler{}
+}
+
+// SetQueue wires the RabbitMQ publisher once it is available.
+func (h *DeadLettersHandler) SetQueue(queue DeadLetterQueue) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.queue = queue
+}
+
+// GET /api/dead-letters?limit=<n>
+func (h *DeadLettersHandler) GetDeadLetters(w http.ResponseWriter, r *http.Request) {
+	queue, ok := h.prepare(w, r, "GET")
+	if !ok {
+		return
+	}
+
+	limit := defaultDeadLetterLimit
+	if parsed, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && parsed > 0 {
+		limit = parsed
+	}
+
+	letters, err := queue.DeadLetters(limit)
+	if err != nil {
+		log.Printf("Error listing dead letters: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(models.HistoryResponse{Success: false, Error: "Failed to list dead letters"})
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(models.HistoryResponse{Success: true, Data: letters, Count: len(letters)})
+}
+
+// POST /api/dead-letters/replay?id=<id>
+//
+// Publishes the request again and answers with the scan result once the
+// scanner replies.
+func (h *DeadLettersHandler) ReplayDeadLetter(w http.ResponseWriter, r *http.Request) {
+	queue, ok := h.prepare(w, r, "POST")
+	if !ok {
+		return
+	}
+	id, ok := requireID(w, r)
+	if !ok {
+		return
+	}
+
+	response, err := queue.ReplayDeadLetter(r.Context(), id)
+	if err != nil {
+		writeDeadLetterError(w, "replay", id, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(models.HistoryResponse{Success: true, Data: response})
+}
+
+// DELETE /api/dead-letters/delete?id=<id>
+func (h *DeadLettersHandler) DeleteDeadLetter(w http.ResponseWriter, r *http.Request) {
+	queue, ok := h.prepare(w, r, "DELETE")
+	if !ok {
+		return
+	}
+	id, ok := requireID(w, r)
+	if !ok {
+		return
+	}
+
+	letter, err := queue.DiscardDeadLetter(id)
+	if err != nil {
+		writeDeadLetterError(w, "discard", id, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(models.HistoryResponse{Success: true, Data: letter})
+}
+
+// prepare writes the common headers and answers preflight, wrong-method
+// and not-yet-connected requests itself.
+func (h *DeadLettersHandler) prepare(w http.ResponseWriter, r *http.Request, method string) (DeadLetterQueue, bool) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return nil, false
+	}
+	if r.Method != method {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return nil, false
+	}
+
+	h.mu.RLock()
+	queue := h.queue
+	h.mu.RUnlock()
+	if queue == nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(models.HistoryResponse{Success: false, Error: "RabbitMQ is not connected"})
+		return nil, false
+	}
+	return queue, true
+}
+
+func requireID(w http.ResponseWriter, r *http.Request) (string, bool) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(models.HistoryResponse{Success: false, Error: "id parameter is required"})
+		return "", false
+	}
+	return id, true
+}
+
+func writeDeadLetterError(w http.ResponseWriter, action, id string, err error) {
+	status := http.StatusInternalServerError
+	if errors.Is(err, rabbitmq.ErrDeadLetterNotFound) {
+		status = http.StatusNotFound
+	} else {
+		log.Printf("Error during dead letter %s of %s: %v", action, id, err)
+	}
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(models.HistoryResponse{Success: false, Error: err.Error()})
+}
@@ -0,0 +1,68 @@
+package rest
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"backend/domain/models"
+	"backend/internal/application/services"
+)
+
+// DiffHandler compares two history records of the same scan type.
+type DiffHandler struct {
+	diff *services.DiffService
+}
+
+func NewDiffHandler(diff *services.DiffService) *DiffHandler {
+	return &DiffHandler{diff: diff}
+}
+
+// GET /api/diff?a={id}&b={id}
+// a is the baseline, b the scan compared against it. Works for every
+// history type; both ids must belong to the same one.
+func (h *DiffHandler) GetDiff(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	a := strings.TrimSpace(r.URL.Query().Get("a"))
+	b := strings.TrimSpace(r.URL.Query().Get("b"))
+	if a == "" || b == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(models.HistoryResponse{Success: false, Error: "both a and b ids are required"})
+		return
+	}
+
+	result, err := h.diff.Diff(a, b)
+	if err != nil {
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, services.ErrDiffNotFound):
+			status = http.StatusNotFound
+		case errors.Is(err, services.ErrDiffTypeMismatch):
+			status = http.StatusBadRequest
+		default:
+			log.Printf("Error diffing %s and %s: %v", a, b, err)
+		}
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(models.HistoryResponse{Success: false, Error: err.Error()})
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(models.HistoryResponse{Success: true, Data: result})
+}
@@ -0,0 +1,420 @@
+package rest
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"backend/domain/models"
+)
+
+// ExportRepository is the minimal interface the export handler needs.
+type ExportRepository interface {
+	EachHistory(ctx context.Context, scanType string, q models.HistoryQuery, fn func(decode func(v interface{}) error) error) error
+	EachL3Device(ctx context.Context, q models.HistoryQuery, fn func(models.L3Device) error) error
+	EachL2Device(ctx context.Context, q models.HistoryQuery, fn func(models.L2Device) error) error
+}
+
+// ExportHandler streams history and inventory as CSV, NDJSON or Nmap XML.
+// Records are written as they are read from MongoDB and flushed in batches,
+// so memory use does not grow with the size of the export.
+type ExportHandler struct {
+	repo ExportRepository
+}
+
+func NewExportHandler(repo ExportRepository) *ExportHandler {
+	return &ExportHandler{repo: repo}
+}
+
+// exportSpec describes how one record type is rendered.
+type exportSpec struct {
+	name      string
+	header    []string
+	newRecord func() interface{}
+	rows      func(rec interface{}) [][]string
+	xmlHost   func(rec interface{}) xmlHost // nil when Nmap XML is not supported
+}
+
+const exportFlushEvery = 500
+
+// GET /api/export/arp?format=csv&target=10.0.0.0/24&since=7d&limit=1000
+func (h *ExportHandler) ExportARP(w http.ResponseWriter, r *http.Request) {
+	h.exportHistory(w, r, "arp", exportSpec{
+		name:      "arp",
+		header:    []string{"task_id", "created_at", "interface_name", "ip_range", "scan_status", "ip", "mac", "vendor", "status"},
+		newRecord: func() interface{} { return &models.ARPHistoryRecord{} },
+		rows: func(v interface{}) [][]string {
+			rec := v.(*models.ARPHistoryRecord)
+			base := []string{rec.TaskID, formatTime(rec.CreatedAt), rec.InterfaceName, rec.IPRange, rec.Status}
+			devices := append(append([]models.ARPDevice{}, rec.OnlineDevices...), rec.OfflineDevices...)
+			if len(devices) == 0 {
+				return [][]string{append(base, "", "", "", "")}
+			}
+			rows := make([][]string, 0, len(devices))
+			for _, d := range devices {
+				rows = append(rows, append(append([]string{}, base...), d.IP, d.MAC, d.Vendor, d.Status))
+			}
+			return rows
+		},
+	})
+}
+
+// GET /api/export/icmp?format=ndjson&target=8.8.8.8
+func (h *ExportHandler) ExportICMP(w http.ResponseWriter, r *http.Request) {
+	h.exportHistory(w, r, "icmp", exportSpec{
+		name:      "icmp",
+		header:    []string{"task_id", "created_at", "target", "address", "packets_sent", "packets_received", "packet_loss_percent", "error"},
+		newRecord: func() interface{} { return &models.ICMPHistoryRecord{} },
+		rows: func(v interface{}) [][]string {
+			rec := v.(*models.ICMPHistoryRecord)
+			rows := make([][]string, 0, len(rec.Results))
+			for _, res := range rec.Results {
+				rows = append(rows, []string{
+					rec.TaskID, formatTime(rec.CreatedAt), res.Target, res.Address,
+					strconv.Itoa(res.PacketsSent), strconv.Itoa(res.PacketsReceived),
+					strconv.FormatFloat(res.PacketLossPercent, 'f', -1, 64), res.Error,
+				})
+			}
+			if len(rows) == 0 {
+				rows = append(rows, []string{rec.TaskID, formatTime(rec.CreatedAt), strings.Join(rec.Targets, " "), "", "", "", "", rec.Error})
+			}
+			return rows
+		},
+	})
+}
+
+// GET /api/export/nmap?type=tcp_udp&format=xml
+//
+// type is tcp_udp (default), os_detection or host_discovery; format=xml is
+// available for tcp_udp and os_detection.
+func (h *ExportHandler) ExportNmap(w http.ResponseWriter, r *http.Request) {
+	scanType := r.URL.Query().Get("type")
+	if scanType == "" {
+		scanType = "tcp_udp"
+	}
+
+	switch scanType {
+	case "tcp_udp":
+		h.exportHistory(w, r, "nmap_tcp_udp", exportSpec{
+			name:      "nmap-tcp-udp",
+			header:    []string{"task_id", "created_at", "ip", "host", "host_status", "protocol", "port", "state", "service"},
+			newRecord: func() interface{} { return &models.NmapTcpUdpHistoryRecord{} },
+			rows: func(v interface{}) [][]string {
+				rec := v.(*models.NmapTcpUdpHistoryRecord)
+				var rows [][]string
+				for _, info := range rec.PortInfo {
+					for i, port := range info.AllPorts {
+						rows = append(rows, []string{
+							rec.TaskID, formatTime(rec.CreatedAt), rec.IP, rec.Host, info.Status,
+							indexOr(info.Protocols, i, "tcp"), strconv.Itoa(int(port)),
+							indexOr(info.State, i, ""), indexOr(info.ServiceName, i, ""),
+						})
+					}
+				}
+				if len(rows) == 0 {
+					rows = append(rows, []string{rec.TaskID, formatTime(rec.CreatedAt), rec.IP, rec.Host, rec.Status, "", "", "", ""})
+				}
+				return rows
+			},
+			xmlHost: func(v interface{}) xmlHost { return nmapTcpUdpXMLHost(v.(*models.NmapTcpUdpHistoryRecord)) },
+		})
+
+	case "os_detection":
+		h.exportHistory(w, r, "nmap_os_detection", exportSpec{
+			name:      "nmap-os-detection",
+			header:    []string{"task_id", "created_at", "ip", "host", "name", "accuracy", "vendor", "family", "type", "status"},
+			newRecord: func() interface{} { return &models.NmapOsDetectionHistoryRecord{} },
+			rows: func(v interface{}) [][]string {
+				rec := v.(*models.NmapOsDetectionHistoryRecord)
+				return [][]string{{
+					rec.TaskID, formatTime(rec.CreatedAt), rec.IP, rec.Host, rec.Name,
+					strconv.Itoa(rec.Accuracy), rec.Vendor, rec.Family, rec.Type, rec.Status,
+				}}
+			},
+			xmlHost: func(v interface{}) xmlHost { return nmapOsXMLHost(v.(*models.NmapOsDetectionHistoryRecord)) },
+		})
+
+	case "host_discovery":
+		h.exportHistory(w, r, "nmap_host_discovery", exportSpec{
+			name:      "nmap-host-discovery",
+			header:    []string{"task_id", "created_at", "ip", "host", "host_up", "host_total", "status", "dns", "reason"},
+			newRecord: func() interface{} { return &models.NmapHostDiscoveryHistoryRecord{} },
+			rows: func(v interface{}) [][]string {
+				rec := v.(*models.NmapHostDiscoveryHistoryRecord)
+				return [][]string{{
+					rec.TaskID, formatTime(rec.CreatedAt), rec.IP, rec.Host,
+					strconv.Itoa(rec.HostUP), strconv.Itoa(rec.HostTotal), rec.Status, rec.DNS, rec.Reason,
+				}}
+			},
+		})
+
+	default:
+		writeJSON(w, http.StatusBadRequest, models.HistoryResponse{Success: false, Error: "unsupported nmap type: " + scanType})
+	}
+}
+
+// GET /api/export/tcp?format=csv&target=example.org
+func (h *ExportHandler) ExportTCP(w http.ResponseWriter, r *http.Request) {
+	h.exportHistory(w, r, "tcp", exportSpec{
+		name:      "tcp",
+		header:    []string{"task_id", "created_at", "host", "port", "status", "hex_object_key", "decoded_text", "error"},
+		newRecord: func() interface{} { return &models.TCPHistoryRecord{} },
+		rows: func(v interface{}) [][]string {
+			rec := v.(*models.TCPHistoryRecord)
+			return [][]string{{
+				rec.TaskID, formatTime(rec.CreatedAt), rec.Host, rec.Port, rec.Status,
+				rec.HexObjectKey, rec.DecodedText, rec.Error,
+			}}
+		},
+	})
+}
+
+// GET /api/export/inventory?layer=l3&format=csv&since=30d
+//
+// layer=l3 (default) exports one row per IP, layer=l2 one row per MAC.
+func (h *ExportHandler) ExportInventory(w http.ResponseWriter, r *http.Request) {
+	format, q, ok := h.parseExportRequest(w, r, false)
+	if !ok {
+		return
+	}
+
+	switch layer := r.URL.Query().Get("layer"); layer {
+	case "", "l3":
+		h.stream(w, format, exportSpec{
+			name:   "inventory-l3",
+			header: []string{"ip", "mac", "vendor", "first_seen", "last_seen"},
+			rows: func(v interface{}) [][]string {
+				d := v.(models.L3Device)
+				return [][]string{{d.IP, d.MAC, d.Vendor, formatTime(d.FirstSeen), formatTime(d.LastSeen)}}
+			},
+		}, func(emit func(interface{}) error) error {
+			return h.repo.EachL3Device(r.Context(), q, func(d models.L3Device) error { return emit(d) })
+		})
+
+	case "l2":
+		h.stream(w, format, exportSpec{
+			name:   "inventory-l2",
+			header: []string{"mac", "vendor", "ip_addresses", "first_seen", "last_seen"},
+			rows: func(v interface{}) [][]string {
+				d := v.(models.L2Device)
+				return [][]string{{d.MAC, d.Vendor, strings.Join(d.IPAddresses, " "), formatTime(d.FirstSeen), formatTime(d.LastSeen)}}
+			},
+		}, func(emit func(interface{}) error) error {
+			return h.repo.EachL2Device(r.Context(), q, func(d models.L2Device) error { return emit(d) })
+		})
+
+	default:
+		writeJSON(w, http.StatusBadRequest, models.HistoryResponse{Success: false, Error: "unsupported layer: " + layer})
+	}
+}
+
+func (h *ExportHandler) exportHistory(w http.ResponseWriter, r *http.Request, scanType string, spec exportSpec) {
+	format, q, ok := h.parseExportRequest(w, r, spec.xmlHost != nil)
+	if !ok {
+		return
+	}
+
+	h.stream(w, format, spec, func(emit func(interface{}) error) error {
+		return h.repo.EachHistory(r.Context(), scanType, q, func(decode func(v interface{}) error) error {
+			rec := spec.newRecord()
+			if err := decode(rec); err != nil {
+				return err
+			}
+			return emit(rec)
+		})
+	})
+}
+
+// parseExportRequest reads format, limit, target, since and until.
+// since/until accept RFC 3339 timestamps or ages such as "7d".
+func (h *ExportHandler) parseExportRequest(w http.ResponseWriter, r *http.Request, xmlSupported bool) (string, models.HistoryQuery, bool) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	var q models.HistoryQuery
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return "", q, false
+	}
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return "", q, false
+	}
+
+	params := r.URL.Query()
+	format := params.Get("format")
+	if format == "" {
+		format = "csv"
+	}
+	switch {
+	case format == "csv", format == "ndjson":
+	case format == "xml" && xmlSupported:
+	default:
+		writeJSON(w, http.StatusBadRequest, models.HistoryResponse{Success: false, Error: "unsupported format: " + format})
+		return "", q, false
+	}
+
+	if l := params.Get("limit"); l != "" {
+		if v, err := strconv.Atoi(l); err == nil && v > 0 {
+			q.Limit = v
+		}
+	}
+	q.Target = strings.TrimSpace(params.Get("target"))
+
+	var err error
+	if q.Since, err = parseTimeOrAge(params.Get("since")); err != nil {
+		writeJSON(w, http.StatusBadRequest, models.HistoryResponse{Success: false, Error: err.Error()})
+		return "", q, false
+	}
+	if q.Until, err = parseTimeOrAge(params.Get("until")); err != nil {
+		writeJSON(w, http.StatusBadRequest, models.HistoryResponse{Success: false, Error: err.Error()})
+		return "", q, false
+	}
+	return format, q, true
+}
+
+// stream writes every record produced by each in the requested format.
+func (h *ExportHandler) stream(w http.ResponseWriter, format string, spec exportSpec, each func(emit func(interface{}) error) error) {
+	filename := fmt.Sprintf("%s-%s.%s", spec.name, time.Now().UTC().Format("20060102-150405"), format)
+	switch format {
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	case "ndjson":
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	case "xml":
+		w.Header().Set("Content-Type", "application/xml")
+	}
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+	w.Header().Set("X-Accel-Buffering", "no") // nginx: stream instead of buffering
+	// Exports may take longer than the server's write timeout allows.
+	liftWriteDeadline(w, "[Export]")
+	w.WriteHeader(http.StatusOK)
+
+	flusher, _ := w.(http.Flusher)
+	flush := func() {
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+
+	var (
+		count int
+		err   error
+	)
+	switch format {
+	case "csv":
+		cw := csv.NewWriter(w)
+		cw.Write(spec.header)
+		err = each(func(rec interface{}) error {
+			if err := cw.WriteAll(spec.rows(rec)); err != nil {
+				return err
+			}
+			if count++; count%exportFlushEvery == 0 {
+				flush()
+			}
+			return nil
+		})
+		cw.Flush()
+
+	case "ndjson":
+		enc := json.NewEncoder(w)
+		err = each(func(rec interface{}) error {
+			if err := enc.Encode(rec); err != nil {
+				return err
+			}
+			if count++; count%exportFlushEvery == 0 {
+				flush()
+			}
+			return nil
+		})
+
+	case "xml":
+		err = h.streamNmapXML(w, spec, each, flush, &count)
+	}
+
+	flush()
+	if err != nil {
+		// Headers are already sent — all we can do is cut the stream short.
+		log.Printf("[Export] %s aborted after %d records: %v", filename, count, err)
+		return
+	}
+	log.Printf("[Export] %s: %d records", filename, count)
+}
+
+func (h *ExportHandler) streamNmapXML(w io.Writer, spec exportSpec, each func(emit func(interface{}) error) error, flush func(), count *int) error {
+	start := time.Now()
+	fmt.Fprintf(w, "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n<!DOCTYPE nmaprun>\n")
+	fmt.Fprintf(w, "<nmaprun scanner=\"nmap\" args=\"network_scanner export\" start=\"%d\" startstr=\"%s\" xmloutputversion=\"1.05\">\n",
+		start.Unix(), start.Format(time.ANSIC))
+
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	up, down := 0, 0
+	err := each(func(rec interface{}) error {
+		host := spec.xmlHost(rec)
+		if host.Status.State == "up" {
+			up++
+		} else {
+			down++
+		}
+		if err := enc.Encode(host); err != nil {
+			return err
+		}
+		fmt.Fprintln(w)
+		if *count++; *count%exportFlushEvery == 0 {
+			flush()
+		}
+		return nil
+	})
+
+	exit := "success"
+	if err != nil {
+		exit = "error"
+	}
+	end := time.Now()
+	fmt.Fprintf(w, "<runstats><finished time=\"%d\" timestr=\"%s\" elapsed=\"%.2f\" exit=\"%s\"/>",
+		end.Unix(), end.Format(time.ANSIC), end.Sub(start).Seconds(), exit)
+	fmt.Fprintf(w, "<hosts up=\"%d\" down=\"%d\" total=\"%d\"/></runstats>\n</nmaprun>\n", up, down, up+down)
+	return err
+}
+
+// ── helpers ──────────────────────────────────────────────────────────────────
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+func indexOr(values []string, i int, def string) string {
+	if i < len(values) {
+		return values[i]
+	}
+	return def
+}
+
+// parseTimeOrAge accepts an RFC 3339 timestamp or an age relative to now
+// ("7d", "12h"). An empty string yields the zero time.
+func parseTimeOrAge(v string) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	age, err := parseAge(v)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q: expected RFC 3339 or an age like 7d", v)
+	}
+	return time.Now().Add(-age), nil
+}
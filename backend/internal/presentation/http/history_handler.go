@@ -2,15 +2,20 @@ package rest
 
 import (
 	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"backend/domain/models"
+	"backend/internal/application/services"
 )
 
 type HistoryHandler struct {
-	repo RepositoryInterface
+	repo      RepositoryInterface
+	retention *services.RetentionService
 }
 
 type RepositoryInterface interface {
@@ -37,10 +42,14 @@ type RepositoryInterface interface {
 	SaveTCPHistory(record *models.TCPHistoryRecord) error
 	GetTCPHistory(limit int) ([]models.TCPHistoryRecord, error)
 	DeleteTCPHistory() error
+
+	SavePluginHistory(collection string, record *models.PluginHistoryRecord) error
+	GetPluginHistory(collection string, limit int) ([]models.PluginHistoryRecord, error)
+	DeletePluginHistory(collection string) error
 }
 
-func NewHistoryHandler(repo RepositoryInterface) *HistoryHandler {
-	return &HistoryHandler{repo: repo}
+func NewHistoryHandler(repo RepositoryInterface, retention *services.RetentionService) *HistoryHandler {
+	return &HistoryHandler{repo: repo, retention: retention}
 }
 
 func (h *HistoryHandler) GetARPHistory(w http.ResponseWriter, r *http.Request) {
@@ -105,7 +114,18 @@ func (h *HistoryHandler) DeleteARPHistory(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	err := h.repo.DeleteARPHistory()
+	filter, selective, err := parseDeleteFilter(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(models.HistoryResponse{Success: false, Error: err.Error()})
+		return
+	}
+	if selective {
+		h.deleteSelective(w, "arp", filter)
+		return
+	}
+
+	err = h.repo.DeleteARPHistory()
 	if err != nil {
 		log.Printf("Error deleting ARP history: %v", err)
 		response := models.HistoryResponse{
@@ -188,7 +208,18 @@ func (h *HistoryHandler) DeleteICMPHistory(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	err := h.repo.DeleteICMPHistory()
+	filter, selective, err := parseDeleteFilter(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(models.HistoryResponse{Success: false, Error: err.Error()})
+		return
+	}
+	if selective {
+		h.deleteSelective(w, "icmp", filter)
+		return
+	}
+
+	err = h.repo.DeleteICMPHistory()
 	if err != nil {
 		log.Printf("Error deleting ICMP history: %v", err)
 		response := models.HistoryResponse{
@@ -297,8 +328,34 @@ func (h *HistoryHandler) DeleteNmapHistory(w http.ResponseWriter, r *http.Reques
 		scanType = "all"
 	}
 
+	filter, selective, err := parseDeleteFilter(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(models.HistoryResponse{Success: false, Error: err.Error()})
+		return
+	}
+
 	result := make(map[string]string)
 
+	if selective {
+		for _, sub := range []string{"tcp_udp", "os_detection", "host_discovery"} {
+			if scanType != "all" && scanType != sub {
+				continue
+			}
+			deleted, err := h.retention.Delete("nmap_"+sub, filter)
+			if err != nil {
+				log.Printf("Error deleting Nmap %s history: %v", sub, err)
+				result[sub] = "Failed to delete: " + err.Error()
+			} else {
+				result[sub] = fmt.Sprintf("Deleted %d records", deleted)
+			}
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(models.HistoryResponse{Success: true, Data: result})
+		return
+	}
+
 	if scanType == "all" || scanType == "tcp_udp" {
 		err := h.repo.DeleteNmapTcpUdpHistory()
 		if err != nil {
@@ -397,7 +454,20 @@ func (h *HistoryHandler) DeleteTCPHistory(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	err := h.repo.DeleteTCPHistory()
+	filter, selective, err := parseDeleteFilter(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(models.HistoryResponse{Success: false, Error: err.Error()})
+		return
+	}
+	if selective {
+		h.deleteSelective(w, "tcp", filter)
+		return
+	}
+
+	// Goes through the retention service so the MinIO objects referenced
+	// by HexObjectKey are purged together with the records.
+	_, err = h.retention.Delete("tcp", filter)
 	if err != nil {
 		log.Printf("Error deleting TCP history: %v", err)
 		response := models.HistoryResponse{
@@ -417,3 +487,118 @@ func (h *HistoryHandler) DeleteTCPHistory(w http.ResponseWriter, r *http.Request
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(response)
 }
+
+// GET /api/history/summaries?scan_type=nmap_tcp_udp&target=10.0.0.1&limit=100
+//
+// Daily summaries left behind by the retention policy once raw records are
+// older than HISTORY_RETENTION_DAYS.
+func (h *HistoryHandler) GetHistorySummaries(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	limit := 0
+	if l := r.URL.Query().Get("limit"); l != "" {
+		if v, err := strconv.Atoi(l); err == nil && v > 0 {
+			limit = v
+		}
+	}
+
+	records, err := h.retention.GetSummaries(r.URL.Query().Get("scan_type"), r.URL.Query().Get("target"), limit)
+	if err != nil {
+		log.Printf("Error getting history summaries: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(models.HistoryResponse{Success: false, Error: "Failed to retrieve history summaries"})
+		return
+	}
+	if records == nil {
+		records = []models.HistoryDailySummary{}
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(models.HistoryResponse{
+		Success: true,
+		Data:    map[string]interface{}{"retention_days": h.retention.RetentionDays(), "summaries": records},
+		Count:   len(records),
+	})
+}
+
+// deleteSelective runs a filtered delete for scanType and writes the result.
+func (h *HistoryHandler) deleteSelective(w http.ResponseWriter, scanType string, filter models.HistoryDeleteFilter) {
+	deleted, err := h.retention.Delete(scanType, filter)
+	if err != nil {
+		log.Printf("Error deleting %s history: %v", scanType, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(models.HistoryResponse{Success: false, Error: "Failed to delete " + scanType + " history"})
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(models.HistoryResponse{
+		Success: true,
+		Data:    fmt.Sprintf("Deleted %d %s history records", deleted, scanType),
+		Count:   int(deleted),
+	})
+}
+
+// parseDeleteFilter reads the optional selective-delete parameters:
+//
+//	id          — a single record
+//	target      — ip_range (ARP), target (ICMP), ip (Nmap) or host (TCP)
+//	older_than  — age such as "30d" or "12h"
+//	before      — RFC 3339 timestamp
+//
+// selective is false when none of them is present, in which case the caller
+// keeps the delete-everything behaviour.
+func parseDeleteFilter(r *http.Request) (filter models.HistoryDeleteFilter, selective bool, err error) {
+	q := r.URL.Query()
+	filter.ID = strings.TrimSpace(q.Get("id"))
+	filter.Target = strings.TrimSpace(q.Get("target"))
+
+	if v := q.Get("older_than"); v != "" {
+		age, err := parseAge(v)
+		if err != nil {
+			return filter, false, err
+		}
+		filter.OlderThan = time.Now().Add(-age)
+	}
+	if v := q.Get("before"); v != "" {
+		before, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return filter, false, fmt.Errorf("invalid before %q: expected RFC 3339", v)
+		}
+		if filter.OlderThan.IsZero() || before.Before(filter.OlderThan) {
+			filter.OlderThan = before
+		}
+	}
+
+	selective = filter.ID != "" || filter.Target != "" || !filter.OlderThan.IsZero()
+	return filter, selective, nil
+}
+
+// parseAge accepts Go durations ("36h") plus a day suffix ("30d").
+func parseAge(v string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(v, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid age %q", v)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid age %q", v)
+	}
+	return d, nil
+}
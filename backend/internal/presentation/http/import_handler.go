@@ -0,0 +1,95 @@
+package rest
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"backend/domain/models"
+	"backend/internal/application/services"
+)
+
+// maxImportSize bounds the upload size of a single import.
+const maxImportSize = 64 << 20
+
+// ImportHandler accepts Nmap XML (-oX) and masscan JSON (-oJ) output and
+// stores it as regular history.
+type ImportHandler struct {
+	importer *services.ImportService
+}
+
+func NewImportHandler(importer *services.ImportService) *ImportHandler {
+	return &ImportHandler{importer: importer}
+}
+
+// POST /api/import?format=nmap-xml|masscan-json
+// The body is either the raw file or a multipart form with a "file" field.
+func (h *ImportHandler) Import(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	// Large scan files take longer to upload and store than the server's
+	// timeouts allow.
+	rc := http.NewResponseController(w)
+	_ = rc.SetReadDeadline(time.Time{})
+	_ = rc.SetWriteDeadline(time.Time{})
+
+	format := strings.ToLower(r.URL.Query().Get("format"))
+	switch format {
+	case "xml", "nmap":
+		format = services.ImportFormatNmapXML
+	case "json", "masscan":
+		format = services.ImportFormatMasscanJSON
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportSize)
+	var body io.Reader = r.Body
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(models.HistoryResponse{Success: false, Error: "multipart upload requires a 'file' field"})
+			return
+		}
+		defer file.Close()
+		body = file
+	}
+
+	result, err := h.importer.Import(format, body)
+	if err != nil {
+		log.Printf("[Import] %s import failed: %v", format, err)
+		status := http.StatusBadRequest
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			status = http.StatusRequestEntityTooLarge
+		} else if result != nil {
+			// Parsed fine but MongoDB rejected a record part way through.
+			status = http.StatusInternalServerError
+		}
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(models.HistoryResponse{Success: false, Data: result, Error: err.Error()})
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(models.HistoryResponse{
+		Success: true,
+		Data:    result,
+		Count:   result.TcpUdpRecords + result.OsDetectionCount,
+	})
+}
@@ -0,0 +1,128 @@
+package rest
+
+import (
+	"encoding/xml"
+	"strings"
+
+	"backend/domain/models"
+)
+
+// Minimal subset of the Nmap XML output format (nmap.dtd) — enough for
+// ndiff, Metasploit's db_import and other tools that read `nmap -oX`.
+
+type xmlHost struct {
+	XMLName   xml.Name     `xml:"host"`
+	StartTime int64        `xml:"starttime,attr,omitempty"`
+	Status    xmlState     `xml:"status"`
+	Addresses []xmlAddress `xml:"address"`
+	Ports     *xmlPorts    `xml:"ports,omitempty"`
+	OS        *xmlOS       `xml:"os,omitempty"`
+}
+
+type xmlState struct {
+	State  string `xml:"state,attr"`
+	Reason string `xml:"reason,attr"`
+}
+
+type xmlAddress struct {
+	Addr     string `xml:"addr,attr"`
+	AddrType string `xml:"addrtype,attr"`
+}
+
+type xmlPorts struct {
+	Ports []xmlPort `xml:"port"`
+}
+
+type xmlPort struct {
+	Protocol string     `xml:"protocol,attr"`
+	PortID   uint16     `xml:"portid,attr"`
+	State    xmlState   `xml:"state"`
+	Service  xmlService `xml:"service"`
+}
+
+type xmlService struct {
+	Name string `xml:"name,attr"`
+}
+
+type xmlOS struct {
+	Matches []xmlOSMatch `xml:"osmatch"`
+}
+
+type xmlOSMatch struct {
+	Name     string       `xml:"name,attr"`
+	Accuracy int          `xml:"accuracy,attr"`
+	Classes  []xmlOSClass `xml:"osclass"`
+}
+
+type xmlOSClass struct {
+	Type     string `xml:"type,attr"`
+	Vendor   string `xml:"vendor,attr"`
+	Family   string `xml:"osfamily,attr"`
+	Accuracy int    `xml:"accuracy,attr"`
+}
+
+func xmlAddressOf(ip string) xmlAddress {
+	if strings.Contains(ip, ":") {
+		return xmlAddress{Addr: ip, AddrType: "ipv6"}
+	}
+	return xmlAddress{Addr: ip, AddrType: "ipv4"}
+}
+
+// nmapTcpUdpXMLHost converts a TCP/UDP history record into an Nmap <host>.
+func nmapTcpUdpXMLHost(rec *models.NmapTcpUdpHistoryRecord) xmlHost {
+	addr := rec.Host
+	if addr == "" {
+		addr = rec.IP
+	}
+	host := xmlHost{
+		StartTime: rec.CreatedAt.Unix(),
+		Status:    xmlState{State: "up", Reason: "user-set"},
+		Addresses: []xmlAddress{xmlAddressOf(addr)},
+		Ports:     &xmlPorts{},
+	}
+	for _, info := range rec.PortInfo {
+		if info.Status != "" {
+			host.Status.State = info.Status
+		}
+		for i, port := range info.AllPorts {
+			p := xmlPort{PortID: port, Protocol: "tcp", State: xmlState{State: "unknown", Reason: "unknown"}}
+			if i < len(info.Protocols) {
+				p.Protocol = info.Protocols[i]
+			}
+			if i < len(info.State) {
+				p.State.State = info.State[i]
+			}
+			if i < len(info.ServiceName) {
+				p.Service.Name = info.ServiceName[i]
+			}
+			host.Ports.Ports = append(host.Ports.Ports, p)
+		}
+	}
+	return host
+}
+
+// nmapOsXMLHost converts an OS-detection history record into an Nmap <host>.
+func nmapOsXMLHost(rec *models.NmapOsDetectionHistoryRecord) xmlHost {
+	addr := rec.Host
+	if addr == "" {
+		addr = rec.IP
+	}
+	host := xmlHost{
+		StartTime: rec.CreatedAt.Unix(),
+		Status:    xmlState{State: "up", Reason: "user-set"},
+		Addresses: []xmlAddress{xmlAddressOf(addr)},
+	}
+	if rec.Name != "" && rec.Name != "unknown" {
+		host.OS = &xmlOS{Matches: []xmlOSMatch{{
+			Name:     rec.Name,
+			Accuracy: rec.Accuracy,
+			Classes: []xmlOSClass{{
+				Type:     rec.Type,
+				Vendor:   rec.Vendor,
+				Family:   rec.Family,
+				Accuracy: rec.Accuracy,
+			}},
+		}}}
+	}
+	return host
+}
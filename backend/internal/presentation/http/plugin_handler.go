@@ -0,0 +1,116 @@
+package rest
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"backend/domain/models"
+	"backend/internal/application/services"
+)
+
+// PluginHistoryHandler serves the stored results of plug-in scanners from
+// the collection each one advertises.
+type PluginHistoryHandler struct {
+	registry *services.ScannerRegistry
+	repo     RepositoryInterface
+}
+
+func NewPluginHistoryHandler(registry *services.ScannerRegistry, repo RepositoryInterface) *PluginHistoryHandler {
+	return &PluginHistoryHandler{registry: registry, repo: repo}
+}
+
+// GET /api/history/plugin?service=<name>&limit=<n>
+func (h *PluginHistoryHandler) GetPluginHistory(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, DELETE")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	collection, ok := h.collection(w, r)
+	if !ok {
+		return
+	}
+
+	limit := 0
+	if parsed, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && parsed > 0 {
+		limit = parsed
+	}
+
+	records, err := h.repo.GetPluginHistory(collection, limit)
+	if err != nil {
+		log.Printf("Error getting %s history: %v", collection, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(models.HistoryResponse{Success: false, Error: "Failed to retrieve plugin history"})
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(models.HistoryResponse{Success: true, Data: records, Count: len(records)})
+}
+
+// DELETE /api/history/plugin/delete?service=<name>
+func (h *PluginHistoryHandler) DeletePluginHistory(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, DELETE")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != "DELETE" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	collection, ok := h.collection(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.repo.DeletePluginHistory(collection); err != nil {
+		log.Printf("Error deleting %s history: %v", collection, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(models.HistoryResponse{Success: false, Error: "Failed to delete plugin history"})
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(models.HistoryResponse{Success: true, Data: "Plugin history deleted successfully"})
+}
+
+// collection resolves the service parameter to its storage collection,
+// writing the error response when it cannot.
+func (h *PluginHistoryHandler) collection(w http.ResponseWriter, r *http.Request) (string, bool) {
+	service := r.URL.Query().Get("service")
+	if service == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(models.HistoryResponse{Success: false, Error: "service parameter is required"})
+		return "", false
+	}
+
+	collection, err := h.registry.PluginCollection(service)
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, services.ErrUnknownScanner) {
+			status = http.StatusNotFound
+		}
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(models.HistoryResponse{Success: false, Error: err.Error()})
+		return "", false
+	}
+	return collection, true
+}
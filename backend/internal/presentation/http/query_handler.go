@@ -0,0 +1,143 @@
+package rest
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"backend/domain/models"
+	"backend/internal/application/services"
+)
+
+// QueryHandler serves the search query language:
+//
+//	GET  /api/query?q=port:22 state:open net:10.1.0.0/16&scope=nmap&limit=50
+//	POST /api/query {"query": "...", "scopes": ["nmap"], "limit": 50}
+//	GET  /api/query/fields
+//	GET  /api/query/suggest?field=service&prefix=ht
+type QueryHandler struct {
+	query *services.QueryService
+}
+
+func NewQueryHandler(query *services.QueryService) *QueryHandler {
+	return &QueryHandler{query: query}
+}
+
+func (h *QueryHandler) setCORS(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+}
+
+func (h *QueryHandler) Query(w http.ResponseWriter, r *http.Request) {
+	h.setCORS(w)
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	var body struct {
+		Query  string   `json:"query"`
+		Scopes []string `json:"scopes"`
+		Limit  int      `json:"limit"`
+	}
+	switch r.Method {
+	case "GET":
+		body.Query = r.URL.Query().Get("q")
+		if s := r.URL.Query().Get("scope"); s != "" {
+			body.Scopes = strings.Split(s, ",")
+		}
+		body.Limit, _ = strconv.Atoi(r.URL.Query().Get("limit"))
+	case "POST":
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(models.HistoryResponse{Success: false, Error: "invalid JSON"})
+			return
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q, results, err := h.query.Search(body.Query, body.Scopes, body.Limit)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	total := 0
+	for _, res := range results {
+		total += res.Count
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(models.HistoryResponse{
+		Success: true,
+		Data:    map[string]interface{}{"query": q, "results": results},
+		Count:   total,
+	})
+}
+
+// GET /api/query/fields — field catalogue for autocomplete.
+func (h *QueryHandler) Fields(w http.ResponseWriter, r *http.Request) {
+	h.setCORS(w)
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	fields := h.query.Fields()
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(models.HistoryResponse{
+		Success: true,
+		Data:    map[string]interface{}{"fields": fields, "scopes": models.QueryScopes},
+		Count:   len(fields),
+	})
+}
+
+// GET /api/query/suggest?field=vendor&prefix=cis&scope=arp — value autocomplete.
+func (h *QueryHandler) Suggest(w http.ResponseWriter, r *http.Request) {
+	h.setCORS(w)
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	field := strings.TrimSpace(r.URL.Query().Get("field"))
+	if field == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(models.HistoryResponse{Success: false, Error: "field required"})
+		return
+	}
+
+	values, err := h.query.Suggest(field, r.URL.Query().Get("prefix"), r.URL.Query().Get("scope"))
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(models.HistoryResponse{Success: true, Data: values, Count: len(values)})
+}
+
+func (h *QueryHandler) writeError(w http.ResponseWriter, err error) {
+	var qerr *models.QueryError
+	if errors.As(err, &qerr) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(models.HistoryResponse{Success: false, Error: qerr.Error()})
+		return
+	}
+	log.Printf("Query failed: %v", err)
+	w.WriteHeader(http.StatusInternalServerError)
+	json.NewEncoder(w).Encode(models.HistoryResponse{Success: false, Error: "query failed"})
+}
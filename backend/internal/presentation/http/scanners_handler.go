@@ -0,0 +1,39 @@
+package rest
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"backend/domain/models"
+	"backend/internal/application/services"
+)
+
+// ScannersHandler lists the scanner services known from their heartbeats.
+type ScannersHandler struct {
+	registry *services.ScannerRegistry
+}
+
+func NewScannersHandler(registry *services.ScannerRegistry) *ScannersHandler {
+	return &ScannersHandler{registry: registry}
+}
+
+// GET /api/scanners — services, their instances and capabilities.
+func (h *ScannersHandler) GetScanners(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	scanners := h.registry.Scanners()
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(models.HistoryResponse{Success: true, Data: scanners, Count: len(scanners)})
+}
@@ -2,6 +2,7 @@ package rest
 
 import (
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
 	"strings"
@@ -196,9 +197,14 @@ func (h *SearchHandler) SearchARP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// ip_range may be an address, CIDR or range; interface_name is matched
+	// exactly; mac is a MAC prefix and vendor a substring, both matched
+	// against the devices found.
 	var body struct {
 		InterfaceName string `json:"interface_name"`
 		IPRange       string `json:"ip_range"`
+		MAC           string `json:"mac"`
+		Vendor        string `json:"vendor"`
 		Limit         int    `json:"limit"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
@@ -206,18 +212,29 @@ func (h *SearchHandler) SearchARP(w http.ResponseWriter, r *http.Request) {
 		json.NewEncoder(w).Encode(SearchResponse{Success: false, Error: "invalid JSON"})
 		return
 	}
-	if body.InterfaceName == "" || body.IPRange == "" {
+	if body.IPRange == "" && body.InterfaceName == "" && body.MAC == "" && body.Vendor == "" {
 		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(SearchResponse{Success: false, Error: "interface_name and ip_range required"})
+		json.NewEncoder(w).Encode(SearchResponse{Success: false, Error: "ip_range, interface_name, mac or vendor required"})
 		return
 	}
 	if body.Limit <= 0 {
 		body.Limit = 20
 	}
 
-	records, err := h.repo.GetARPHistoryByIPRange(body.IPRange, body.Limit)
+	records, err := h.repo.SearchARPHistory(models.ARPSearchQuery{
+		IPRange:   strings.TrimSpace(body.IPRange),
+		Interface: strings.TrimSpace(body.InterfaceName),
+		MACPrefix: strings.TrimSpace(body.MAC),
+		Vendor:    strings.TrimSpace(body.Vendor),
+	}, body.Limit)
+	var qerr *models.QueryError
+	if errors.As(err, &qerr) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(SearchResponse{Success: false, Error: qerr.Error()})
+		return
+	}
 	if err != nil {
-		log.Printf("Search ARP by ip_range: %v", err)
+		log.Printf("Search ARP: %v", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		json.NewEncoder(w).Encode(SearchResponse{Success: false, Error: "search failed"})
 		return
@@ -0,0 +1,134 @@
+package rest
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"backend/domain/models"
+	"backend/internal/application/services"
+)
+
+// StatsHandler serves the dashboard aggregations. Every endpoint accepts
+//
+//	since, until   RFC 3339 timestamps or ages such as "7d" (default: last 30 days)
+//	subnet         IPv4 address, CIDR or range, e.g. 10.1.0.0/16
+//	bucket         "hour" or "day" for time series
+//	limit          length of top-N lists (default 20)
+type StatsHandler struct {
+	stats *services.StatsService
+}
+
+func NewStatsHandler(stats *services.StatsService) *StatsHandler {
+	return &StatsHandler{stats: stats}
+}
+
+// GET /api/stats — every aggregation in one response.
+func (h *StatsHandler) GetOverview(w http.ResponseWriter, r *http.Request) {
+	h.serve(w, r, func(f models.StatsFilter) (interface{}, error) { return h.stats.Overview(f) })
+}
+
+// GET /api/stats/ports — open ports by number of hosts.
+func (h *StatsHandler) GetPorts(w http.ResponseWriter, r *http.Request) {
+	h.serve(w, r, func(f models.StatsFilter) (interface{}, error) { return h.stats.OpenPorts(f) })
+}
+
+// GET /api/stats/services — top services on open ports.
+func (h *StatsHandler) GetServices(w http.ResponseWriter, r *http.Request) {
+	h.serve(w, r, func(f models.StatsFilter) (interface{}, error) { return h.stats.Services(f) })
+}
+
+// GET /api/stats/vendors — vendor breakdown of ARP devices.
+func (h *StatsHandler) GetVendors(w http.ResponseWriter, r *http.Request) {
+	h.serve(w, r, func(f models.StatsFilter) (interface{}, error) { return h.stats.Vendors(f) })
+}
+
+// GET /api/stats/os — OS family breakdown.
+func (h *StatsHandler) GetOSFamilies(w http.ResponseWriter, r *http.Request) {
+	h.serve(w, r, func(f models.StatsFilter) (interface{}, error) { return h.stats.OSFamilies(f) })
+}
+
+// GET /api/stats/hosts — hosts up/down per bucket.
+func (h *StatsHandler) GetHosts(w http.ResponseWriter, r *http.Request) {
+	h.serve(w, r, func(f models.StatsFilter) (interface{}, error) { return h.stats.Hosts(f) })
+}
+
+// GET /api/stats/events — change events per severity per day.
+func (h *StatsHandler) GetEvents(w http.ResponseWriter, r *http.Request) {
+	h.serve(w, r, func(f models.StatsFilter) (interface{}, error) { return h.stats.Events(f) })
+}
+
+// GET /api/stats/scanners — scan volume per scanner service.
+func (h *StatsHandler) GetScanners(w http.ResponseWriter, r *http.Request) {
+	h.serve(w, r, func(f models.StatsFilter) (interface{}, error) { return h.stats.ScannerVolume(f) })
+}
+
+func (h *StatsHandler) serve(w http.ResponseWriter, r *http.Request, run func(models.StatsFilter) (interface{}, error)) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	f, err := parseStatsFilter(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(models.HistoryResponse{Success: false, Error: err.Error()})
+		return
+	}
+
+	data, err := run(f)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, services.ErrInvalidStatsFilter) {
+			status = http.StatusBadRequest
+		} else {
+			log.Printf("Error computing stats %s: %v", r.URL.Path, err)
+			err = errors.New("stats query failed")
+		}
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(models.HistoryResponse{Success: false, Error: err.Error()})
+		return
+	}
+
+	count := 0
+	if v := reflect.ValueOf(data); v.Kind() == reflect.Slice {
+		count = v.Len()
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(models.HistoryResponse{Success: true, Data: data, Count: count})
+}
+
+func parseStatsFilter(r *http.Request) (models.StatsFilter, error) {
+	params := r.URL.Query()
+	f := models.StatsFilter{
+		Subnet: strings.TrimSpace(params.Get("subnet")),
+		Bucket: strings.ToLower(strings.TrimSpace(params.Get("bucket"))),
+	}
+	if l := params.Get("limit"); l != "" {
+		if v, err := strconv.Atoi(l); err == nil && v > 0 {
+			f.Limit = v
+		}
+	}
+
+	var err error
+	if f.Since, err = parseTimeOrAge(params.Get("since")); err != nil {
+		return f, err
+	}
+	if f.Until, err = parseTimeOrAge(params.Get("until")); err != nil {
+		return f, err
+	}
+	return f, nil
+}
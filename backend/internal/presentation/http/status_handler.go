@@ -0,0 +1,59 @@
+package rest
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"backend/domain/models"
+	"backend/internal/application/services"
+)
+
+// StatusHandler serves the system status and the liveness / readiness
+// probes.
+type StatusHandler struct {
+	status *services.StatusService
+}
+
+func NewStatusHandler(status *services.StatusService) *StatusHandler {
+	return &StatusHandler{status: status}
+}
+
+// GET /api/status — MongoDB, RabbitMQ, MinIO and per-scanner queue state.
+// Always 200; the overall state is in the body.
+func (h *StatusHandler) GetStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(models.HistoryResponse{Success: true, Data: h.status.Status()})
+}
+
+// GET /health/live — the process is up and serving HTTP.
+func (h *StatusHandler) Live(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": models.StatusOK})
+}
+
+// GET /health/ready — 200 once MongoDB answers and RabbitMQ is connected,
+// 503 otherwise.
+func (h *StatusHandler) Ready(w http.ResponseWriter, r *http.Request) {
+	ready, checks := h.status.Ready()
+	w.Header().Set("Content-Type", "application/json")
+	status := models.StatusOK
+	if !ready {
+		status = models.StatusDown
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": status, "checks": checks})
+}
@@ -0,0 +1,88 @@
+package rest
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"backend/domain/models"
+)
+
+const shortWriteTimeout = 100 * time.Millisecond
+
+// serveWithWriteTimeout serves handler with a WriteTimeout far shorter
+// than the streams it is tested with.
+func serveWithWriteTimeout(t *testing.T, handler http.HandlerFunc) *httptest.Server {
+	srv := httptest.NewUnstartedServer(handler)
+	srv.Config.WriteTimeout = shortWriteTimeout
+	srv.Start()
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestExportOutlivesWriteTimeout(t *testing.T) {
+	h := &ExportHandler{}
+	srv := serveWithWriteTimeout(t, func(w http.ResponseWriter, r *http.Request) {
+		h.stream(w, "ndjson", exportSpec{name: "test"}, func(emit func(interface{}) error) error {
+			for i := 0; i < 3; i++ {
+				if err := emit(map[string]int{"n": i}); err != nil {
+					return err
+				}
+				time.Sleep(shortWriteTimeout)
+			}
+			return nil
+		})
+	})
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("export cut off after %q: %v", body, err)
+	}
+	if lines := strings.Count(string(body), "\n"); lines != 3 {
+		t.Errorf("export has %d records, want 3:\n%s", lines, body)
+	}
+}
+
+// changesRepo returns one new event on every poll.
+type changesRepo struct{}
+
+func (changesRepo) GetChangeEvents(int, string) ([]models.ChangeEvent, error) { return nil, nil }
+func (changesRepo) GetChangeEventsSince(time.Time) ([]models.ChangeEvent, error) {
+	return []models.ChangeEvent{{CreatedAt: time.Now()}}, nil
+}
+func (changesRepo) DeleteChangeEvents() error { return nil }
+
+func TestStreamChangesOutlivesWriteTimeout(t *testing.T) {
+	defer func(poll, keepalive time.Duration) { ssePollInterval, sseKeepalive = poll, keepalive }(ssePollInterval, sseKeepalive)
+	ssePollInterval, sseKeepalive = shortWriteTimeout/2, time.Hour
+
+	srv := serveWithWriteTimeout(t, NewChangesHandler(changesRepo{}).StreamChanges)
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	// Events keep arriving well past the write timeout.
+	deadline := time.Now().Add(5 * shortWriteTimeout)
+	buf := make([]byte, 4096)
+	var events int
+	for time.Now().Before(deadline) {
+		n, err := resp.Body.Read(buf)
+		if err != nil {
+			t.Fatalf("stream cut off after %d events: %v", events, err)
+		}
+		events += strings.Count(string(buf[:n]), "event: change")
+	}
+	if events < 5 {
+		t.Errorf("%d events in %s, want one every %s", events, 5*shortWriteTimeout, ssePollInterval)
+	}
+}
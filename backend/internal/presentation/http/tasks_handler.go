@@ -0,0 +1,115 @@
+package rest
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"backend/domain/models"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// TaskStore is the read side of the scan task store.
+type TaskStore interface {
+	Get(taskID string) (*models.ScanTask, error)
+	Find(q models.TaskQuery) ([]models.ScanTask, int64, error)
+}
+
+// TasksHandler lists the scan requests published, with their parameters,
+// requester and final state.
+type TasksHandler struct {
+	tasks TaskStore
+}
+
+func NewTasksHandler(tasks TaskStore) *TasksHandler {
+	return &TasksHandler{tasks: tasks}
+}
+
+// GET /api/tasks?status=pending&service=nmap_service&target=10.0.0.&since=1h&until=…&limit=100
+//
+// since/until accept RFC 3339 timestamps or ages such as "7d"; target
+// matches the start of the task's target parameter. Count is the number of
+// tasks matching, which may exceed the number returned.
+func (h *TasksHandler) GetTasks(w http.ResponseWriter, r *http.Request) {
+	if !h.prepare(w, r) {
+		return
+	}
+
+	params := r.URL.Query()
+	q := models.TaskQuery{
+		Status:  params.Get("status"),
+		Service: params.Get("service"),
+		Target:  strings.TrimSpace(params.Get("target")),
+	}
+	if q.Status != "" && !slices.Contains(models.TaskStatuses, q.Status) {
+		writeJSON(w, http.StatusBadRequest, models.HistoryResponse{
+			Success: false,
+			Error:   "unsupported status: " + q.Status + " (expected " + strings.Join(models.TaskStatuses, ", ") + ")",
+		})
+		return
+	}
+	if parsed, err := strconv.Atoi(params.Get("limit")); err == nil && parsed > 0 {
+		q.Limit = parsed
+	}
+	var err error
+	if q.Since, err = parseTimeOrAge(params.Get("since")); err != nil {
+		writeJSON(w, http.StatusBadRequest, models.HistoryResponse{Success: false, Error: err.Error()})
+		return
+	}
+	if q.Until, err = parseTimeOrAge(params.Get("until")); err != nil {
+		writeJSON(w, http.StatusBadRequest, models.HistoryResponse{Success: false, Error: err.Error()})
+		return
+	}
+
+	tasks, total, err := h.tasks.Find(q)
+	if err != nil {
+		log.Printf("Error listing tasks: %v", err)
+		writeJSON(w, http.StatusInternalServerError, models.HistoryResponse{Success: false, Error: "Failed to list tasks"})
+		return
+	}
+	writeJSON(w, http.StatusOK, models.HistoryResponse{Success: true, Data: tasks, Count: int(total)})
+}
+
+// GET /api/tasks/by-id?id=<task id>
+func (h *TasksHandler) GetTaskByID(w http.ResponseWriter, r *http.Request) {
+	if !h.prepare(w, r) {
+		return
+	}
+	id, ok := requireID(w, r)
+	if !ok {
+		return
+	}
+
+	task, err := h.tasks.Get(id)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		writeJSON(w, http.StatusNotFound, models.HistoryResponse{Success: false, Error: "not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("Error reading task %s: %v", id, err)
+		writeJSON(w, http.StatusInternalServerError, models.HistoryResponse{Success: false, Error: "Failed to read task"})
+		return
+	}
+	writeJSON(w, http.StatusOK, models.HistoryResponse{Success: true, Data: task})
+}
+
+func (h *TasksHandler) prepare(w http.ResponseWriter, r *http.Request) bool {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return false
+	}
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return false
+	}
+	return true
+}
@@ -1,15 +1,28 @@
 package websocket
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"log"
+	"log/slog"
+	"net"
 	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
 
 	"backend/domain/models"
 	api "backend/internal/application"
+	"backend/internal/application/services"
+	rabbitmq "backend/internal/infrastructure/messaging"
+	"backend/internal/infrastructure/telemetry"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type WSHandler struct {
@@ -22,21 +35,100 @@ func NewWSHandler(app *api.App) *WSHandler {
 	}
 }
 
+// Keepalive and flow limits of a connection.
+const (
+	// writeWait bounds the write of one frame.
+	writeWait = 10 * time.Second
+	// pongWait is how long the connection may stay silent; the backend
+	// pings every pingPeriod, so a live client always answers in time.
+	pongWait   = 60 * time.Second
+	pingPeriod = pongWait * 9 / 10
+	// maxMessageSize bounds a frame from the client.
+	maxMessageSize = 1 << 20
+	// maxInFlight bounds the scan requests a connection has running.
+	maxInFlight = 16
+)
+
+// Message is a WebSocket frame in either direction.
+//
+// A client sends {"type":"scan","id":…,"request":{…}} to run a scan; id is
+// its own and is echoed on everything sent back about the request. The
+// backend answers "accepted" with the task_id at once, then "response"
+// with the result; requests run concurrently, at most maxInFlight of them
+// per connection. While the scan runs the client gets its "progress" and
+// "result_chunk" messages.
+//
+// {"type":"subscribe","id":…,"topic":…} subscribes to a topic: "task" with
+// a task_id for the chunks and result of a task, e.g. once a timed-out
+// scan finishes or after a backend restart, "changes" with an optional
+// "severity" list, "tasks" or "inventory"; "unsubscribe" ends it. Both are
+// answered with "subscribed"/"unsubscribed". Clients start subscribed to
+// "changes" and "tasks". A malformed or unsupported message is answered
+// with "error". {"type":"ping"} is answered with "pong", for clients that
+// cannot send ping frames.
 type Message struct {
-	Type   string               `json:"type"`
-	Req    *models.Request      `json:"request,omitempty"`
-	Resp   *models.Response     `json:"response,omitempty"`
-	Change *models.ChangeEvent  `json:"change,omitempty"`
+	Type      string                  `json:"type"`
+	ID        string                  `json:"id,omitempty"`
+	TaskID    string                  `json:"task_id,omitempty"`
+	Topic     string                  `json:"topic,omitempty"`
+	Severity  []string                `json:"severity,omitempty"`
+	Error     string                  `json:"error,omitempty"`
+	Req       *models.Request         `json:"request,omitempty"`
+	Resp      *models.Response        `json:"response,omitempty"`
+	Change    *models.ChangeEvent     `json:"change,omitempty"`
+	Task      *models.TaskUpdate      `json:"task,omitempty"`
+	Chunk     *models.ResultChunk     `json:"chunk,omitempty"`
+	Progress  *models.ScanProgress    `json:"progress,omitempty"`
+	Inventory *models.InventoryUpdate `json:"inventory,omitempty"`
 }
 
 type Client struct {
-	conn *websocket.Conn
-	send chan Message
-	app  *api.App
+	conn      *websocket.Conn
+	send      chan Message
+	app       *api.App
+	requester models.TaskRequester
+	topics    *topics
+	// inflight holds a token per scan request running.
+	inflight chan struct{}
+	// quit asks the write pump to flush, send a close frame with the
+	// reason and stop.
+	quit chan string
+	// done is closed, and the connection with it, once either pump stops.
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// allowedOrigins are the origins, besides the backend's own host, whose
+// pages may open /ws; "*" allows any.
+var allowedOrigins []string
+
+// SetAllowedOrigins sets the origins, besides the backend's own host, whose
+// pages may open /ws. Call it before serving.
+func SetAllowedOrigins(origins []string) {
+	allowedOrigins = origins
+}
+
+// checkOrigin accepts clients that are not browsers (no Origin header),
+// pages served from the host the request was sent to, and allowedOrigins.
+func checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	for _, allowed := range allowedOrigins {
+		if allowed == "*" || strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+			return true
+		}
+	}
+	log.Printf("[WS] Rejected connection from origin %s", origin)
+	return false
 }
 
 var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool { return true },
+	CheckOrigin: checkOrigin,
 }
 
 func (h *WSHandler) WsHandler(w http.ResponseWriter, r *http.Request) {
@@ -47,9 +139,14 @@ func (h *WSHandler) WsHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	client := &Client{
-		conn: conn,
-		send: make(chan Message, 256),
-		app:  h.app,
+		conn:      conn,
+		send:      make(chan Message, 256),
+		app:       h.app,
+		requester: requesterOf(r),
+		topics:    defaultTopics(),
+		inflight:  make(chan struct{}, maxInFlight),
+		quit:      make(chan string, 1),
+		done:      make(chan struct{}),
 	}
 
 	globalHub.Register(client)
@@ -57,56 +154,215 @@ func (h *WSHandler) WsHandler(w http.ResponseWriter, r *http.Request) {
 	go client.readPump()
 }
 
+// requesterOf identifies the client behind r for the tasks it publishes,
+// preferring the first X-Forwarded-For address when behind a proxy.
+func requesterOf(r *http.Request) models.TaskRequester {
+	address := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		address = host
+	}
+	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+		address = strings.TrimSpace(strings.Split(forwarded, ",")[0])
+	}
+	return models.TaskRequester{
+		Source:    "websocket",
+		Address:   address,
+		UserAgent: r.UserAgent(),
+	}
+}
+
+// close ends the connection; pending deliveries give up.
+func (c *Client) close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.conn.Close()
+	})
+}
+
+// shutdown asks the client to go away once the messages queued for it are
+// written. It returns once the connection is closed or ctx is done.
+func (c *Client) shutdown(ctx context.Context, reason string) {
+	select {
+	case c.quit <- reason:
+	default:
+	}
+	select {
+	case <-c.done:
+	case <-ctx.Done():
+		c.close()
+	}
+}
+
+// deliver queues msg for the client, waiting while its buffer is full. It
+// reports false once the connection is gone.
+func (c *Client) deliver(msg Message) bool {
+	select {
+	case c.send <- msg:
+		return true
+	case <-c.done:
+		return false
+	}
+}
+
 func (c *Client) readPump() {
 	defer func() {
 		globalHub.Unregister(c)
-		c.conn.Close()
+		c.close()
 	}()
 
-	for {
-		var msg Message
+	c.conn.SetReadLimit(maxMessageSize)
+	c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
 
-		if err := c.conn.ReadJSON(&msg); err != nil {
+	for {
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
 				log.Printf("WebSocket read error: %v", err)
 			}
 			break
 		}
+		c.conn.SetReadDeadline(time.Now().Add(pongWait))
+
+		var msg Message
+		if err := json.Unmarshal(data, &msg); err != nil {
+			c.deliver(Message{Type: "error", Error: "malformed message: " + err.Error()})
+			continue
+		}
 
 		scannerService := ""
 		if msg.Req != nil {
 			scannerService = msg.Req.ScannerService
 		}
-		log.Printf("Received message type=%s, scanner_service=%s", msg.Type, scannerService)
+		log.Printf("Received message type=%s, id=%s, scanner_service=%s", msg.Type, msg.ID, scannerService)
+
+		switch {
+		case msg.Type == "subscribe":
+			c.subscribe(msg)
+		case msg.Type == "unsubscribe":
+			c.unsubscribe(msg)
+		case msg.Type == "ping":
+			c.deliver(Message{Type: "pong", ID: msg.ID})
+		case msg.Req != nil:
+			c.startRequest(msg)
+		default:
+			c.deliver(Message{Type: "error", ID: msg.ID, Error: "unsupported message type: " + msg.Type})
+		}
+	}
+}
 
-		if msg.Req != nil {
-			response := c.processRequest(msg.Req)
+// startRequest runs the scan msg asks for in the background, answering
+// "accepted" with its task id now and "response" once it is done.
+func (c *Client) startRequest(msg Message) {
+	select {
+	case c.inflight <- struct{}{}:
+	default:
+		c.deliver(Message{Type: "error", ID: msg.ID, Error: fmt.Sprintf("too many requests in flight (at most %d)", maxInFlight)})
+		return
+	}
+
+	taskID := generateTaskID()
+	c.deliver(Message{Type: "accepted", ID: msg.ID, TaskID: taskID})
+	go func() {
+		defer func() { <-c.inflight }()
+		response := c.processRequest(taskID, msg.Req)
+		c.deliver(Message{Type: "response", ID: msg.ID, TaskID: taskID, Resp: response})
+	}()
+}
+
+// subscribe handles {"type":"subscribe"}. A task_id without a topic, as
+// clients sent before topics existed, subscribes to that task.
+func (c *Client) subscribe(msg Message) {
+	topic := msg.Topic
+	if topic == "" && msg.TaskID != "" {
+		topic = TopicTask
+	}
+	var err error
+	switch {
+	case topic == TopicTask && msg.TaskID == "":
+		err = fmt.Errorf("task_id is required for topic %q", TopicTask)
+	case topic == TopicTask:
+		globalHub.Subscribe(msg.TaskID, c)
+	default:
+		err = c.topics.subscribe(topic, msg.Severity)
+	}
+	if err != nil {
+		c.deliver(Message{Type: "error", ID: msg.ID, Error: err.Error()})
+		return
+	}
+	c.deliver(Message{Type: "subscribed", ID: msg.ID, Topic: topic, TaskID: msg.TaskID, Severity: msg.Severity})
+}
 
-			c.send <- Message{
-				Type: "response",
-				Resp: response,
+func (c *Client) unsubscribe(msg Message) {
+	topic := msg.Topic
+	if topic == "" && msg.TaskID != "" {
+		topic = TopicTask
+	}
+	var err error
+	if topic == TopicTask {
+		globalHub.Unsubscribe(msg.TaskID, c)
+	} else {
+		err = c.topics.unsubscribe(topic)
+	}
+	if err != nil {
+		c.deliver(Message{Type: "error", ID: msg.ID, Error: err.Error()})
+		return
+	}
+	c.deliver(Message{Type: "unsubscribed", ID: msg.ID, Topic: topic, TaskID: msg.TaskID})
+}
+
+// processRequest opens the root span of the scan of taskID; the span and
+// the task id are carried by ctx through the publisher to the scanner.
+func (c *Client) processRequest(taskID string, req *models.Request) *models.Response {
+	ctx, span := telemetry.Tracer().Start(telemetry.WithTaskID(context.Background(), taskID), "scan request",
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("task_id", taskID),
+			attribute.String("scanner", req.ScannerService),
+		))
+	defer span.End()
+	ctx = services.WithRequester(ctx, c.requester)
+
+	globalHub.Follow(taskID, c)
+	defer globalHub.Unfollow(taskID, c)
+
+	// An explicitly chosen agent overrides routing by target subnet.
+	if req.Agent != "" {
+		ctx = rabbitmq.WithAgent(ctx, req.Agent)
+		span.SetAttributes(attribute.String("scanner.site", req.Agent))
+	}
+
+	// Requests without a lane are interactive.
+	if req.Priority != "" {
+		if _, ok := models.PriorityRank(req.Priority); !ok {
+			return &models.Response{
+				TaskID: taskID,
+				Result: map[string]string{
+					"error": "unsupported priority: " + req.Priority + " (expected " + strings.Join(models.Priorities, ", ") + ")",
+				},
 			}
 		}
+		ctx = rabbitmq.WithPriority(ctx, req.Priority)
 	}
-}
 
-func (c *Client) processRequest(req *models.Request) *models.Response {
-	taskID := generateTaskID()
-	log.Printf("Processing request for scanner_service: %s", req.ScannerService)
-	log.Printf("Request options: %+v", req.Options)
+	slog.InfoContext(ctx, "processing scan request", "scanner", req.ScannerService, "agent", req.Agent, "priority", req.Priority)
 
 	switch req.ScannerService {
 	case "arp_service":
-		return c.processARPRequest(req.Options, taskID)
+		return c.processARPRequest(ctx, req.Options, taskID)
 	case "icmp_service", "ping_service":
-		return c.processICMPRequest(req.Options, taskID)
+		return c.processICMPRequest(ctx, req.Options, taskID)
 	case "nmap_service":
-		log.Printf("Calling processNmapRequest")
-		return c.processNmapRequest(req.Options, taskID)
+		return c.processNmapRequest(ctx, req.Options, taskID)
 	case "tcp_service":
-		return c.processTCPRequest(req.Options, taskID)
+		return c.processTCPRequest(ctx, req.Options, taskID)
 	default:
-		log.Printf("Unsupported scanner_service: %s", req.ScannerService)
+		if response, err := c.app.ProcessPluginRequest(ctx, taskID, req); err == nil {
+			return response
+		}
+		slog.WarnContext(ctx, "unsupported scanner service", "scanner", req.ScannerService)
 		return &models.Response{
 			TaskID: taskID,
 			Result: map[string]string{
@@ -116,7 +372,7 @@ func (c *Client) processRequest(req *models.Request) *models.Response {
 	}
 }
 
-func (c *Client) processARPRequest(options any, taskID string) *models.Response {
+func (c *Client) processARPRequest(ctx context.Context, options any, taskID string) *models.Response {
 	var arpOpts struct {
 		InterfaceName string `json:"interface_name"`
 		IPRange       string `json:"ip_range"`
@@ -148,13 +404,15 @@ func (c *Client) processARPRequest(options any, taskID string) *models.Response
 		IPRange:       arpOpts.IPRange,
 	}
 
-	return c.app.ProcessRequest(&models.Request{
+	slog.InfoContext(ctx, "arp scan requested", "scanner", "arp_service",
+		"target", arpRequest.IPRange, "interface", arpRequest.InterfaceName)
+	return c.app.ProcessRequest(ctx, &models.Request{
 		ScannerService: "arp_service",
 		Options:        arpRequest,
 	})
 }
 
-func (c *Client) processICMPRequest(options any, taskID string) *models.Response {
+func (c *Client) processICMPRequest(ctx context.Context, options any, taskID string) *models.Response {
 	var icmpOpts struct {
 		Targets   []string `json:"targets"`
 		PingCount int      `json:"ping_count"`
@@ -183,15 +441,15 @@ func (c *Client) processICMPRequest(options any, taskID string) *models.Response
 		PingCount: icmpOpts.PingCount,
 	}
 
-	return c.app.ProcessRequest(&models.Request{
+	slog.InfoContext(ctx, "icmp scan requested", "scanner", "icmp_service",
+		"target", strings.Join(icmpRequest.Targets, ","), "ping_count", icmpRequest.PingCount)
+	return c.app.ProcessRequest(ctx, &models.Request{
 		ScannerService: "icmp_service",
 		Options:        icmpRequest,
 	})
 }
 
-func (c *Client) processNmapRequest(options any, taskID string) *models.Response {
-	log.Printf("Processing Nmap request with options: %+v", options)
-
+func (c *Client) processNmapRequest(ctx context.Context, options any, taskID string) *models.Response {
 	var nmapOpts struct {
 		ScanMethod  string `json:"scan_method"`
 		IP          string `json:"ip"`
@@ -200,15 +458,15 @@ func (c *Client) processNmapRequest(options any, taskID string) *models.Response
 	}
 
 	if err := parseOptions(options, &nmapOpts); err != nil {
-		log.Printf("Failed to parse Nmap options: %v", err)
+		slog.WarnContext(ctx, "invalid nmap options", "scanner", "nmap_service", "error", err)
 		return &models.Response{
 			TaskID: taskID,
 			Result: map[string]string{"error": "invalid Nmap options: " + err.Error()},
 		}
 	}
 
-	log.Printf("Parsed Nmap options: ScanMethod=%s, IP=%s, Ports=%s, ScannerType=%s",
-		nmapOpts.ScanMethod, nmapOpts.IP, nmapOpts.Ports, nmapOpts.ScannerType)
+	slog.InfoContext(ctx, "nmap scan requested", "scanner", "nmap_service",
+		"target", nmapOpts.IP, "scan_method", nmapOpts.ScanMethod, "ports", nmapOpts.Ports)
 
 	switch nmapOpts.ScanMethod {
 	case "tcp_udp_scan":
@@ -227,8 +485,7 @@ func (c *Client) processNmapRequest(options any, taskID string) *models.Response
 			ScanMethod:  "tcp_udp_scan",
 		}
 
-		log.Printf("Created NmapTcpUdpRequest: %+v", nmapRequest)
-		return c.app.PublishNmapRequest(nmapRequest)
+		return c.app.PublishNmapRequest(ctx, nmapRequest)
 
 	case "os_detection":
 		if nmapOpts.IP == "" {
@@ -244,8 +501,7 @@ func (c *Client) processNmapRequest(options any, taskID string) *models.Response
 			ScanMethod: "os_detection",
 		}
 
-		log.Printf("Created NmapOsDetectionRequest: %+v", nmapRequest)
-		return c.app.PublishNmapRequest(nmapRequest)
+		return c.app.PublishNmapRequest(ctx, nmapRequest)
 
 	case "host_discovery":
 		if nmapOpts.IP == "" {
@@ -261,8 +517,7 @@ func (c *Client) processNmapRequest(options any, taskID string) *models.Response
 			ScanMethod: "host_discovery",
 		}
 
-		log.Printf("Created NmapHostDiscoveryRequest: %+v", nmapRequest)
-		return c.app.PublishNmapRequest(nmapRequest)
+		return c.app.PublishNmapRequest(ctx, nmapRequest)
 
 	default:
 		return &models.Response{
@@ -272,7 +527,7 @@ func (c *Client) processNmapRequest(options any, taskID string) *models.Response
 	}
 }
 
-func (c *Client) processTCPRequest(options any, taskID string) *models.Response {
+func (c *Client) processTCPRequest(ctx context.Context, options any, taskID string) *models.Response {
 	var tcpOpts struct {
 		Host string `json:"host"`
 		Port string `json:"port"`
@@ -304,7 +559,9 @@ func (c *Client) processTCPRequest(options any, taskID string) *models.Response
 		Port:   tcpOpts.Port,
 	}
 
-	return c.app.ProcessRequest(&models.Request{
+	slog.InfoContext(ctx, "tcp scan requested", "scanner", "tcp_service",
+		"target", net.JoinHostPort(tcpRequest.Host, tcpRequest.Port))
+	return c.app.ProcessRequest(ctx, &models.Request{
 		ScannerService: "tcp_service",
 		Options:        tcpRequest,
 	})
@@ -322,21 +579,44 @@ func parseOptions(options any, target interface{}) error {
 	return json.Unmarshal(optionsJSON, target)
 }
 
+// writePump writes queued messages and pings the client every pingPeriod.
 func (c *Client) writePump() {
-	defer c.conn.Close()
+	ticker := time.NewTicker(pingPeriod)
+	defer func() {
+		ticker.Stop()
+		c.close()
+	}()
 
 	for {
 		select {
-		case message, ok := <-c.send:
-			if !ok {
-				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
-				return
-			}
+		case message := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
 			if err := c.conn.WriteJSON(message); err != nil {
 				log.Printf("WebSocket write error: %v", err)
 				return
 			}
-			log.Printf("Sent response: %+v", message.Resp)
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		case reason := <-c.quit:
+			// Whatever is queued goes out first; the read pump then sees
+			// the client's close frame and ends the connection.
+			for len(c.send) > 0 {
+				c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+				if err := c.conn.WriteJSON(<-c.send); err != nil {
+					return
+				}
+			}
+			frame := websocket.FormatCloseMessage(websocket.CloseGoingAway, reason)
+			if err := c.conn.WriteControl(websocket.CloseMessage, frame, time.Now().Add(writeWait)); err != nil {
+				return
+			}
+			<-c.done
+			return
+		case <-c.done:
+			return
 		}
 	}
 }
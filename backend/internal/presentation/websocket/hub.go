@@ -1,19 +1,34 @@
 package websocket
 
-import "sync"
+import (
+	"context"
+	"sync"
+
+	"backend/internal/infrastructure/metrics"
+)
 
 // Hub maintains the set of all active WebSocket clients and allows
 // broadcasting messages to every one of them.
 //
 // A singleton is used so that the RabbitMQ consumer goroutine started in
-// main.go can broadcast change events without knowing individual clients.
+// main.go can broadcast change events without knowing individual clients;
+// each client only gets the broadcasts of the topics it subscribed to.
+// The hub only knows this instance's clients; events reach the clients of
+// the other backend instances through the broadcast exchange.
+//
+// Clients may also subscribe to a task id; the result of that task is sent
+// to them whichever backend instance receives it, and the chunks of a
+// streamed result as they arrive. The client that requested a scan follows
+// its chunks until it gets the result in reply.
 type Hub struct {
-	mu      sync.RWMutex
-	clients map[*Client]struct{}
+	mu            sync.RWMutex
+	clients       map[*Client]struct{}
+	subscriptions map[string]map[*Client]bool // task id → clients, true if waiting for the result
 }
 
 var globalHub = &Hub{
-	clients: make(map[*Client]struct{}),
+	clients:       make(map[*Client]struct{}),
+	subscriptions: make(map[string]map[*Client]bool),
 }
 
 // GetHub returns the process-wide singleton Hub.
@@ -23,22 +38,55 @@ func GetHub() *Hub { return globalHub }
 func (h *Hub) Register(c *Client) {
 	h.mu.Lock()
 	h.clients[c] = struct{}{}
+	metrics.WebSocketClients.Set(float64(len(h.clients)))
 	h.mu.Unlock()
 }
 
-// Unregister removes a client from the hub.
+// Unregister removes a client and its subscriptions from the hub.
 func (h *Hub) Unregister(c *Client) {
 	h.mu.Lock()
 	delete(h.clients, c)
+	for taskID, subscribers := range h.subscriptions {
+		delete(subscribers, c)
+		if len(subscribers) == 0 {
+			delete(h.subscriptions, taskID)
+		}
+	}
+	metrics.WebSocketClients.Set(float64(len(h.clients)))
 	h.mu.Unlock()
 }
 
-// Broadcast sends msg to every connected client.
+// CloseAll sends every client a close frame with reason, once the messages
+// queued for it are written, and waits for the connections to end. Those
+// still open when ctx is done are closed at once.
+func (h *Hub) CloseAll(ctx context.Context, reason string) {
+	h.mu.RLock()
+	clients := make([]*Client, 0, len(h.clients))
+	for c := range h.clients {
+		clients = append(clients, c)
+	}
+	h.mu.RUnlock()
+
+	var wg sync.WaitGroup
+	for _, c := range clients {
+		wg.Add(1)
+		go func(c *Client) {
+			defer wg.Done()
+			c.shutdown(ctx, reason)
+		}(c)
+	}
+	wg.Wait()
+}
+
+// Broadcast sends msg to every connected client subscribed to its topic.
 // Slow / disconnected clients are skipped (non-blocking send).
 func (h *Hub) Broadcast(msg Message) {
 	h.mu.RLock()
 	defer h.mu.RUnlock()
 	for c := range h.clients {
+		if !c.topics.wants(msg) {
+			continue
+		}
 		select {
 		case c.send <- msg:
 		default:
@@ -47,3 +95,88 @@ func (h *Hub) Broadcast(msg Message) {
 	}
 }
 
+
+// Subscribe registers c for the chunks and late result of taskID.
+func (h *Hub) Subscribe(taskID string, c *Client) {
+	h.subscribe(taskID, c, true)
+}
+
+// Follow registers c for the chunks of taskID only.
+func (h *Hub) Follow(taskID string, c *Client) {
+	h.subscribe(taskID, c, false)
+}
+
+func (h *Hub) subscribe(taskID string, c *Client, result bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.clients[c]; !ok {
+		return
+	}
+	subscribers := h.subscriptions[taskID]
+	if subscribers == nil {
+		subscribers = make(map[*Client]bool)
+		h.subscriptions[taskID] = subscribers
+	}
+	subscribers[c] = subscribers[c] || result
+}
+
+// Unsubscribe ends c's subscription to taskID, whether through Subscribe
+// or Follow.
+func (h *Hub) Unsubscribe(taskID string, c *Client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	subscribers := h.subscriptions[taskID]
+	delete(subscribers, c)
+	if len(subscribers) == 0 {
+		delete(h.subscriptions, taskID)
+	}
+}
+
+// Unfollow ends c's Follow of taskID; a Subscribe stays in place.
+func (h *Hub) Unfollow(taskID string, c *Client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	subscribers := h.subscriptions[taskID]
+	if result, ok := subscribers[c]; ok && !result {
+		delete(subscribers, c)
+		if len(subscribers) == 0 {
+			delete(h.subscriptions, taskID)
+		}
+	}
+}
+
+// Stream sends msg, a chunk of the result of taskID, to every client
+// subscribed to or following it.
+func (h *Hub) Stream(taskID string, msg Message) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for c := range h.subscriptions[taskID] {
+		select {
+		case c.send <- msg:
+		default:
+		}
+	}
+}
+
+// Publish sends msg, the result of taskID, to the clients subscribed to it
+// and ends every subscription to the task. It reports how many clients it
+// reached.
+func (h *Hub) Publish(taskID string, msg Message) int {
+	h.mu.Lock()
+	subscribers := h.subscriptions[taskID]
+	delete(h.subscriptions, taskID)
+	h.mu.Unlock()
+
+	sent := 0
+	for c, result := range subscribers {
+		if !result {
+			continue
+		}
+		select {
+		case c.send <- msg:
+			sent++
+		default:
+		}
+	}
+	return sent
+}
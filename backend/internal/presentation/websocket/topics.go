@@ -0,0 +1,93 @@
+package websocket
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Topics a client subscribes to with {"type":"subscribe","topic":…}.
+const (
+	// TopicTask carries the progress, result chunks and result of task_id.
+	TopicTask = "task"
+	// TopicTasks carries a "task_status" message as any task is created
+	// or finished.
+	TopicTasks = "tasks"
+	// TopicChanges carries "change_event" messages, of the severities
+	// listed in the subscription or of all of them.
+	TopicChanges = "changes"
+	// TopicInventory carries an "inventory" message as stored results add
+	// hosts to the device inventory.
+	TopicInventory = "inventory"
+)
+
+// topics records which broadcasts a client receives. New clients get
+// every change event and task update, as before subscriptions existed,
+// and no inventory updates. Task subscriptions are kept by the hub.
+type topics struct {
+	mu         sync.RWMutex
+	tasks      bool
+	changes    bool
+	severities map[string]bool // empty: every severity
+	inventory  bool
+}
+
+func defaultTopics() *topics {
+	return &topics{tasks: true, changes: true}
+}
+
+// wants reports whether msg, broadcast to every client, is for this one.
+func (t *topics) wants(msg Message) bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	switch msg.Type {
+	case "change_event":
+		if !t.changes || msg.Change == nil {
+			return false
+		}
+		return len(t.severities) == 0 || t.severities[strings.ToUpper(msg.Change.Severity)]
+	case "task_status":
+		return t.tasks
+	case "inventory":
+		return t.inventory
+	}
+	return true
+}
+
+// subscribe adds topic; for TopicChanges severities replaces the filter.
+func (t *topics) subscribe(topic string, severities []string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	switch topic {
+	case TopicTasks:
+		t.tasks = true
+	case TopicChanges:
+		t.changes = true
+		t.severities = make(map[string]bool, len(severities))
+		for _, severity := range severities {
+			t.severities[strings.ToUpper(strings.TrimSpace(severity))] = true
+		}
+	case TopicInventory:
+		t.inventory = true
+	default:
+		return fmt.Errorf("unsupported topic: %q", topic)
+	}
+	return nil
+}
+
+func (t *topics) unsubscribe(topic string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	switch topic {
+	case TopicTasks:
+		t.tasks = false
+	case TopicChanges:
+		t.changes = false
+		t.severities = nil
+	case TopicInventory:
+		t.inventory = false
+	default:
+		return fmt.Errorf("unsupported topic: %q", topic)
+	}
+	return nil
+}
@@ -25,8 +25,9 @@ CRITICAL_SERVICES = {
 
 
 class ChangeDetector:
-    def __init__(self, mongo_uri: str, db_name: str):
-        self.client = MongoClient(mongo_uri, serverSelectionTimeoutMS=5000)
+    def __init__(self, mongo_uri: str, db_name: str, **client_options):
+        # client_options carries the TLS settings (tlsCAFile, …) to MongoClient.
+        self.client = MongoClient(mongo_uri, serverSelectionTimeoutMS=5000, **client_options)
         self.db = self.client[db_name]
 
     def run_detection(self) -> list:
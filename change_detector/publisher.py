@@ -6,6 +6,7 @@ The backend Go service consumes from this queue and broadcasts to WebSocket clie
 """
 import json
 import logging
+import ssl
 import time
 
 import pika
@@ -17,8 +18,9 @@ logger = logging.getLogger(__name__)
 class ChangeEventPublisher:
     QUEUE = "change_events"
 
-    def __init__(self, rabbitmq_url: str):
+    def __init__(self, rabbitmq_url: str, tls: dict | None = None):
         self._url    = rabbitmq_url
+        self._tls    = tls or {}
         self._conn   = None
         self._channel = None
 
@@ -28,6 +30,8 @@ class ChangeEventPublisher:
         for attempt in range(1, max_attempts + 1):
             try:
                 params = pika.URLParameters(self._url)
+                if self._url.startswith("amqps://"):
+                    params.ssl_options = self._ssl_options(params.host)
                 params.heartbeat = 60
                 params.blocked_connection_timeout = 30
                 self._conn    = pika.BlockingConnection(params)
@@ -79,6 +83,17 @@ class ChangeEventPublisher:
 
     # ── Internals ─────────────────────────────────────────────────────────────
 
+    def _ssl_options(self, host: str) -> pika.SSLOptions:
+        """
+        TLS for amqps:// URLs, with a client certificate for mutual TLS when one
+        is configured. The files are read on every connect, so a reconnect
+        picks up rotated certificates.
+        """
+        context = ssl.create_default_context(cafile=self._tls.get("ca") or None)
+        if self._tls.get("cert"):
+            context.load_cert_chain(self._tls["cert"], self._tls.get("key") or None)
+        return pika.SSLOptions(context, self._tls.get("server_name") or host)
+
     def _try_reconnect(self) -> None:
         try:
             self.close()
@@ -100,7 +100,7 @@ function ARPRow({ r }) {
             <table>
               <thead><tr><th>IP</th><th>MAC</th><th>Vendor</th><th>Status</th></tr></thead>
               <tbody>
-                {(r.devices ?? []).map((d, i) => (
+                {[...(r.online_devices ?? []), ...(r.offline_devices ?? [])].map((d, i) => (
                   <tr key={i}>
                     <td className="td-mono">{d.ip}</td>
                     <td className="td-mono">{d.mac || '—'}</td>
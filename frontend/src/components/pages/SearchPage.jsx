@@ -83,6 +83,8 @@ export default function SearchPage() {
 function ARPForm({ onSearch, loading }) {
   const [iface,   setIface]   = useState('')
   const [ipRange, setIpRange] = useState('')
+  const [mac,     setMac]     = useState('')
+  const [vendor,  setVendor]  = useState('')
   return (
     <div style={{ display: 'grid', gap: 12 }}>
       <div className="form-group">
@@ -90,10 +92,18 @@ function ARPForm({ onSearch, loading }) {
         <input value={iface} onChange={(e) => setIface(e.target.value)} placeholder="eth0" />
       </div>
       <div className="form-group">
-        <label>IP Range (CIDR)</label>
+        <label>IP Range (CIDR or range)</label>
         <input value={ipRange} onChange={(e) => setIpRange(e.target.value)} placeholder="192.168.1.0/24" />
       </div>
-      <Button variant="primary" loading={loading} onClick={() => onSearch({ interface_name: iface, ip_range: ipRange })} disabled={!iface && !ipRange}>
+      <div className="form-group">
+        <label>MAC Prefix</label>
+        <input value={mac} onChange={(e) => setMac(e.target.value)} placeholder="00:1b:54" />
+      </div>
+      <div className="form-group">
+        <label>Vendor</label>
+        <input value={vendor} onChange={(e) => setVendor(e.target.value)} placeholder="Cisco" />
+      </div>
+      <Button variant="primary" loading={loading} onClick={() => onSearch({ interface_name: iface, ip_range: ipRange, mac, vendor })} disabled={!iface && !ipRange && !mac && !vendor}>
         Search
       </Button>
     </div>
@@ -122,8 +132,8 @@ function NmapForm({ onSearch, loading }) {
   return (
     <div style={{ display: 'grid', gap: 12 }}>
       <div className="form-group">
-        <label>IP Address</label>
-        <input value={ip} onChange={(e) => setIp(e.target.value)} placeholder="192.168.1.1" />
+        <label>IP Address, CIDR or range</label>
+        <input value={ip} onChange={(e) => setIp(e.target.value)} placeholder="192.168.1.0/24" />
       </div>
       <div className="form-group">
         <label>Scan Method</label>
@@ -187,7 +197,7 @@ function ARPResults({ rows }) {
         <table>
           <thead><tr><th>IP</th><th>MAC</th><th>Status</th></tr></thead>
           <tbody>
-            {(r.devices ?? []).slice(0, 10).map((d, j) => (
+            {(r.online_devices ?? []).slice(0, 10).map((d, j) => (
               <tr key={j}><td className="td-mono">{d.ip}</td><td className="td-mono">{d.mac}</td><td><Badge>{d.status}</Badge></td></tr>
             ))}
           </tbody>
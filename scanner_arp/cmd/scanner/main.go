@@ -4,19 +4,37 @@ import (
 	"arp_scanner/internal/config"
 	"arp_scanner/internal/service"
 	"arp_scanner/pkg/logger"
+	"arp_scanner/pkg/tracing"
 	"context"
 	"os"
 	"os/signal"
+	"shared/tlsconfig"
 	"syscall"
 )
 
 func main() {
-	log := logger.New()
 	cfg := config.Load()
+	log := logger.New(cfg.ScannerName)
 
 	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer cancel()
 
+	shutdownTracing, err := tracing.Setup(ctx, cfg.ScannerName, cfg.OTLPEndpoint)
+	if err != nil {
+		log.Errorf("Tracing setup failed: %v", err)
+		os.Exit(1)
+	}
+	defer shutdownTracing(context.Background())
+
+	// SIGHUP re-reads the TLS certificates for new connections.
+	go tlsconfig.WatchSIGHUP(ctx, func(err error) {
+		if err != nil {
+			log.Errorf("TLS certificate reload failed: %v", err)
+			return
+		}
+		log.Info("TLS certificates reloaded")
+	})
+
 	if err := service.Run(ctx, cfg, *log); err != nil {
 		log.Errorf("Service failed: %v", err)
 		os.Exit(1)
@@ -2,20 +2,85 @@ package handler
 
 import (
 	"arp_scanner/internal/scanner"
+	"arp_scanner/pkg/heartbeat"
 	"arp_scanner/pkg/logger"
 	"arp_scanner/pkg/queue"
+	"arp_scanner/pkg/tracing"
 	"context"
 	"encoding/json"
+	"fmt"
+	"shared/metrics"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
-func HandleMessage(ctx context.Context, msg queue.Delivery, rabbitMQ *queue.RabbitMQ, log logger.Logger) {
+// Capabilities are advertised in the scanner's heartbeats.
+var Capabilities = heartbeat.Capabilities{
+	ScanMethods: []string{"arp_scan"},
+	OptionsSchema: `{
+    "type": "object",
+    "required": ["interface_name", "ip_range"],
+    "properties": {
+      "interface_name": {"type": "string", "description": "Network interface to send ARP requests on"},
+      "ip_range": {"type": "string", "description": "CIDR or address range, e.g. 192.168.1.0/24"}
+    }
+  }`,
+	ResultSchema: `{
+    "type": "object",
+    "required": ["task_id", "status"],
+    "properties": {
+      "task_id": {"type": "string"},
+      "status": {"type": "string", "enum": ["completed", "failed"]},
+      "devices": {"type": "array", "items": {"type": "object", "properties": {
+        "ip": {"type": "string"}, "mac": {"type": "string"}, "vendor": {"type": "string"}, "status": {"type": "string"}
+      }}},
+      "total_count": {"type": "integer"},
+      "online_count": {"type": "integer"},
+      "offline_count": {"type": "integer"},
+      "error": {"type": "string"}
+    }
+  }`,
+	Collection: "l2_devices",
+}
+
+// Inspect returns the task id of a request and what it scans, for
+// idempotency and per-target locking. The target is the whole range, so
+// overlapping sweeps of one segment do not run side by side.
+func Inspect(body []byte) (taskID string, targets []string) {
+	var req queue.ARPRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return "", nil
+	}
+	return req.TaskID, []string{req.IPRange}
+}
+
+// HandleMessage runs the scan a request asks for and replies with the
+// result. It returns an error only for requests that can never be
+// processed; those are dead-lettered rather than retried.
+func HandleMessage(ctx context.Context, msg queue.Delivery, rabbitMQ *queue.RabbitMQ, log logger.Logger) error {
+	ctx = tracing.Extract(ctx, msg.Headers)
+
 	var req queue.ARPRequest
 	if err := json.Unmarshal(msg.Body, &req); err != nil {
-		log.Errorf("Failed to unmarshal ARP scan request: %v", err)
-		return
+		log.WithContext(ctx).Errorf("Failed to unmarshal ARP scan request: %v", err)
+		metrics.Errors.WithLabelValues("decode").Inc()
+		return fmt.Errorf("decode ARP request: %w", err)
 	}
 
-	log.Infof("Received ARP scan request for range: %s on interface: %s", req.IPRange, req.InterfaceName)
+	ctx = tracing.WithTaskID(ctx, req.TaskID)
+	ctx, span := tracing.Tracer().Start(ctx, "arp scan",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.String("task_id", req.TaskID),
+			attribute.String("target", req.IPRange),
+			attribute.String("interface", req.InterfaceName),
+		))
+	defer span.End()
+	log = log.WithContext(ctx).With("target", req.IPRange)
+
+	log.Infof("Received ARP scan request on interface: %s", req.InterfaceName)
 
 	arpScanner := scanner.NewARPScanner(
 		req.InterfaceName,
@@ -24,32 +89,69 @@ func HandleMessage(ctx context.Context, msg queue.Delivery, rabbitMQ *queue.Rabb
 		scanner.DefaultRetryDelay,
 	)
 
-	devices, err := arpScanner.Scan(ctx, req.IPRange)
+	// Online devices are streamed to the backend as they answer; large
+	// ranges would not fit in a single reply. Progress is reported as
+	// probes complete.
+	chunker := rabbitMQ.NewChunker(ctx, msg, req.TaskID, "devices")
+	progress := rabbitMQ.NewProgress(ctx, req.TaskID)
+	observe := func(target string, found *scanner.DeviceInfo, done, total int) {
+		if found != nil {
+			chunker.Add(toARPDevice(*found), done, total)
+		} else {
+			chunker.Add(nil, done, total)
+		}
+		progress.Update(target, done, total)
+	}
+
+	done := metrics.StartScan("arp")
+	devices, err := arpScanner.Scan(ctx, req.IPRange, observe)
+	metrics.TargetsScanned.WithLabelValues("arp").Add(float64(len(devices)))
+	if err != nil {
+		metrics.Errors.WithLabelValues("scan").Inc()
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		done("failed")
+	} else {
+		done("completed")
+	}
 
 	if msg.ReplyTo != "" {
-		sendResponse(rabbitMQ, msg, req, devices, err, log)
+		chunks, chunkErr := chunker.Finish()
+		if chunkErr != nil {
+			log.Errorf("Failed to stream result chunks, replying with the whole result: %v", chunkErr)
+			metrics.Errors.WithLabelValues("reply").Inc()
+			chunks = 0
+		}
+		sendResponse(ctx, rabbitMQ, msg, req, devices, chunks, err, log)
 	}
 
 	if err != nil {
 		log.Errorf("ARP scan failed: %v", err)
-		return
+		return nil
 	}
 
 	log.Infof("ARP scan completed, found %d devices", len(devices))
+	return nil
 }
 
-func sendResponse(rabbitMQ *queue.RabbitMQ, msg queue.Delivery, req queue.ARPRequest, devices []scanner.DeviceInfo, err error, log logger.Logger) {
+func toARPDevice(device scanner.DeviceInfo) queue.ARPDevice {
+	return queue.ARPDevice{
+		IP:     device.IP,
+		MAC:    device.MAC,
+		Vendor: device.Vendor,
+		Status: device.Status,
+	}
+}
+
+// sendResponse replies with the result of the scan. When its online
+// devices were streamed in chunks the reply only carries the counts.
+func sendResponse(ctx context.Context, rabbitMQ *queue.RabbitMQ, msg queue.Delivery, req queue.ARPRequest, devices []scanner.DeviceInfo, chunks int, err error, log logger.Logger) {
 	arpDevices := make([]queue.ARPDevice, len(devices))
 	var onlineDevices []queue.ARPDevice
 	var offlineDevices []queue.ARPDevice
 
 	for i, device := range devices {
-		arpDevice := queue.ARPDevice{
-			IP:     device.IP,
-			MAC:    device.MAC,
-			Vendor: device.Vendor,
-			Status: device.Status,
-		}
+		arpDevice := toARPDevice(device)
 		arpDevices[i] = arpDevice
 
 		if device.Status == "online" {
@@ -73,12 +175,17 @@ func sendResponse(rabbitMQ *queue.RabbitMQ, msg queue.Delivery, req queue.ARPReq
 		response.Error = err.Error()
 		response.Status = "failed"
 	}
+	if chunks > 0 {
+		response.Devices, response.OnlineDevices, response.OfflineDevices = nil, nil, nil
+		response.Chunks = chunks
+	}
 
 	log.Infof("Sending ARP response: TaskID=%s, Status=%s, Total=%d, Online=%d, Offline=%d, Error=%s",
 		response.TaskID, response.Status, response.TotalCount, response.OnlineCount, response.OfflineCount, response.Error)
 
-	if err := rabbitMQ.SendResponse(msg.ReplyTo, msg.CorrelationId, response); err != nil {
+	if err := rabbitMQ.SendResponse(ctx, msg.ReplyTo, msg.CorrelationId, response); err != nil {
 		log.Errorf("Failed to send RPC response: %v", err)
+		metrics.Errors.WithLabelValues("reply").Inc()
 	} else {
 		log.Infof("Successfully sent ARP response for task %s", response.TaskID)
 	}
@@ -0,0 +1,88 @@
+package scanner
+
+import "sync"
+
+// observerQueue calls an Observer from a goroutine of its own, in the order
+// the observations were added. Observers publish chunks and progress to the
+// broker; adding never waits for them, so probes do not either.
+type observerQueue struct {
+	observe Observer
+	total   int
+
+	mu     sync.Mutex
+	queued []observation
+	closed bool
+	wake   chan struct{}
+	done   chan struct{}
+}
+
+type observation struct {
+	target string
+	found  *DeviceInfo
+	done   int
+}
+
+// newObserverQueue starts the goroutine calling observe; nil if observe is.
+func newObserverQueue(observe Observer, total int) *observerQueue {
+	if observe == nil {
+		return nil
+	}
+	q := &observerQueue{
+		observe: observe,
+		total:   total,
+		wake:    make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+	go q.run()
+	return q
+}
+
+func (q *observerQueue) add(target string, found *DeviceInfo, done int) {
+	if q == nil {
+		return
+	}
+	q.mu.Lock()
+	q.queued = append(q.queued, observation{target: target, found: found, done: done})
+	q.mu.Unlock()
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// close waits until every observation added so far has been observed.
+// Later ones are dropped.
+func (q *observerQueue) close() {
+	if q == nil {
+		return
+	}
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		<-q.done
+		return
+	}
+	q.closed = true
+	q.mu.Unlock()
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	<-q.done
+}
+
+func (q *observerQueue) run() {
+	defer close(q.done)
+	for range q.wake {
+		q.mu.Lock()
+		batch, closed := q.queued, q.closed
+		q.queued = nil
+		q.mu.Unlock()
+		for _, o := range batch {
+			q.observe(o.target, o.found, o.done, q.total)
+		}
+		if closed {
+			return
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package scanner
+
+import (
+	"testing"
+	"time"
+)
+
+func TestObserverQueueOrder(t *testing.T) {
+	var got []int
+	release := make(chan struct{})
+	q := newObserverQueue(func(target string, found *DeviceInfo, done, total int) {
+		<-release
+		if total != 100 {
+			t.Errorf("total = %d", total)
+		}
+		got = append(got, done)
+	}, 100)
+
+	// Adding does not wait for the observer, even while it is blocked.
+	added := make(chan struct{})
+	go func() {
+		for i := 1; i <= 100; i++ {
+			q.add("10.0.0.1", nil, i)
+		}
+		close(added)
+	}()
+	select {
+	case <-added:
+	case <-time.After(time.Second):
+		t.Fatal("add waited for the observer")
+	}
+
+	close(release)
+	q.close()
+	if len(got) != 100 {
+		t.Fatalf("%d observations, want 100", len(got))
+	}
+	for i, done := range got {
+		if done != i+1 {
+			t.Fatalf("observation %d has done = %d", i, done)
+		}
+	}
+	q.close()
+}
+
+func TestObserverQueueNil(t *testing.T) {
+	q := newObserverQueue(nil, 10)
+	q.add("10.0.0.1", nil, 1)
+	q.close()
+}
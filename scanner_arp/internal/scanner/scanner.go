@@ -22,6 +22,12 @@ const (
 	DefaultRetryDelay = 1 * time.Second
 )
 
+// resolveErrorRatio is the share of probes failing with an error other
+// than a timeout above which the scan fails: the interface or the socket
+// is broken, rather than the hosts being offline, and the scan is worth
+// retrying.
+const resolveErrorRatio = 0.5
+
 type DeviceInfo struct {
 	IP     string `json:"ip"`
 	MAC    string `json:"mac"`
@@ -29,8 +35,15 @@ type DeviceInfo struct {
 	Status string `json:"status"`
 }
 
+// Observer is told about every address of a scan as its probe completes:
+// found is the device that answered at target, nil if none did, and done
+// counts the addresses finished out of total. Calls are serialised, in
+// order, on a goroutine of their own, so a slow observer does not hold up
+// the probes; Scan returns once all of them are made.
+type Observer func(target string, found *DeviceInfo, done, total int)
+
 type ARPScanner interface {
-	Scan(ctx context.Context, ipRange string) ([]DeviceInfo, error)
+	Scan(ctx context.Context, ipRange string, observe Observer) ([]DeviceInfo, error)
 }
 
 type arpScanner struct {
@@ -49,7 +62,10 @@ func NewARPScanner(ifaceName string, timeout time.Duration, maxRetries int, retr
 	}
 }
 
-func (s *arpScanner) Scan(ctx context.Context, ipRange string) ([]DeviceInfo, error) {
+// Scan resolves every address of ipRange. observe may be nil. Devices only
+// found in the system ARP table are reported to it once all probes are
+// done.
+func (s *arpScanner) Scan(ctx context.Context, ipRange string, observe Observer) ([]DeviceInfo, error) {
 	log.Printf("Starting ARP scan on interface %s for range %s", s.ifaceName, ipRange)
 
 	iface, err := net.InterfaceByName(s.ifaceName)
@@ -66,7 +82,26 @@ func (s *arpScanner) Scan(ctx context.Context, ipRange string) ([]DeviceInfo, er
 	var (
 		results   = make(map[string]DeviceInfo)
 		resultsMu sync.Mutex
+		done      int
+		failures  int
+		failure   error
 	)
+	// failed records a probe that failed other than by timing out;
+	// resultsMu must be held.
+	failed := func(err error) {
+		failures++
+		if failure == nil {
+			failure = err
+		}
+	}
+	// finished records the end of one probe; resultsMu must be held. It
+	// only queues the observation, which is made outside the lock.
+	observed := newObserverQueue(observe, len(ips))
+	defer observed.close()
+	finished := func(target netip.Addr, found *DeviceInfo) {
+		done++
+		observed.add(target.String(), found, done)
+	}
 
 	var wg sync.WaitGroup
 	const maxConcurrency = 200
@@ -88,6 +123,10 @@ func (s *arpScanner) Scan(ctx context.Context, ipRange string) ([]DeviceInfo, er
 
 				client, err := arp.Dial(iface)
 				if err != nil {
+					resultsMu.Lock()
+					failed(err)
+					finished(targetIP, nil)
+					resultsMu.Unlock()
 					return
 				}
 				defer client.Close()
@@ -95,18 +134,25 @@ func (s *arpScanner) Scan(ctx context.Context, ipRange string) ([]DeviceInfo, er
 				client.SetReadDeadline(time.Now().Add(requestTimeout))
 				mac, err := client.Resolve(targetIP)
 
+				resultsMu.Lock()
+				defer resultsMu.Unlock()
 				if err == nil && mac != nil {
 					ipStr := targetIP.String()
 					macStr := mac.String()
 
-					resultsMu.Lock()
-					results[ipStr] = DeviceInfo{
+					device := DeviceInfo{
 						IP:     ipStr,
 						MAC:    macStr,
 						Vendor: LookupVendor(macStr),
 						Status: "online",
 					}
-					resultsMu.Unlock()
+					results[ipStr] = device
+					finished(targetIP, &device)
+				} else {
+					if err != nil && !isTimeout(err) {
+						failed(err)
+					}
+					finished(targetIP, nil)
 				}
 			}(ip)
 		}
@@ -114,6 +160,10 @@ func (s *arpScanner) Scan(ctx context.Context, ipRange string) ([]DeviceInfo, er
 
 	wg.Wait()
 
+	if failures > 0 && float64(failures) >= resolveErrorRatio*float64(len(ips)) {
+		return nil, fmt.Errorf("ARP resolve failed for %d of %d addresses: %w", failures, len(ips), failure)
+	}
+
 	requestedIPs := make(map[string]bool, len(ips))
 	for _, ip := range ips {
 		requestedIPs[ip.String()] = true
@@ -124,12 +174,14 @@ func (s *arpScanner) Scan(ctx context.Context, ipRange string) ([]DeviceInfo, er
 	for ip, mac := range systemDevices {
 		if requestedIPs[ip] {
 			if _, exists := results[ip]; !exists {
-				results[ip] = DeviceInfo{
+				device := DeviceInfo{
 					IP:     ip,
 					MAC:    mac,
 					Vendor: LookupVendor(mac),
 					Status: "online",
 				}
+				results[ip] = device
+				observed.add(ip, &device, done)
 			}
 		}
 	}
@@ -161,6 +213,13 @@ func (s *arpScanner) Scan(ctx context.Context, ipRange string) ([]DeviceInfo, er
 	return devices, nil
 }
 
+// isTimeout reports whether a probe failed only because no reply came in
+// time, i.e. the address is offline.
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, os.ErrDeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout())
+}
+
 func readSystemARPTable(iface *net.Interface) map[string]string {
 	devices := make(map[string]string)
 
@@ -0,0 +1,107 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// HeaderResultChunk marks a partial result; its value is the chunk's
+// sequence number, counting from 0.
+const HeaderResultChunk = "x-result-chunk"
+
+// A chunk is sent once this many items are buffered, or once this long
+// has passed since the previous one.
+const (
+	ChunkSize     = 256
+	ChunkInterval = time.Second
+)
+
+// Chunker streams the items of a large result to the backend while the
+// scan runs, so they are stored incrementally and clients see them live.
+// Each chunk carries the items found since the previous one under field,
+// with the number of targets done out of total. The final reply then only
+// carries the number of chunks sent; the backend joins them back up.
+type Chunker struct {
+	mq            *RabbitMQ
+	ctx           context.Context
+	replyTo       string
+	correlationID string
+	taskID        string
+	field         string
+
+	mu          sync.Mutex
+	items       []interface{}
+	seq         int
+	done, total int
+	last        time.Time
+	err         error
+}
+
+// NewChunker streams the result of the request in msg for taskID.
+func (r *RabbitMQ) NewChunker(ctx context.Context, msg Delivery, taskID, field string) *Chunker {
+	return &Chunker{
+		mq:            r,
+		ctx:           ctx,
+		replyTo:       msg.ReplyTo,
+		correlationID: msg.CorrelationId,
+		taskID:        taskID,
+		field:         field,
+		last:          time.Now(),
+	}
+}
+
+// Add records that done of total targets are finished, buffering item if
+// it is not nil, and sends a chunk when one is due.
+func (c *Chunker) Add(item interface{}, done, total int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if item != nil {
+		c.items = append(c.items, item)
+	}
+	c.done, c.total = done, total
+	if len(c.items) >= ChunkSize || (len(c.items) > 0 && time.Since(c.last) >= ChunkInterval) {
+		c.flush()
+	}
+}
+
+// Finish sends the items still buffered and returns the number of chunks
+// sent. When no chunk was due during the scan nothing is sent and 0 is
+// returned: the whole result then goes in the final reply as usual.
+func (c *Chunker) Finish() (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.seq == 0 {
+		return 0, nil
+	}
+	if len(c.items) > 0 {
+		c.flush()
+	}
+	return c.seq, c.err
+}
+
+func (c *Chunker) flush() {
+	if c.replyTo == "" {
+		c.items = nil
+		return
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"task_id": c.taskID,
+		"seq":     c.seq,
+		"done":    c.done,
+		"total":   c.total,
+		c.field:   c.items,
+	})
+	if err == nil {
+		err = c.mq.publishTo(c.ctx, c.replyTo, c.correlationID, body, amqp.Table{HeaderResultChunk: int32(c.seq)})
+	}
+	if err != nil && c.err == nil {
+		c.err = err
+	}
+	c.items = nil
+	c.seq++
+	c.last = time.Now()
+}
@@ -0,0 +1,121 @@
+package queue
+
+import (
+	"arp_scanner/pkg/tracing"
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// ProgressExchange is the topic exchange scanners report the progress of
+// running scans on, with the task id as routing key.
+const ProgressExchange = "scan_progress"
+
+// ProgressInterval is the least time between two progress events of a
+// scan; the event reporting the scan complete is always sent.
+const ProgressInterval = time.Second
+
+// ScanProgress is one progress event. Total is 0 when only the
+// percentage is known.
+type ScanProgress struct {
+	TaskID    string    `json:"task_id"`
+	Service   string    `json:"service"`
+	Done      int       `json:"done"`
+	Total     int       `json:"total"`
+	Percent   float64   `json:"percent"`
+	Current   string    `json:"current,omitempty"`
+	ElapsedMs int64     `json:"elapsed_ms"`
+	EtaMs     int64     `json:"eta_ms,omitempty"`
+	At        time.Time `json:"at"`
+}
+
+// Progress reports the progress of one scan. Events are best effort: they
+// are transient and dropped if they cannot be published. It is safe for
+// concurrent use.
+type Progress struct {
+	mq      *RabbitMQ
+	ctx     context.Context
+	taskID  string
+	started time.Time
+
+	mu       sync.Mutex
+	last     time.Time
+	finished bool
+}
+
+// NewProgress reports the progress of the scan of taskID.
+func (r *RabbitMQ) NewProgress(ctx context.Context, taskID string) *Progress {
+	return &Progress{mq: r, ctx: ctx, taskID: taskID, started: time.Now()}
+}
+
+// Update reports that done of total targets are finished, current being
+// the last one.
+func (p *Progress) Update(current string, done, total int) {
+	percent := 100.0
+	if total > 0 {
+		percent = 100 * float64(done) / float64(total)
+	}
+	p.report(current, done, total, percent)
+}
+
+func (p *Progress) report(current string, done, total int, percent float64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	complete := percent >= 100
+	if p.finished || (!complete && now.Sub(p.last) < ProgressInterval) {
+		return
+	}
+	p.last, p.finished = now, complete
+
+	elapsed := now.Sub(p.started)
+	event := ScanProgress{
+		TaskID:    p.taskID,
+		Service:   p.mq.config.ScannerQueue,
+		Done:      done,
+		Total:     total,
+		Percent:   percent,
+		Current:   current,
+		ElapsedMs: elapsed.Milliseconds(),
+		At:        now,
+	}
+	if percent > 0 && !complete {
+		event.EtaMs = int64(float64(elapsed.Milliseconds()) * (100 - percent) / percent)
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	_ = p.mq.publishProgress(p.ctx, p.taskID, body)
+}
+
+// publishProgress sends body to ProgressExchange under taskID. Progress
+// is only of use while the scan runs, so events expire quickly.
+func (r *RabbitMQ) publishProgress(ctx context.Context, taskID string, body []byte) error {
+	r.progressOnce.Do(func() {
+		r.progressErr = r.channel.ExchangeDeclare(ProgressExchange, "topic", true, false, false, false, nil)
+	})
+	if r.progressErr != nil {
+		return r.progressErr
+	}
+	headers := amqp.Table{}
+	for k, v := range r.agent {
+		headers[k] = v
+	}
+	tracing.Inject(ctx, headers)
+
+	return r.channel.Publish(
+		ProgressExchange,
+		taskID,
+		false,
+		false,
+		amqp.Publishing{
+			ContentType: "application/json",
+			Expiration:  "10000",
+			Headers:     headers,
+			Body:        body,
+		})
+}
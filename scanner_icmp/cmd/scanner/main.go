@@ -7,16 +7,34 @@ import (
 	"scanner_icmp/internal/config"
 	"scanner_icmp/internal/service"
 	"scanner_icmp/pkg/logger"
+	"scanner_icmp/pkg/tracing"
+	"shared/tlsconfig"
 	"syscall"
 )
 
 func main() {
-	log := logger.New()
 	cfg := config.Load()
+	log := logger.New(cfg.ScannerName)
 
 	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer cancel()
 
+	shutdownTracing, err := tracing.Setup(ctx, cfg.ScannerName, cfg.OTLPEndpoint)
+	if err != nil {
+		log.Errorf("Tracing setup failed: %v", err)
+		os.Exit(1)
+	}
+	defer shutdownTracing(context.Background())
+
+	// SIGHUP re-reads the TLS certificates for new connections.
+	go tlsconfig.WatchSIGHUP(ctx, func(err error) {
+		if err != nil {
+			log.Errorf("TLS certificate reload failed: %v", err)
+			return
+		}
+		log.Info("TLS certificates reloaded")
+	})
+
 	if err := service.Run(ctx, cfg, *log); err != nil {
 		log.Errorf("Service failed: %v", err)
 		os.Exit(1)
@@ -3,49 +3,138 @@ package handler
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"scanner_icmp/internal/config"
 	"scanner_icmp/internal/scanner"
+	"scanner_icmp/pkg/heartbeat"
 	"scanner_icmp/pkg/logger"
 	"scanner_icmp/pkg/queue"
+	"scanner_icmp/pkg/tracing"
+	"shared/metrics"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
-func HandleMessage(ctx context.Context, msg queue.Delivery, rabbitMQ *queue.RabbitMQ, log logger.Logger, cfg *config.Config) {
+// Capabilities are advertised in the scanner's heartbeats.
+var Capabilities = heartbeat.Capabilities{
+	ScanMethods: []string{"ping"},
+	OptionsSchema: `{
+    "type": "object",
+    "required": ["targets"],
+    "properties": {
+      "targets": {"type": "array", "items": {"type": "string"}, "description": "Host names or IPv4 addresses to ping"},
+      "ping_count": {"type": "integer", "minimum": 1, "default": 4}
+    }
+  }`,
+	ResultSchema: `{
+    "type": "object",
+    "required": ["task_id", "status", "results"],
+    "properties": {
+      "task_id": {"type": "string"},
+      "status": {"type": "string"},
+      "results": {"type": "array", "items": {"type": "object", "properties": {
+        "target": {"type": "string"}, "address": {"type": "string"},
+        "packets_sent": {"type": "integer"}, "packets_received": {"type": "integer"},
+        "packet_loss_percent": {"type": "number"}, "error": {"type": "string"}
+      }}}
+    }
+  }`,
+	Collection: "l3_devices",
+}
+
+// Inspect returns the task id of a request and the hosts it pings, for
+// idempotency and per-target locking.
+func Inspect(body []byte) (taskID string, targets []string) {
+	var req queue.PingRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return "", nil
+	}
+	return req.TaskID, req.Targets
+}
+
+// HandleMessage runs the scan a request asks for and replies with the
+// result. It returns an error only for requests that can never be
+// processed; those are dead-lettered rather than retried.
+func HandleMessage(ctx context.Context, msg queue.Delivery, rabbitMQ *queue.RabbitMQ, log logger.Logger, cfg *config.Config) error {
+	ctx = tracing.Extract(ctx, msg.Headers)
+
 	var req queue.PingRequest
 	if err := json.Unmarshal(msg.Body, &req); err != nil {
-		log.Errorf("Failed to unmarshal Ping scan request: %v", err)
-		return
+		log.WithContext(ctx).Errorf("Failed to unmarshal Ping scan request: %v", err)
+		metrics.Errors.WithLabelValues("decode").Inc()
+		return fmt.Errorf("decode ping request: %w", err)
 	}
 
-	log.Infof("Received Ping scan request for targets: %v", req.Targets)
+	ctx = tracing.WithTaskID(ctx, req.TaskID)
+	ctx, span := tracing.Tracer().Start(ctx, "icmp scan",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.String("task_id", req.TaskID),
+			attribute.StringSlice("targets", req.Targets),
+		))
+	defer span.End()
+	log = log.WithContext(ctx)
+
+	log.With("target", strings.Join(req.Targets, ",")).Infof("Received Ping scan request for %d targets", len(req.Targets))
 
 	pingScanner := scanner.NewPingScanner(req.PingCount, cfg.PingTimeout)
 
+	// Results are streamed to the backend as targets finish, so long
+	// target lists show up live and need not fit in a single reply.
+	chunker := rabbitMQ.NewChunker(ctx, msg, req.TaskID, "results")
+	progress := rabbitMQ.NewProgress(ctx, req.TaskID)
+
+	done := metrics.StartScan("icmp")
 	results := make([]scanner.PingResult, 0, len(req.Targets))
 	for _, target := range req.Targets {
 		select {
 		case <-ctx.Done():
-			return
+			done("failed")
+			return nil
 		default:
-			result := pingScanner.Ping(ctx, target)
+			pingCtx, pingSpan := tracing.Tracer().Start(ctx, "icmp ping", trace.WithAttributes(attribute.String("target", target)))
+			result := pingScanner.Ping(pingCtx, target)
+			pingSpan.End()
+			log.With("target", target).Infof("Ping finished: %d/%d packets received", result.PacketsReceived, result.PacketsSent)
 			results = append(results, result)
+			chunker.Add(result, len(results), len(req.Targets))
+			progress.Update(target, len(results), len(req.Targets))
+			metrics.TargetsScanned.WithLabelValues("icmp").Inc()
 		}
 	}
+	done("completed")
 
 	if msg.ReplyTo != "" {
-		sendResponse(rabbitMQ, msg, req, results, log)
+		chunks, err := chunker.Finish()
+		if err != nil {
+			log.Errorf("Failed to stream result chunks, replying with the whole result: %v", err)
+			metrics.Errors.WithLabelValues("reply").Inc()
+			chunks = 0
+		}
+		sendResponse(ctx, rabbitMQ, msg, req, results, chunks, log)
 	}
 
 	log.Infof("Ping scan completed, scanned %d targets", len(results))
+	return nil
 }
 
-func sendResponse(rabbitMQ *queue.RabbitMQ, msg queue.Delivery, req queue.PingRequest, results []scanner.PingResult, log logger.Logger) {
+// sendResponse replies with the results of the scan, or only with the
+// number of chunks they were streamed in.
+func sendResponse(ctx context.Context, rabbitMQ *queue.RabbitMQ, msg queue.Delivery, req queue.PingRequest, results []scanner.PingResult, chunks int, log logger.Logger) {
 	response := queue.PingResponse{
 		TaskID:  req.TaskID,
 		Status:  "completed",
 		Results: results,
 	}
+	if chunks > 0 {
+		response.Results = nil
+		response.Chunks = chunks
+	}
 
-	if err := rabbitMQ.SendResponse(msg.ReplyTo, msg.CorrelationId, response); err != nil {
+	if err := rabbitMQ.SendResponse(ctx, msg.ReplyTo, msg.CorrelationId, response); err != nil {
 		log.Errorf("Failed to send RPC response: %v", err)
+		metrics.Errors.WithLabelValues("reply").Inc()
 	}
 }
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"github.com/go-ping/ping"
 	"net"
+	"shared/metrics"
 	"time"
 )
 
@@ -42,6 +43,7 @@ func (s *pingScanner) Ping(ctx context.Context, target string) PingResult {
 		ips, err := net.LookupIP(target)
 		if err != nil || len(ips) == 0 {
 			res.Error = fmt.Sprintf("DNS lookup error: %v", err)
+			metrics.Errors.WithLabelValues("dns").Inc()
 			return res
 		}
 		ip = ips[0]
@@ -51,6 +53,7 @@ func (s *pingScanner) Ping(ctx context.Context, target string) PingResult {
 	pinger, err := ping.NewPinger(res.Address)
 	if err != nil {
 		res.Error = fmt.Sprintf("Ping init error: %v", err)
+		metrics.Errors.WithLabelValues("ping").Inc()
 		return res
 	}
 	pinger.Count = s.count
@@ -60,6 +63,7 @@ func (s *pingScanner) Ping(ctx context.Context, target string) PingResult {
 	err = pinger.Run()
 	if err != nil {
 		res.Error = fmt.Sprintf("Ping run error: %v", err)
+		metrics.Errors.WithLabelValues("ping").Inc()
 		return res
 	}
 	stats := pinger.Statistics()
@@ -2,14 +2,55 @@ package queue
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
 	"github.com/streadway/amqp"
 	"scanner_icmp/internal/scanner"
+	"scanner_icmp/pkg/heartbeat"
+	"scanner_icmp/pkg/tracing"
+	"shared/replies"
+	"strings"
+	"sync"
+	"time"
 )
 
+// DeadLetterQueue holds requests no scanner can process, with the reason
+// in the HeaderDeadLetterReason header.
+const DeadLetterQueue = "scan_dead_letters"
+
+const (
+	HeaderDeadLetterReason = "x-dead-letter-reason"
+	HeaderOriginalQueue    = "x-original-queue"
+)
+
+// RequestExchange is the topic exchange site-tagged instances receive scan
+// requests on, with routing key "<scanner queue>.<site>".
+const RequestExchange = "scan_requests"
+
 type RabbitMQConfig struct {
 	URL          string
 	ScannerQueue string
+	// TLS configures amqps:// URLs, including the client certificate for
+	// mutual TLS; nil verifies the broker against the system roots.
+	TLS *tls.Config
+	// Site tags the instance; tagged instances consume the site queue
+	// instead of the shared scanner queue.
+	Site string
+	// Prefetch caps the unacknowledged requests delivered to this
+	// instance; set it to the worker pool size.
+	Prefetch int
+	// ReplyDir keeps sent replies across restarts, so requests redelivered
+	// after a crash are answered without scanning again; empty keeps them
+	// in memory only.
+	ReplyDir string
+}
+
+// SiteQueue is the queue, and routing key, of service's instances at site.
+func SiteQueue(service, site string) string {
+	return service + "." + site
 }
 
 type PingRequest struct {
@@ -23,6 +64,9 @@ type PingResponse struct {
 	Status  string               `json:"status"`
 	Results []scanner.PingResult `json:"results"`
 	Error   string               `json:"error,omitempty"`
+	// Chunks is the number of chunks the results were streamed in; Results
+	// is then left empty.
+	Chunks int `json:"chunks,omitempty"`
 }
 
 type Delivery struct {
@@ -34,10 +78,19 @@ type RabbitMQ struct {
 	channel *amqp.Channel
 	queue   amqp.Queue
 	config  RabbitMQConfig
+	agent   amqp.Table
+	replies *replies.Cache
+	// prioritized is false when the request queue predates priority
+	// lanes and messages are taken in arrival order.
+	prioritized bool
+	// progressOnce declares ProgressExchange before the first progress
+	// event is published.
+	progressOnce sync.Once
+	progressErr  error
 }
 
 func NewRabbitMQ(config RabbitMQConfig) (*RabbitMQ, error) {
-	conn, err := amqp.Dial(config.URL)
+	conn, err := dial(config.URL, config.TLS)
 	if err != nil {
 		return nil, err
 	}
@@ -48,28 +101,74 @@ func NewRabbitMQ(config RabbitMQConfig) (*RabbitMQ, error) {
 		return nil, err
 	}
 
-	queue, err := channel.QueueDeclare(
-		config.ScannerQueue,
-		true,
-		false,
-		false,
-		false,
-		nil,
-	)
+	queueName := config.ScannerQueue
+	if config.Site != "" {
+		if strings.ContainsAny(config.Site, ".*#") {
+			channel.Close()
+			conn.Close()
+			return nil, fmt.Errorf("site %q must not contain '.', '*' or '#'", config.Site)
+		}
+		queueName = SiteQueue(config.ScannerQueue, config.Site)
+	}
+
+	queue, channel, prioritized, err := declareRequestQueue(conn, channel, queueName)
 	if err != nil {
-		channel.Close()
+		if channel != nil {
+			channel.Close()
+		}
 		conn.Close()
 		return nil, err
 	}
 
+	if config.Prefetch > 0 {
+		if err := channel.Qos(config.Prefetch, 0, false); err != nil {
+			channel.Close()
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	cache, err := replies.New(config.ReplyDir)
+	if err != nil {
+		channel.Close()
+		conn.Close()
+		return nil, fmt.Errorf("reply directory: %w", err)
+	}
+
+	if config.Site != "" {
+		err = channel.ExchangeDeclare(RequestExchange, "topic", true, false, false, false, nil)
+		if err == nil {
+			err = channel.QueueBind(queueName, queueName, RequestExchange, false, nil)
+		}
+		if err != nil {
+			channel.Close()
+			conn.Close()
+			return nil, err
+		}
+	}
+
 	return &RabbitMQ{
-		conn:    conn,
-		channel: channel,
-		queue:   queue,
-		config:  config,
+		conn:        conn,
+		channel:     channel,
+		queue:       queue,
+		config:      config,
+		replies:     cache,
+		prioritized: prioritized,
 	}, nil
 }
 
+// dial connects to url, over TLS for amqps:// URLs. A TLS configuration
+// with a plain amqp:// URL is refused rather than silently ignored.
+func dial(url string, tlsConfig *tls.Config) (*amqp.Connection, error) {
+	if tlsConfig == nil {
+		return amqp.Dial(url)
+	}
+	if !strings.HasPrefix(url, "amqps://") {
+		return nil, errors.New("RabbitMQ TLS is configured but the URL is not amqps://")
+	}
+	return amqp.DialTLS(url, tlsConfig)
+}
+
 func (r *RabbitMQ) Close() error {
 	if r.channel != nil {
 		if err := r.channel.Close(); err != nil {
@@ -82,11 +181,67 @@ func (r *RabbitMQ) Close() error {
 	return nil
 }
 
-func (r *RabbitMQ) SendResponse(replyTo string, correlationID string, response interface{}) error {
+// Prioritized reports whether the request queue orders messages by
+// priority lane.
+func (r *RabbitMQ) Prioritized() bool {
+	return r.prioritized
+}
+
+// SetAgent sets the headers identifying this instance on every reply.
+func (r *RabbitMQ) SetAgent(headers map[string]interface{}) {
+	r.agent = amqp.Table(headers)
+}
+
+// SendResponse publishes response to replyTo, carrying the trace context
+// and task id of ctx in the message headers.
+func (r *RabbitMQ) SendResponse(ctx context.Context, replyTo string, correlationID string, response interface{}) error {
 	body, err := json.Marshal(response)
 	if err != nil {
 		return err
 	}
+	return r.publishReply(ctx, replyTo, correlationID, body)
+}
+
+// Resend answers a redelivered request with the reply already sent for
+// its task.
+func (r *RabbitMQ) Resend(ctx context.Context, msg Delivery, body []byte) error {
+	return r.publishReply(ctx, msg.ReplyTo, msg.CorrelationId, body)
+}
+
+// CachedReply returns the reply sent recently for the task of msg, if any.
+// A reply reporting an error is returned only to a redelivery of the
+// request it answered, so a retried task is scanned again.
+func (r *RabbitMQ) CachedReply(msg Delivery, taskID string) ([]byte, bool) {
+	return r.replies.Get(taskID, msg.Redelivered)
+}
+
+// Replied reports whether a reply, successful or not, was sent recently
+// for taskID.
+func (r *RabbitMQ) Replied(taskID string) bool {
+	return r.replies.Sent(taskID)
+}
+
+// publishReply sends body to replyTo and remembers it under the task id of
+// ctx for answering redeliveries.
+func (r *RabbitMQ) publishReply(ctx context.Context, replyTo string, correlationID string, body []byte) error {
+	if err := r.publishTo(ctx, replyTo, correlationID, body, nil); err != nil {
+		return err
+	}
+	r.replies.Put(tracing.TaskID(ctx), body)
+	return nil
+}
+
+// publishTo sends body to replyTo with the agent headers, the trace
+// context of ctx and extra.
+func (r *RabbitMQ) publishTo(ctx context.Context, replyTo string, correlationID string, body []byte, extra amqp.Table) error {
+	headers := amqp.Table{}
+	for k, v := range r.agent {
+		headers[k] = v
+	}
+	for k, v := range extra {
+		headers[k] = v
+	}
+	tracing.Inject(ctx, headers)
 
 	return r.channel.Publish(
 		"",
@@ -96,10 +251,127 @@ func (r *RabbitMQ) SendResponse(replyTo string, correlationID string, response i
 		amqp.Publishing{
 			ContentType:   "application/json",
 			CorrelationId: correlationID,
+			DeliveryMode:  amqp.Persistent,
+			Headers:       headers,
 			Body:          body,
 		})
 }
 
+// busyDelay is how long a request whose targets are being scanned waits in
+// the busy queue before it is delivered again.
+const busyDelay = 5 * time.Second
+
+// HeaderBusyDeferrals counts how often a request was deferred because its
+// targets were being scanned.
+const HeaderBusyDeferrals = "x-busy-deferrals"
+
+// Defer parks msg, whose targets are being scanned, in a delay queue for
+// busyDelay and acknowledges it. The broker then dead-letters it back to
+// the tail of the request queue, so while it waits it holds no prefetch
+// slot and cannot hold up requests for other targets. It returns how many
+// times the request had been deferred before.
+func (r *RabbitMQ) Defer(msg Delivery) (int, error) {
+	name := r.queue.Name + ".busy"
+	_, err := r.channel.QueueDeclare(name, true, false, false, false, amqp.Table{
+		"x-message-ttl":             busyDelay.Milliseconds(),
+		"x-dead-letter-exchange":    "",
+		"x-dead-letter-routing-key": r.queue.Name,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	deferrals, _ := msg.Headers[HeaderBusyDeferrals].(int32)
+	headers := amqp.Table{}
+	for k, v := range msg.Headers {
+		headers[k] = v
+	}
+	headers[HeaderBusyDeferrals] = deferrals + 1
+
+	err = r.channel.Publish("", name, false, false, amqp.Publishing{
+		ContentType:   msg.ContentType,
+		CorrelationId: msg.CorrelationId,
+		ReplyTo:       msg.ReplyTo,
+		MessageId:     msg.MessageId,
+		Priority:      msg.Priority,
+		DeliveryMode:  amqp.Persistent,
+		Headers:       headers,
+		Body:          msg.Body,
+	})
+	if err != nil {
+		return 0, err
+	}
+	return int(deferrals), msg.Ack(false)
+}
+
+// maxReplyRequeues is how many times a request whose reply could not be
+// published is requeued before it is dead-lettered.
+const maxReplyRequeues = 5
+
+// Requeue hands msg back to the queue after its reply for taskID could not
+// be published. After maxReplyRequeues attempts it is dead-lettered
+// instead, so an unreachable reply queue cannot keep it circulating.
+func (r *RabbitMQ) Requeue(msg Delivery, taskID string, reason string) error {
+	if r.replies.Requeued(taskID) > maxReplyRequeues {
+		r.replies.Forget(taskID)
+		return r.DeadLetter(msg, reason)
+	}
+	return msg.Nack(false, true)
+}
+
+// DeadLetter moves a request that can never be processed to
+// DeadLetterQueue, recording why in its headers, and acknowledges it. The
+// message id identifies the entry for replay through the backend.
+func (r *RabbitMQ) DeadLetter(msg Delivery, reason string) error {
+	if _, err := r.channel.QueueDeclare(DeadLetterQueue, true, false, false, false, nil); err != nil {
+		return err
+	}
+	headers := amqp.Table{}
+	for k, v := range msg.Headers {
+		headers[k] = v
+	}
+	headers[HeaderDeadLetterReason] = reason
+	headers[HeaderOriginalQueue] = r.queue.Name
+
+	err := r.channel.Publish(
+		"",
+		DeadLetterQueue,
+		false,
+		false,
+		amqp.Publishing{
+			ContentType:   msg.ContentType,
+			CorrelationId: msg.CorrelationId,
+			ReplyTo:       msg.ReplyTo,
+			MessageId:     uuid.New().String(),
+			Timestamp:     time.Now(),
+			DeliveryMode:  amqp.Persistent,
+			Headers:       headers,
+			Body:          msg.Body,
+		})
+	if err != nil {
+		return err
+	}
+	return msg.Ack(false)
+}
+
+// PublishHeartbeat announces this instance on the heartbeat fanout
+// exchange. Heartbeats are transient: only live subscribers need them.
+func (r *RabbitMQ) PublishHeartbeat(body []byte) error {
+	if err := r.channel.ExchangeDeclare(heartbeat.Exchange, "fanout", true, false, false, false, nil); err != nil {
+		return err
+	}
+	return r.channel.Publish(
+		heartbeat.Exchange,
+		"",
+		false,
+		false,
+		amqp.Publishing{
+			ContentType: "application/json",
+			Expiration:  "60000",
+			Body:        body,
+		})
+}
+
 func (r *RabbitMQ) ConsumeScanRequests(ctx context.Context) (<-chan Delivery, error) {
 	msgs, err := r.channel.Consume(
 		r.queue.Name,
@@ -127,14 +399,19 @@ func (r *RabbitMQ) ConsumeScanRequests(ctx context.Context) (<-chan Delivery, er
 					return
 				}
 
+				// Undecodable requests are dead-lettered here; the rest
+				// are acknowledged by the worker once replied to.
 				var req PingRequest
 				if err := json.Unmarshal(msg.Body, &req); err != nil {
-					_ = msg.Nack(false, false)
+					_ = r.DeadLetter(Delivery{msg}, "decode: "+err.Error())
+					continue
+				}
+				if req.TaskID == "" {
+					_ = r.DeadLetter(Delivery{msg}, "missing task_id")
 					continue
 				}
 
 				deliveries <- Delivery{msg}
-				_ = msg.Ack(false)
 			}
 		}
 	}()
@@ -1,6 +1,7 @@
 package domain
 
 type RawRequest struct {
+	TaskID     string `json:"task_id"`
 	ScanMethod string `json:"scan_method"`
 }
 type ScanTcpUdpRequest struct {
@@ -15,6 +16,7 @@ type ScanTcpUdpResponse struct {
 	Host     string           `json:"host"`
 	PortInfo []PortTcpUdpInfo `json:"port_info"`
 	Status   string           `json:"status"`
+	Error    string           `json:"error,omitempty"`
 }
 
 type PortTcpUdpInfo struct {
@@ -39,6 +41,7 @@ type OsDetectionResponse struct {
 	Family   string `json:"family"`
 	Type     string `json:"type"`
 	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
 }
 
 type HostDiscoveryRequest struct {
@@ -53,4 +56,5 @@ type HostDiscoveryResponse struct {
 	Status    string `json:"status"`
 	DNS       string `json:"dns"`
 	Reason    string `json:"reason"`
+	Error     string `json:"error,omitempty"`
 }
@@ -3,79 +3,183 @@ package handler
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"scanner_nmap/internal/domain"
 	"scanner_nmap/internal/usecases"
+	"scanner_nmap/pkg/heartbeat"
 	"scanner_nmap/pkg/logger"
 	"scanner_nmap/pkg/queue"
+	"scanner_nmap/pkg/tracing"
+	"shared/metrics"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
-func HandleMessage(ctx context.Context, msg queue.Delivery, rabbitMQ *queue.RabbitMQ, log logger.Logger) {
-	log.Infof("Received scan request: %s", string(msg.Body))
+// Capabilities are advertised in the scanner's heartbeats.
+var Capabilities = heartbeat.Capabilities{
+	ScanMethods: []string{"tcp_udp_scan", "os_detection", "host_discovery"},
+	OptionsSchema: `{
+    "type": "object",
+    "required": ["scan_method", "ip"],
+    "properties": {
+      "scan_method": {"type": "string", "enum": ["tcp_udp_scan", "os_detection", "host_discovery"]},
+      "ip": {"type": "string", "description": "Target address, CIDR or range"},
+      "ports": {"type": "string", "description": "Port list for tcp_udp_scan, e.g. 22,80,1000-2000"},
+      "scanner_type": {"type": "string", "enum": ["tcp_scan", "udp_scan"]}
+    }
+  }`,
+	ResultSchema: `{
+    "type": "object",
+    "required": ["task_id", "status"],
+    "properties": {
+      "task_id": {"type": "string"},
+      "host": {"type": "string"},
+      "status": {"type": "string"},
+      "port_info": {"type": "array", "items": {"type": "object"}},
+      "name": {"type": "string"}, "family": {"type": "string"}, "vendor": {"type": "string"}, "accuracy": {"type": "integer"},
+      "host_up": {"type": "integer"}, "host_total": {"type": "integer"}, "dns": {"type": "string"}, "reason": {"type": "string"}
+    }
+  }`,
+	Collection: "l3_devices",
+}
+
+// Inspect returns the task id of a request and the host it scans, for
+// idempotency and per-target locking.
+func Inspect(body []byte) (taskID string, targets []string) {
+	var req struct {
+		TaskID string `json:"task_id"`
+		IP     string `json:"ip"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		return "", nil
+	}
+	return req.TaskID, []string{req.IP}
+}
+
+// HandleMessage runs the scan a request asks for and replies with the
+// result. It returns an error only for requests that can never be
+// processed; those are dead-lettered rather than retried.
+func HandleMessage(ctx context.Context, msg queue.Delivery, rabbitMQ *queue.RabbitMQ, log logger.Logger) error {
+	ctx = tracing.Extract(ctx, msg.Headers)
 
 	if msg.ReplyTo == "" {
-		return
+		return nil
 	}
 
 	var scanType struct {
+		TaskID      string `json:"task_id"`
+		IP          string `json:"ip"`
 		ScanMethod  string `json:"scan_method"`
 		ScannerType string `json:"scanner_type"`
 	}
 
 	if err := json.Unmarshal(msg.Body, &scanType); err != nil {
-		log.Errorf("Failed to unmarshal scan type: %v", err)
-		return
+		log.WithContext(ctx).Errorf("Failed to unmarshal scan type: %v", err)
+		metrics.Errors.WithLabelValues("decode").Inc()
+		return fmt.Errorf("decode scan type: %w", err)
 	}
 
-	log.Infof("Scan method: %s, Scanner type: %s", scanType.ScanMethod, scanType.ScannerType)
+	ctx = tracing.WithTaskID(ctx, scanType.TaskID)
+	ctx, span := tracing.Tracer().Start(ctx, "nmap "+scanType.ScanMethod,
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.String("task_id", scanType.TaskID),
+			attribute.String("target", scanType.IP),
+			attribute.String("scan_method", scanType.ScanMethod),
+		))
+	defer span.End()
+	log = log.WithContext(ctx).With("target", scanType.IP)
+
+	log.Infof("Received scan request: method=%s, scanner type=%s", scanType.ScanMethod, scanType.ScannerType)
+
+	// nmap's periodic stats are reported as progress; the scan is reported
+	// complete once it returns, whether or not nmap got to 100%.
+	progress := rabbitMQ.NewProgress(ctx, scanType.TaskID)
+	report := func(percent float32) { progress.Percent(scanType.IP, float64(percent)) }
 
 	switch {
 	case scanType.ScanMethod == "tcp_udp_scan" || scanType.ScannerType == "tcp_scan" || scanType.ScannerType == "udp_scan":
 		var tcpUdpRequest domain.ScanTcpUdpRequest
 		if err := json.Unmarshal(msg.Body, &tcpUdpRequest); err != nil {
 			log.Errorf("Failed to unmarshal TCP/UDP request: %v", err)
-			return
+			metrics.Errors.WithLabelValues("decode").Inc()
+			return fmt.Errorf("decode TCP/UDP request: %w", err)
 		}
 		log.Infof("Processing TCP/UDP scan for %s on ports %s", tcpUdpRequest.IP, tcpUdpRequest.Ports)
-		req, err := usecases.UdpTcpScanner(ctx, tcpUdpRequest)
+		done := metrics.StartScan("tcp_udp")
+		req, err := usecases.UdpTcpScanner(ctx, tcpUdpRequest, report)
+		metrics.TargetsScanned.WithLabelValues("tcp_udp").Inc()
 		if err != nil {
 			log.Errorf("Failed to scan TCP/UDP: %v", err)
 		}
-		sendResponse(rabbitMQ, msg, req, err, log)
+		finishScan(ctx, done, err)
+		progress.Complete(scanType.IP)
+		sendResponse(ctx, rabbitMQ, msg, req, err, log)
 
 	case scanType.ScanMethod == "os_detection":
 		var osRequest domain.OsDetectionRequest
 		if err := json.Unmarshal(msg.Body, &osRequest); err != nil {
 			log.Errorf("Failed to unmarshal OS detection request: %v", err)
-			return
+			metrics.Errors.WithLabelValues("decode").Inc()
+			return fmt.Errorf("decode OS detection request: %w", err)
 		}
 		log.Infof("Processing OS detection for %s", osRequest.IP)
-		req, err := usecases.OSDetectionScanner(ctx, osRequest)
+		done := metrics.StartScan("os_detection")
+		req, err := usecases.OSDetectionScanner(ctx, osRequest, report)
+		metrics.TargetsScanned.WithLabelValues("os_detection").Inc()
 		if err != nil {
 			log.Errorf("Failed to scan OS detection: %v", err)
 		}
-		sendResponse(rabbitMQ, msg, req, err, log)
+		finishScan(ctx, done, err)
+		progress.Complete(scanType.IP)
+		sendResponse(ctx, rabbitMQ, msg, req, err, log)
 
 	case scanType.ScanMethod == "host_discovery":
 		var hostRequest domain.HostDiscoveryRequest
 		if err := json.Unmarshal(msg.Body, &hostRequest); err != nil {
 			log.Errorf("Failed to unmarshal host discovery request: %v", err)
-			return
+			metrics.Errors.WithLabelValues("decode").Inc()
+			return fmt.Errorf("decode host discovery request: %w", err)
 		}
 		log.Infof("Processing host discovery for %s", hostRequest.IP)
-		req, err := usecases.HostDiscoveryScanner(ctx, hostRequest)
+		done := metrics.StartScan("host_discovery")
+		req, err := usecases.HostDiscoveryScanner(ctx, hostRequest, report)
+		metrics.TargetsScanned.WithLabelValues("host_discovery").Add(float64(req.HostTotal))
 		if err != nil {
 			log.Errorf("Failed to scan host discovery: %v", err)
 		}
-		sendResponse(rabbitMQ, msg, req, err, log)
+		finishScan(ctx, done, err)
+		progress.Complete(scanType.IP)
+		sendResponse(ctx, rabbitMQ, msg, req, err, log)
 
 	default:
 		log.Errorf("Invalid scan method: %s", scanType.ScanMethod)
+		metrics.Errors.WithLabelValues("invalid_method").Inc()
+		return fmt.Errorf("invalid scan method %q", scanType.ScanMethod)
 	}
 
 	log.Infof("Scan completed")
+	return nil
+}
+
+// finishScan ends a scan started with metrics.StartScan and marks the
+// span of ctx as failed on error.
+func finishScan(ctx context.Context, done func(status string), err error) {
+	if err != nil {
+		span := trace.SpanFromContext(ctx)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		metrics.Errors.WithLabelValues("scan").Inc()
+		done("failed")
+		return
+	}
+	done("completed")
 }
 
 func sendResponse[T domain.ScanTcpUdpResponse | domain.OsDetectionResponse | domain.HostDiscoveryResponse](
+	ctx context.Context,
 	rabbitMQ *queue.RabbitMQ,
 	msg queue.Delivery,
 	req T,
@@ -92,6 +196,7 @@ func sendResponse[T domain.ScanTcpUdpResponse | domain.OsDetectionResponse | dom
 		}
 		if err != nil {
 			response.Status = "failed"
+			response.Error = err.Error()
 			log.Errorf("TCP/UDP scan failed: %v", err)
 		} else {
 			log.Infof("TCP/UDP scan completed for task %s", r.TaskID)
@@ -99,8 +204,9 @@ func sendResponse[T domain.ScanTcpUdpResponse | domain.OsDetectionResponse | dom
 
 		body, _ := json.Marshal(response)
 
-		if err := rabbitMQ.SendResponse(msg.ReplyTo, msg.CorrelationId, body); err != nil {
+		if err := rabbitMQ.SendResponse(ctx, msg.ReplyTo, msg.CorrelationId, body); err != nil {
 			log.Errorf("Failed to send TCP/UDP response: %v", err)
+			metrics.Errors.WithLabelValues("reply").Inc()
 		}
 
 	case domain.OsDetectionResponse:
@@ -116,16 +222,17 @@ func sendResponse[T domain.ScanTcpUdpResponse | domain.OsDetectionResponse | dom
 		}
 		if err != nil {
 			response.Status = "failed"
+			response.Error = err.Error()
 			log.Errorf("OS detection failed: %v", err)
 		} else {
 			log.Infof("OS detection completed for task %s", r.TaskID)
 		}
 
 		body, _ := json.Marshal(response)
-		log.Infof("Sending OS detection response for task %s: %s", r.TaskID, string(body))
 
-		if err := rabbitMQ.SendResponse(msg.ReplyTo, msg.CorrelationId, body); err != nil {
+		if err := rabbitMQ.SendResponse(ctx, msg.ReplyTo, msg.CorrelationId, body); err != nil {
 			log.Errorf("Failed to send OS detection response: %v", err)
+			metrics.Errors.WithLabelValues("reply").Inc()
 		} else {
 			log.Infof("OS detection response sent successfully for task %s", r.TaskID)
 		}
@@ -142,16 +249,17 @@ func sendResponse[T domain.ScanTcpUdpResponse | domain.OsDetectionResponse | dom
 		}
 		if err != nil {
 			response.Status = "failed"
+			response.Error = err.Error()
 			log.Errorf("Host discovery failed: %v", err)
 		} else {
 			log.Infof("Host discovery completed for task %s", r.TaskID)
 		}
 
 		body, _ := json.Marshal(response)
-		log.Infof("Sending host discovery response for task %s: %s", r.TaskID, string(body))
 
-		if err := rabbitMQ.SendResponse(msg.ReplyTo, msg.CorrelationId, body); err != nil {
+		if err := rabbitMQ.SendResponse(ctx, msg.ReplyTo, msg.CorrelationId, body); err != nil {
 			log.Errorf("Failed to send host discovery response: %v", err)
+			metrics.Errors.WithLabelValues("reply").Inc()
 		} else {
 			log.Infof("Host discovery response sent successfully for task %s", r.TaskID)
 		}
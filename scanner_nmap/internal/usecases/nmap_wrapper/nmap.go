@@ -9,7 +9,7 @@ import (
 	"github.com/Ullaakut/nmap/v3"
 )
 
-func UDPScan(ctx context.Context, target string, ports string) (*nmap.Run, error) {
+func UDPScan(ctx context.Context, target string, ports string, progress func(percent float32)) (*nmap.Run, error) {
 	scanCtx, cancel := context.WithTimeout(ctx, 5*time.Minute)
 	defer cancel()
 
@@ -27,7 +27,7 @@ func UDPScan(ctx context.Context, target string, ports string) (*nmap.Run, error
 		return nil, fmt.Errorf("failed to create UDP scanner: %w", err)
 	}
 
-	result, warnings, err := udpScanner.Run()
+	result, warnings, err := run(udpScanner, progress)
 	if err != nil {
 		return nil, fmt.Errorf("failed to run UDP scanner: %w", err)
 	}
@@ -39,7 +39,7 @@ func UDPScan(ctx context.Context, target string, ports string) (*nmap.Run, error
 	return result, nil
 }
 
-func TCPScan(ctx context.Context, target string, ports string) (*nmap.Run, error) {
+func TCPScan(ctx context.Context, target string, ports string, progress func(percent float32)) (*nmap.Run, error) {
 	scanCtx, cancel := context.WithTimeout(ctx, 5*time.Minute)
 	defer cancel()
 
@@ -56,7 +56,7 @@ func TCPScan(ctx context.Context, target string, ports string) (*nmap.Run, error
 		return nil, fmt.Errorf("failed to create TCP scanner: %w", err)
 	}
 
-	result, warnings, err := tcpScanner.Run()
+	result, warnings, err := run(tcpScanner, progress)
 	if err != nil {
 		return nil, fmt.Errorf("failed to run TCP scanner: %w", err)
 	}
@@ -68,7 +68,7 @@ func TCPScan(ctx context.Context, target string, ports string) (*nmap.Run, error
 	return result, nil
 }
 
-func OSDetectionScan(ctx context.Context, target string) (*nmap.Run, error) {
+func OSDetectionScan(ctx context.Context, target string, progress func(percent float32)) (*nmap.Run, error) {
 	scanCtx, cancel := context.WithTimeout(ctx, 5*time.Minute)
 	defer cancel()
 
@@ -87,7 +87,7 @@ func OSDetectionScan(ctx context.Context, target string) (*nmap.Run, error) {
 		return nil, fmt.Errorf("failed to create OS detection scanner: %w", err)
 	}
 
-	result, warnings, err := scanner.Run()
+	result, warnings, err := run(scanner, progress)
 	if err != nil {
 		return nil, fmt.Errorf("failed to run OS detection scanner: %w", err)
 	}
@@ -99,7 +99,7 @@ func OSDetectionScan(ctx context.Context, target string) (*nmap.Run, error) {
 	return result, nil
 }
 
-func HostDiscovery(ctx context.Context, target string) (*nmap.Run, error) {
+func HostDiscovery(ctx context.Context, target string, progress func(percent float32)) (*nmap.Run, error) {
 	scanCtx, cancel := context.WithTimeout(ctx, 120*time.Second)
 	defer cancel()
 
@@ -118,7 +118,7 @@ func HostDiscovery(ctx context.Context, target string) (*nmap.Run, error) {
 		return nil, fmt.Errorf("failed to create host discovery scanner: %w", err)
 	}
 
-	result, warnings, err := scanner.Run()
+	result, warnings, err := run(scanner, progress)
 	if err != nil {
 		return nil, fmt.Errorf("failed to run host discovery scanner: %w", err)
 	}
@@ -129,3 +129,44 @@ func HostDiscovery(ctx context.Context, target string) (*nmap.Run, error) {
 
 	return result, nil
 }
+
+// run runs scanner, passing the percentage done from nmap's periodic stats
+// output to progress unless it is nil.
+func run(scanner *nmap.Scanner, progress func(percent float32)) (*nmap.Run, *[]string, error) {
+	if progress == nil {
+		return scanner.Run()
+	}
+
+	percents := make(chan float32)
+	scanner.Progress(percents)
+	stop := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case percent, ok := <-percents:
+				if !ok {
+					return
+				}
+				progress(percent)
+			case <-stop:
+				// nmap has exited: take the last stats sent, if any,
+				// until the channel is closed. It is never closed when
+				// nmap failed to start.
+				timeout := time.After(time.Second)
+				for {
+					select {
+					case _, ok := <-percents:
+						if !ok {
+							return
+						}
+					case <-timeout:
+						return
+					}
+				}
+			}
+		}
+	}()
+	defer close(stop)
+
+	return scanner.Run()
+}
@@ -9,13 +9,13 @@ import (
 	"github.com/Ullaakut/nmap/v3"
 )
 
-func UdpTcpScanner(ctx context.Context, request domain.ScanTcpUdpRequest) (response domain.ScanTcpUdpResponse, err error) {
+func UdpTcpScanner(ctx context.Context, request domain.ScanTcpUdpRequest, progress func(percent float32)) (response domain.ScanTcpUdpResponse, err error) {
 	var scanResult *nmap.Run
 
 	if request.ScannerType == "UDP" || request.ScannerType == "udp_scan" {
-		scanResult, err = nmap_wrapper.UDPScan(ctx, request.IP, request.Ports)
+		scanResult, err = nmap_wrapper.UDPScan(ctx, request.IP, request.Ports, progress)
 	} else {
-		scanResult, err = nmap_wrapper.TCPScan(ctx, request.IP, request.Ports)
+		scanResult, err = nmap_wrapper.TCPScan(ctx, request.IP, request.Ports, progress)
 	}
 
 	if scanResult == nil {
@@ -62,8 +62,8 @@ func UdpTcpScanner(ctx context.Context, request domain.ScanTcpUdpRequest) (respo
 	return responseResult, err
 }
 
-func OSDetectionScanner(ctx context.Context, request domain.OsDetectionRequest) (response domain.OsDetectionResponse, err error) {
-	scanResult, err := nmap_wrapper.OSDetectionScan(ctx, request.IP)
+func OSDetectionScanner(ctx context.Context, request domain.OsDetectionRequest, progress func(percent float32)) (response domain.OsDetectionResponse, err error) {
+	scanResult, err := nmap_wrapper.OSDetectionScan(ctx, request.IP, progress)
 
 	if scanResult == nil {
 		fmt.Println("OS detection scanner doesn't have any results")
@@ -111,9 +111,9 @@ func OSDetectionScanner(ctx context.Context, request domain.OsDetectionRequest)
 	return responseResult, err
 }
 
-func HostDiscoveryScanner(ctx context.Context, request domain.HostDiscoveryRequest) (response domain.HostDiscoveryResponse, err error) {
+func HostDiscoveryScanner(ctx context.Context, request domain.HostDiscoveryRequest, progress func(percent float32)) (response domain.HostDiscoveryResponse, err error) {
 	fmt.Printf("Starting host discovery for %s\n", request.IP)
-	scanResult, err := nmap_wrapper.HostDiscovery(ctx, request.IP)
+	scanResult, err := nmap_wrapper.HostDiscovery(ctx, request.IP, progress)
 
 	if scanResult == nil {
 		fmt.Println("Host discovery scanner doesn't have any results")
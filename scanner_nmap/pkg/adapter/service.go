@@ -5,40 +5,156 @@ import (
 	"fmt"
 	"scanner_nmap/internal/config"
 	"scanner_nmap/internal/handler"
+	"scanner_nmap/pkg/heartbeat"
 	"scanner_nmap/pkg/logger"
 	"scanner_nmap/pkg/queue"
+	"shared/metrics"
+	"shared/worker"
+	"time"
 )
 
 func Run(ctx context.Context, cfg config.Config, log logger.Logger) error {
+	go func() {
+		if err := metrics.Serve(ctx, cfg.MetricsAddr); err != nil {
+			log.Errorf("Metrics listener on %s failed: %v", cfg.MetricsAddr, err)
+		}
+	}()
+
+	rabbitTLS, err := cfg.RabbitMQTLS.Client()
+	if err != nil {
+		return fmt.Errorf("RabbitMQ TLS: %w", err)
+	}
 	rabbitMQ, err := queue.NewRabbitMQ(queue.RabbitMQConfig{
 		URL:          cfg.RabbitMQURL,
+		TLS:          rabbitTLS,
 		ScannerQueue: cfg.ScannerName,
+		Site:         cfg.Site,
+		Prefetch:     cfg.Workers,
+		ReplyDir:     cfg.ReplyDir,
 	})
 	if err != nil {
 		return fmt.Errorf("failed to connect to RabbitMQ: %w", err)
 	}
 	defer rabbitMQ.Close()
+	if !rabbitMQ.Prioritized() {
+		log.Errorf("Queue for %s was declared without priorities; delete it to enable priority lanes", cfg.ScannerName)
+	}
+
+	hb := heartbeat.New(cfg.ScannerName, handler.Capabilities, heartbeat.Placement{Site: cfg.Site, Subnets: cfg.Subnets})
+	rabbitMQ.SetAgent(hb.AgentHeaders())
+	go heartbeat.Run(ctx, rabbitMQ, hb, cfg.Heartbeat, log)
 
 	msgs, err := rabbitMQ.ConsumeScanRequests(ctx)
 	if err != nil {
 		return fmt.Errorf("failed to consume scan requests: %w", err)
 	}
 
-	log.Infof("Scanner service started (%s), waiting for tasks...", cfg.ScannerName)
-	return processMessages(ctx, msgs, rabbitMQ, log)
+	log.Infof("Scanner service started (%s, %d workers), waiting for tasks...", cfg.ScannerName, cfg.Workers)
+	return processMessages(ctx, msgs, rabbitMQ, log, cfg)
 }
 
-func processMessages(ctx context.Context, msgs <-chan queue.Delivery, rabbitMQ *queue.RabbitMQ, log logger.Logger) error {
-	for {
+// processMessages runs requests on a pool of cfg.Workers workers until ctx
+// is done, then drains: in-flight scans get cfg.DrainTimeout to finish and
+// reply before they are cancelled. Unstarted requests stay unacknowledged
+// and are redelivered.
+func processMessages(ctx context.Context, msgs <-chan queue.Delivery, rabbitMQ *queue.RabbitMQ, log logger.Logger, cfg config.Config) error {
+	// Scans run on a context that outlives ctx so they can finish during
+	// the drain.
+	scanCtx, cancelScans := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancelScans()
+
+	pool := worker.NewPool(cfg.Workers)
+	locks := worker.NewTargetLocks()
+
+	// A worker is reserved before a message is taken, so a message is only
+	// taken off the queue when it can start. A request whose targets
+	// overlap a scan in flight gives its worker back and is deferred
+	// through the busy queue, so it cannot hold up requests for other
+	// targets.
+	for pool.Reserve(ctx) {
+		var msg queue.Delivery
+		ok := false
 		select {
-		case msg, ok := <-msgs:
-			if !ok {
-				return nil
+		case msg, ok = <-msgs:
+		case <-ctx.Done():
+		}
+		if !ok {
+			pool.Release()
+			break
+		}
+
+		taskID, targets := handler.Inspect(msg.Body)
+		unlock, free := locks.TryLock(targets...)
+		if !free {
+			pool.Release()
+			deferrals, err := rabbitMQ.Defer(msg)
+			if err != nil {
+				log.Errorf("Deferring task %s failed, requeueing: %v", taskID, err)
+				_ = msg.Nack(false, true)
+			} else if deferrals == 0 {
+				log.Infof("Task %s targets are being scanned, deferring", taskID)
 			}
-			handler.HandleMessage(ctx, msg, rabbitMQ, log)
+			continue
+		}
+		pool.Go(func() {
+			defer unlock()
+			handle(scanCtx, msg, taskID, rabbitMQ, log)
+		})
+	}
 
-		case <-ctx.Done():
-			return nil
+	if n := pool.Running(); n > 0 {
+		log.Infof("Draining %d in-flight scans (up to %s)...", n, cfg.DrainTimeout)
+	}
+	if !pool.Drain(cfg.DrainTimeout) {
+		log.Errorf("Drain deadline exceeded, cancelling %d scans", pool.Running())
+		cancelScans()
+		// Cancelled scans still reply with their error.
+		pool.Drain(5 * time.Second)
+	}
+	return nil
+}
+
+// handle runs one request, with its targets locked, and settles it:
+// acknowledged once its reply is published, requeued when no reply went
+// out, dead-lettered when it can never be processed or its reply keeps
+// failing. A redelivered task that was already answered gets the cached
+// reply instead of a new scan.
+func handle(ctx context.Context, msg queue.Delivery, taskID string, rabbitMQ *queue.RabbitMQ, log logger.Logger) {
+	if resendCached(ctx, msg, taskID, rabbitMQ, log) {
+		return
+	}
+
+	if err := handler.HandleMessage(ctx, msg, rabbitMQ, log); err != nil {
+		if err := rabbitMQ.DeadLetter(msg, err.Error()); err != nil {
+			log.Errorf("Dead-lettering task %s failed: %v", taskID, err)
+		}
+		return
+	}
+	if msg.ReplyTo != "" && !rabbitMQ.Replied(taskID) {
+		log.Errorf("Task %s produced no reply, requeueing", taskID)
+		if err := rabbitMQ.Requeue(msg, taskID, "no reply published"); err != nil {
+			log.Errorf("Requeueing task %s failed: %v", taskID, err)
+		}
+		return
+	}
+	_ = msg.Ack(false)
+}
+
+func resendCached(ctx context.Context, msg queue.Delivery, taskID string, rabbitMQ *queue.RabbitMQ, log logger.Logger) bool {
+	body, ok := rabbitMQ.CachedReply(msg, taskID)
+	if !ok {
+		return false
+	}
+	log.Infof("Task %s already answered, resending the cached reply", taskID)
+	if msg.ReplyTo != "" {
+		if err := rabbitMQ.Resend(ctx, msg, body); err != nil {
+			log.Errorf("Resending reply for task %s failed: %v", taskID, err)
+			if err := rabbitMQ.Requeue(msg, taskID, "resend: "+err.Error()); err != nil {
+				log.Errorf("Requeueing task %s failed: %v", taskID, err)
+			}
+			return true
 		}
 	}
+	_ = msg.Ack(false)
+	return true
 }
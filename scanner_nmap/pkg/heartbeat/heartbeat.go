@@ -0,0 +1,173 @@
+package heartbeat
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"os"
+	"time"
+
+	"scanner_nmap/pkg/logger"
+
+	"github.com/google/uuid"
+)
+
+// Exchange is the fanout exchange scanner instances announce themselves on.
+const Exchange = "scanner_heartbeats"
+
+// Reply headers naming the instance that produced a result.
+const (
+	HeaderSite     = "x-agent-site"
+	HeaderInstance = "x-agent-instance"
+	HeaderHostname = "x-agent-hostname"
+)
+
+// Version is reported in every heartbeat; set at build time with
+// -ldflags "-X scanner_nmap/pkg/heartbeat.Version=1.2.3".
+var Version = "dev"
+
+type Interface struct {
+	Name  string   `json:"name"`
+	MAC   string   `json:"mac,omitempty"`
+	Addrs []string `json:"addrs,omitempty"`
+}
+
+// Heartbeat describes a running scanner instance: where it runs, which
+// queue it consumes, and what requests it accepts.
+type Heartbeat struct {
+	InstanceID      string          `json:"instance_id"`
+	Service         string          `json:"service"`
+	Version         string          `json:"version"`
+	Hostname        string          `json:"hostname"`
+	Site            string          `json:"site,omitempty"`
+	Subnets         []string        `json:"subnets"`
+	Interfaces      []Interface     `json:"interfaces"`
+	ScanMethods     []string        `json:"scan_methods"`
+	OptionsSchema   json.RawMessage `json:"options_schema"`
+	ResultSchema    json.RawMessage `json:"result_schema"`
+	Collection      string          `json:"collection"`
+	IntervalSeconds float64         `json:"interval_seconds"`
+	StartedAt       time.Time       `json:"started_at"`
+	SentAt          time.Time       `json:"sent_at"`
+
+	fixedSubnets bool
+}
+
+// Capabilities is what a scanner accepts and produces: its scan methods,
+// JSON schemas of request options and results, and the MongoDB collection
+// its results are stored in.
+type Capabilities struct {
+	ScanMethods   []string
+	OptionsSchema string
+	ResultSchema  string
+	Collection    string
+}
+
+// Placement is where an instance scans from: the site or segment it is
+// tagged with and the subnets it reaches. Without configured subnets the
+// networks of its interfaces are advertised.
+type Placement struct {
+	Site    string
+	Subnets []string
+}
+
+type Publisher interface {
+	PublishHeartbeat(body []byte) error
+}
+
+// New describes this process as an instance of service.
+func New(service string, caps Capabilities, place Placement) *Heartbeat {
+	hostname, _ := os.Hostname()
+	return &Heartbeat{
+		InstanceID:    uuid.New().String(),
+		Service:       service,
+		Version:       Version,
+		Hostname:      hostname,
+		Site:          place.Site,
+		Subnets:       place.Subnets,
+		ScanMethods:   caps.ScanMethods,
+		OptionsSchema: json.RawMessage(caps.OptionsSchema),
+		ResultSchema:  json.RawMessage(caps.ResultSchema),
+		Collection:    caps.Collection,
+		StartedAt:     time.Now().UTC(),
+		fixedSubnets:  len(place.Subnets) > 0,
+	}
+}
+
+// AgentHeaders identify this instance in reply headers, so results can
+// be attributed to the agent that produced them.
+func (h *Heartbeat) AgentHeaders() map[string]interface{} {
+	return map[string]interface{}{
+		HeaderSite:     h.Site,
+		HeaderInstance: h.InstanceID,
+		HeaderHostname: h.Hostname,
+	}
+}
+
+// Run publishes hb right away and then every interval until ctx is done.
+// Interfaces are re-read for every beat.
+func Run(ctx context.Context, pub Publisher, hb *Heartbeat, interval time.Duration, log logger.Logger) {
+	hb.IntervalSeconds = interval.Seconds()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		hb.Interfaces = interfaces()
+		if !hb.fixedSubnets {
+			hb.Subnets = subnets(hb.Interfaces)
+		}
+		hb.SentAt = time.Now().UTC()
+		body, err := json.Marshal(hb)
+		if err == nil {
+			err = pub.PublishHeartbeat(body)
+		}
+		if err != nil {
+			log.Errorf("Heartbeat failed: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// interfaces lists the up, non-loopback interfaces and their addresses.
+func interfaces() []Interface {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil
+	}
+	var out []Interface
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		entry := Interface{Name: iface.Name, MAC: iface.HardwareAddr.String()}
+		if addrs, err := iface.Addrs(); err == nil {
+			for _, a := range addrs {
+				entry.Addrs = append(entry.Addrs, a.String())
+			}
+		}
+		out = append(out, entry)
+	}
+	return out
+}
+
+// subnets lists the IPv4 networks the interfaces are attached to.
+func subnets(ifaces []Interface) []string {
+	seen := make(map[string]bool)
+	var out []string
+	for _, iface := range ifaces {
+		for _, addr := range iface.Addrs {
+			ip, network, err := net.ParseCIDR(addr)
+			if err != nil || ip.To4() == nil || seen[network.String()] {
+				continue
+			}
+			seen[network.String()] = true
+			out = append(out, network.String())
+		}
+	}
+	return out
+}
@@ -1,8 +1,13 @@
 package logger
 
 import (
-	"log"
+	"context"
+	"fmt"
+	"log/slog"
 	"os"
+	"scanner_nmap/pkg/tracing"
+
+	"go.opentelemetry.io/otel/trace"
 )
 
 type LoggerInterface interface {
@@ -12,30 +17,49 @@ type LoggerInterface interface {
 	Errorf(format string, args ...interface{})
 }
 
+// Logger writes JSON lines to stdout. Every line carries the scanner
+// name; With and WithContext add fields such as task_id and target.
 type Logger struct {
-	infoLog  *log.Logger
-	errorLog *log.Logger
+	log *slog.Logger
+}
+
+// New returns a logger for scanner and makes it the default for the
+// standard log package too.
+func New(scanner string) *Logger {
+	l := slog.New(slog.NewJSONHandler(os.Stdout, nil)).With("scanner", scanner)
+	slog.SetDefault(l)
+	return &Logger{log: l}
 }
 
-func New() *Logger {
-	return &Logger{
-		infoLog:  log.New(os.Stdout, "INFO: ", log.Ldate|log.Ltime|log.Lshortfile),
-		errorLog: log.New(os.Stderr, "ERROR: ", log.Ldate|log.Ltime|log.Lshortfile),
+// With returns a logger adding the given key/value pairs to every line.
+func (l Logger) With(args ...interface{}) Logger {
+	return Logger{log: l.log.With(args...)}
+}
+
+// WithContext returns a logger adding the task id and trace ids of ctx.
+func (l Logger) WithContext(ctx context.Context) Logger {
+	var args []interface{}
+	if id := tracing.TaskID(ctx); id != "" {
+		args = append(args, "task_id", id)
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		args = append(args, "trace_id", sc.TraceID().String(), "span_id", sc.SpanID().String())
 	}
+	return l.With(args...)
 }
 
-func (l *Logger) Info(msg string) {
-	l.infoLog.Println(msg)
+func (l Logger) Info(msg string) {
+	l.log.Info(msg)
 }
 
-func (l *Logger) Infof(format string, args ...interface{}) {
-	l.infoLog.Printf(format, args...)
+func (l Logger) Infof(format string, args ...interface{}) {
+	l.log.Info(fmt.Sprintf(format, args...))
 }
 
-func (l *Logger) Error(msg string) {
-	l.errorLog.Println(msg)
+func (l Logger) Error(msg string) {
+	l.log.Error(msg)
 }
 
-func (l *Logger) Errorf(format string, args ...interface{}) {
-	l.errorLog.Printf(format, args...)
+func (l Logger) Errorf(format string, args ...interface{}) {
+	l.log.Error(fmt.Sprintf(format, args...))
 }
@@ -0,0 +1,33 @@
+package queue
+
+import (
+	"errors"
+
+	"github.com/streadway/amqp"
+)
+
+// MaxPriority is the x-max-priority scan request queues are declared with.
+// The backend publishes interactive scans at 9, scheduled at 5 and bulk
+// at 1.
+const MaxPriority = 10
+
+// declareRequestQueue declares name as a durable priority queue. A queue
+// declared earlier without x-max-priority cannot be changed in place; it
+// is then used as it is, without priority ordering, and prioritized is
+// false until an operator deletes it. The failed declare closes ch, so the
+// channel to use from then on is returned.
+func declareRequestQueue(conn *amqp.Connection, ch *amqp.Channel, name string) (q amqp.Queue, channel *amqp.Channel, prioritized bool, err error) {
+	q, err = ch.QueueDeclare(name, true, false, false, false, amqp.Table{"x-max-priority": int32(MaxPriority)})
+	if err == nil {
+		return q, ch, true, nil
+	}
+	var amqpErr *amqp.Error
+	if !errors.As(err, &amqpErr) || amqpErr.Code != amqp.PreconditionFailed {
+		return q, ch, false, err
+	}
+	if ch, err = conn.Channel(); err != nil {
+		return q, nil, false, err
+	}
+	q, err = ch.QueueDeclarePassive(name, true, false, false, false, nil)
+	return q, ch, false, err
+}
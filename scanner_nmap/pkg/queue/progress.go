@@ -0,0 +1,162 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"scanner_nmap/pkg/tracing"
+	"sync"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// ProgressExchange is the topic exchange scanners report the progress of
+// running scans on, with the task id as routing key.
+const ProgressExchange = "scan_progress"
+
+// ProgressInterval is the least time between two progress events of a
+// scan; the event reporting the scan complete is always sent.
+const ProgressInterval = time.Second
+
+// ScanProgress is one progress event. Total is 0 when only the
+// percentage is known.
+type ScanProgress struct {
+	TaskID    string    `json:"task_id"`
+	Service   string    `json:"service"`
+	Done      int       `json:"done"`
+	Total     int       `json:"total"`
+	Percent   float64   `json:"percent"`
+	Current   string    `json:"current,omitempty"`
+	ElapsedMs int64     `json:"elapsed_ms"`
+	EtaMs     int64     `json:"eta_ms,omitempty"`
+	At        time.Time `json:"at"`
+}
+
+// Progress reports the progress of one scan. Events are best effort: they
+// are transient and dropped if they cannot be published. It is safe for
+// concurrent use.
+type Progress struct {
+	publish func(body []byte) error
+	taskID  string
+	service string
+	started time.Time
+
+	mu       sync.Mutex
+	last     time.Time
+	finished bool
+	// Of progress known only as a percentage: the percentage of the
+	// current nmap phase, whether a later phase started, and the highest
+	// percentage reported.
+	phasePercent float64
+	laterPhase   bool
+	reported     float64
+}
+
+// phaseCap is the most a percentage reported per nmap phase can reach;
+// only Complete reports 100.
+const phaseCap = 99
+
+// NewProgress reports the progress of the scan of taskID.
+func (r *RabbitMQ) NewProgress(ctx context.Context, taskID string) *Progress {
+	return &Progress{
+		publish: func(body []byte) error { return r.publishProgress(ctx, taskID, body) },
+		taskID:  taskID,
+		service: r.config.ScannerQueue,
+		started: time.Now(),
+	}
+}
+
+// Update reports that done of total targets are finished, current being
+// the last one.
+func (p *Progress) Update(current string, done, total int) {
+	percent := 100.0
+	if total > 0 {
+		percent = 100 * float64(done) / float64(total)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.report(current, done, total, percent, percent >= 100, true)
+}
+
+// Percent reports progress known only as a percentage, as nmap's is. nmap
+// reports it per phase (host discovery, port scan, service scan, ...),
+// each going from 0 to 100, so a drop means a new phase started. The
+// reported percentage never goes back and stays below 100 until Complete;
+// the time left is only estimated while the first phase runs, as how many
+// phases follow is not known.
+func (p *Progress) Percent(current string, percent float64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if percent < p.phasePercent {
+		p.laterPhase = true
+	}
+	p.phasePercent = percent
+	p.reported = math.Min(math.Max(percent, p.reported), phaseCap)
+	p.report(current, 0, 0, p.reported, false, !p.laterPhase && percent < 100)
+}
+
+// Complete reports the scan finished, however far its progress got.
+func (p *Progress) Complete(current string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.report(current, 0, 0, 100, true, false)
+}
+
+// report publishes an event unless the scan was reported complete or the
+// last event is less than ProgressInterval old; p.mu must be held.
+func (p *Progress) report(current string, done, total int, percent float64, complete, eta bool) {
+	now := time.Now()
+	if p.finished || (!complete && now.Sub(p.last) < ProgressInterval) {
+		return
+	}
+	p.last, p.finished = now, complete
+
+	elapsed := now.Sub(p.started)
+	event := ScanProgress{
+		TaskID:    p.taskID,
+		Service:   p.service,
+		Done:      done,
+		Total:     total,
+		Percent:   percent,
+		Current:   current,
+		ElapsedMs: elapsed.Milliseconds(),
+		At:        now,
+	}
+	if eta && percent > 0 && !complete {
+		event.EtaMs = int64(float64(elapsed.Milliseconds()) * (100 - percent) / percent)
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	_ = p.publish(body)
+}
+
+// publishProgress sends body to ProgressExchange under taskID. Progress
+// is only of use while the scan runs, so events expire quickly.
+func (r *RabbitMQ) publishProgress(ctx context.Context, taskID string, body []byte) error {
+	r.progressOnce.Do(func() {
+		r.progressErr = r.channel.ExchangeDeclare(ProgressExchange, "topic", true, false, false, false, nil)
+	})
+	if r.progressErr != nil {
+		return r.progressErr
+	}
+	headers := amqp.Table{}
+	for k, v := range r.agent {
+		headers[k] = v
+	}
+	tracing.Inject(ctx, headers)
+
+	return r.channel.Publish(
+		ProgressExchange,
+		taskID,
+		false,
+		false,
+		amqp.Publishing{
+			ContentType: "application/json",
+			Expiration:  "10000",
+			Headers:     headers,
+			Body:        body,
+		})
+}
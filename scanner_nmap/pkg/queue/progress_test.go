@@ -0,0 +1,99 @@
+package queue
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// recorder is a Progress whose events are kept instead of published, and
+// are never held back by ProgressInterval.
+func recorder(events *[]ScanProgress) *Progress {
+	p := &Progress{taskID: "t", service: "nmap_service", started: time.Now().Add(-10 * time.Second)}
+	p.publish = func(body []byte) error {
+		var event ScanProgress
+		if err := json.Unmarshal(body, &event); err != nil {
+			return err
+		}
+		*events = append(*events, event)
+		p.last = time.Time{}
+		return nil
+	}
+	return p
+}
+
+func TestProgressPercentPhases(t *testing.T) {
+	tests := []struct {
+		name     string
+		percents []float64
+		want     []float64
+		eta      []bool
+	}{
+		{
+			name:     "one phase",
+			percents: []float64{10, 50, 90},
+			want:     []float64{10, 50, 90},
+			eta:      []bool{true, true, true},
+		},
+		{
+			name:     "phase restarts from 0",
+			percents: []float64{40, 100, 0, 30, 99.5},
+			want:     []float64{40, 99, 99, 99, 99},
+			eta:      []bool{true, false, false, false, false},
+		},
+		{
+			name:     "later phase passes the first",
+			percents: []float64{20, 5, 60},
+			want:     []float64{20, 20, 60},
+			eta:      []bool{true, false, false},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var events []ScanProgress
+			p := recorder(&events)
+			for _, percent := range tt.percents {
+				p.Percent("10.0.0.1", percent)
+			}
+			p.Complete("10.0.0.1")
+			p.Percent("10.0.0.1", 50) // after completion: dropped
+
+			if len(events) != len(tt.want)+1 {
+				t.Fatalf("%d events, want %d", len(events), len(tt.want)+1)
+			}
+			for i, want := range tt.want {
+				if events[i].Percent != want {
+					t.Errorf("event %d: percent = %v, want %v", i, events[i].Percent, want)
+				}
+				if hasETA := events[i].EtaMs > 0; hasETA != tt.eta[i] {
+					t.Errorf("event %d: eta = %dms, want one: %v", i, events[i].EtaMs, tt.eta[i])
+				}
+			}
+			if final := events[len(events)-1]; final.Percent != 100 || final.EtaMs != 0 {
+				t.Errorf("final event = %+v, want 100%% with no ETA", final)
+			}
+		})
+	}
+}
+
+func TestProgressUpdateCompletes(t *testing.T) {
+	var events []ScanProgress
+	p := recorder(&events)
+	p.Update("10.0.0.1", 1, 2)
+	p.Update("10.0.0.2", 2, 2)
+	p.Update("10.0.0.2", 2, 2)
+	if len(events) != 2 || events[1].Percent != 100 {
+		t.Fatalf("events = %+v", events)
+	}
+}
+
+func TestProgressInterval(t *testing.T) {
+	var sent int
+	p := &Progress{publish: func([]byte) error { sent++; return nil }, started: time.Now()}
+	p.Percent("10.0.0.1", 10)
+	p.Percent("10.0.0.1", 20)
+	p.Complete("10.0.0.1")
+	if sent != 2 {
+		t.Errorf("sent %d events, want the first and the completion", sent)
+	}
+}
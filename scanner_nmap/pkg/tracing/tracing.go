@@ -0,0 +1,97 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/streadway/amqp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TaskIDHeader is the AMQP header carrying the backend's task id next to
+// the W3C trace context.
+const TaskIDHeader = "task_id"
+
+type taskIDKey struct{}
+
+func WithTaskID(ctx context.Context, taskID string) context.Context {
+	if taskID == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, taskIDKey{}, taskID)
+}
+
+func TaskID(ctx context.Context) string {
+	id, _ := ctx.Value(taskIDKey{}).(string)
+	return id
+}
+
+func Tracer() trace.Tracer {
+	return otel.Tracer("scanner_nmap")
+}
+
+// Setup exports spans to the OTLP/HTTP endpoint; with an empty endpoint
+// only the trace context is propagated. The returned function flushes
+// pending spans.
+func Setup(ctx context.Context, service, endpoint string) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	if endpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(endpoint))
+	if err != nil {
+		return nil, fmt.Errorf("otlp exporter: %w", err)
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(service)))
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+type headerCarrier amqp.Table
+
+func (c headerCarrier) Get(key string) string {
+	v, _ := c[key].(string)
+	return v
+}
+
+func (c headerCarrier) Set(key, value string) { c[key] = value }
+
+func (c headerCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// Inject writes the trace context and task id of ctx into headers.
+func Inject(ctx context.Context, headers amqp.Table) {
+	otel.GetTextMapPropagator().Inject(ctx, headerCarrier(headers))
+	if id := TaskID(ctx); id != "" {
+		headers[TaskIDHeader] = id
+	}
+}
+
+// Extract returns ctx extended with the trace context and task id found
+// in headers.
+func Extract(ctx context.Context, headers amqp.Table) context.Context {
+	if headers == nil {
+		return ctx
+	}
+	ctx = otel.GetTextMapPropagator().Extract(ctx, headerCarrier(headers))
+	if id, ok := headers[TaskIDHeader].(string); ok {
+		ctx = WithTaskID(ctx, id)
+	}
+	return ctx
+}
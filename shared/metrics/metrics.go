@@ -0,0 +1,71 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "scanner"
+
+var (
+	ScanDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "scan_duration_seconds",
+		Help:      "Duration of scans by scan type and status.",
+		Buckets:   []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600},
+	}, []string{"scan_type", "status"})
+
+	TargetsScanned = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "targets_scanned_total",
+		Help:      "Targets (addresses, host:port pairs) scanned, by scan type.",
+	}, []string{"scan_type"})
+
+	Errors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "errors_total",
+		Help:      "Errors by kind.",
+	}, []string{"kind"})
+
+	InFlight = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "scans_in_flight",
+		Help:      "Scans currently running, by scan type.",
+	}, []string{"scan_type"})
+)
+
+// StartScan marks a scan of scanType as running. The returned function
+// ends it, recording its duration under status ("completed" or "failed").
+func StartScan(scanType string) func(status string) {
+	start := time.Now()
+	InFlight.WithLabelValues(scanType).Inc()
+	return func(status string) {
+		InFlight.WithLabelValues(scanType).Dec()
+		ScanDuration.WithLabelValues(scanType, status).Observe(time.Since(start).Seconds())
+	}
+}
+
+// Serve exposes /metrics on addr until ctx is cancelled.
+func Serve(ctx context.Context, addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	srv := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,231 @@
+// Package replies remembers the replies a scanner sent, so redelivered
+// requests are answered without scanning again.
+package replies
+
+import (
+	"container/list"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Replies are remembered this long, and at most this many, for answering
+// redelivered requests.
+const (
+	cacheTTL  = time.Hour
+	cacheSize = 1024
+)
+
+// Cache keeps recently sent replies by task id, so a redelivered request
+// is answered again without scanning. A reply reporting an error is resent
+// only to a redelivery of the request it answered: the backend retries
+// transient failures as new messages under the same task id and expects a
+// fresh scan, so such a message drops the failed reply instead.
+//
+// With a directory every reply is also written there, one file per task,
+// so the requests redelivered after a crash are still answered from the
+// cache. Writes are best effort; files older than cacheTTL are
+// removed.
+type Cache struct {
+	mu        sync.Mutex
+	entries   map[string]*list.Element
+	order     *list.List // oldest first
+	dir       string
+	lastPrune time.Time
+	// requeues counts the requeues of each task whose reply could not be
+	// published.
+	requeues map[string]int
+}
+
+type cachedReply struct {
+	taskID string
+	body   []byte
+	failed bool
+	sentAt time.Time
+}
+
+// storedReply is the file form of a cachedReply.
+type storedReply struct {
+	Body   []byte    `json:"body"`
+	Failed bool      `json:"failed"`
+	SentAt time.Time `json:"sent_at"`
+}
+
+// New returns a cache that also stores replies in dir, unless it is empty.
+func New(dir string) (*Cache, error) {
+	c := &Cache{
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+		dir:      dir,
+		requeues: make(map[string]int),
+	}
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return nil, err
+		}
+		c.prune()
+	}
+	return c, nil
+}
+
+// Get returns the reply sent for taskID. A reply reporting an error is
+// returned only when redelivered is set, i.e. for the message it answered;
+// otherwise it is dropped so the task is scanned again.
+func (c *Cache) Get(taskID string, redelivered bool) ([]byte, bool) {
+	entry, ok := c.lookup(taskID)
+	if !ok {
+		return nil, false
+	}
+	if entry.failed && !redelivered {
+		c.drop(taskID)
+		return nil, false
+	}
+	return entry.body, true
+}
+
+// Sent reports whether any reply, failed or not, went out for taskID.
+func (c *Cache) Sent(taskID string) bool {
+	_, ok := c.lookup(taskID)
+	return ok
+}
+
+func (c *Cache) lookup(taskID string) (*cachedReply, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[taskID]
+	if !ok {
+		return c.load(taskID)
+	}
+	entry := el.Value.(*cachedReply)
+	if time.Since(entry.sentAt) > cacheTTL {
+		c.order.Remove(el)
+		delete(c.entries, taskID)
+		return nil, false
+	}
+	return entry, true
+}
+
+// Put remembers body as the reply sent for taskID.
+func (c *Cache) Put(taskID string, body []byte) {
+	if taskID == "" {
+		return
+	}
+	var reply struct {
+		Error string `json:"error"`
+	}
+	failed := json.Unmarshal(body, &reply) == nil && reply.Error != ""
+	entry := &cachedReply{taskID: taskID, body: body, failed: failed, sentAt: time.Now()}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.insert(entry)
+	delete(c.requeues, taskID)
+	c.store(entry)
+	if time.Since(c.lastPrune) > cacheTTL/4 {
+		c.prune()
+	}
+}
+
+func (c *Cache) insert(entry *cachedReply) {
+	if el, ok := c.entries[entry.taskID]; ok {
+		c.order.Remove(el)
+	}
+	c.entries[entry.taskID] = c.order.PushBack(entry)
+	for c.order.Len() > cacheSize {
+		oldest := c.order.Front()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cachedReply).taskID)
+	}
+}
+
+// drop forgets the reply of taskID, including its file.
+func (c *Cache) drop(taskID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[taskID]; ok {
+		c.order.Remove(el)
+		delete(c.entries, taskID)
+	}
+	if path := c.path(taskID); path != "" {
+		_ = os.Remove(path)
+	}
+}
+
+// Requeued counts one more requeue of taskID and returns the total.
+func (c *Cache) Requeued(taskID string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.requeues) > cacheSize {
+		c.requeues = make(map[string]int)
+	}
+	c.requeues[taskID]++
+	return c.requeues[taskID]
+}
+
+// Forget drops the requeue count of taskID.
+func (c *Cache) Forget(taskID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.requeues, taskID)
+}
+
+// path is the file of taskID, "" when replies are not stored or the task
+// id is not usable as a file name.
+func (c *Cache) path(taskID string) string {
+	if c.dir == "" || taskID == "" || strings.HasPrefix(taskID, ".") || filepath.Base(taskID) != taskID {
+		return ""
+	}
+	return filepath.Join(c.dir, taskID+".json")
+}
+
+func (c *Cache) store(entry *cachedReply) {
+	path := c.path(entry.taskID)
+	if path == "" {
+		return
+	}
+	data, err := json.Marshal(storedReply{Body: entry.body, Failed: entry.failed, SentAt: entry.sentAt})
+	if err != nil {
+		return
+	}
+	tmp := path + ".tmp"
+	if os.WriteFile(tmp, data, 0o600) == nil {
+		_ = os.Rename(tmp, path)
+	}
+}
+
+// load reads the stored reply of taskID into the cache.
+func (c *Cache) load(taskID string) (*cachedReply, bool) {
+	path := c.path(taskID)
+	if path == "" {
+		return nil, false
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+	var stored storedReply
+	if json.Unmarshal(data, &stored) != nil || time.Since(stored.SentAt) > cacheTTL {
+		_ = os.Remove(path)
+		return nil, false
+	}
+	entry := &cachedReply{taskID: taskID, body: stored.Body, failed: stored.Failed, sentAt: stored.SentAt}
+	c.insert(entry)
+	return entry, true
+}
+
+// prune removes the stored replies older than cacheTTL.
+func (c *Cache) prune() {
+	c.lastPrune = time.Now()
+	files, err := os.ReadDir(c.dir)
+	if err != nil {
+		return
+	}
+	for _, f := range files {
+		if info, err := f.Info(); err == nil && time.Since(info.ModTime()) > cacheTTL {
+			_ = os.Remove(filepath.Join(c.dir, f.Name()))
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package replies
+
+import "testing"
+
+func TestCacheFailedReply(t *testing.T) {
+	dir := t.TempDir()
+	c, err := New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Put("done", []byte(`{"task_id": "done", "status": "completed"}`))
+	c.Put("failed", []byte(`{"task_id": "failed", "error": "interface down"}`))
+
+	if _, ok := c.Get("done", false); !ok {
+		t.Error("successful reply not returned to a new message")
+	}
+	if _, ok := c.Get("failed", true); !ok {
+		t.Error("failed reply not resent to a redelivery")
+	}
+
+	// After a restart the stored failed reply is still resent.
+	restarted, err := New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := restarted.Get("failed", true); !ok {
+		t.Error("stored failed reply not resent to a redelivery")
+	}
+
+	// A retry is a new message: it drops the failed reply and is scanned.
+	if _, ok := restarted.Get("failed", false); ok {
+		t.Error("failed reply returned to a retry")
+	}
+	if restarted.Sent("failed") {
+		t.Error("failed reply kept after a retry")
+	}
+	if again, _ := New(dir); again.Sent("failed") {
+		t.Error("failed reply file kept after a retry")
+	}
+}
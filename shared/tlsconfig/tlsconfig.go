@@ -0,0 +1,249 @@
+// Package tlsconfig builds TLS client and server configurations from PEM
+// files and re-reads their certificates on SIGHUP.
+//
+// The key pairs of both, and the client CA bundle of a server, are
+// re-read by Reload. The CA bundle a client verifies servers with is read
+// once: changing it takes a restart.
+package tlsconfig
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// Options names the PEM files of one TLS connection. CAFile verifies the
+// peer: the server for Client, with the system roots used when it is
+// empty, and client certificates for Server. CertFile and KeyFile are the
+// certificate presented to the peer.
+type Options struct {
+	CAFile     string
+	CertFile   string
+	KeyFile    string
+	ServerName string
+}
+
+// Enabled reports whether any option is set.
+func (o Options) Enabled() bool {
+	return o.CAFile != "" || o.CertFile != "" || o.KeyFile != "" || o.ServerName != ""
+}
+
+// Client returns the configuration for dialing a TLS peer, or nil when no
+// option is set. The client certificate is read through the reload set, so
+// connections made after a Reload present the new one. CAFile is read once.
+func (o Options) Client() (*tls.Config, error) {
+	if !o.Enabled() {
+		return nil, nil
+	}
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12, ServerName: o.ServerName}
+	if o.CAFile != "" {
+		pool, err := loadPool(o.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.RootCAs = pool
+	}
+	if o.CertFile != "" || o.KeyFile != "" {
+		pair, err := loadKeyPair(o.CertFile, o.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return pair.current(), nil
+		}
+	}
+	return cfg, nil
+}
+
+// Server returns the configuration for a listener presenting CertFile and
+// KeyFile. With CAFile set, clients must present a certificate it
+// verifies. The server certificate and CAFile are read through the reload
+// set, so handshakes after a Reload use the new ones.
+func (o Options) Server() (*tls.Config, error) {
+	pair, err := loadKeyPair(o.CertFile, o.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return pair.current(), nil
+		},
+	}
+	if o.CAFile != "" {
+		pool, err := loadCAPool(o.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		// ClientCAs is fixed once the listener starts, so the certificate
+		// is verified against the current bundle here instead. It stays
+		// unset: as a hint it would make clients withhold a certificate
+		// from a CA added by a later Reload.
+		cfg.ClientAuth = tls.RequireAnyClientCert
+		cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+			return pool.verifyClient(cs.PeerCertificates)
+		}
+	}
+	return cfg, nil
+}
+
+func loadPool(file string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("read CA bundle: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates in CA bundle %s", file)
+	}
+	return pool, nil
+}
+
+// caPool is a CA bundle re-read from disk by Reload.
+type caPool struct {
+	file string
+
+	mu   sync.RWMutex
+	pool *x509.CertPool
+}
+
+func (p *caPool) current() *x509.CertPool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.pool
+}
+
+func (p *caPool) reload() error {
+	pool, err := loadPool(p.file)
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	p.pool = pool
+	p.mu.Unlock()
+	return nil
+}
+
+// verifyClient verifies a client certificate chain as
+// tls.RequireAndVerifyClientCert would, against the current bundle.
+func (p *caPool) verifyClient(certs []*x509.Certificate) error {
+	if len(certs) == 0 {
+		return errors.New("client certificate required")
+	}
+	opts := x509.VerifyOptions{
+		Roots:         p.current(),
+		Intermediates: x509.NewCertPool(),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	for _, cert := range certs[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	_, err := certs[0].Verify(opts)
+	return err
+}
+
+// keyPair is a certificate and key re-read from disk by Reload.
+type keyPair struct {
+	certFile, keyFile string
+
+	mu   sync.RWMutex
+	cert *tls.Certificate
+}
+
+func (p *keyPair) current() *tls.Certificate {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.cert
+}
+
+func (p *keyPair) reload() error {
+	cert, err := tls.LoadX509KeyPair(p.certFile, p.keyFile)
+	if err != nil {
+		return fmt.Errorf("load key pair %s: %w", p.certFile, err)
+	}
+	p.mu.Lock()
+	p.cert = &cert
+	p.mu.Unlock()
+	return nil
+}
+
+// pairs and pools are the reload set, keyed by their files.
+var (
+	reloadMu sync.Mutex
+	pairs    = make(map[[2]string]*keyPair)
+	pools    = make(map[string]*caPool)
+)
+
+func loadKeyPair(certFile, keyFile string) (*keyPair, error) {
+	if certFile == "" || keyFile == "" {
+		return nil, errors.New("certificate and key must be set together")
+	}
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	key := [2]string{certFile, keyFile}
+	if pair, ok := pairs[key]; ok {
+		return pair, nil
+	}
+	pair := &keyPair{certFile: certFile, keyFile: keyFile}
+	if err := pair.reload(); err != nil {
+		return nil, err
+	}
+	pairs[key] = pair
+	return pair, nil
+}
+
+func loadCAPool(file string) (*caPool, error) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	if pool, ok := pools[file]; ok {
+		return pool, nil
+	}
+	pool := &caPool{file: file}
+	if err := pool.reload(); err != nil {
+		return nil, err
+	}
+	pools[file] = pool
+	return pool, nil
+}
+
+// Reload re-reads every certificate and server CA bundle loaded so far.
+// One that fails to load keeps what it had.
+func Reload() error {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	var errs []error
+	for _, pair := range pairs {
+		if err := pair.reload(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for _, pool := range pools {
+		if err := pool.reload(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// WatchSIGHUP calls Reload on every SIGHUP until ctx is done and passes
+// the result to report. Established connections keep the certificate they
+// were made with.
+func WatchSIGHUP(ctx context.Context, report func(error)) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			report(Reload())
+		}
+	}
+}
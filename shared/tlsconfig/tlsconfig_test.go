@@ -0,0 +1,163 @@
+package tlsconfig
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+func newCert(t *testing.T, name string, parent *testCert, usage x509.ExtKeyUsage) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	signer, signerKey := tmpl, key
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage |= x509.KeyUsageCertSign
+		tmpl.ExtKeyUsage = nil
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{cert: cert, key: key, der: der}
+}
+
+func writeCert(t *testing.T, dir, name string, c *testCert) (certFile, keyFile string) {
+	t.Helper()
+	certFile = filepath.Join(dir, name+".crt")
+	keyFile = filepath.Join(dir, name+".key")
+	keyDER, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+// handshake runs a TLS handshake between the two configurations and
+// returns the server's error.
+func handshake(t *testing.T, server, client *tls.Config) error {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := tls.Dial("tcp", ln.Addr().String(), client)
+		if err == nil {
+			conn.Close()
+		}
+	}()
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	return tls.Server(conn, server).Handshake()
+}
+
+func TestServerReloadsClientCAs(t *testing.T) {
+	dir := t.TempDir()
+	oldCA := newCert(t, "old-ca", nil, 0)
+	newCA := newCert(t, "new-ca", nil, 0)
+	server := newCert(t, "backend", oldCA, x509.ExtKeyUsageServerAuth)
+	client := newCert(t, "scanner", newCA, x509.ExtKeyUsageClientAuth)
+
+	caFile, _ := writeCert(t, dir, "ca", oldCA)
+	serverCert, serverKey := writeCert(t, dir, "server", server)
+	clientCert, clientKey := writeCert(t, dir, "client", client)
+
+	serverCfg, err := Options{CAFile: caFile, CertFile: serverCert, KeyFile: serverKey}.Server()
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(oldCA.cert)
+	clientCfg := &tls.Config{ServerName: "backend", RootCAs: roots}
+	pair, err := tls.LoadX509KeyPair(clientCert, clientKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := handshake(t, serverCfg, clientCfg); err == nil {
+		t.Fatal("handshake without a client certificate succeeded")
+	}
+	clientCfg.Certificates = []tls.Certificate{pair}
+	if err := handshake(t, serverCfg, clientCfg); err == nil {
+		t.Fatal("client certificate from an unknown CA was accepted")
+	}
+
+	writeCert(t, dir, "ca", newCA)
+	if err := Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if err := handshake(t, serverCfg, clientCfg); err != nil {
+		t.Fatalf("client certificate from the reloaded CA was rejected: %v", err)
+	}
+}
+
+func TestVerifyClientUsage(t *testing.T) {
+	ca := newCert(t, "ca", nil, 0)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	p := &caPool{pool: pool}
+
+	tests := []struct {
+		name    string
+		certs   []*x509.Certificate
+		wantErr bool
+	}{
+		{"client auth", []*x509.Certificate{newCert(t, "scanner", ca, x509.ExtKeyUsageClientAuth).cert}, false},
+		{"server auth only", []*x509.Certificate{newCert(t, "backend", ca, x509.ExtKeyUsageServerAuth).cert}, true},
+		{"no certificate", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := p.verifyClient(tt.certs); (err != nil) != tt.wantErr {
+				t.Fatalf("verifyClient() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
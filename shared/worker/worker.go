@@ -0,0 +1,333 @@
+// Package worker runs scan requests concurrently on a bounded pool and
+// keeps parallel scans off overlapping targets.
+package worker
+
+import (
+	"context"
+	"net/netip"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode"
+)
+
+// Pool bounds the number of scans running at once.
+type Pool struct {
+	slots   chan struct{}
+	wg      sync.WaitGroup
+	running atomic.Int64
+}
+
+func NewPool(size int) *Pool {
+	if size < 1 {
+		size = 1
+	}
+	return &Pool{slots: make(chan struct{}, size)}
+}
+
+// Size is the number of workers.
+func (p *Pool) Size() int { return cap(p.slots) }
+
+// Running is the number of scans in flight.
+func (p *Pool) Running() int { return int(p.running.Load()) }
+
+// Reserve waits for a free worker. It returns false if ctx is done first.
+// Reserve before taking a message off the queue, so no message is taken
+// that cannot be started.
+func (p *Pool) Reserve(ctx context.Context) bool {
+	select {
+	case p.slots <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// Release returns a reserved worker that was not used.
+func (p *Pool) Release() { <-p.slots }
+
+// Go runs fn on the worker reserved by Reserve.
+func (p *Pool) Go(fn func()) {
+	p.wg.Add(1)
+	p.running.Add(1)
+	go func() {
+		defer func() {
+			p.running.Add(-1)
+			<-p.slots
+			p.wg.Done()
+		}()
+		fn()
+	}()
+}
+
+// Drain waits for running scans to finish. It returns false if they are
+// still running after timeout.
+func (p *Pool) Drain(timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// TargetLocks keeps concurrent scans off overlapping targets. Addresses,
+// CIDR prefixes, "first-last" ranges and nmap octet ranges such as
+// 10.0.5.1-20 or 10.0.5.* are compared as address ranges, so a subnet and a
+// host inside it overlap. A hostname only overlaps itself; a target that is
+// none of these overlaps everything, so it cannot run beside another scan.
+type TargetLocks struct {
+	mu   sync.Mutex
+	held map[*[]span]struct{}
+}
+
+func NewTargetLocks() *TargetLocks {
+	return &TargetLocks{held: make(map[*[]span]struct{})}
+}
+
+// TryLock claims targets unless one of them overlaps a target already
+// claimed, in which case nothing is claimed and ok is false. Claiming all
+// targets at once means overlapping requests cannot deadlock.
+func (l *TargetLocks) TryLock(targets ...string) (unlock func(), ok bool) {
+	spans := make([]span, 0, len(targets))
+	for _, t := range targets {
+		if t = strings.TrimSpace(t); t != "" {
+			spans = append(spans, parseSpans(t)...)
+		}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for held := range l.held {
+		for _, h := range *held {
+			for _, s := range spans {
+				if h.overlaps(s) {
+					return nil, false
+				}
+			}
+		}
+	}
+	claim := &spans
+	l.held[claim] = struct{}{}
+	return func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		delete(l.held, claim)
+	}, true
+}
+
+// span is a target as the inclusive address range first..last, by name
+// when it is a hostname, or any when it could not be parsed.
+type span struct {
+	name        string
+	any         bool
+	first, last netip.Addr
+}
+
+// maxOctetSpans bounds how many ranges an nmap octet target is split into;
+// beyond it the target is locked as one range from its lowest address to
+// its highest.
+const maxOctetSpans = 256
+
+// parseSpans parses one target, which may list several separated by commas
+// or spaces.
+func parseSpans(target string) []span {
+	if prefix, err := netip.ParsePrefix(target); err == nil {
+		prefix = prefix.Masked()
+		return []span{{first: prefix.Addr(), last: lastAddr(prefix)}}
+	}
+	if from, to, ok := strings.Cut(target, "-"); ok {
+		first, err1 := netip.ParseAddr(strings.TrimSpace(from))
+		last, err2 := netip.ParseAddr(strings.TrimSpace(to))
+		if err1 == nil && err2 == nil && first.BitLen() == last.BitLen() && first.Compare(last) <= 0 {
+			return []span{{first: first, last: last}}
+		}
+	}
+	if addr, err := netip.ParseAddr(target); err == nil {
+		addr = addr.Unmap()
+		return []span{{first: addr, last: addr}}
+	}
+	if spans, ok := parseOctets(target); ok {
+		return spans
+	}
+	fields := strings.FieldsFunc(target, func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
+	})
+	if len(fields) > 1 {
+		var spans []span
+		for _, f := range fields {
+			spans = append(spans, parseSpans(f)...)
+		}
+		return spans
+	}
+	if isHostname(target) {
+		return []span{{name: strings.ToLower(target)}}
+	}
+	return []span{{any: true}}
+}
+
+// octetRange is an inclusive range of values of one IPv4 octet.
+type octetRange struct{ lo, hi int }
+
+// parseOctets parses an nmap IPv4 target whose octets are numbers, ranges,
+// "*" or comma lists of those, e.g. 10.0.5.1-20, 10.0.5.*, 10.0.5-6.1 or
+// 10.0.5.1,3,7. ok is false if target is not one.
+func parseOctets(target string) (spans []span, ok bool) {
+	parts := strings.Split(target, ".")
+	if len(parts) != 4 {
+		return nil, false
+	}
+	var octets [4][]octetRange
+	for i, part := range parts {
+		if octets[i], ok = parseOctet(part); !ok {
+			return nil, false
+		}
+	}
+
+	// Octets after i that take every value make one contiguous range of
+	// octet i; otherwise octet i is walked value by value.
+	full := func(i int) bool {
+		for _, o := range octets[i+1:] {
+			if len(o) != 1 || o[0] != (octetRange{0, 255}) {
+				return false
+			}
+		}
+		return true
+	}
+	var walk func(i int, addr [4]byte) bool
+	walk = func(i int, addr [4]byte) bool {
+		for _, r := range octets[i] {
+			if full(i) {
+				first, last := addr, addr
+				first[i], last[i] = byte(r.lo), byte(r.hi)
+				for j := i + 1; j < 4; j++ {
+					first[j], last[j] = 0, 255
+				}
+				spans = append(spans, span{first: netip.AddrFrom4(first), last: netip.AddrFrom4(last)})
+				if len(spans) > maxOctetSpans {
+					return false
+				}
+				continue
+			}
+			for v := r.lo; v <= r.hi; v++ {
+				addr[i] = byte(v)
+				if !walk(i+1, addr) {
+					return false
+				}
+			}
+		}
+		return true
+	}
+	if !walk(0, [4]byte{}) {
+		var first, last [4]byte
+		for i, o := range octets {
+			first[i], last[i] = 255, 0
+			for _, r := range o {
+				first[i] = min(first[i], byte(r.lo))
+				last[i] = max(last[i], byte(r.hi))
+			}
+		}
+		return []span{{first: netip.AddrFrom4(first), last: netip.AddrFrom4(last)}}, true
+	}
+	return spans, true
+}
+
+// parseOctet parses one octet of an nmap target: "*", "n", "n-m", "-m",
+// "n-" or a comma list of those.
+func parseOctet(part string) ([]octetRange, bool) {
+	var ranges []octetRange
+	for _, item := range strings.Split(part, ",") {
+		if item == "*" {
+			ranges = append(ranges, octetRange{0, 255})
+			continue
+		}
+		from, to, isRange := strings.Cut(item, "-")
+		lo, ok := octetValue(from, 0)
+		if !ok {
+			return nil, false
+		}
+		hi := lo
+		if isRange {
+			if hi, ok = octetValue(to, 255); !ok {
+				return nil, false
+			}
+		} else if from == "" {
+			return nil, false
+		}
+		if lo > hi {
+			return nil, false
+		}
+		ranges = append(ranges, octetRange{lo, hi})
+	}
+	return ranges, true
+}
+
+// octetValue parses an octet number; empty is def, the open end of a range.
+func octetValue(s string, def int) (int, bool) {
+	if s == "" {
+		return def, true
+	}
+	if len(s) > 3 {
+		return 0, false
+	}
+	v := 0
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return 0, false
+		}
+		v = v*10 + int(c-'0')
+	}
+	return v, v <= 255
+}
+
+// isHostname reports whether target can be a DNS name rather than a
+// mistyped address.
+func isHostname(target string) bool {
+	letter := false
+	for _, r := range target {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z':
+			letter = true
+		case r >= '0' && r <= '9', r == '-', r == '.', r == '_':
+		default:
+			return false
+		}
+	}
+	return letter && target[0] != '-' && target[0] != '.'
+}
+
+// lastAddr is the highest address in prefix.
+func lastAddr(prefix netip.Prefix) netip.Addr {
+	addr := prefix.Addr().As16()
+	offset := 0
+	if prefix.Addr().Is4() {
+		offset = 96
+	}
+	for bit := offset + prefix.Bits(); bit < 128; bit++ {
+		addr[bit/8] |= 1 << (7 - bit%8)
+	}
+	last := netip.AddrFrom16(addr)
+	if prefix.Addr().Is4() {
+		last = last.Unmap()
+	}
+	return last
+}
+
+func (s span) overlaps(o span) bool {
+	if s.any || o.any {
+		return true
+	}
+	if s.name != "" || o.name != "" {
+		return s.name == o.name
+	}
+	if s.first.BitLen() != o.first.BitLen() {
+		return false
+	}
+	return s.first.Compare(o.last) <= 0 && o.first.Compare(s.last) <= 0
+}
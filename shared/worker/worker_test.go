@@ -0,0 +1,143 @@
+package worker
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseSpans(t *testing.T) {
+	tests := []struct {
+		target string
+		want   []string // "first-last", a name, or "*" for any
+	}{
+		{target: "10.0.5.7", want: []string{"10.0.5.7-10.0.5.7"}},
+		{target: "10.0.5.0/24", want: []string{"10.0.5.0-10.0.5.255"}},
+		{target: "10.0.5.9/24", want: []string{"10.0.5.0-10.0.5.255"}},
+		{target: "10.0.5.1-10.0.5.20", want: []string{"10.0.5.1-10.0.5.20"}},
+		{target: "10.0.5.1 - 10.0.5.20", want: []string{"10.0.5.1-10.0.5.20"}},
+		{target: "fd00::/120", want: []string{"fd00::-fd00::ff"}},
+		{target: "::ffff:10.0.5.7", want: []string{"10.0.5.7-10.0.5.7"}},
+		{target: "10.0.5.1-20", want: []string{"10.0.5.1-10.0.5.20"}},
+		{target: "10.0.5.*", want: []string{"10.0.5.0-10.0.5.255"}},
+		{target: "10.0.*.*", want: []string{"10.0.0.0-10.0.255.255"}},
+		{target: "10.0.5-6.1", want: []string{"10.0.5.1-10.0.5.1", "10.0.6.1-10.0.6.1"}},
+		{target: "10.0.5.1,3,7-8", want: []string{"10.0.5.1-10.0.5.1", "10.0.5.3-10.0.5.3", "10.0.5.7-10.0.5.8"}},
+		{target: "10.0.5.250-", want: []string{"10.0.5.250-10.0.5.255"}},
+		{target: "10.*.*.1", want: []string{"10.0.0.1-10.255.255.1"}},
+		{target: "10.0.5.1, 10.0.6.0/24 router", want: []string{"10.0.5.1-10.0.5.1", "10.0.6.0-10.0.6.255", "router"}},
+		{target: "Router.Local", want: []string{"router.local"}},
+		{target: "10.0.5.20-10.0.5.1", want: []string{"*"}},
+		{target: "10.0.5.20-1", want: []string{"*"}},
+		{target: "10.0.5.256", want: []string{"*"}},
+		{target: "10.0.5", want: []string{"*"}},
+		{target: "fd00::zz", want: []string{"*"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			var got []string
+			for _, s := range parseSpans(tt.target) {
+				switch {
+				case s.any:
+					got = append(got, "*")
+				case s.name != "":
+					got = append(got, s.name)
+				default:
+					got = append(got, s.first.String()+"-"+s.last.String())
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTryLock(t *testing.T) {
+	tests := []struct {
+		held, next []string
+		free       bool
+	}{
+		{held: []string{"10.0.5.0/24"}, next: []string{"10.0.5.7"}, free: false},
+		{held: []string{"10.0.5.7"}, next: []string{"10.0.5.0/24"}, free: false},
+		{held: []string{"10.0.5.0/24"}, next: []string{"10.0.6.7"}, free: true},
+		{held: []string{"10.0.5.1-10.0.5.20"}, next: []string{"10.0.5.20/32"}, free: false},
+		{held: []string{"10.0.5.1-10.0.5.20"}, next: []string{"10.0.5.21"}, free: true},
+		{held: []string{"10.0.5.7", "10.0.9.1"}, next: []string{"10.0.8.1", "10.0.9.1"}, free: false},
+		{held: []string{"router.local"}, next: []string{"ROUTER.local"}, free: false},
+		{held: []string{"router.local"}, next: []string{"10.0.5.7"}, free: true},
+		{held: []string{"fd00::/64"}, next: []string{"10.0.0.0/8"}, free: true},
+		{held: []string{"10.0.5.7"}, next: []string{" ", ""}, free: true},
+		{held: []string{"10.0.5.1-20"}, next: []string{"10.0.5.20"}, free: false},
+		{held: []string{"10.0.5.1-20"}, next: []string{"10.0.5.21"}, free: true},
+		{held: []string{"10.0.5.*"}, next: []string{"10.0.5.0/28"}, free: false},
+		{held: []string{"10.0.5-6.1"}, next: []string{"10.0.6.1"}, free: false},
+		{held: []string{"10.0.5-6.1"}, next: []string{"10.0.5.2"}, free: true},
+		{held: []string{"10.0.5.1,3,7"}, next: []string{"10.0.5.3"}, free: false},
+		{held: []string{"10.0.5.1,3,7"}, next: []string{"10.0.5.4-6"}, free: true},
+		{held: []string{"10.0.9.1, 10.0.5.0/24"}, next: []string{"10.0.5.7"}, free: false},
+		{held: []string{"10.0.5.20-10.0.5.1"}, next: []string{"192.168.1.1"}, free: false},
+		{held: []string{"10.0.6.1"}, next: []string{"10.0.5.300"}, free: false},
+		{held: []string{"router.local"}, next: []string{"fd00::zz"}, free: false},
+	}
+	for _, tt := range tests {
+		locks := NewTargetLocks()
+		if _, ok := locks.TryLock(tt.held...); !ok {
+			t.Fatalf("%v: first lock refused", tt.held)
+		}
+		unlock, ok := locks.TryLock(tt.next...)
+		if ok != tt.free {
+			t.Errorf("%v then %v: free = %v, want %v", tt.held, tt.next, ok, tt.free)
+		}
+		if ok {
+			unlock()
+		}
+	}
+}
+
+func TestTryLockUnlock(t *testing.T) {
+	locks := NewTargetLocks()
+	unlock, ok := locks.TryLock("10.0.5.0/24")
+	if !ok {
+		t.Fatal("lock refused")
+	}
+	if _, ok := locks.TryLock("10.0.5.7", "10.0.6.1"); ok {
+		t.Fatal("overlapping lock granted")
+	}
+	// A refused request claims nothing.
+	other, ok := locks.TryLock("10.0.6.1")
+	if !ok {
+		t.Fatal("10.0.6.1 was claimed by a refused request")
+	}
+	other()
+	unlock()
+	if _, ok := locks.TryLock("10.0.5.7"); !ok {
+		t.Fatal("lock refused after unlock")
+	}
+}
+
+func TestPoolReserveAndDrain(t *testing.T) {
+	pool := NewPool(1)
+	if !pool.Reserve(context.Background()) {
+		t.Fatal("first reserve failed")
+	}
+	release := make(chan struct{})
+	pool.Go(func() { <-release })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if pool.Reserve(ctx) {
+		t.Fatal("reserved a second worker of a pool of one")
+	}
+	if pool.Drain(10 * time.Millisecond) {
+		t.Fatal("drained with a scan in flight")
+	}
+	close(release)
+	if !pool.Drain(time.Second) {
+		t.Fatal("drain timed out")
+	}
+	if pool.Running() != 0 {
+		t.Fatalf("running = %d after drain", pool.Running())
+	}
+}
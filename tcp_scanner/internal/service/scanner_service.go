@@ -7,17 +7,50 @@ import (
 	"strings"
 	"time"
 
+	"shared/metrics"
 	"test_tcp/internal/config"
+	"test_tcp/pkg/heartbeat"
 	"test_tcp/pkg/logger"
 	"test_tcp/pkg/queue"
+	"test_tcp/pkg/tracing"
 
 	minio "github.com/minio/minio-go/v7"
 	"github.com/minio/minio-go/v7/pkg/credentials"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// Capabilities are advertised in the scanner's heartbeats.
+var Capabilities = heartbeat.Capabilities{
+	ScanMethods: []string{"banner_grab"},
+	OptionsSchema: `{
+    "type": "object",
+    "required": ["host", "port"],
+    "properties": {
+      "host": {"type": "string", "description": "Host name or IPv4 address"},
+      "port": {"type": "string", "description": "TCP port to read the banner from"}
+    }
+  }`,
+	ResultSchema: `{
+    "type": "object",
+    "required": ["task_id", "host", "port", "status"],
+    "properties": {
+      "task_id": {"type": "string"},
+      "host": {"type": "string"},
+      "port": {"type": "string"},
+      "hex_object_key": {"type": "string"},
+      "decoded_text": {"type": "string"},
+      "status": {"type": "string", "enum": ["completed", "failed"]},
+      "error": {"type": "string"}
+    }
+  }`,
+	Collection: "l4_devices",
+}
+
 type Service struct {
 	cfg *config.Config
 	log logger.Logger
@@ -27,14 +60,39 @@ type Service struct {
 }
 
 func Run(ctx context.Context, cfg *config.Config, log logger.Logger) error {
-	mq, err := queue.New(cfg.RabbitMQURL, cfg.ScannerName)
+	go func() {
+		if err := metrics.Serve(ctx, cfg.MetricsAddr); err != nil {
+			log.Errorf("metrics listener on %s: %v", cfg.MetricsAddr, err)
+		}
+	}()
+
+	rabbitTLS, err := cfg.RabbitMQTLS.Client()
+	if err != nil {
+		return fmt.Errorf("rabbitmq tls: %w", err)
+	}
+	mq, err := queue.New(cfg.RabbitMQURL, cfg.ScannerName, cfg.Site, rabbitTLS)
 	if err != nil {
 		return fmt.Errorf("rabbitmq connect: %w", err)
 	}
 	defer mq.Close()
-	log.Infof("Connected RabbitMQ, queue=%s", cfg.ScannerName)
+	log.Infof("Connected RabbitMQ, queue=%s site=%q", cfg.ScannerName, cfg.Site)
+	if !mq.Prioritized() {
+		log.Warnf("queue %s was declared without priorities; delete it to enable priority lanes", cfg.ScannerName)
+	}
+
+	hb := heartbeat.New(cfg.ScannerName, Capabilities, heartbeat.Placement{Site: cfg.Site, Subnets: cfg.Subnets})
+	mq.SetAgent(hb.AgentHeaders())
+	go heartbeat.Run(ctx, mq, hb, cfg.Heartbeat, log)
 
-	mongoCli, err := mongo.Connect(ctx, options.Client().ApplyURI(cfg.MongoURI))
+	mongoOpts := options.Client().ApplyURI(cfg.MongoURI)
+	mongoTLS, err := cfg.MongoTLS.Client()
+	if err != nil {
+		return fmt.Errorf("mongo tls: %w", err)
+	}
+	if mongoTLS != nil {
+		mongoOpts.SetTLSConfig(mongoTLS)
+	}
+	mongoCli, err := mongo.Connect(ctx, mongoOpts)
 	if err != nil {
 		return fmt.Errorf("mongo connect: %w", err)
 	}
@@ -42,10 +100,24 @@ func Run(ctx context.Context, cfg *config.Config, log logger.Logger) error {
 	mdb := mongoCli.Database(cfg.MongoDB)
 	log.Infof("Connected MongoDB db=%s", cfg.MongoDB)
 
-	mio, err := minio.New(cfg.MinIOEndpoint, &minio.Options{
+	mioOpts := &minio.Options{
 		Creds:  credentials.NewStaticV4(cfg.MinIOAccess, cfg.MinIOSecret, ""),
-		Secure: false,
-	})
+		Secure: cfg.MinIOSecure,
+	}
+	minioTLS, err := cfg.MinIOTLS.Client()
+	if err != nil {
+		return fmt.Errorf("minio tls: %w", err)
+	}
+	if minioTLS != nil {
+		transport, err := minio.DefaultTransport(true)
+		if err != nil {
+			return fmt.Errorf("minio transport: %w", err)
+		}
+		transport.TLSClientConfig = minioTLS
+		mioOpts.Secure = true
+		mioOpts.Transport = transport
+	}
+	mio, err := minio.New(cfg.MinIOEndpoint, mioOpts)
 	if err != nil {
 		return fmt.Errorf("minio connect: %w", err)
 	}
@@ -58,13 +130,17 @@ func Run(ctx context.Context, cfg *config.Config, log logger.Logger) error {
 			return fmt.Errorf("minio make bucket: %w", err)
 		}
 	}
-	log.Infof("Connected MinIO bucket=%s", cfg.MinIOBucket)
+	log.Infof("Connected MinIO bucket=%s secure=%t", cfg.MinIOBucket, mioOpts.Secure)
 
 	s := &Service{cfg: cfg, log: log, mq: mq, mdb: mdb, mio: mio}
 	msgs, err := mq.Consume(ctx)
 	if err != nil {
 		return fmt.Errorf("consume: %w", err)
 	}
+	purges, err := mq.ConsumeQueue(ctx, cfg.PurgeQueue)
+	if err != nil {
+		return fmt.Errorf("consume %s: %w", cfg.PurgeQueue, err)
+	}
 	log.Infof("Waiting for TCP tasks...")
 
 	for {
@@ -76,27 +152,61 @@ func Run(ctx context.Context, cfg *config.Config, log logger.Logger) error {
 				return fmt.Errorf("rabbit channel closed")
 			}
 			s.handle(ctx, d)
+		case d, ok := <-purges:
+			if !ok {
+				return fmt.Errorf("rabbit purge channel closed")
+			}
+			s.handlePurge(ctx, d)
 		}
 	}
 }
 
 func (s *Service) handle(ctx context.Context, d queue.Delivery) {
+	ctx = tracing.Extract(ctx, d.Headers)
+
 	req, err := queue.ParseTCPRequest(d.Body)
 	if err != nil {
-		s.log.Errorf("bad msg: %v", err)
+		s.log.WithContext(ctx).Errorf("bad msg: %v", err)
+		metrics.Errors.WithLabelValues("decode").Inc()
 		s.mq.Nack(d)
 		return
 	}
 
-	s.log.Infof("TCP read: %s:%s (task=%s)", req.Host, req.Port, req.TaskID)
+	target := net.JoinHostPort(req.Host, req.Port)
+	ctx = tracing.WithTaskID(ctx, req.TaskID)
+	ctx, span := tracing.Tracer().Start(ctx, "tcp read",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.String("task_id", req.TaskID),
+			attribute.String("target", target),
+		))
+	defer span.End()
+	log := s.log.WithContext(ctx).With("target", target)
+
+	log.Infof("TCP read started")
+	progress := s.mq.NewProgress(ctx, req.TaskID)
+	progress.Update(target, 0, 1)
+	done := metrics.StartScan("tcp")
 	hexData, decoded, readErr := s.readTCP(ctx, req.Host, req.Port)
+	metrics.TargetsScanned.WithLabelValues("tcp").Inc()
+	progress.Update(target, 1, 1)
+	if readErr != nil {
+		log.Errorf("TCP read failed: %v", readErr)
+		span.RecordError(readErr)
+		span.SetStatus(codes.Error, readErr.Error())
+		metrics.Errors.WithLabelValues("connect").Inc()
+		done("failed")
+	} else {
+		done("completed")
+	}
 	var objKey string
 	if readErr == nil {
 		objKey = fmt.Sprintf("%s_%d.hex", req.TaskID, time.Now().UnixNano())
 		reader := strings.NewReader(bytesToHexLine(hexData))
 		_, err := s.mio.PutObject(ctx, s.cfg.MinIOBucket, objKey, reader, int64(reader.Len()), minio.PutObjectOptions{ContentType: "text/plain"})
 		if err != nil {
-			s.log.Errorf("minio put: %v", err)
+			log.Errorf("minio put: %v", err)
+			metrics.Errors.WithLabelValues("storage").Inc()
 		}
 		coll := s.mdb.Collection(s.cfg.MongoColl)
 		status := "completed"
@@ -116,7 +226,8 @@ func (s *Service) handle(ctx context.Context, d queue.Delivery) {
 			"created_at":     time.Now(),
 		})
 		if err != nil {
-			s.log.Errorf("mongo insert: %v", err)
+			log.Errorf("mongo insert: %v", err)
+			metrics.Errors.WithLabelValues("storage").Inc()
 		}
 	}
 
@@ -126,13 +237,77 @@ func (s *Service) handle(ctx context.Context, d queue.Delivery) {
 			resp.Status = "failed"
 			resp.Error = readErr.Error()
 		}
-		if err := s.mq.Reply(d.ReplyTo, d.CorrelationId, resp); err != nil {
-			s.log.Errorf("reply: %v", err)
+		if err := s.mq.Reply(ctx, d.ReplyTo, d.CorrelationId, resp); err != nil {
+			log.Errorf("reply: %v", err)
+			metrics.Errors.WithLabelValues("reply").Inc()
 		}
 	}
 	s.mq.Ack(d)
 }
 
+// maxPurgeAttempts is how often a purge whose objects could not all be
+// removed is tried before it is dead-lettered.
+const maxPurgeAttempts = 5
+
+// handlePurge removes MinIO objects (and their raw l4 documents) whose
+// history records were deleted in the backend. The raw documents index the
+// objects, so unless every object is gone they are kept and the purge is
+// retried later, whole.
+func (s *Service) handlePurge(ctx context.Context, d queue.Delivery) {
+	req, err := queue.ParsePurgeRequest(d.Body)
+	if err != nil {
+		s.log.Errorf("bad purge msg: %v", err)
+		metrics.Errors.WithLabelValues("decode").Inc()
+		s.mq.Nack(d)
+		return
+	}
+	if len(req.ObjectKeys) == 0 {
+		s.mq.Ack(d)
+		return
+	}
+
+	objects := make(chan minio.ObjectInfo, len(req.ObjectKeys))
+	for _, key := range req.ObjectKeys {
+		objects <- minio.ObjectInfo{Key: key}
+	}
+	close(objects)
+
+	var failed int
+	var firstErr error
+	for rerr := range s.mio.RemoveObjects(ctx, s.cfg.MinIOBucket, objects, minio.RemoveObjectsOptions{}) {
+		failed++
+		if firstErr == nil {
+			firstErr = fmt.Errorf("minio remove %s: %w", rerr.ObjectName, rerr.Err)
+		}
+		s.log.Errorf("minio remove %s: %v", rerr.ObjectName, rerr.Err)
+		metrics.Errors.WithLabelValues("purge").Inc()
+	}
+	if failed > 0 {
+		s.retryPurge(d, fmt.Sprintf("%d of %d objects not removed: %v", failed, len(req.ObjectKeys), firstErr))
+		return
+	}
+
+	res, err := s.mdb.Collection(s.cfg.MongoColl).DeleteMany(ctx, bson.M{"hex_object_key": bson.M{"$in": req.ObjectKeys}})
+	if err != nil {
+		s.log.Errorf("mongo purge: %v", err)
+		metrics.Errors.WithLabelValues("purge").Inc()
+		s.retryPurge(d, fmt.Sprintf("mongo purge: %v", err))
+		return
+	}
+	s.log.Infof("Purged %d objects, %d raw docs", len(req.ObjectKeys), res.DeletedCount)
+	s.mq.Ack(d)
+}
+
+// retryPurge hands a failed purge back to its queue, or to the dead-letter
+// queue once it has failed maxPurgeAttempts times. Removing objects again
+// is harmless: missing ones are not an error.
+func (s *Service) retryPurge(d queue.Delivery, reason string) {
+	if err := s.mq.Retry(d, s.cfg.PurgeQueue, maxPurgeAttempts, reason); err != nil {
+		s.log.Errorf("retry purge: %v", err)
+		_ = d.Nack(false, true)
+	}
+}
+
 func (s *Service) readTCP(ctx context.Context, host, port string) (raw []byte, decoded string, err error) {
 	addr := net.JoinHostPort(host, port)
 	conn, err := (&net.Dialer{Timeout: s.cfg.ConnTimeout}).DialContext(ctx, "tcp", addr)
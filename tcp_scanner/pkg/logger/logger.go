@@ -1,7 +1,14 @@
 package logger
 
 import (
-	"log"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"test_tcp/pkg/tracing"
+
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Logger interface {
@@ -11,15 +18,41 @@ type Logger interface {
 	Warnf(format string, args ...interface{})
 	Error(args ...interface{})
 	Errorf(format string, args ...interface{})
+	// With returns a logger adding the key/value pairs to every line.
+	With(args ...interface{}) Logger
+	// WithContext returns a logger adding the task id and trace ids of ctx.
+	WithContext(ctx context.Context) Logger
 }
 
-type stdLogger struct{}
+// jsonLogger writes JSON lines to stdout, each carrying the scanner name.
+type jsonLogger struct{ l *slog.Logger }
+
+// New returns a logger for scanner and makes it the default for the
+// standard log package too.
+func New(scanner string) Logger {
+	l := slog.New(slog.NewJSONHandler(os.Stdout, nil)).With("scanner", scanner)
+	slog.SetDefault(l)
+	return &jsonLogger{l: l}
+}
 
-func New() Logger { return &stdLogger{} }
+func (j *jsonLogger) Info(args ...interface{})          { j.l.Info(fmt.Sprint(args...)) }
+func (j *jsonLogger) Infof(f string, a ...interface{})  { j.l.Info(fmt.Sprintf(f, a...)) }
+func (j *jsonLogger) Warn(args ...interface{})          { j.l.Warn(fmt.Sprint(args...)) }
+func (j *jsonLogger) Warnf(f string, a ...interface{})  { j.l.Warn(fmt.Sprintf(f, a...)) }
+func (j *jsonLogger) Error(args ...interface{})         { j.l.Error(fmt.Sprint(args...)) }
+func (j *jsonLogger) Errorf(f string, a ...interface{}) { j.l.Error(fmt.Sprintf(f, a...)) }
 
-func (l *stdLogger) Info(args ...interface{})          { log.Println(args...) }
-func (l *stdLogger) Infof(f string, a ...interface{})  { log.Printf(f, a...) }
-func (l *stdLogger) Warn(args ...interface{})          { log.Println(args...) }
-func (l *stdLogger) Warnf(f string, a ...interface{})  { log.Printf(f, a...) }
-func (l *stdLogger) Error(args ...interface{})         { log.Println(args...) }
-func (l *stdLogger) Errorf(f string, a ...interface{}) { log.Printf(f, a...) }
+func (j *jsonLogger) With(args ...interface{}) Logger {
+	return &jsonLogger{l: j.l.With(args...)}
+}
+
+func (j *jsonLogger) WithContext(ctx context.Context) Logger {
+	var args []interface{}
+	if id := tracing.TaskID(ctx); id != "" {
+		args = append(args, "task_id", id)
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		args = append(args, "trace_id", sc.TraceID().String(), "span_id", sc.SpanID().String())
+	}
+	return j.With(args...)
+}
@@ -2,17 +2,42 @@ package queue
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"strings"
+	"sync"
 	"time"
 
+	"test_tcp/pkg/heartbeat"
+	"test_tcp/pkg/tracing"
+
 	amqp "github.com/rabbitmq/amqp091-go"
 )
 
+// RequestExchange is the topic exchange site-tagged instances receive scan
+// requests on, with routing key "<scanner queue>.<site>".
+const RequestExchange = "scan_requests"
+
 type RabbitMQ struct {
-	conn  *amqp.Connection
-	ch    *amqp.Channel
-	queue amqp.Queue
+	conn    *amqp.Connection
+	ch      *amqp.Channel
+	queue   amqp.Queue
+	service string
+	agent   amqp.Table
+	// prioritized is false when the request queue predates priority
+	// lanes and messages are taken in arrival order.
+	prioritized bool
+	// progressOnce declares ProgressExchange before the first progress
+	// event is published.
+	progressOnce sync.Once
+	progressErr  error
+}
+
+// SiteQueue is the queue, and routing key, of service's instances at site.
+func SiteQueue(service, site string) string {
+	return service + "." + site
 }
 
 type Delivery = amqp.Delivery
@@ -33,8 +58,25 @@ type TCPResponse struct {
 	Error        string `json:"error,omitempty"`
 }
 
-func New(url, queueName string) (*RabbitMQ, error) {
-	conn, err := amqp.Dial(url)
+// PurgeRequest lists MinIO object keys whose history records were deleted
+// by the backend; the scanner removes the objects and their raw documents.
+type PurgeRequest struct {
+	ObjectKeys []string `json:"object_keys"`
+}
+
+// New connects and declares the scanner queue. With a site the instance
+// consumes the site queue, bound on RequestExchange, instead. tlsConfig
+// applies to amqps:// URLs; nil verifies the broker against the system
+// roots.
+func New(url, queueName, site string, tlsConfig *tls.Config) (*RabbitMQ, error) {
+	if strings.ContainsAny(site, ".*#") {
+		return nil, fmt.Errorf("site %q must not contain '.', '*' or '#'", site)
+	}
+	service := queueName
+	if site != "" {
+		queueName = SiteQueue(queueName, site)
+	}
+	conn, err := dial(url, tlsConfig)
 	if err != nil {
 		return nil, err
 	}
@@ -43,18 +85,43 @@ func New(url, queueName string) (*RabbitMQ, error) {
 		conn.Close()
 		return nil, err
 	}
-	if err := ch.Qos(1, 0, false); err != nil {
-		ch.Close()
+	q, ch, prioritized, err := declareRequestQueue(conn, ch, queueName)
+	if err != nil {
+		if ch != nil {
+			ch.Close()
+		}
 		conn.Close()
 		return nil, err
 	}
-	q, err := ch.QueueDeclare(queueName, true, false, false, false, nil)
-	if err != nil {
+	if err := ch.Qos(1, 0, false); err != nil {
 		ch.Close()
 		conn.Close()
 		return nil, err
 	}
-	return &RabbitMQ{conn: conn, ch: ch, queue: q}, nil
+	if site != "" {
+		err = ch.ExchangeDeclare(RequestExchange, "topic", true, false, false, false, nil)
+		if err == nil {
+			err = ch.QueueBind(queueName, queueName, RequestExchange, false, nil)
+		}
+		if err != nil {
+			ch.Close()
+			conn.Close()
+			return nil, err
+		}
+	}
+	return &RabbitMQ{conn: conn, ch: ch, queue: q, service: service, prioritized: prioritized}, nil
+}
+
+// dial connects to url, over TLS for amqps:// URLs. A TLS configuration
+// with a plain amqp:// URL is refused rather than silently ignored.
+func dial(url string, tlsConfig *tls.Config) (*amqp.Connection, error) {
+	if tlsConfig == nil {
+		return amqp.Dial(url)
+	}
+	if !strings.HasPrefix(url, "amqps://") {
+		return nil, errors.New("RabbitMQ TLS is configured but the URL is not amqps://")
+	}
+	return amqp.DialTLS(url, tlsConfig)
 }
 
 func (r *RabbitMQ) Consume(ctx context.Context) (<-chan Delivery, error) {
@@ -65,23 +132,128 @@ func (r *RabbitMQ) Consume(ctx context.Context) (<-chan Delivery, error) {
 	return msgs, nil
 }
 
+// ConsumeQueue declares a durable queue on the same channel and consumes
+// it with manual ack.
+func (r *RabbitMQ) ConsumeQueue(ctx context.Context, name string) (<-chan Delivery, error) {
+	q, err := r.ch.QueueDeclare(name, true, false, false, false, nil)
+	if err != nil {
+		return nil, err
+	}
+	return r.ch.Consume(q.Name, "", false, false, false, false, nil)
+}
+
 func (r *RabbitMQ) Ack(d Delivery)  { _ = d.Ack(false) }
 func (r *RabbitMQ) Nack(d Delivery) { _ = d.Nack(false, false) }
 
-func (r *RabbitMQ) Reply(replyTo, corrID string, v interface{}) error {
+// DeadLetterQueue holds messages no scanner can process, with the reason
+// and the queue they came from in the headers below.
+const DeadLetterQueue = "scan_dead_letters"
+
+const (
+	HeaderDeadLetterReason = "x-dead-letter-reason"
+	HeaderOriginalQueue    = "x-original-queue"
+	// HeaderRetryAttempt counts the deliveries of a message handed to
+	// Retry.
+	HeaderRetryAttempt = "x-retry-attempt"
+)
+
+// retryDelay is how long a message handed to Retry waits before it is
+// delivered again.
+const retryDelay = 30 * time.Second
+
+// Retry hands d, taken from queue, back to it after retryDelay, through a
+// TTL queue dead-lettering into it, and acknowledges d. Once d has been
+// delivered maxAttempts times it is moved to DeadLetterQueue with reason
+// instead.
+func (r *RabbitMQ) Retry(d Delivery, queue string, maxAttempts int, reason string) error {
+	attempt, _ := d.Headers[HeaderRetryAttempt].(int32)
+	attempt++
+	headers := amqp.Table{}
+	for k, v := range d.Headers {
+		headers[k] = v
+	}
+	headers[HeaderRetryAttempt] = attempt
+
+	target := queue + ".retry"
+	if int(attempt) >= maxAttempts {
+		target = DeadLetterQueue
+		headers[HeaderDeadLetterReason] = reason
+		headers[HeaderOriginalQueue] = queue
+		if _, err := r.ch.QueueDeclare(DeadLetterQueue, true, false, false, false, nil); err != nil {
+			return err
+		}
+	} else if _, err := r.ch.QueueDeclare(target, true, false, false, false, amqp.Table{
+		"x-message-ttl":             retryDelay.Milliseconds(),
+		"x-dead-letter-exchange":    "",
+		"x-dead-letter-routing-key": queue,
+	}); err != nil {
+		return err
+	}
+
+	err := r.ch.Publish("", target, false, false, amqp.Publishing{
+		ContentType:  d.ContentType,
+		MessageId:    d.MessageId,
+		Timestamp:    time.Now(),
+		DeliveryMode: amqp.Persistent,
+		Headers:      headers,
+		Body:         d.Body,
+	})
+	if err != nil {
+		return err
+	}
+	return d.Ack(false)
+}
+
+// Prioritized reports whether the request queue orders messages by
+// priority lane.
+func (r *RabbitMQ) Prioritized() bool {
+	return r.prioritized
+}
+
+// SetAgent sets the headers identifying this instance on every reply.
+func (r *RabbitMQ) SetAgent(headers map[string]interface{}) {
+	r.agent = amqp.Table(headers)
+}
+
+// Reply publishes v to replyTo, carrying the trace context and task id of
+// ctx in the message headers.
+func (r *RabbitMQ) Reply(ctx context.Context, replyTo, corrID string, v interface{}) error {
 	b, err := json.Marshal(v)
 	if err != nil {
 		return err
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	headers := amqp.Table{}
+	for k, v := range r.agent {
+		headers[k] = v
+	}
+	tracing.Inject(ctx, headers)
+
+	pubCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
-	return r.ch.PublishWithContext(ctx, "", replyTo, false, false, amqp.Publishing{
+	return r.ch.PublishWithContext(pubCtx, "", replyTo, false, false, amqp.Publishing{
 		ContentType:   "application/json",
 		CorrelationId: corrID,
+		DeliveryMode:  amqp.Persistent,
+		Headers:       headers,
 		Body:          b,
 	})
 }
 
+// PublishHeartbeat announces this instance on the heartbeat fanout
+// exchange. Heartbeats are transient: only live subscribers need them.
+func (r *RabbitMQ) PublishHeartbeat(body []byte) error {
+	if err := r.ch.ExchangeDeclare(heartbeat.Exchange, "fanout", true, false, false, false, nil); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return r.ch.PublishWithContext(ctx, heartbeat.Exchange, "", false, false, amqp.Publishing{
+		ContentType: "application/json",
+		Expiration:  "60000",
+		Body:        body,
+	})
+}
+
 func (r *RabbitMQ) Close() {
 	if r.ch != nil {
 		_ = r.ch.Close()
@@ -98,3 +270,11 @@ func ParseTCPRequest(body []byte) (TCPRequest, error) {
 	}
 	return req, nil
 }
+
+func ParsePurgeRequest(body []byte) (PurgeRequest, error) {
+	var req PurgeRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return req, fmt.Errorf("bad purge request: %w", err)
+	}
+	return req, nil
+}